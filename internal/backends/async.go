@@ -0,0 +1,207 @@
+package backends
+
+import (
+	"errors"
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/models"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+)
+
+// AsyncBackend decouples processing from sending by queueing entries and handing them to
+// the wrapped backend in batches from a single goroutine.
+// Entries are sent in the order they were queued, so per-source ordering is preserved.
+type AsyncBackend struct {
+	inner     Backend
+	queue     chan asyncItem
+	batchSize int
+	maxWait   time.Duration
+	done      chan struct{}
+
+	mu     sync.RWMutex // Guards closed against concurrent sends during Shutdown.
+	closed bool
+}
+
+// asyncItem is either an entry to send or a CloseWriter request, which must stay ordered with the entries.
+type asyncItem struct {
+	entry       *models.LogEntry
+	closeSource string
+}
+
+// NewAsyncBackend wraps inner with a queue of queueSize entries.
+// A batch is sent once it holds batchSize entries or its oldest entry has waited maxWait.
+func NewAsyncBackend(inner Backend, queueSize, batchSize int, maxWait time.Duration) *AsyncBackend {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if maxWait <= 0 {
+		maxWait = 200 * time.Millisecond
+	}
+
+	b := &AsyncBackend{
+		inner:     inner,
+		queue:     make(chan asyncItem, queueSize),
+		batchSize: batchSize,
+		maxWait:   maxWait,
+		done:      make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *AsyncBackend) Name() string {
+	return b.inner.Name()
+}
+
+// Unwrap returns the backend that batches are sent to.
+func (b *AsyncBackend) Unwrap() Backend {
+	return b.inner
+}
+
+// Send queues a copy of the entry, blocking while the queue is full.
+// The caller may reuse the entry as soon as Send returns. Send failures of the wrapped
+// backend happen later and are logged and dead-lettered instead of being returned.
+// Delivery is at most once: callers that record progress after Send, like the tailer's file
+// state, count queued entries as sent, and a crash before they were flushed loses them.
+func (b *AsyncBackend) Send(entry *models.LogEntry) error {
+	return b.enqueue(asyncItem{entry: copyEntry(entry)})
+}
+
+// SendBatch queues copies of all entries in order.
+func (b *AsyncBackend) SendBatch(entries []*models.LogEntry) error {
+	for _, entry := range entries {
+		if err := b.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseWriter is forwarded once all entries queued before it have been sent.
+func (b *AsyncBackend) CloseWriter(sourcePath string) {
+	if err := b.enqueue(asyncItem{closeSource: sourcePath}); err != nil {
+		slog.Warn("Dropping CloseWriter request for shut down backend", "source_path", sourcePath)
+	}
+}
+
+// Shutdown sends everything still queued, then shuts down the wrapped backend.
+func (b *AsyncBackend) Shutdown() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	<-b.done
+	b.inner.Shutdown()
+}
+
+// QueueDepth returns the number of items waiting to be sent.
+func (b *AsyncBackend) QueueDepth() int {
+	return len(b.queue)
+}
+
+var errAsyncBackendClosed = errors.New("backend is shut down")
+
+func (b *AsyncBackend) enqueue(item asyncItem) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errAsyncBackendClosed
+	}
+	b.queue <- item
+	return nil
+}
+
+func (b *AsyncBackend) run() {
+	defer close(b.done)
+
+	batch := make([]*models.LogEntry, 0, b.batchSize)
+	timer := time.NewTimer(b.maxWait)
+	timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		if len(batch) == 0 {
+			return
+		}
+		sendBatchOrDeadLetter(b.inner, batch)
+		clear(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case item, ok := <-b.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if item.entry == nil {
+				flush()
+				b.inner.CloseWriter(item.closeSource)
+				continue
+			}
+			batch = append(batch, item.entry)
+			if len(batch) == 1 {
+				timer.Reset(b.maxWait)
+			}
+			if len(batch) >= b.batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// sendBatchOrDeadLetter sends a batch to inner, dead-letters the entries it could not send and returns their number.
+func sendBatchOrDeadLetter(inner Backend, batch []*models.LogEntry) int {
+	err := inner.SendBatch(batch)
+	if err == nil {
+		return 0
+	}
+
+	failed := batch
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		failed = make([]*models.LogEntry, 0, len(batchErr.Failed))
+		for _, i := range batchErr.Failed {
+			failed = append(failed, batch[i])
+		}
+	}
+
+	slog.Error("Failed to send log batch to backend", "error", err, "backend", inner.Name(), "failed", len(failed), "batch_size", len(batch))
+	for _, entry := range failed {
+		deadletter.Write(deadletter.Record{
+			App:       entry.App,
+			Source:    entry.SourcePath,
+			Tenant:    entry.Tenant,
+			Timestamp: entry.Timestamp,
+			Line:      string(entry.LogLine),
+			Backend:   inner.Name(),
+			Error:     err.Error(),
+		})
+	}
+	return len(failed)
+}
+
+// copyEntry detaches an entry from the caller, which usually returns it to the entry pool right after Send.
+func copyEntry(entry *models.LogEntry) *models.LogEntry {
+	return &models.LogEntry{
+		Fields:             maps.Clone(entry.Fields),
+		LogLine:            append([]byte(nil), entry.LogLine...),
+		Timestamp:          entry.Timestamp,
+		SourcePath:         entry.SourcePath,
+		App:                entry.App,
+		Labels:             maps.Clone(entry.Labels),
+		Tenant:             entry.Tenant,
+		StructuredMetadata: maps.Clone(entry.StructuredMetadata),
+	}
+}
@@ -0,0 +1,206 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingBackend records every call it receives, in order.
+type recordingBackend struct {
+	mu       sync.Mutex
+	events   []string
+	batches  []int
+	failAt   map[string]bool
+	shutdown bool
+}
+
+func (b *recordingBackend) Send(entry *models.LogEntry) error {
+	return b.SendBatch([]*models.LogEntry{entry})
+}
+
+func (b *recordingBackend) SendBatch(entries []*models.LogEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batches = append(b.batches, len(entries))
+	var batchErr *BatchError
+	for i, entry := range entries {
+		if b.failAt[string(entry.LogLine)] {
+			if batchErr == nil {
+				batchErr = &BatchError{Err: errors.New("rejected")}
+			}
+			batchErr.Failed = append(batchErr.Failed, i)
+			continue
+		}
+		b.events = append(b.events, entry.SourcePath+":"+string(entry.LogLine))
+	}
+	if batchErr != nil {
+		return batchErr
+	}
+	return nil
+}
+
+func (b *recordingBackend) CloseWriter(sourcePath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, "close:"+sourcePath)
+}
+
+func (b *recordingBackend) Shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.shutdown = true
+}
+
+func (b *recordingBackend) Name() string { return "recording" }
+
+func (b *recordingBackend) snapshot() ([]string, []int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.events...), append([]int(nil), b.batches...)
+}
+
+type captureSink struct {
+	mu      sync.Mutex
+	records []deadletter.Record
+}
+
+func (s *captureSink) Write(record deadletter.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *captureSink) Close() error { return nil }
+
+func TestAsyncBackend_SendsInOrderInBatches(t *testing.T) {
+	inner := &recordingBackend{}
+	backend := NewAsyncBackend(inner, 100, 3, time.Hour)
+
+	var want []string
+	for i := 0; i < 7; i++ {
+		source := fmt.Sprintf("/logs/%d.log", i%2)
+		line := fmt.Sprintf("line-%d", i)
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: source, LogLine: []byte(line)}))
+		want = append(want, source+":"+line)
+	}
+	backend.Shutdown()
+
+	events, batches := inner.snapshot()
+	assert.Equal(t, want, events)
+	// Two full batches, then the remainder is flushed on shutdown.
+	assert.Equal(t, []int{3, 3, 1}, batches)
+	assert.True(t, inner.shutdown)
+}
+
+func TestAsyncBackend_FlushesPartialBatchAfterMaxWait(t *testing.T) {
+	inner := &recordingBackend{}
+	backend := NewAsyncBackend(inner, 100, 100, 20*time.Millisecond)
+	defer backend.Shutdown()
+
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", LogLine: []byte("one")}))
+
+	require.Eventually(t, func() bool {
+		events, _ := inner.snapshot()
+		return len(events) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestAsyncBackend_CloseWriterWaitsForQueuedEntries(t *testing.T) {
+	inner := &recordingBackend{}
+	backend := NewAsyncBackend(inner, 100, 100, time.Hour)
+
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", LogLine: []byte("one")}))
+	backend.CloseWriter("/logs/a.log")
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", LogLine: []byte("two")}))
+	backend.Shutdown()
+
+	events, _ := inner.snapshot()
+	assert.Equal(t, []string{"/logs/a.log:one", "close:/logs/a.log", "/logs/a.log:two"}, events)
+}
+
+func TestAsyncBackend_CopiesEntriesBeforeQueueing(t *testing.T) {
+	inner := &recordingBackend{}
+	backend := NewAsyncBackend(inner, 100, 100, time.Hour)
+
+	entry := &models.LogEntry{SourcePath: "/logs/a.log", LogLine: []byte("original"), Fields: map[string]interface{}{"k": "v"}}
+	require.NoError(t, backend.Send(entry))
+	// The processor returns entries to the pool right after Send, which reuses them.
+	copy(entry.LogLine, "reusedxx")
+	clear(entry.Fields)
+	backend.Shutdown()
+
+	events, _ := inner.snapshot()
+	assert.Equal(t, []string{"/logs/a.log:original"}, events)
+}
+
+func TestAsyncBackend_DeadLettersFailedEntries(t *testing.T) {
+	sink := &captureSink{}
+	deadletter.SetSink(sink)
+	t.Cleanup(func() { deadletter.SetSink(nil) })
+
+	inner := &recordingBackend{failAt: map[string]bool{"bad": true}}
+	backend := NewAsyncBackend(inner, 100, 10, time.Hour)
+
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", App: "web", LogLine: []byte("good")}))
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", App: "web", LogLine: []byte("bad")}))
+	backend.Shutdown()
+
+	events, _ := inner.snapshot()
+	assert.Equal(t, []string{"/logs/a.log:good"}, events)
+
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, "bad", sink.records[0].Line)
+	assert.Equal(t, "web", sink.records[0].App)
+	assert.Equal(t, "recording", sink.records[0].Backend)
+	assert.Contains(t, sink.records[0].Error, "rejected")
+}
+
+func TestAsyncBackend_RejectsSendAfterShutdown(t *testing.T) {
+	backend := NewAsyncBackend(&recordingBackend{}, 1, 1, time.Hour)
+	backend.Shutdown()
+
+	assert.Error(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log"}))
+	// A second shutdown must not panic on the closed queue.
+	backend.Shutdown()
+}
+
+// blockingBackend holds every batch until release is closed.
+type blockingBackend struct {
+	recordingBackend
+	release chan struct{}
+}
+
+func (b *blockingBackend) SendBatch(entries []*models.LogEntry) error {
+	<-b.release
+	return b.recordingBackend.SendBatch(entries)
+}
+
+func TestAsyncBackend_SendReturnsBeforeDelivery(t *testing.T) {
+	inner := &blockingBackend{release: make(chan struct{})}
+	backend := NewAsyncBackend(inner, 100, 1, time.Hour)
+
+	// Send succeeds once the entry is queued, so callers such as the tailer treat it as delivered while the
+	// wrapped backend has not seen it yet. A crash at this point loses the queued entries.
+	for _, line := range []string{"one", "two", "three"} {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", LogLine: []byte(line)}))
+	}
+	events, _ := inner.snapshot()
+	assert.Empty(t, events)
+
+	// A graceful shutdown closes the window by delivering everything still queued.
+	close(inner.release)
+	backend.Shutdown()
+	events, _ = inner.snapshot()
+	assert.Equal(t, []string{"/logs/a.log:one", "/logs/a.log:two", "/logs/a.log:three"}, events)
+}
@@ -1,6 +1,7 @@
 package backends
 
 import (
+	"fmt"
 	"log-enricher/internal/models"
 )
 
@@ -10,6 +11,9 @@ type Backend interface {
 	// sourcePath is the path of the original log file being processed.
 	// entryAsBytes is the pre-marshaled JSON log entry.
 	Send(entry *models.LogEntry) error
+	// SendBatch transmits several entries at once, preserving their order per sourcePath.
+	// If only some entries fail, the returned error is a *BatchError listing them.
+	SendBatch(entries []*models.LogEntry) error
 	// Shutdown gracefully closes the backend connection or flushes buffers.
 	Shutdown()
 	// Name returns the descriptive name of the backend.
@@ -18,3 +22,18 @@ type Backend interface {
 	// This allows the backend to release any resources (e.g., file handles) associated with that path.
 	CloseWriter(sourcePath string)
 }
+
+// BatchError reports which entries of a SendBatch call could not be sent.
+type BatchError struct {
+	// Failed holds the indices of the failed entries in the slice passed to SendBatch.
+	Failed []int
+	Err    error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("failed to send %d entries: %v", len(e.Failed), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
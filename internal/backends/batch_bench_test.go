@@ -0,0 +1,87 @@
+package backends
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+)
+
+// These benchmarks compare per-entry sends with batched and queued sends to the file backend.
+// Run them with: go test -run '^$' -bench . -benchmem ./internal/backends/
+// The lines/s metric is the comparable throughput figure.
+
+const benchmarkSources = 4
+
+func benchmarkEntries(dir string, n int) []*models.LogEntry {
+	entries := make([]*models.LogEntry, n)
+	for i := range entries {
+		entries[i] = &models.LogEntry{
+			SourcePath: filepath.Join(dir, fmt.Sprintf("source-%d.log", i%benchmarkSources)),
+			Timestamp:  time.Unix(1700000000, 0),
+			App:        "bench",
+			Fields: map[string]interface{}{
+				"level":     "info",
+				"msg":       "request completed",
+				"status":    200,
+				"client_ip": "203.0.113.7",
+			},
+		}
+	}
+	return entries
+}
+
+func reportLinesPerSecond(b *testing.B) {
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lines/s")
+}
+
+func BenchmarkFileBackend_Send(b *testing.B) {
+	backend := NewFileBackend(".enriched")
+	entries := benchmarkEntries(b.TempDir(), 1024)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := backend.Send(entries[i%len(entries)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	backend.Shutdown()
+	reportLinesPerSecond(b)
+}
+
+func BenchmarkFileBackend_SendBatch(b *testing.B) {
+	for _, batchSize := range []int{100, 500} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			backend := NewFileBackend(".enriched")
+			entries := benchmarkEntries(b.TempDir(), batchSize)
+			b.ResetTimer()
+
+			for sent := 0; sent < b.N; sent += batchSize {
+				batch := entries[:min(batchSize, b.N-sent)]
+				if err := backend.SendBatch(batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+			backend.Shutdown()
+			reportLinesPerSecond(b)
+		})
+	}
+}
+
+func BenchmarkAsyncBackend_FileSend(b *testing.B) {
+	inner := NewFileBackend(".enriched")
+	backend := NewAsyncBackend(inner, 10000, 500, 200*time.Millisecond)
+	entries := benchmarkEntries(b.TempDir(), 1024)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := backend.Send(entries[i%len(entries)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// Shutdown drains the queue, so the measurement includes writing every entry.
+	backend.Shutdown()
+	reportLinesPerSecond(b)
+}
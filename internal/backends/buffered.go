@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"log-enricher/internal/models"
+)
+
+// BufferedBackend collects entries and sends them to the wrapped backend with SendBatch, from the caller's
+// goroutine, once batchSize entries were collected or Flush is called. SendBatch is synchronous for every backend,
+// unlike Loki's Send, so once Flush returns the entries were delivered or dead-lettered. Callers that save progress,
+// like backfill, flush before every save. It is not safe for concurrent use.
+type BufferedBackend struct {
+	inner     Backend
+	batchSize int
+	batch     []*models.LogEntry
+	failed    int
+}
+
+// NewBufferedBackend wraps inner with a buffer of batchSize entries.
+func NewBufferedBackend(inner Backend, batchSize int) *BufferedBackend {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &BufferedBackend{inner: inner, batchSize: batchSize, batch: make([]*models.LogEntry, 0, batchSize)}
+}
+
+func (b *BufferedBackend) Name() string {
+	return b.inner.Name()
+}
+
+// Send buffers a copy of the entry and sends the buffer once it is full. Send failures are dead-lettered and
+// counted by Flush instead of being returned.
+func (b *BufferedBackend) Send(entry *models.LogEntry) error {
+	b.batch = append(b.batch, copyEntry(entry))
+	if len(b.batch) >= b.batchSize {
+		b.sendBuffered()
+	}
+	return nil
+}
+
+// SendBatch buffers copies of all entries in order.
+func (b *BufferedBackend) SendBatch(entries []*models.LogEntry) error {
+	for _, entry := range entries {
+		if err := b.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush sends the buffered entries and returns how many entries failed since the last Flush; they were
+// dead-lettered.
+func (b *BufferedBackend) Flush() int {
+	b.sendBuffered()
+	failed := b.failed
+	b.failed = 0
+	return failed
+}
+
+func (b *BufferedBackend) sendBuffered() {
+	if len(b.batch) == 0 {
+		return
+	}
+	b.failed += sendBatchOrDeadLetter(b.inner, b.batch)
+	clear(b.batch)
+	b.batch = b.batch[:0]
+}
+
+// CloseWriter sends the buffered entries before closing the wrapped backend's writer. Their failures are
+// reported by the next Flush.
+func (b *BufferedBackend) CloseWriter(sourcePath string) {
+	b.sendBuffered()
+	b.inner.CloseWriter(sourcePath)
+}
+
+// Shutdown sends the buffered entries, then shuts down the wrapped backend.
+func (b *BufferedBackend) Shutdown() {
+	b.Flush()
+	b.inner.Shutdown()
+}
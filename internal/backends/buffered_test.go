@@ -0,0 +1,49 @@
+package backends
+
+import (
+	"testing"
+
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferedBackend_SendsFullBatchesAndFlushesTheRest(t *testing.T) {
+	inner := &recordingBackend{}
+	backend := NewBufferedBackend(inner, 2)
+
+	for _, line := range []string{"one", "two", "three"} {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", LogLine: []byte(line)}))
+	}
+	events, batches := inner.snapshot()
+	assert.Equal(t, []string{"/logs/a.log:one", "/logs/a.log:two"}, events)
+	assert.Equal(t, []int{2}, batches)
+
+	backend.CloseWriter("/logs/a.log")
+	events, batches = inner.snapshot()
+	assert.Equal(t, []string{"/logs/a.log:one", "/logs/a.log:two", "/logs/a.log:three", "close:/logs/a.log"}, events)
+	assert.Equal(t, []int{2, 1}, batches)
+	assert.Zero(t, backend.Flush())
+}
+
+func TestBufferedBackend_FlushCountsAndDeadLettersFailures(t *testing.T) {
+	sink := &captureSink{}
+	deadletter.SetSink(sink)
+	t.Cleanup(func() { deadletter.SetSink(nil) })
+
+	inner := &recordingBackend{failAt: map[string]bool{"bad": true}}
+	backend := NewBufferedBackend(inner, 2)
+
+	for _, line := range []string{"bad", "good", "bad"} {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", Tenant: "team-a", LogLine: []byte(line)}))
+	}
+	assert.Equal(t, 2, backend.Flush(), "failures of full batches sent by Send are included")
+	assert.Zero(t, backend.Flush())
+
+	events, _ := inner.snapshot()
+	assert.Equal(t, []string{"/logs/a.log:good"}, events)
+	require.Len(t, sink.records, 2)
+	assert.Equal(t, "team-a", sink.records[0].Tenant)
+}
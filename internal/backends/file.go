@@ -6,7 +6,9 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/goccy/go-json"
 )
@@ -14,8 +16,20 @@ import (
 // FileBackend writes enriched logs to separate files based on the original log's path.
 type FileBackend struct {
 	suffix string
-	// writers is a map from sourcePath to *os.File. It is concurrency-safe.
+	// writers is a map from sourcePath to *fileWriter. It is concurrency-safe.
 	writers sync.Map
+
+	// Hash chain settings. When hashChain is false, records are written as-is.
+	hashChain          bool
+	checkpointKey      []byte
+	checkpointInterval int
+}
+
+// fileWriter is a single open enriched file together with its optional hash chain state.
+type fileWriter struct {
+	mu    sync.Mutex // Serializes writes so the hash chain stays in record order.
+	file  *os.File
+	chain *hashChain
 }
 
 // NewFileBackend creates a new file-writing backend.
@@ -25,52 +39,178 @@ func NewFileBackend(suffix string) *FileBackend {
 	}
 }
 
+// NewHashChainFileBackend creates a file-writing backend that appends a running SHA-256 hash to every record.
+// If checkpointKey is non-empty, an HMAC-signed checkpoint record is written every checkpointInterval records.
+func NewHashChainFileBackend(suffix string, checkpointKey []byte, checkpointInterval int) *FileBackend {
+	return &FileBackend{
+		suffix:             suffix,
+		hashChain:          true,
+		checkpointKey:      checkpointKey,
+		checkpointInterval: checkpointInterval,
+	}
+}
+
 func (b *FileBackend) Name() string {
 	return "file"
 }
 
-// Send writes the pre-marshaled entry to a corresponding .enriched file.
-// The incoming byte slice already contains a newline.
+// Send writes the entry to the corresponding .enriched file.
 func (b *FileBackend) Send(entry *models.LogEntry) error {
 	writer, err := b.getWriter(entry.SourcePath)
 	if err != nil {
 		return err
 	}
 
-	// If there are no fields (no JSON) send the log line as the log message
-	if len(entry.Fields) == 0 {
-		if len(entry.LogLine) == 0 {
-			_, err = writer.Write([]byte{'\n'})
-			return err
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	buf, err := b.appendRecord(nil, writer, entry)
+	if err != nil {
+		return err
+	}
+	_, err = writer.file.Write(buf)
+	return err
+}
+
+// SendBatch groups the entries by sourcePath and writes each group to its .enriched file with a single write.
+func (b *FileBackend) SendBatch(entries []*models.LogEntry) error {
+	var order []string
+	groups := make(map[string][]int)
+	for i, entry := range entries {
+		if _, ok := groups[entry.SourcePath]; !ok {
+			order = append(order, entry.SourcePath)
 		}
-		_, err = writer.Write(entry.LogLine)
+		groups[entry.SourcePath] = append(groups[entry.SourcePath], i)
+	}
+
+	var batchErr *BatchError
+	for _, sourcePath := range order {
+		failed, err := b.writeGroup(sourcePath, entries, groups[sourcePath])
 		if err != nil {
-			return err
+			if batchErr == nil {
+				batchErr = &BatchError{Err: err}
+			}
+			batchErr.Failed = append(batchErr.Failed, failed...)
 		}
-		if entry.LogLine[len(entry.LogLine)-1] != '\n' {
-			_, err = writer.Write([]byte{'\n'})
+	}
+	if batchErr != nil {
+		sort.Ints(batchErr.Failed)
+		return batchErr
+	}
+	return nil
+}
+
+// writeGroup writes the entries at indices, which all belong to sourcePath, and returns the indices that failed.
+// An entry that cannot be encoded is skipped so the records already sealed into the hash chain are still written.
+func (b *FileBackend) writeGroup(sourcePath string, entries []*models.LogEntry, indices []int) ([]int, error) {
+	writer, err := b.getWriter(sourcePath)
+	if err != nil {
+		return indices, err
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	var buf []byte
+	var failed []int
+	var firstErr error
+	written := make([]int, 0, len(indices))
+	for _, i := range indices {
+		next, err := b.appendRecord(buf, writer, entries[i])
+		if err != nil {
+			failed = append(failed, i)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
-		return err
+		buf = next
+		written = append(written, i)
 	}
 
-	// Use json.MarshalNoEscape with json.Unordered() to prevent key sorting.
-	buf, err := json.MarshalWithOption(entry.Fields, json.UnorderedMap())
+	if _, err := writer.file.Write(buf); err != nil {
+		return append(failed, written...), err
+	}
+	return failed, firstErr
+}
+
+// appendRecord appends the newline-terminated output record for entry to buf.
+// The caller must hold writer.mu, as chained records advance the writer's hash chain.
+func (b *FileBackend) appendRecord(buf []byte, writer *fileWriter, entry *models.LogEntry) ([]byte, error) {
+	if writer.chain != nil {
+		return appendChainedRecord(buf, writer.chain, entry)
+	}
+	return appendPlainRecord(buf, entry)
+}
+
+// appendPlainRecord appends entry as its fields in JSON, or as its raw line if it has no fields.
+func appendPlainRecord(buf []byte, entry *models.LogEntry) ([]byte, error) {
+	// If there are no fields (no JSON) send the log line as the log message
+	if len(entry.Fields) == 0 {
+		buf = append(buf, entry.LogLine...)
+		if len(entry.LogLine) == 0 || entry.LogLine[len(entry.LogLine)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+		return buf, nil
+	}
 
+	// Use json.MarshalNoEscape with json.Unordered() to prevent key sorting.
+	payload, err := json.MarshalWithOption(entry.Fields, json.UnorderedMap())
 	if err != nil {
-		return err
+		return buf, err
 	}
 
 	// Add a newline character after each JSON log entry for readability in the file.
+	buf = append(buf, payload...)
+	return append(buf, '\n'), nil
+}
+
+// appendChainedRecord appends the entry as a JSON record with the running chain hash appended.
+// Raw lines are wrapped in a {"line": ...} object so every record carries its hash in the same place.
+func appendChainedRecord(buf []byte, chain *hashChain, entry *models.LogEntry) ([]byte, error) {
+	var payload []byte
+	var err error
+	if len(entry.Fields) == 0 {
+		line := entry.LogLine
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+		}
+		payload, err = json.Marshal(map[string]string{"line": string(line)})
+	} else {
+		if name := reservedHashChainField(entry.Fields); name != "" {
+			return buf, fmt.Errorf("entry field %q is reserved for the hash chain", name)
+		}
+		payload, err = json.MarshalWithOption(entry.Fields, json.UnorderedMap())
+	}
+	if err != nil {
+		return buf, err
+	}
+
+	// A checkpoint can already be due if the process stopped before writing it; it must precede the next record.
+	if buf, err = appendDueCheckpoint(buf, chain); err != nil {
+		return buf, err
+	}
+	buf = append(buf, chain.seal(payload)...)
 	buf = append(buf, '\n')
+	return appendDueCheckpoint(buf, chain)
+}
 
-	_, err = writer.Write(buf)
-	return err
+func appendDueCheckpoint(buf []byte, chain *hashChain) ([]byte, error) {
+	if !chain.checkpointDue() {
+		return buf, nil
+	}
+	checkpoint, err := chain.checkpoint(time.Now())
+	if err != nil {
+		return buf, err
+	}
+	buf = append(buf, checkpoint...)
+	return append(buf, '\n'), nil
 }
 
-func (b *FileBackend) getWriter(sourcePath string) (*os.File, error) {
+func (b *FileBackend) getWriter(sourcePath string) (*fileWriter, error) {
 	// Optimistic path (lock-free): check if writer already exists.
 	if writer, ok := b.writers.Load(sourcePath); ok {
-		return writer.(*os.File), nil
+		return writer.(*fileWriter), nil
 	}
 
 	// Slow path: writer does not exist. We need to create it.
@@ -85,32 +225,42 @@ func (b *FileBackend) getWriter(sourcePath string) (*os.File, error) {
 
 	// If another goroutine created the writer in the meantime, close our redundant one.
 	if loaded {
-		_ = newWriter.Close()
+		_ = newWriter.file.Close()
 	}
 
-	return actualWriter.(*os.File), nil
+	return actualWriter.(*fileWriter), nil
 }
 
-func (b *FileBackend) createWriter(sourcePath string) (*os.File, error) {
+func (b *FileBackend) createWriter(sourcePath string) (*fileWriter, error) {
 	outputPath := sourcePath + b.suffix
 	dir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create directory for enriched file %s: %w", outputPath, err)
 	}
 
-	f, err := os.OpenFile(outputPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(outputPath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open enriched file %s: %w", outputPath, err)
 	}
-	return f, nil
+
+	writer := &fileWriter{file: f}
+	if b.hashChain {
+		writer.chain = newHashChain(b.checkpointKey, b.checkpointInterval)
+		if err := writer.chain.resume(f); err != nil {
+			// Appending to an unchained file would make every following record unverifiable, so refuse it.
+			_ = f.Close()
+			return nil, fmt.Errorf("failed to resume hash chain for enriched file %s: %w", outputPath, err)
+		}
+	}
+	return writer, nil
 }
 
 // CloseWriter closes the file writer for a specific sourcePath and removes it from the map.
 func (b *FileBackend) CloseWriter(sourcePath string) {
 	if writer, loaded := b.writers.LoadAndDelete(sourcePath); loaded {
-		if file, ok := writer.(*os.File); ok {
+		if fw, ok := writer.(*fileWriter); ok {
 			slog.Info("Closing enriched log file", "path", sourcePath+b.suffix)
-			if err := file.Close(); err != nil {
+			if err := fw.close(); err != nil {
 				slog.Error("Failed to close enriched log file", "path", sourcePath+b.suffix, "error", err)
 			}
 		}
@@ -121,9 +271,9 @@ func (b *FileBackend) CloseWriter(sourcePath string) {
 func (b *FileBackend) Shutdown() {
 	// Iterate over the sync.Map and close each writer.
 	b.writers.Range(func(key, value interface{}) bool {
-		if writer, ok := value.(*os.File); ok {
+		if writer, ok := value.(*fileWriter); ok {
 			slog.Info("Closing enriched log file during shutdown", "path", key.(string)+b.suffix)
-			if err := writer.Close(); err != nil {
+			if err := writer.close(); err != nil {
 				slog.Error("Failed to close enriched log file during shutdown", "path", key.(string)+b.suffix, "error", err)
 			}
 		}
@@ -131,3 +281,9 @@ func (b *FileBackend) Shutdown() {
 		return true
 	})
 }
+
+func (w *fileWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
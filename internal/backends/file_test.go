@@ -100,3 +100,52 @@ func TestFileBackendCloseWriter_AllowsReopenAndAppend(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "line-one\nline-two\n", string(content))
 }
+
+func TestFileBackendSendBatch_GroupsEntriesPerSource(t *testing.T) {
+	tempDir := t.TempDir()
+	first := filepath.Join(tempDir, "first.log")
+	second := filepath.Join(tempDir, "second.log")
+	backend := NewFileBackend(".enriched")
+	defer backend.Shutdown()
+
+	require.NoError(t, backend.SendBatch([]*models.LogEntry{
+		{SourcePath: first, LogLine: []byte("a")},
+		{SourcePath: second, LogLine: []byte("x")},
+		{SourcePath: first, Fields: map[string]interface{}{"msg": "b"}},
+		{SourcePath: second, LogLine: []byte("y\n")},
+	}))
+
+	content, err := os.ReadFile(first + ".enriched")
+	require.NoError(t, err)
+	assert.Equal(t, "a\n{\"msg\":\"b\"}\n", string(content))
+
+	content, err = os.ReadFile(second + ".enriched")
+	require.NoError(t, err)
+	assert.Equal(t, "x\ny\n", string(content))
+}
+
+func TestFileBackendSendBatch_ReportsFailedEntries(t *testing.T) {
+	tempDir := t.TempDir()
+	good := filepath.Join(tempDir, "good.log")
+	// A regular file where the backend expects a directory makes the writer for bad fail.
+	blocker := filepath.Join(tempDir, "blocker")
+	require.NoError(t, os.WriteFile(blocker, nil, 0644))
+	bad := filepath.Join(blocker, "bad.log")
+
+	backend := NewFileBackend(".enriched")
+	defer backend.Shutdown()
+
+	err := backend.SendBatch([]*models.LogEntry{
+		{SourcePath: bad, LogLine: []byte("lost")},
+		{SourcePath: good, LogLine: []byte("kept")},
+		{SourcePath: bad, LogLine: []byte("lost")},
+	})
+
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, []int{0, 2}, batchErr.Failed)
+
+	content, err := os.ReadFile(good + ".enriched")
+	require.NoError(t, err)
+	assert.Equal(t, "kept\n", string(content))
+}
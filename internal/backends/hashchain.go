@@ -0,0 +1,347 @@
+package backends
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	hashChainField       = "_hash"
+	hashChainHMACField   = "_hmac"
+	hashChainCheckpoint  = "_checkpoint"
+	hashChainHashHexSize = sha256.Size * 2
+)
+
+// hashChainSuffixPrefix is the textual marker that precedes the hex hash at the end of every chained record.
+var hashChainSuffixPrefix = []byte(`"` + hashChainField + `":"`)
+
+// hashChain holds the running hash of a single enriched file.
+// It is not safe for concurrent use; callers must serialize access.
+type hashChain struct {
+	prev               [sha256.Size]byte
+	records            int
+	sinceCheckpoint    int
+	checkpointKey      []byte
+	checkpointInterval int
+}
+
+func newHashChain(checkpointKey []byte, checkpointInterval int) *hashChain {
+	return &hashChain{
+		checkpointKey:      checkpointKey,
+		checkpointInterval: checkpointInterval,
+	}
+}
+
+// seal computes the next chain hash over payload and returns the record with the hash appended as the final JSON key.
+// payload must be a JSON object without a trailing newline.
+func (c *hashChain) seal(payload []byte) []byte {
+	sum := chainHash(c.prev, payload)
+	c.prev = sum
+	c.records++
+	c.sinceCheckpoint++
+	return appendHashField(payload, sum)
+}
+
+// checkpointDue reports whether a signed checkpoint record should be written after the current record.
+func (c *hashChain) checkpointDue() bool {
+	return len(c.checkpointKey) > 0 && c.checkpointInterval > 0 && c.sinceCheckpoint >= c.checkpointInterval
+}
+
+// checkpoint builds a signed checkpoint record over the current chain head and seals it into the chain.
+func (c *hashChain) checkpoint(now time.Time) ([]byte, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		hashChainCheckpoint: map[string]interface{}{
+			"records": c.records,
+			"time":    now.UTC().Format(time.RFC3339Nano),
+		},
+		hashChainHMACField: hex.EncodeToString(checkpointMAC(c.checkpointKey, c.prev, c.records)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal hash chain checkpoint: %w", err)
+	}
+	record := c.seal(payload)
+	c.sinceCheckpoint = 0
+	return record, nil
+}
+
+// resume seeds the chain from an existing enriched file so appends continue the same chain. The file is read
+// backwards: the last record holds the chain head, and with a checkpoint key the records up to the last checkpoint
+// restore the record counts, so the checkpoint cadence continues where it stopped.
+func (c *hashChain) resume(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var headErr error
+	first := true
+	sinceCheckpoint := 0
+	checkpointRecords := -1
+	err = readRecordsBackward(f, info.Size(), func(record []byte) bool {
+		payload, sum, ok := splitHashField(record)
+		if first {
+			first = false
+			if !ok {
+				headErr = fmt.Errorf("last record of %s is not part of a hash chain", f.Name())
+				return false
+			}
+			c.prev = sum
+		}
+		// Without a key no checkpoints are written, so the record counts are not needed.
+		if len(c.checkpointKey) == 0 {
+			return false
+		}
+		if ok && isCheckpointRecord(payload) {
+			var checkpoint checkpointRecord
+			if json.Unmarshal(payload, &checkpoint) == nil && checkpoint.Checkpoint.Records != nil {
+				checkpointRecords = *checkpoint.Checkpoint.Records
+				return false
+			}
+		}
+		sinceCheckpoint++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if headErr != nil {
+		return headErr
+	}
+
+	// A checkpoint counts the records before it and is a record itself.
+	c.records = sinceCheckpoint
+	if checkpointRecords >= 0 {
+		c.records += checkpointRecords + 1
+	}
+	c.sinceCheckpoint = sinceCheckpoint
+	return nil
+}
+
+// readRecordsBackward calls fn with the non-empty lines of the first size bytes of r, from the last to the first,
+// until fn returns false. The record passed to fn is only valid during the call.
+func readRecordsBackward(r io.ReaderAt, size int64, fn func(record []byte) bool) error {
+	const chunkSize = 64 * 1024
+
+	// rest is the start of the data read so far that does not end a line yet.
+	var rest []byte
+	for end := size; end > 0; {
+		start := max(end-chunkSize, 0)
+		data := make([]byte, end-start, end-start+int64(len(rest)))
+		if _, err := r.ReadAt(data, start); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		data = append(data, rest...)
+		end = start
+
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if record := bytes.TrimRight(data[i+1:], "\r"); len(record) > 0 && !fn(record) {
+				return nil
+			}
+			data = data[:i]
+		}
+		rest = data
+	}
+	if record := bytes.TrimRight(rest, "\r"); len(record) > 0 {
+		fn(record)
+	}
+	return nil
+}
+
+// reservedHashChainField returns the first field of fields that only the hash chain may write, or "". An entry
+// with "_checkpoint" and "_hmac" fields would otherwise be taken for a checkpoint record.
+func reservedHashChainField(fields map[string]interface{}) string {
+	for _, name := range []string{hashChainField, hashChainHMACField, hashChainCheckpoint} {
+		if _, ok := fields[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func chainHash(prev [sha256.Size]byte, payload []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(payload)
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// checkpointMAC signs the chain head together with the number of records before the checkpoint.
+func checkpointMAC(key []byte, head [sha256.Size]byte, records int) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(head[:])
+	mac.Write(strconv.AppendInt(nil, int64(records), 10))
+	return mac.Sum(nil)
+}
+
+// appendHashField turns {...} into {...,"_hash":"<hex>"}.
+func appendHashField(payload []byte, sum [sha256.Size]byte) []byte {
+	body := bytes.TrimSuffix(payload, []byte{'}'})
+	out := make([]byte, 0, len(payload)+len(hashChainSuffixPrefix)+hashChainHashHexSize+3)
+	out = append(out, body...)
+	if len(bytes.TrimSpace(body)) > 1 {
+		out = append(out, ',')
+	}
+	out = append(out, hashChainSuffixPrefix...)
+	out = hex.AppendEncode(out, sum[:])
+	out = append(out, '"', '}')
+	return out
+}
+
+// splitHashField reverses appendHashField, returning the original payload and the decoded hash.
+func splitHashField(record []byte) ([]byte, [sha256.Size]byte, bool) {
+	var sum [sha256.Size]byte
+
+	tailLen := len(hashChainSuffixPrefix) + hashChainHashHexSize + 2
+	if len(record) < tailLen+1 || !bytes.HasSuffix(record, []byte(`"}`)) {
+		return nil, sum, false
+	}
+
+	tailStart := len(record) - tailLen
+	if !bytes.Equal(record[tailStart:tailStart+len(hashChainSuffixPrefix)], hashChainSuffixPrefix) {
+		return nil, sum, false
+	}
+
+	hexHash := record[tailStart+len(hashChainSuffixPrefix) : len(record)-2]
+	if _, err := hex.Decode(sum[:], hexHash); err != nil {
+		return nil, sum, false
+	}
+
+	body := record[:tailStart]
+	payload := make([]byte, 0, len(body)+1)
+	payload = append(payload, bytes.TrimSuffix(body, []byte{','})...)
+	payload = append(payload, '}')
+	return payload, sum, true
+}
+
+// HashChainVerification is the result of walking an enriched file's hash chain.
+type HashChainVerification struct {
+	// Records is the number of records that were verified successfully.
+	Records int
+	// Checkpoints is the number of signed checkpoint records that were verified.
+	Checkpoints int
+	// BrokenLine is the 1-based line number of the first broken record, or 0 if the chain is intact.
+	BrokenLine int
+	// Reason describes why BrokenLine failed verification.
+	Reason string
+}
+
+// Intact reports whether every record in the file verified successfully.
+func (v HashChainVerification) Intact() bool {
+	return v.BrokenLine == 0
+}
+
+// VerifyHashChain walks the hash chain of an enriched file and reports the first broken record.
+// If checkpointKey is non-empty, checkpoint signatures and record counts are verified as well, and with a positive
+// checkpointInterval a checkpoint must follow every checkpointInterval records; stripping or moving checkpoints and
+// recomputing the unkeyed chain is then detected. Without a key, checkpoints are only chained.
+func VerifyHashChain(r io.Reader, checkpointKey []byte, checkpointInterval int) (HashChainVerification, error) {
+	var result HashChainVerification
+	var prev [sha256.Size]byte
+	sinceCheckpoint := 0
+	keyed := len(checkpointKey) > 0
+
+	reader := bufio.NewReader(r)
+	lineNumber := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lineNumber++
+			record := bytes.TrimRight(line, "\r\n")
+
+			payload, sum, ok := splitHashField(record)
+			if !ok {
+				result.BrokenLine = lineNumber
+				result.Reason = "record has no chain hash"
+				return result, nil
+			}
+			if chainHash(prev, payload) != sum {
+				result.BrokenLine = lineNumber
+				result.Reason = "chain hash mismatch"
+				return result, nil
+			}
+
+			if keyed && isCheckpointRecord(payload) {
+				if reason := verifyCheckpoint(payload, prev, checkpointKey, result.Records); reason != "" {
+					result.BrokenLine = lineNumber
+					result.Reason = reason
+					return result, nil
+				}
+				if checkpointInterval > 0 && sinceCheckpoint != checkpointInterval {
+					result.BrokenLine = lineNumber
+					result.Reason = fmt.Sprintf("checkpoint after %d records, expected %d", sinceCheckpoint, checkpointInterval)
+					return result, nil
+				}
+				result.Checkpoints++
+				sinceCheckpoint = 0
+			} else {
+				if keyed && checkpointInterval > 0 && sinceCheckpoint >= checkpointInterval {
+					result.BrokenLine = lineNumber
+					result.Reason = "missing checkpoint"
+					return result, nil
+				}
+				sinceCheckpoint++
+			}
+
+			prev = sum
+			result.Records++
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return result, nil
+			}
+			return result, err
+		}
+	}
+}
+
+// checkpointRecord is the payload of a checkpoint record.
+type checkpointRecord struct {
+	Checkpoint *struct {
+		Records *int `json:"records"`
+	} `json:"_checkpoint"`
+	HMAC string `json:"_hmac"`
+}
+
+// isCheckpointRecord reports whether a chained payload is a checkpoint record.
+func isCheckpointRecord(payload []byte) bool {
+	if !bytes.Contains(payload, []byte(`"`+hashChainCheckpoint+`"`)) {
+		return false
+	}
+	var record checkpointRecord
+	return json.Unmarshal(payload, &record) == nil && record.Checkpoint != nil && record.HMAC != ""
+}
+
+// verifyCheckpoint checks a checkpoint record against the chain head and the number of records that preceded it.
+// It returns why the checkpoint is invalid, or "" if it verifies.
+func verifyCheckpoint(payload []byte, head [sha256.Size]byte, key []byte, records int) string {
+	var record checkpointRecord
+	if err := json.Unmarshal(payload, &record); err != nil || record.Checkpoint.Records == nil {
+		return "checkpoint has no record count"
+	}
+	signature, err := hex.DecodeString(record.HMAC)
+	if err != nil || !hmac.Equal(signature, checkpointMAC(key, head, *record.Checkpoint.Records)) {
+		return "checkpoint signature mismatch"
+	}
+	if *record.Checkpoint.Records != records {
+		return fmt.Sprintf("checkpoint covers %d records, found %d", *record.Checkpoint.Records, records)
+	}
+	return ""
+}
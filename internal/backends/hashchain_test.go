@@ -0,0 +1,325 @@
+package backends
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"log-enricher/internal/models"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func verifyFile(t *testing.T, path string, key []byte, interval int) HashChainVerification {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	result, err := VerifyHashChain(f, key, interval)
+	require.NoError(t, err)
+	return result
+}
+
+func TestHashChainFileBackend_WritesVerifiableChain(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "access.log")
+	backend := NewHashChainFileBackend(".enriched", nil, 0)
+
+	require.NoError(t, backend.Send(&models.LogEntry{
+		SourcePath: sourcePath,
+		Fields:     map[string]interface{}{"level": "info", "msg": "hello"},
+	}))
+	require.NoError(t, backend.Send(&models.LogEntry{
+		SourcePath: sourcePath,
+		LogLine:    []byte("plain-text-line\n"),
+		Fields:     map[string]interface{}{},
+	}))
+	require.NoError(t, backend.Send(&models.LogEntry{
+		SourcePath: sourcePath,
+		Fields:     map[string]interface{}{},
+	}))
+	backend.Shutdown()
+
+	content, err := os.ReadFile(sourcePath + ".enriched")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 3)
+
+	var first map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "hello", first["msg"])
+	assert.Len(t, first[hashChainField], hashChainHashHexSize)
+
+	var second map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "plain-text-line", second["line"])
+
+	result := verifyFile(t, sourcePath+".enriched", nil, 0)
+	assert.True(t, result.Intact())
+	assert.Equal(t, 3, result.Records)
+}
+
+func TestHashChainFileBackend_ResumesChainAfterReopen(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "resume.log")
+	backend := NewHashChainFileBackend(".enriched", nil, 0)
+	defer backend.Shutdown()
+
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("one")}))
+	backend.CloseWriter(sourcePath)
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("two")}))
+	backend.CloseWriter(sourcePath)
+
+	result := verifyFile(t, sourcePath+".enriched", nil, 0)
+	assert.True(t, result.Intact())
+	assert.Equal(t, 2, result.Records)
+}
+
+func TestHashChain_ResumeReadsBackToTheLastCheckpoint(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "tail.log")
+	key := []byte("secret")
+	backend := NewHashChainFileBackend(".enriched", key, 2)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("line")}))
+	}
+	backend.Shutdown()
+
+	// Records before the last checkpoint are not read, so damaging the first one does not matter.
+	content, err := os.ReadFile(sourcePath + ".enriched")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.Len(t, lines, 7)
+	lines[0] = "not chained"
+	require.NoError(t, os.WriteFile(sourcePath+".enriched", []byte(strings.Join(lines, "\n")+"\n"), 0644))
+
+	f, err := os.Open(sourcePath + ".enriched")
+	require.NoError(t, err)
+	defer f.Close()
+	chain := newHashChain(key, 2)
+	require.NoError(t, chain.resume(f))
+	assert.Equal(t, 7, chain.records)
+	assert.Equal(t, 1, chain.sinceCheckpoint)
+	_, head, ok := splitHashField([]byte(lines[6]))
+	require.True(t, ok)
+	assert.Equal(t, head, chain.prev)
+}
+
+func TestReadRecordsBackward(t *testing.T) {
+	var content strings.Builder
+	var want []string
+	for i := 0; i < 5000; i++ {
+		line := fmt.Sprintf("record %d %s", i, strings.Repeat("x", i%100))
+		want = append([]string{line}, want...)
+		content.WriteString(line)
+		if i%7 == 0 {
+			content.WriteString("\r\n\n")
+		} else if i < 4999 {
+			content.WriteString("\n")
+		}
+	}
+
+	var got []string
+	data := []byte(content.String())
+	require.NoError(t, readRecordsBackward(bytes.NewReader(data), int64(len(data)), func(record []byte) bool {
+		got = append(got, string(record))
+		return true
+	}))
+	assert.Equal(t, want, got)
+
+	got = nil
+	require.NoError(t, readRecordsBackward(bytes.NewReader(data), int64(len(data)), func(record []byte) bool {
+		got = append(got, string(record))
+		return len(got) < 3
+	}))
+	assert.Equal(t, want[:3], got)
+}
+
+func TestHashChainFileBackend_RefusesToAppendToUnchainedFile(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "legacy.log")
+	require.NoError(t, os.WriteFile(sourcePath+".enriched", []byte("legacy line\n"), 0644))
+
+	backend := NewHashChainFileBackend(".enriched", nil, 0)
+	defer backend.Shutdown()
+
+	err := backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("new")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not part of a hash chain")
+}
+
+func TestHashChainFileBackend_SignedCheckpoints(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "signed.log")
+	key := []byte("secret")
+	backend := NewHashChainFileBackend(".enriched", key, 2)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("line")}))
+	}
+	backend.Shutdown()
+
+	content, err := os.ReadFile(sourcePath + ".enriched")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	// 5 records plus a checkpoint after every second record.
+	require.Len(t, lines, 7)
+	assert.Contains(t, lines[2], hashChainCheckpoint)
+	assert.Contains(t, lines[5], hashChainCheckpoint)
+
+	result := verifyFile(t, sourcePath+".enriched", key, 2)
+	assert.True(t, result.Intact())
+	assert.Equal(t, 7, result.Records)
+	assert.Equal(t, 2, result.Checkpoints)
+
+	wrongKey := verifyFile(t, sourcePath+".enriched", []byte("wrong"), 2)
+	assert.False(t, wrongKey.Intact())
+	assert.Equal(t, 3, wrongKey.BrokenLine)
+	assert.Equal(t, "checkpoint signature mismatch", wrongKey.Reason)
+}
+
+func TestHashChainFileBackend_CheckpointCadenceSurvivesReopen(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "reopen.log")
+	key := []byte("secret")
+	backend := NewHashChainFileBackend(".enriched", key, 2)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("before")}))
+	}
+	backend.CloseWriter(sourcePath)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("after")}))
+	}
+	backend.Shutdown()
+
+	result := verifyFile(t, sourcePath+".enriched", key, 2)
+	assert.True(t, result.Intact(), result.Reason)
+	assert.Equal(t, 9, result.Records)
+	assert.Equal(t, 3, result.Checkpoints)
+}
+
+func TestVerifyHashChain_DetectsStrippedCheckpoints(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "stripped.log")
+	key := []byte("secret")
+	backend := NewHashChainFileBackend(".enriched", key, 2)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("line")}))
+	}
+	backend.Shutdown()
+
+	content, err := os.ReadFile(sourcePath + ".enriched")
+	require.NoError(t, err)
+
+	// Drop the checkpoints and recompute the unkeyed chain, as someone without the key could.
+	rechained := newHashChain(nil, 0)
+	var stripped []byte
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		payload, _, ok := splitHashField([]byte(line))
+		require.True(t, ok)
+		if isCheckpointRecord(payload) {
+			continue
+		}
+		stripped = append(append(stripped, rechained.seal(payload)...), '\n')
+	}
+
+	unkeyed, err := VerifyHashChain(bytes.NewReader(stripped), nil, 2)
+	require.NoError(t, err)
+	assert.True(t, unkeyed.Intact(), "without a key only the chain is checked")
+
+	keyed, err := VerifyHashChain(bytes.NewReader(stripped), key, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, keyed.BrokenLine)
+	assert.Equal(t, "missing checkpoint", keyed.Reason)
+
+	offInterval, err := VerifyHashChain(bytes.NewReader(content), key, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, offInterval.BrokenLine, "checkpoints must follow the configured interval")
+}
+
+func TestVerifyHashChain_ReportsFirstTamperedRecord(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "tamper.log")
+	backend := NewHashChainFileBackend(".enriched", nil, 0)
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, backend.Send(&models.LogEntry{
+			SourcePath: sourcePath,
+			Fields:     map[string]interface{}{"msg": msg},
+		}))
+	}
+	backend.Shutdown()
+
+	content, err := os.ReadFile(sourcePath + ".enriched")
+	require.NoError(t, err)
+
+	t.Run("edited record", func(t *testing.T) {
+		tampered := bytes.Replace(content, []byte(`"msg":"c"`), []byte(`"msg":"x"`), 1)
+		result, err := VerifyHashChain(bytes.NewReader(tampered), nil, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.BrokenLine)
+		assert.Equal(t, 2, result.Records)
+		assert.Equal(t, "chain hash mismatch", result.Reason)
+	})
+
+	t.Run("deleted record", func(t *testing.T) {
+		lines := bytes.SplitAfter(content, []byte{'\n'})
+		tampered := bytes.Join(append(lines[:1:1], lines[2:]...), nil)
+		result, err := VerifyHashChain(bytes.NewReader(tampered), nil, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.BrokenLine)
+	})
+
+	t.Run("inserted unchained record", func(t *testing.T) {
+		tampered := append([]byte("injected\n"), content...)
+		result, err := VerifyHashChain(bytes.NewReader(tampered), nil, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.BrokenLine)
+		assert.Equal(t, "record has no chain hash", result.Reason)
+	})
+}
+
+func TestHashChainFileBackend_SendBatchContinuesChain(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "batch.log")
+	backend := NewHashChainFileBackend(".enriched", []byte("secret"), 2)
+
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("single")}))
+	require.NoError(t, backend.SendBatch([]*models.LogEntry{
+		{SourcePath: sourcePath, LogLine: []byte("one")},
+		{SourcePath: sourcePath, LogLine: []byte("two")},
+		{SourcePath: sourcePath, Fields: map[string]interface{}{"msg": "three"}},
+	}))
+	backend.Shutdown()
+
+	result := verifyFile(t, sourcePath+".enriched", []byte("secret"), 2)
+	assert.True(t, result.Intact())
+	assert.Equal(t, 6, result.Records)
+	assert.Equal(t, 2, result.Checkpoints)
+}
+
+func TestHashChainFileBackend_RejectsReservedFields(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "forged.log")
+	key := []byte("secret")
+	backend := NewHashChainFileBackend(".enriched", key, 2)
+
+	forged := &models.LogEntry{SourcePath: sourcePath, Fields: map[string]interface{}{
+		hashChainCheckpoint: map[string]interface{}{"records": 1},
+		hashChainHMACField:  "00",
+	}}
+	err := backend.Send(forged)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reserved for the hash chain")
+
+	err = backend.SendBatch([]*models.LogEntry{
+		{SourcePath: sourcePath, LogLine: []byte("one")},
+		{SourcePath: sourcePath, Fields: map[string]interface{}{hashChainField: "x"}},
+		{SourcePath: sourcePath, LogLine: []byte("two")},
+	})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, []int{1}, batchErr.Failed)
+	backend.Shutdown()
+
+	result := verifyFile(t, sourcePath+".enriched", key, 2)
+	assert.True(t, result.Intact(), result.Reason)
+	assert.Equal(t, 3, result.Records)
+	assert.Equal(t, 1, result.Checkpoints)
+}
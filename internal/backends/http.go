@@ -0,0 +1,190 @@
+package backends
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/models"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	httpBackendTimeout = 10 * time.Second
+	httpMaxAttempts    = 3
+	// httpErrorBodyLimit caps how much of an error response is kept in a StatusError.
+	httpErrorBodyLimit = 1024
+)
+
+// httpRetryBackoff is the wait before the first retry of a push; it doubles with every further attempt.
+var httpRetryBackoff = 500 * time.Millisecond
+
+// StatusError is returned when an HTTP endpoint answers a push with an unsuccessful status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether sending again may succeed. Rate limiting and server errors are temporary; other
+// statuses, e.g. a rejected payload, fail the same way on every attempt.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsPermanent reports whether err, or an error it wraps, is a *StatusError that is not Temporary. Other errors,
+// e.g. connection failures, may go away and are not considered permanent.
+func IsPermanent(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && !statusErr.Temporary()
+}
+
+// HTTPBackend posts batches of enriched entries as newline-delimited JSON to an HTTP endpoint.
+type HTTPBackend struct {
+	url    string
+	client *http.Client
+}
+
+// httpRecord is the JSON object an entry is posted as. Entries without fields carry their raw line instead.
+type httpRecord struct {
+	Timestamp time.Time              `json:"timestamp"`
+	App       string                 `json:"app,omitempty"`
+	Source    string                 `json:"source,omitempty"`
+	Tenant    string                 `json:"tenant,omitempty"`
+	Labels    map[string]string      `json:"labels,omitempty"`
+	Metadata  map[string]string      `json:"structured_metadata,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Line      string                 `json:"line,omitempty"`
+}
+
+// NewHTTPBackend creates a backend that posts to endpoint, which must be an http or https URL.
+func NewHTTPBackend(endpoint string) (*HTTPBackend, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("HTTP backend URL is empty")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTTP backend URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("HTTP backend URL must use http or https, got %q", endpoint)
+	}
+
+	slog.Info("HTTP backend enabled, sending logs to", "url", endpoint)
+	return &HTTPBackend{url: endpoint, client: &http.Client{Timeout: httpBackendTimeout}}, nil
+}
+
+func (b *HTTPBackend) Name() string {
+	return "http"
+}
+
+func (b *HTTPBackend) Send(entry *models.LogEntry) error {
+	return b.SendBatch([]*models.LogEntry{entry})
+}
+
+// SendBatch posts all entries in order with a single request. Entries that cannot be encoded are reported in a
+// *BatchError; if the request fails, all entries are.
+func (b *HTTPBackend) SendBatch(entries []*models.LogEntry) error {
+	var buf bytes.Buffer
+	var failed []int
+	var firstErr error
+	for i, entry := range entries {
+		record := httpRecord{
+			Timestamp: entry.Timestamp,
+			App:       entry.App,
+			Source:    entry.SourcePath,
+			Tenant:    entry.Tenant,
+			Labels:    entry.Labels,
+			Metadata:  entry.StructuredMetadata,
+			Fields:    entry.Fields,
+		}
+		if len(entry.Fields) == 0 {
+			record.Line = string(entry.LogLine)
+		}
+		payload, err := json.MarshalWithOption(record, json.UnorderedMap())
+		if err != nil {
+			failed = append(failed, i)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to marshal log entry: %w", err)
+			}
+			continue
+		}
+		buf.Write(payload)
+		buf.WriteByte('\n')
+	}
+
+	if buf.Len() > 0 {
+		if err := postWithRetry(b.client, b.url, "application/x-ndjson", buf.Bytes(), nil); err != nil {
+			all := make([]int, len(entries))
+			for i := range all {
+				all[i] = i
+			}
+			return &BatchError{Failed: all, Err: err}
+		}
+	}
+	if firstErr != nil {
+		return &BatchError{Failed: failed, Err: firstErr}
+	}
+	return nil
+}
+
+func (b *HTTPBackend) Shutdown() {
+	b.client.CloseIdleConnections()
+	slog.Info("HTTP backend shut down.")
+}
+
+// CloseWriter is a no-op, the backend keeps no per-file resources.
+func (b *HTTPBackend) CloseWriter(sourcePath string) {}
+
+// postWithRetry posts body to endpoint, retrying connection errors and temporary statuses up to httpMaxAttempts
+// times. An unsuccessful final status is returned as a *StatusError.
+func postWithRetry(client *http.Client, endpoint, contentType string, body []byte, header http.Header) error {
+	backoff := httpRetryBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = post(client, endpoint, contentType, body, header)
+		if err == nil {
+			return nil
+		}
+		if statusErr, ok := err.(*StatusError); ok && !statusErr.Temporary() {
+			return err
+		}
+		if attempt == httpMaxAttempts {
+			return err
+		}
+		slog.Warn("Push failed, retrying", "url", endpoint, "error", err, "attempt", attempt, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func post(client *http.Client, endpoint, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create push request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, httpErrorBodyLimit))
+	return &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(message))}
+}
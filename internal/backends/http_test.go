@@ -0,0 +1,123 @@
+package backends
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ndjsonServer records the records of every request; the first failures requests are answered with status.
+type ndjsonServer struct {
+	mu       sync.Mutex
+	requests [][]map[string]interface{}
+	failures int
+	status   int
+}
+
+func (s *ndjsonServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, "unavailable", s.status)
+		return
+	}
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		records = append(records, record)
+	}
+	s.requests = append(s.requests, records)
+}
+
+func newTestHTTPBackend(t *testing.T, handler http.Handler) *HTTPBackend {
+	t.Helper()
+	backoff := httpRetryBackoff
+	httpRetryBackoff = time.Millisecond
+	t.Cleanup(func() { httpRetryBackoff = backoff })
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	backend, err := NewHTTPBackend(server.URL + "/ingest")
+	require.NoError(t, err)
+	t.Cleanup(backend.Shutdown)
+	return backend
+}
+
+func TestHTTPBackend_PostsBatchAsNDJSON(t *testing.T) {
+	server := &ndjsonServer{}
+	backend := newTestHTTPBackend(t, server)
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, backend.SendBatch([]*models.LogEntry{
+		{SourcePath: "/logs/a.log", App: "web", Timestamp: ts, LogLine: []byte("plain line")},
+		{SourcePath: "/logs/b.log", App: "api", Tenant: "team-a", Timestamp: ts, Labels: map[string]string{"env": "prod"},
+			LogLine: []byte(`{"msg":"x"}`), Fields: map[string]interface{}{"msg": "x"}},
+	}))
+
+	require.Len(t, server.requests, 1, "a batch is posted with a single request")
+	assert.Equal(t, []map[string]interface{}{
+		{"timestamp": "2024-05-01T12:00:00Z", "app": "web", "source": "/logs/a.log", "line": "plain line"},
+		{"timestamp": "2024-05-01T12:00:00Z", "app": "api", "source": "/logs/b.log", "tenant": "team-a",
+			"labels": map[string]interface{}{"env": "prod"}, "fields": map[string]interface{}{"msg": "x"}},
+	}, server.requests[0])
+}
+
+func TestHTTPBackend_RetriesTemporaryFailures(t *testing.T) {
+	server := &ndjsonServer{failures: 2, status: http.StatusServiceUnavailable}
+	backend := newTestHTTPBackend(t, server)
+
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: "/logs/a.log", LogLine: []byte("one")}))
+	assert.Len(t, server.requests, 1)
+}
+
+func TestHTTPBackend_ReportsFailedBatch(t *testing.T) {
+	server := &ndjsonServer{failures: 10, status: http.StatusBadRequest}
+	backend := newTestHTTPBackend(t, server)
+
+	err := backend.SendBatch([]*models.LogEntry{
+		{SourcePath: "/logs/a.log", LogLine: []byte("one")},
+		{SourcePath: "/logs/a.log", LogLine: []byte("two")},
+	})
+
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, []int{0, 1}, batchErr.Failed)
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.False(t, statusErr.Temporary())
+	assert.Equal(t, 9, server.failures, "permanent failures are not retried")
+	assert.True(t, IsPermanent(err))
+}
+
+func TestIsPermanent(t *testing.T) {
+	assert.True(t, IsPermanent(fmt.Errorf("push: %w", &StatusError{StatusCode: http.StatusBadRequest})))
+	assert.False(t, IsPermanent(&StatusError{StatusCode: http.StatusTooManyRequests}))
+	assert.False(t, IsPermanent(&StatusError{StatusCode: http.StatusBadGateway}))
+	assert.False(t, IsPermanent(errors.New("connection refused")))
+	assert.False(t, IsPermanent(nil))
+}
+
+func TestNewHTTPBackend_RejectsInvalidURL(t *testing.T) {
+	for _, endpoint := range []string{"", "ftp://example.com/ingest", "://bad"} {
+		_, err := NewHTTPBackend(endpoint)
+		assert.Error(t, err, endpoint)
+	}
+}
@@ -7,13 +7,16 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/goccy/go-json"
+	"github.com/golang/snappy"
 	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/loki-client-go/loki"
 	"github.com/grafana/loki-client-go/pkg/urlutil"
+	"github.com/grafana/loki/pkg/push"
 	"github.com/prometheus/common/model"
 )
 
@@ -21,7 +24,9 @@ const defaultLokiPushPath = "/loki/api/v1/push"
 
 // LokiBackend sends enriched logs to a Grafana Loki instance.
 type LokiBackend struct {
-	client *loki.Client
+	client     *loki.Client
+	pushURL    string
+	httpClient *http.Client // Sends the push requests of SendBatch.
 }
 
 // NewLokiBackend creates a new Loki backend.
@@ -75,7 +80,9 @@ func NewLokiBackend(lokiURL string) (*LokiBackend, error) {
 	slog.Info("Loki backend enabled, sending logs to", "url", lokiURL)
 
 	b := &LokiBackend{
-		client: client,
+		client:     client,
+		pushURL:    u.String(),
+		httpClient: &http.Client{Timeout: cfg.Timeout},
 	}
 	return b, nil
 }
@@ -101,39 +108,161 @@ func (b *LokiBackend) Name() string {
 	return "loki"
 }
 
-// Send now accepts a LogEntry struct directly.
+// Send hands the entry to the Loki client, which batches pushes by size and wait time itself.
 func (b *LokiBackend) Send(entry *models.LogEntry) error {
-	// Use the App field from the LogEntry for the 'app' label.
-	// Fallback to "log-enricher" if App is empty.
-	appName := strings.Clone(entry.App)
-	if appName == "" {
-		appName = "log-enricher"
-	}
-
-	labels := model.LabelSet{
-		"job":         "log-enricher",
-		"source_file": model.LabelValue(strings.Clone(filepath.Base(entry.SourcePath))),
-		"app":         model.LabelValue(appName),
-	}
+	labels := streamLabels(entry)
 
 	// Manually copy the timestamp struct.
 	// This creates an independent copy of the time.Time value.
 	timestampCopy := entry.Timestamp
 
-	// If there are no fields (no JSON) send the log line as the log message
-	if len(entry.Fields) == 0 {
-		return b.client.Handle(labels, timestampCopy, string(entry.LogLine))
+	line, err := lokiLine(entry)
+	if err != nil {
+		return err
 	}
+	return b.client.HandleWithMetadata(labels, timestampCopy, line, structuredMetadata(entry))
+}
 
-	// Marshal the Fields map to JSON for the log line content.
-	// This ensures all processed fields, including any added by pipeline stages, are sent.
+// lokiLine returns the entry's fields as JSON, so all processed fields, including any added by pipeline stages,
+// are sent. Entries without fields (no JSON) are sent as their log line.
+func lokiLine(entry *models.LogEntry) (string, error) {
+	if len(entry.Fields) == 0 {
+		return string(entry.LogLine), nil
+	}
 	entryAsBytes, err := json.MarshalWithOption(entry.Fields, json.UnorderedMap())
 	if err != nil {
 		slog.Error("Failed to marshal log entry fields to JSON", "error", err)
-		return fmt.Errorf("failed to marshal log entry fields to JSON: %w", err)
+		return "", fmt.Errorf("failed to marshal log entry fields to JSON: %w", err)
+	}
+	return string(entryAsBytes), nil
+}
+
+// streamLabels returns the default labels merged with the entry's labels. Entry labels override the
+// job and source_file defaults; app always follows entry.App, falling back to "log-enricher".
+// Labels Loki would reject (reserved "__" names, invalid names, empty values) are skipped.
+// The entry's tenant, if any, selects the Loki tenant.
+func streamLabels(entry *models.LogEntry) model.LabelSet {
+	labels := make(model.LabelSet, len(entry.Labels)+3)
+	labels["job"] = "log-enricher"
+	labels["source_file"] = model.LabelValue(strings.Clone(filepath.Base(entry.SourcePath)))
+
+	for name, value := range entry.Labels {
+		if value == "" || strings.HasPrefix(name, "__") || !model.LabelName(name).IsValidLegacy() {
+			continue
+		}
+		// Entries may go back to the entry pool after Send, while the client still holds the labels.
+		labels[model.LabelName(strings.Clone(name))] = model.LabelValue(strings.Clone(value))
+	}
+
+	appName := strings.Clone(entry.App)
+	if appName == "" {
+		appName = "log-enricher"
+	}
+	labels["app"] = model.LabelValue(appName)
+	if entry.Tenant != "" {
+		// The Loki client sends entries with this label to the tenant as X-Scope-OrgID and removes the label.
+		labels[loki.ReservedLabelTenantID] = model.LabelValue(strings.Clone(entry.Tenant))
+	}
+	return labels
+}
+
+// structuredMetadata returns the entry's structured metadata sorted by name, skipping empty names and values.
+func structuredMetadata(entry *models.LogEntry) push.LabelsAdapter {
+	if len(entry.StructuredMetadata) == 0 {
+		return nil
 	}
+	metadata := make(push.LabelsAdapter, 0, len(entry.StructuredMetadata))
+	for name, value := range entry.StructuredMetadata {
+		if name == "" || value == "" {
+			continue
+		}
+		// Like labels, the metadata outlives the pooled entry in the client's batch.
+		metadata = append(metadata, push.LabelAdapter{Name: strings.Clone(name), Value: strings.Clone(value)})
+	}
+	slices.SortFunc(metadata, func(a, b push.LabelAdapter) int { return strings.Compare(a.Name, b.Name) })
+	return metadata
+}
 
-	return b.client.Handle(labels, timestampCopy, string(entryAsBytes))
+// SendBatch pushes the entries with one push request per tenant, bypassing the client's own batching. Entries of
+// a stream keep their order. Entries that cannot be encoded, and all entries of a tenant whose push failed, are
+// reported in a *BatchError.
+func (b *LokiBackend) SendBatch(entries []*models.LogEntry) error {
+	var batchErr *BatchError
+	fail := func(indices []int, err error) {
+		if batchErr == nil {
+			batchErr = &BatchError{Err: err}
+		}
+		batchErr.Failed = append(batchErr.Failed, indices...)
+	}
+
+	var tenants []string
+	requests := make(map[string]*lokiPushRequest)
+	for i, entry := range entries {
+		line, err := lokiLine(entry)
+		if err != nil {
+			fail([]int{i}, err)
+			continue
+		}
+		request, ok := requests[entry.Tenant]
+		if !ok {
+			request = &lokiPushRequest{streams: make(map[string]int)}
+			requests[entry.Tenant] = request
+			tenants = append(tenants, entry.Tenant)
+		}
+		request.add(i, entry, line)
+	}
+
+	for _, tenant := range tenants {
+		request := requests[tenant]
+		if err := b.push(tenant, &request.req); err != nil {
+			fail(request.indices, err)
+		}
+	}
+	if batchErr != nil {
+		slices.Sort(batchErr.Failed)
+		return batchErr
+	}
+	return nil
+}
+
+// lokiPushRequest collects the entries of one tenant, grouped into streams by label set.
+type lokiPushRequest struct {
+	req     push.PushRequest
+	streams map[string]int // Index into req.Streams by label set.
+	indices []int          // Indices of the entries in the SendBatch slice.
+}
+
+func (r *lokiPushRequest) add(index int, entry *models.LogEntry, line string) {
+	labels := streamLabels(entry)
+	// The tenant is sent as X-Scope-OrgID, not as a label.
+	delete(labels, loki.ReservedLabelTenantID)
+	key := labels.String()
+
+	stream, ok := r.streams[key]
+	if !ok {
+		stream = len(r.req.Streams)
+		r.streams[key] = stream
+		r.req.Streams = append(r.req.Streams, push.Stream{Labels: key})
+	}
+	r.req.Streams[stream].Entries = append(r.req.Streams[stream].Entries, push.Entry{
+		Timestamp:          entry.Timestamp,
+		Line:               line,
+		StructuredMetadata: structuredMetadata(entry),
+	})
+	r.indices = append(r.indices, index)
+}
+
+// push sends a push request as snappy-compressed protobuf, as the Loki client does.
+func (b *LokiBackend) push(tenant string, req *push.PushRequest) error {
+	payload, err := req.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal Loki push request: %w", err)
+	}
+	var header http.Header
+	if tenant != "" {
+		header = http.Header{"X-Scope-OrgID": []string{tenant}}
+	}
+	return postWithRetry(b.httpClient, b.pushURL, "application/x-protobuf", snappy.Encode(nil, payload), header)
 }
 
 // CloseWriter is a no-op for LokiBackend as it doesn't manage per-file resources.
@@ -1,8 +1,20 @@
 package backends
 
 import (
+	"errors"
+	"io"
+	"log-enricher/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/loki/pkg/push"
+	"github.com/prometheus/common/model"
 )
 
 func TestNewLokiBackend_EmptyURLReturnsError(t *testing.T) {
@@ -59,3 +71,158 @@ func TestNormalizeLokiPushURL(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamLabels(t *testing.T) {
+	entry := &models.LogEntry{
+		App:        "",
+		SourcePath: "/cache/promtail/web/access.log",
+		Labels: map[string]string{
+			"job":       "nginx",
+			"namespace": "prod",
+			"app":       "ignored",
+			"__path__":  "/var/log/access.log",
+			"bad-name":  "x",
+			"empty":     "",
+		},
+	}
+
+	got := streamLabels(entry)
+	want := model.LabelSet{
+		"job":         "nginx",
+		"source_file": "access.log",
+		"namespace":   "prod",
+		"app":         "log-enricher",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected labels: got %v, want %v", got, want)
+	}
+
+	entry.App = "web"
+	entry.Labels = nil
+	got = streamLabels(entry)
+	want = model.LabelSet{"job": "log-enricher", "source_file": "access.log", "app": "web"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected default labels: got %v, want %v", got, want)
+	}
+
+	entry.Tenant = "team-a"
+	entry.Labels = map[string]string{"__tenant_id__": "team-b"}
+	got = streamLabels(entry)
+	if got["__tenant_id__"] != "team-a" {
+		t.Fatalf("expected the entry's tenant to select the Loki tenant, got %v", got)
+	}
+}
+
+// lokiPushServer is a fake Loki that records the push requests it receives, per tenant.
+type lokiPushServer struct {
+	mu       sync.Mutex
+	pushes   map[string][]push.Stream
+	requests int
+	status   int
+}
+
+func newLokiPushServer(t *testing.T) (*lokiPushServer, *LokiBackend) {
+	t.Helper()
+	s := &lokiPushServer{pushes: make(map[string][]push.Stream), status: http.StatusNoContent}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" {
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		decoded, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("invalid snappy payload: %v", err)
+		}
+		var req push.PushRequest
+		if err := req.Unmarshal(decoded); err != nil {
+			t.Errorf("invalid push request: %v", err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if s.status != http.StatusNoContent {
+			http.Error(w, "rejected", s.status)
+			return
+		}
+		tenant := r.Header.Get("X-Scope-OrgID")
+		s.pushes[tenant] = append(s.pushes[tenant], req.Streams...)
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(server.Close)
+
+	backend, err := NewLokiBackend(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(backend.Shutdown)
+	return s, backend
+}
+
+func TestLokiBackend_SendBatchPushesOneRequestPerTenant(t *testing.T) {
+	server, backend := newLokiPushServer(t)
+
+	ts := time.Unix(1700000000, 0).UTC()
+	entries := []*models.LogEntry{
+		{SourcePath: "/logs/a.log", App: "web", LogLine: []byte("one"), Timestamp: ts},
+		{SourcePath: "/logs/b.log", App: "web", LogLine: []byte("two"), Timestamp: ts},
+		{SourcePath: "/logs/a.log", App: "web", Tenant: "team-a", LogLine: []byte("three"), Timestamp: ts},
+		{SourcePath: "/logs/a.log", App: "web", Fields: map[string]interface{}{"msg": "four"}, Timestamp: ts,
+			StructuredMetadata: map[string]string{"trace_id": "abc", "pod": "web-1", "empty": ""}},
+	}
+	if err := backend.SendBatch(entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if server.requests != 2 {
+		t.Fatalf("expected one push request per tenant, got %d", server.requests)
+	}
+	streams := server.pushes[""]
+	if len(streams) != 2 {
+		t.Fatalf("expected two streams for the default tenant, got %v", streams)
+	}
+	if streams[0].Labels != `{app="web", job="log-enricher", source_file="a.log"}` {
+		t.Fatalf("unexpected stream labels: %s", streams[0].Labels)
+	}
+	var lines []string
+	for _, entry := range streams[0].Entries {
+		lines = append(lines, entry.Line)
+	}
+	if !reflect.DeepEqual(lines, []string{"one", `{"msg":"four"}`}) {
+		t.Fatalf("unexpected stream entries: %v", lines)
+	}
+	wantMetadata := push.LabelsAdapter{{Name: "pod", Value: "web-1"}, {Name: "trace_id", Value: "abc"}}
+	if !reflect.DeepEqual(streams[0].Entries[1].StructuredMetadata, wantMetadata) {
+		t.Fatalf("expected structured metadata apart from the stream labels, got %v", streams[0].Entries[1].StructuredMetadata)
+	}
+	if !streams[0].Entries[0].Timestamp.Equal(ts) {
+		t.Fatalf("unexpected timestamp: %v", streams[0].Entries[0].Timestamp)
+	}
+
+	tenant := server.pushes["team-a"]
+	if len(tenant) != 1 || strings.Contains(tenant[0].Labels, "__tenant_id__") || tenant[0].Entries[0].Line != "three" {
+		t.Fatalf("expected the tenant's entries without the tenant label, got %v", tenant)
+	}
+}
+
+func TestLokiBackend_SendBatchReportsRejectedPushes(t *testing.T) {
+	server, backend := newLokiPushServer(t)
+	server.status = http.StatusBadRequest
+
+	err := backend.SendBatch([]*models.LogEntry{
+		{SourcePath: "/logs/a.log", LogLine: []byte("one")},
+		{SourcePath: "/logs/a.log", LogLine: []byte("two")},
+	})
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || !reflect.DeepEqual(batchErr.Failed, []int{0, 1}) {
+		t.Fatalf("expected both entries to fail, got %v", err)
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest || statusErr.Temporary() {
+		t.Fatalf("expected a permanent status error, got %v", err)
+	}
+	if server.requests != 1 {
+		t.Fatalf("expected a rejected push not to be retried, got %d requests", server.requests)
+	}
+}
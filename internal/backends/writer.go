@@ -0,0 +1,91 @@
+package backends
+
+import (
+	"errors"
+	"io"
+	"log-enricher/internal/models"
+	"sync"
+	"syscall"
+)
+
+// WriterBackend writes enriched entries to a single writer, e.g. stdout, in the file backend's record format.
+// Every Send is written right away, so a reader at the other end of a pipe sees entries as they arrive.
+type WriterBackend struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closed bool
+}
+
+// NewWriterBackend creates a backend that writes to w.
+func NewWriterBackend(w io.Writer) *WriterBackend {
+	return &WriterBackend{w: w}
+}
+
+func (b *WriterBackend) Name() string {
+	return "writer"
+}
+
+func (b *WriterBackend) Send(entry *models.LogEntry) error {
+	buf, err := appendPlainRecord(nil, entry)
+	if err != nil {
+		return err
+	}
+	return b.write(buf)
+}
+
+func (b *WriterBackend) SendBatch(entries []*models.LogEntry) error {
+	var buf []byte
+	var failed []int
+	var firstErr error
+	for i, entry := range entries {
+		next, err := appendPlainRecord(buf, entry)
+		if err != nil {
+			failed = append(failed, i)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		buf = next
+	}
+
+	if err := b.write(buf); err != nil {
+		all := make([]int, len(entries))
+		for i := range all {
+			all[i] = i
+		}
+		return &BatchError{Failed: all, Err: err}
+	}
+	if firstErr != nil {
+		return &BatchError{Failed: failed, Err: firstErr}
+	}
+	return nil
+}
+
+// write writes buf unless the reader went away. A closed pipe is not an error: like other Unix filters, the
+// backend stops writing and later entries are discarded, see Closed.
+func (b *WriterBackend) write(buf []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(buf) == 0 {
+		return nil
+	}
+	if _, err := b.w.Write(buf); err != nil {
+		if errors.Is(err, syscall.EPIPE) {
+			b.closed = true
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Closed reports whether the reader closed its end of the pipe.
+func (b *WriterBackend) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *WriterBackend) Shutdown()                     {}
+func (b *WriterBackend) CloseWriter(sourcePath string) {}
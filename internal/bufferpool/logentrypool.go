@@ -64,6 +64,9 @@ func (op *logEntryPool) Release(obj *models.LogEntry) {
 
 	obj.Timestamp = time.Time{}
 	obj.App = ""
+	obj.Labels = nil
+	obj.StructuredMetadata = nil
+	obj.Tenant = ""
 
 	op.pool <- obj
 }
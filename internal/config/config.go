@@ -7,50 +7,204 @@ import (
 	"strings"
 )
 
+// Labels the Promtail receiver derives the app and source path from when no template applies. Inputs that get a
+// Config without them, or with only blank names, fall back to these as well.
+var (
+	DefaultPromtailHTTPAppLabels    = []string{"app", "service", "job"}
+	DefaultPromtailHTTPSourceLabels = []string{"filename", "__path__", "path", "source"}
+)
+
 type Config struct {
-	StateFilePath            string
-	LogBasePath              string
-	LogFileExtensions        []string
-	LogFilesIgnored          string
-	Backend                  string
-	LokiURL                  string
-	EnrichedFileSuffix       string
-	AppName                  string
-	AppIdentificationRegex   string
-	LogLevel                 string
-	PromtailHTTPEnabled      bool
-	PromtailHTTPAddr         string
-	PromtailHTTPMaxBodyBytes int
-	PromtailHTTPBearerToken  string
-	PromtailHTTPSourceRoot   string
-	Stages                   []StageConfig
+	StateFilePath               string
+	BackfillCheckpointLines     int
+	BackfillCheckpointMs        int
+	LogBasePath                 string
+	LogFileExtensions           []string
+	LogFilesIgnored             string
+	Backend                     string
+	LokiURL                     string
+	HTTPBackendURL              string
+	EnrichedFileSuffix          string
+	FileHashChainEnabled        bool
+	FileHashChainHMACKey        string
+	FileHashChainCheckpoint     int
+	BackendAsyncEnabled         bool
+	BackendQueueSize            int
+	BackendBatchSize            int
+	BackendBatchMaxWaitMs       int
+	PipelineWorkers             int
+	PipelineQueueSize           int
+	AppName                     string
+	AppIdentificationRegex      string
+	LogLevel                    string
+	DeadLetterSink              string
+	DeadLetterPath              string
+	PromtailHTTPEnabled         bool
+	PromtailHTTPAddr            string
+	PromtailHTTPMaxBodyBytes    int
+	PromtailHTTPBearerToken     string
+	PromtailHTTPSourceRoot      string
+	PromtailHTTPAppTemplate     string
+	PromtailHTTPAppLabels       []string
+	PromtailHTTPSourceTemplate  string
+	PromtailHTTPSourceLabels    []string
+	PromtailHTTPTLSCertFile     string
+	PromtailHTTPTLSKeyFile      string
+	PromtailHTTPTLSClientCAFile string
+	PromtailHTTPTenantsFile     string
+	// Requests processed at once and requests waiting for a slot; further requests get a 503.
+	PromtailHTTPMaxConcurrentRequests int
+	PromtailHTTPQueueSize             int
+	// Token bucket rate limits per tenant and per stream; a zero rate disables the bucket.
+	PromtailHTTPTenantRateBytes  int
+	PromtailHTTPTenantBurstBytes int
+	PromtailHTTPTenantRateLines  int
+	PromtailHTTPTenantBurstLines int
+	PromtailHTTPStreamRateBytes  int
+	PromtailHTTPStreamBurstBytes int
+	PromtailHTTPStreamRateLines  int
+	PromtailHTTPStreamBurstLines int
+	// Optional directory of the durable spool; pushes are acknowledged once persisted there.
+	PromtailHTTPSpoolDir            string
+	PromtailHTTPSpoolMaxBytes       int
+	PromtailHTTPSpoolSegmentBytes   int
+	PromtailHTTPSpoolMaxAttempts    int
+	SyslogEnabled                   bool
+	SyslogUDPAddr                   string
+	SyslogTCPAddr                   string
+	SyslogUnixSocket                string
+	SyslogAppTemplate               string
+	SyslogSourceTemplate            string
+	SyslogSourceRoot                string
+	SyslogMaxMessageBytes           int
+	FluentForwardEnabled            bool
+	FluentForwardAddr               string
+	FluentForwardSharedKey          string
+	FluentForwardHostname           string
+	FluentForwardAppTemplate        string
+	FluentForwardSourceTemplate     string
+	FluentForwardSourceRoot         string
+	FluentForwardMaxMessageBytes    int
+	FluentForwardHandshakeTimeoutMs int
+	GelfEnabled                     bool
+	GelfUDPAddr                     string
+	GelfTCPAddr                     string
+	GelfAppTemplate                 string
+	GelfSourceTemplate              string
+	GelfSourceRoot                  string
+	GelfMaxMessageBytes             int
+	GelfChunkTimeoutMs              int
+	GelfMaxChunkedMessages          int
+	InputMaxSources                 int
+	InputSourceIdleTimeoutMs        int
+	Stages                          []StageConfig
+	Multiline                       []MultilineConfig
 }
 
 // StageConfig holds the configuration for a single pipeline stage.
 type StageConfig struct {
 	Type      string
 	AppliesTo string
-	Params    map[string]interface{}
+	// Tenants restricts the stage to entries of these receiver tenants; the stage applies to all entries if empty.
+	Tenants []string
+	Params  map[string]interface{}
+}
+
+// MultilineConfig combines consecutive lines of matching tailed files into one entry, e.g. a stack trace.
+type MultilineConfig struct {
+	AppliesTo string
+	// Mode is "start" (Pattern matches the first line of an event), "continuation" (Pattern matches lines that
+	// belong to the previous one) or "indent" (lines starting with a space or tab belong to the previous one).
+	Mode           string
+	Pattern        string
+	MaxLines       int
+	MaxBytes       int
+	FlushTimeoutMs int
 }
 
 func Load() *Config {
 	cfg := &Config{
-		StateFilePath:            getEnv("STATE_FILE_PATH", "/cache/state.json"),
-		LogBasePath:              getEnv("LOG_BASE_PATH", "/logs"),
-		LogFilesIgnored:          getEnv("LOG_FILES_IGNORED", ""),
-		LogFileExtensions:        getEnvSlice("LOG_FILE_EXTENSIONS", []string{".log"}),
-		Backend:                  getEnv("BACKEND", "file"),
-		LokiURL:                  getEnv("LOKI_URL", ""),
-		EnrichedFileSuffix:       getEnv("ENRICHED_FILE_SUFFIX", ".enriched"),
-		AppName:                  getEnv("APP_NAME", ""),
-		AppIdentificationRegex:   getEnv("APP_IDENTIFICATION_REGEX", ""),
-		LogLevel:                 getEnv("LOG_LEVEL", "INFO"),
-		PromtailHTTPEnabled:      getEnvBool("PROMTAIL_HTTP_ENABLED", false),
-		PromtailHTTPAddr:         getEnv("PROMTAIL_HTTP_ADDR", "0.0.0.0:3500"),
-		PromtailHTTPMaxBodyBytes: getEnvInt("PROMTAIL_HTTP_MAX_BODY_BYTES", 10*1024*1024),
-		PromtailHTTPBearerToken:  getEnv("PROMTAIL_HTTP_BEARER_TOKEN", ""),
-		PromtailHTTPSourceRoot:   getEnv("PROMTAIL_HTTP_SOURCE_ROOT", "/cache/promtail"),
-		Stages:                   loadStages(),
+		StateFilePath:                     getEnv("STATE_FILE_PATH", "/cache/state.json"),
+		BackfillCheckpointLines:           getEnvInt("BACKFILL_CHECKPOINT_LINES", 10000),
+		BackfillCheckpointMs:              getEnvInt("BACKFILL_CHECKPOINT_INTERVAL_MS", 10000),
+		LogBasePath:                       getEnv("LOG_BASE_PATH", "/logs"),
+		LogFilesIgnored:                   getEnv("LOG_FILES_IGNORED", ""),
+		LogFileExtensions:                 getEnvSlice("LOG_FILE_EXTENSIONS", []string{".log"}),
+		Backend:                           getEnv("BACKEND", "file"),
+		LokiURL:                           getEnv("LOKI_URL", ""),
+		HTTPBackendURL:                    getEnv("HTTP_BACKEND_URL", ""),
+		EnrichedFileSuffix:                getEnv("ENRICHED_FILE_SUFFIX", ".enriched"),
+		FileHashChainEnabled:              getEnvBool("FILE_HASH_CHAIN_ENABLED", false),
+		FileHashChainHMACKey:              getEnv("FILE_HASH_CHAIN_HMAC_KEY", ""),
+		FileHashChainCheckpoint:           getEnvInt("FILE_HASH_CHAIN_CHECKPOINT_INTERVAL", 1000),
+		BackendAsyncEnabled:               getEnvBool("BACKEND_ASYNC_ENABLED", false),
+		BackendQueueSize:                  getEnvInt("BACKEND_QUEUE_SIZE", 10000),
+		BackendBatchSize:                  getEnvInt("BACKEND_BATCH_SIZE", 500),
+		BackendBatchMaxWaitMs:             getEnvInt("BACKEND_BATCH_MAX_WAIT_MS", 200),
+		PipelineWorkers:                   getEnvInt("PIPELINE_WORKERS", 0),
+		PipelineQueueSize:                 getEnvInt("PIPELINE_QUEUE_SIZE", 1000),
+		AppName:                           getEnv("APP_NAME", ""),
+		AppIdentificationRegex:            getEnv("APP_IDENTIFICATION_REGEX", ""),
+		LogLevel:                          getEnv("LOG_LEVEL", "INFO"),
+		DeadLetterSink:                    getEnv("DEAD_LETTER_SINK", ""),
+		DeadLetterPath:                    getEnv("DEAD_LETTER_PATH", "/cache/dead-letter.ndjson"),
+		PromtailHTTPEnabled:               getEnvBool("PROMTAIL_HTTP_ENABLED", false),
+		PromtailHTTPAddr:                  getEnv("PROMTAIL_HTTP_ADDR", "0.0.0.0:3500"),
+		PromtailHTTPMaxBodyBytes:          getEnvInt("PROMTAIL_HTTP_MAX_BODY_BYTES", 10*1024*1024),
+		PromtailHTTPBearerToken:           getEnv("PROMTAIL_HTTP_BEARER_TOKEN", ""),
+		PromtailHTTPSourceRoot:            getEnv("PROMTAIL_HTTP_SOURCE_ROOT", "/cache/promtail"),
+		PromtailHTTPAppTemplate:           getEnv("PROMTAIL_HTTP_APP_TEMPLATE", ""),
+		PromtailHTTPAppLabels:             getEnvSlice("PROMTAIL_HTTP_APP_LABELS", DefaultPromtailHTTPAppLabels),
+		PromtailHTTPSourceTemplate:        getEnv("PROMTAIL_HTTP_SOURCE_TEMPLATE", ""),
+		PromtailHTTPSourceLabels:          getEnvSlice("PROMTAIL_HTTP_SOURCE_LABELS", DefaultPromtailHTTPSourceLabels),
+		PromtailHTTPTLSCertFile:           getEnv("PROMTAIL_HTTP_TLS_CERT_FILE", ""),
+		PromtailHTTPTLSKeyFile:            getEnv("PROMTAIL_HTTP_TLS_KEY_FILE", ""),
+		PromtailHTTPTLSClientCAFile:       getEnv("PROMTAIL_HTTP_TLS_CLIENT_CA_FILE", ""),
+		PromtailHTTPTenantsFile:           getEnv("PROMTAIL_HTTP_TENANTS_FILE", ""),
+		PromtailHTTPMaxConcurrentRequests: getEnvInt("PROMTAIL_HTTP_MAX_CONCURRENT_REQUESTS", 32),
+		PromtailHTTPQueueSize:             getEnvInt("PROMTAIL_HTTP_QUEUE_SIZE", 256),
+		PromtailHTTPTenantRateBytes:       getEnvInt("PROMTAIL_HTTP_TENANT_RATE_BYTES", 0),
+		PromtailHTTPTenantBurstBytes:      getEnvInt("PROMTAIL_HTTP_TENANT_BURST_BYTES", 0),
+		PromtailHTTPTenantRateLines:       getEnvInt("PROMTAIL_HTTP_TENANT_RATE_LINES", 0),
+		PromtailHTTPTenantBurstLines:      getEnvInt("PROMTAIL_HTTP_TENANT_BURST_LINES", 0),
+		PromtailHTTPStreamRateBytes:       getEnvInt("PROMTAIL_HTTP_STREAM_RATE_BYTES", 0),
+		PromtailHTTPStreamBurstBytes:      getEnvInt("PROMTAIL_HTTP_STREAM_BURST_BYTES", 0),
+		PromtailHTTPStreamRateLines:       getEnvInt("PROMTAIL_HTTP_STREAM_RATE_LINES", 0),
+		PromtailHTTPStreamBurstLines:      getEnvInt("PROMTAIL_HTTP_STREAM_BURST_LINES", 0),
+		PromtailHTTPSpoolDir:              getEnv("PROMTAIL_HTTP_SPOOL_DIR", ""),
+		PromtailHTTPSpoolMaxBytes:         getEnvInt("PROMTAIL_HTTP_SPOOL_MAX_BYTES", 1024*1024*1024),
+		PromtailHTTPSpoolSegmentBytes:     getEnvInt("PROMTAIL_HTTP_SPOOL_SEGMENT_BYTES", 16*1024*1024),
+		PromtailHTTPSpoolMaxAttempts:      getEnvInt("PROMTAIL_HTTP_SPOOL_MAX_ATTEMPTS", 10),
+		SyslogEnabled:                     getEnvBool("SYSLOG_ENABLED", false),
+		SyslogUDPAddr:                     getEnvAllowEmpty("SYSLOG_UDP_ADDR", "0.0.0.0:5514"),
+		SyslogTCPAddr:                     getEnvAllowEmpty("SYSLOG_TCP_ADDR", "0.0.0.0:5514"),
+		SyslogUnixSocket:                  getEnv("SYSLOG_UNIX_SOCKET", ""),
+		SyslogAppTemplate:                 getEnv("SYSLOG_APP_TEMPLATE", `{{if .app_name}}{{.app_name}}{{else}}syslog{{end}}`),
+		SyslogSourceTemplate:              getEnv("SYSLOG_SOURCE_TEMPLATE", `{{.hostname}}/{{if .app_name}}{{.app_name}}{{else}}syslog{{end}}.log`),
+		SyslogSourceRoot:                  getEnv("SYSLOG_SOURCE_ROOT", "/cache/syslog"),
+		SyslogMaxMessageBytes:             getEnvInt("SYSLOG_MAX_MESSAGE_BYTES", 64*1024),
+		FluentForwardEnabled:              getEnvBool("FLUENT_FORWARD_ENABLED", false),
+		FluentForwardAddr:                 getEnv("FLUENT_FORWARD_ADDR", "0.0.0.0:24224"),
+		FluentForwardSharedKey:            getEnv("FLUENT_FORWARD_SHARED_KEY", ""),
+		FluentForwardHostname:             getEnv("FLUENT_FORWARD_HOSTNAME", ""),
+		FluentForwardAppTemplate:          getEnv("FLUENT_FORWARD_APP_TEMPLATE", "{{.tag}}"),
+		FluentForwardSourceTemplate:       getEnv("FLUENT_FORWARD_SOURCE_TEMPLATE", "{{.tag}}.log"),
+		FluentForwardSourceRoot:           getEnv("FLUENT_FORWARD_SOURCE_ROOT", "/cache/fluent"),
+		FluentForwardMaxMessageBytes:      getEnvInt("FLUENT_FORWARD_MAX_MESSAGE_BYTES", 16*1024*1024),
+		FluentForwardHandshakeTimeoutMs:   getEnvInt("FLUENT_FORWARD_HANDSHAKE_TIMEOUT_MS", 10000),
+		GelfEnabled:                       getEnvBool("GELF_ENABLED", false),
+		GelfUDPAddr:                       getEnvAllowEmpty("GELF_UDP_ADDR", "0.0.0.0:12201"),
+		GelfTCPAddr:                       getEnvAllowEmpty("GELF_TCP_ADDR", "0.0.0.0:12201"),
+		GelfAppTemplate:                   getEnv("GELF_APP_TEMPLATE", `{{if .container_name}}{{.container_name}}{{else}}gelf{{end}}`),
+		GelfSourceTemplate:                getEnv("GELF_SOURCE_TEMPLATE", `{{.host}}/{{if .container_name}}{{.container_name}}{{else}}gelf{{end}}.log`),
+		GelfSourceRoot:                    getEnv("GELF_SOURCE_ROOT", "/cache/gelf"),
+		GelfMaxMessageBytes:               getEnvInt("GELF_MAX_MESSAGE_BYTES", 1024*1024),
+		GelfChunkTimeoutMs:                getEnvInt("GELF_CHUNK_TIMEOUT_MS", 5000),
+		GelfMaxChunkedMessages:            getEnvInt("GELF_MAX_CHUNKED_MESSAGES", 1000),
+		InputMaxSources:                   getEnvInt("INPUT_MAX_SOURCES", 1000),
+		InputSourceIdleTimeoutMs:          getEnvInt("INPUT_SOURCE_IDLE_TIMEOUT_MS", 300000),
+		Stages:                            loadStages(),
+		Multiline:                         loadMultiline(),
 	}
 
 	return cfg
@@ -74,6 +228,11 @@ func loadStages() []StageConfig {
 		// Check for AppliesTo specifically
 		appliesToKey := fmt.Sprintf("STAGE_%d_APPLIES_TO", i)
 		stage.AppliesTo = getEnv(appliesToKey, "")
+		for _, tenant := range getEnvSlice(fmt.Sprintf("STAGE_%d_TENANTS", i), nil) {
+			if tenant = strings.TrimSpace(tenant); tenant != "" {
+				stage.Tenants = append(stage.Tenants, tenant)
+			}
+		}
 
 		// Find all STAGE_i_* variables and add them to the stage's params.
 		prefix := fmt.Sprintf("STAGE_%d_", i)
@@ -81,8 +240,8 @@ func loadStages() []StageConfig {
 			if strings.HasPrefix(e, prefix) {
 				parts := strings.SplitN(e, "=", 2)
 				key := strings.ToLower(strings.TrimPrefix(parts[0], prefix))
-				// Only add to params if it's not 'type' or 'applies_to'
-				if key != "type" && key != "applies_to" {
+				// Only add to params if it's not 'type', 'applies_to' or 'tenants'
+				if key != "type" && key != "applies_to" && key != "tenants" {
 					stage.Params[key] = parts[1]
 				}
 			}
@@ -92,6 +251,27 @@ func loadStages() []StageConfig {
 	return stages
 }
 
+// loadMultiline loads the multiline rules MULTILINE_0_*, MULTILINE_1_*, ... up to the first one without a mode.
+func loadMultiline() []MultilineConfig {
+	var rules []MultilineConfig
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("MULTILINE_%d_", i)
+		mode := getEnv(prefix+"MODE", "")
+		if mode == "" {
+			break
+		}
+		rules = append(rules, MultilineConfig{
+			AppliesTo:      getEnv(prefix+"APPLIES_TO", ""),
+			Mode:           mode,
+			Pattern:        getEnv(prefix+"PATTERN", ""),
+			MaxLines:       getEnvInt(prefix+"MAX_LINES", 500),
+			MaxBytes:       getEnvInt(prefix+"MAX_BYTES", 256*1024),
+			FlushTimeoutMs: getEnvInt(prefix+"FLUSH_TIMEOUT_MS", 1000),
+		})
+	}
+	return rules
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -99,6 +279,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvAllowEmpty is getEnv for variables where an empty value is meaningful, e.g. a listener address set to
+// empty to disable the listener. Only an unset variable falls back to the default.
+func getEnvAllowEmpty(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
 func getEnvSlice(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
 		return strings.Split(value, ",")
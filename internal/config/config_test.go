@@ -32,6 +32,78 @@ func TestLoadConfig(t *testing.T) {
 		if cfg.PromtailHTTPSourceRoot != "/cache/promtail" {
 			t.Errorf("expected default PromtailHTTPSourceRoot to be '/cache/promtail', got %s", cfg.PromtailHTTPSourceRoot)
 		}
+		if cfg.PromtailHTTPAppTemplate != "" || cfg.PromtailHTTPSourceTemplate != "" {
+			t.Errorf("expected default Promtail HTTP app and source templates to be empty")
+		}
+		if cfg.PromtailHTTPTenantsFile != "" {
+			t.Errorf("expected default PromtailHTTPTenantsFile to be empty")
+		}
+		if cfg.PromtailHTTPMaxConcurrentRequests != 32 {
+			t.Errorf("expected default PromtailHTTPMaxConcurrentRequests to be 32, got %d", cfg.PromtailHTTPMaxConcurrentRequests)
+		}
+		if cfg.PromtailHTTPQueueSize != 256 {
+			t.Errorf("expected default PromtailHTTPQueueSize to be 256, got %d", cfg.PromtailHTTPQueueSize)
+		}
+		if cfg.PromtailHTTPTenantRateBytes != 0 || cfg.PromtailHTTPTenantRateLines != 0 || cfg.PromtailHTTPStreamRateBytes != 0 || cfg.PromtailHTTPStreamRateLines != 0 {
+			t.Errorf("expected Promtail HTTP rate limits to be disabled by default")
+		}
+		if cfg.PromtailHTTPSpoolDir != "" {
+			t.Errorf("expected the Promtail HTTP spool to be disabled by default")
+		}
+		if cfg.PromtailHTTPSpoolMaxBytes != 1024*1024*1024 || cfg.PromtailHTTPSpoolSegmentBytes != 16*1024*1024 {
+			t.Errorf("expected default Promtail HTTP spool sizes to be 1 GiB and 16 MiB, got %d and %d", cfg.PromtailHTTPSpoolMaxBytes, cfg.PromtailHTTPSpoolSegmentBytes)
+		}
+		if cfg.BackfillCheckpointLines != 10000 || cfg.BackfillCheckpointMs != 10000 {
+			t.Errorf("expected default backfill checkpoints every 10000 lines and 10000 ms, got %d and %d", cfg.BackfillCheckpointLines, cfg.BackfillCheckpointMs)
+		}
+		if cfg.PromtailHTTPSpoolMaxAttempts != 10 {
+			t.Errorf("expected default Promtail HTTP spool max attempts to be 10, got %d", cfg.PromtailHTTPSpoolMaxAttempts)
+		}
+		if cfg.PromtailHTTPTLSCertFile != "" || cfg.PromtailHTTPTLSKeyFile != "" || cfg.PromtailHTTPTLSClientCAFile != "" {
+			t.Errorf("expected Promtail HTTP TLS to be disabled by default")
+		}
+		if !reflect.DeepEqual(cfg.PromtailHTTPAppLabels, []string{"app", "service", "job"}) {
+			t.Errorf("expected default PromtailHTTPAppLabels to be [app service job], got %v", cfg.PromtailHTTPAppLabels)
+		}
+		if !reflect.DeepEqual(cfg.PromtailHTTPSourceLabels, []string{"filename", "__path__", "path", "source"}) {
+			t.Errorf("expected default PromtailHTTPSourceLabels to be [filename __path__ path source], got %v", cfg.PromtailHTTPSourceLabels)
+		}
+		if cfg.FileHashChainEnabled {
+			t.Errorf("expected default FileHashChainEnabled to be false")
+		}
+		if cfg.FileHashChainCheckpoint != 1000 {
+			t.Errorf("expected default FileHashChainCheckpoint to be 1000, got %d", cfg.FileHashChainCheckpoint)
+		}
+		if cfg.SyslogEnabled {
+			t.Errorf("expected default SyslogEnabled to be false")
+		}
+		if cfg.SyslogUDPAddr != "0.0.0.0:5514" || cfg.SyslogTCPAddr != "0.0.0.0:5514" || cfg.SyslogUnixSocket != "" {
+			t.Errorf("unexpected syslog listener defaults: udp=%s tcp=%s unix=%s", cfg.SyslogUDPAddr, cfg.SyslogTCPAddr, cfg.SyslogUnixSocket)
+		}
+		if cfg.SyslogSourceRoot != "/cache/syslog" || cfg.SyslogMaxMessageBytes != 64*1024 {
+			t.Errorf("unexpected syslog defaults: root=%s max=%d", cfg.SyslogSourceRoot, cfg.SyslogMaxMessageBytes)
+		}
+		if cfg.FluentForwardEnabled || cfg.FluentForwardAddr != "0.0.0.0:24224" || cfg.FluentForwardSharedKey != "" {
+			t.Errorf("unexpected fluent forward listener defaults: enabled=%v addr=%s", cfg.FluentForwardEnabled, cfg.FluentForwardAddr)
+		}
+		if cfg.FluentForwardAppTemplate != "{{.tag}}" || cfg.FluentForwardSourceTemplate != "{{.tag}}.log" || cfg.FluentForwardSourceRoot != "/cache/fluent" || cfg.FluentForwardMaxMessageBytes != 16*1024*1024 {
+			t.Errorf("unexpected fluent forward defaults: app=%s source=%s root=%s max=%d", cfg.FluentForwardAppTemplate, cfg.FluentForwardSourceTemplate, cfg.FluentForwardSourceRoot, cfg.FluentForwardMaxMessageBytes)
+		}
+		if cfg.GelfEnabled || cfg.GelfUDPAddr != "0.0.0.0:12201" || cfg.GelfTCPAddr != "0.0.0.0:12201" || cfg.GelfSourceRoot != "/cache/gelf" {
+			t.Errorf("unexpected gelf listener defaults: enabled=%v udp=%s tcp=%s root=%s", cfg.GelfEnabled, cfg.GelfUDPAddr, cfg.GelfTCPAddr, cfg.GelfSourceRoot)
+		}
+		if cfg.GelfMaxMessageBytes != 1024*1024 || cfg.GelfChunkTimeoutMs != 5000 || cfg.GelfMaxChunkedMessages != 1000 {
+			t.Errorf("unexpected gelf limits: max=%d timeout=%d chunked=%d", cfg.GelfMaxMessageBytes, cfg.GelfChunkTimeoutMs, cfg.GelfMaxChunkedMessages)
+		}
+		if cfg.PipelineWorkers != 0 || cfg.PipelineQueueSize != 1000 {
+			t.Errorf("unexpected pipeline worker defaults: workers=%d queue=%d", cfg.PipelineWorkers, cfg.PipelineQueueSize)
+		}
+		if cfg.BackendAsyncEnabled {
+			t.Errorf("expected default BackendAsyncEnabled to be false")
+		}
+		if cfg.BackendQueueSize != 10000 || cfg.BackendBatchSize != 500 || cfg.BackendBatchMaxWaitMs != 200 {
+			t.Errorf("unexpected backend queue defaults: queue=%d batch=%d wait=%d", cfg.BackendQueueSize, cfg.BackendBatchSize, cfg.BackendBatchMaxWaitMs)
+		}
 	})
 
 	t.Run("overrides default values from environment variables", func(t *testing.T) {
@@ -47,6 +119,7 @@ func TestLoadConfig(t *testing.T) {
 		t.Setenv("PROMTAIL_HTTP_MAX_BODY_BYTES", "2048")
 		t.Setenv("PROMTAIL_HTTP_BEARER_TOKEN", "secret-token")
 		t.Setenv("PROMTAIL_HTTP_SOURCE_ROOT", "/tmp/promtail")
+		t.Setenv("PROMTAIL_HTTP_APP_LABELS", "container,job")
 
 		cfg := Load()
 
@@ -80,6 +153,9 @@ func TestLoadConfig(t *testing.T) {
 		if cfg.PromtailHTTPSourceRoot != "/tmp/promtail" {
 			t.Errorf("expected PromtailHTTPSourceRoot to be '/tmp/promtail', got %s", cfg.PromtailHTTPSourceRoot)
 		}
+		if !reflect.DeepEqual(cfg.PromtailHTTPAppLabels, []string{"container", "job"}) {
+			t.Errorf("expected PromtailHTTPAppLabels to be [container job], got %v", cfg.PromtailHTTPAppLabels)
+		}
 	})
 
 	t.Run("falls back to defaults for invalid bool and int values", func(t *testing.T) {
@@ -95,6 +171,28 @@ func TestLoadConfig(t *testing.T) {
 			t.Errorf("expected invalid PROMTAIL_HTTP_MAX_BODY_BYTES to fall back to 10485760, got %d", cfg.PromtailHTTPMaxBodyBytes)
 		}
 	})
+
+	t.Run("empty listener addresses disable the listener", func(t *testing.T) {
+		t.Setenv("SYSLOG_UDP_ADDR", "")
+		t.Setenv("SYSLOG_TCP_ADDR", "127.0.0.1:6514")
+		t.Setenv("GELF_UDP_ADDR", "127.0.0.1:12202")
+		t.Setenv("GELF_TCP_ADDR", "")
+
+		cfg := Load()
+
+		if cfg.SyslogUDPAddr != "" {
+			t.Errorf("expected empty SYSLOG_UDP_ADDR to stay empty, got %s", cfg.SyslogUDPAddr)
+		}
+		if cfg.SyslogTCPAddr != "127.0.0.1:6514" {
+			t.Errorf("expected SyslogTCPAddr to be '127.0.0.1:6514', got %s", cfg.SyslogTCPAddr)
+		}
+		if cfg.GelfUDPAddr != "127.0.0.1:12202" {
+			t.Errorf("expected GelfUDPAddr to be '127.0.0.1:12202', got %s", cfg.GelfUDPAddr)
+		}
+		if cfg.GelfTCPAddr != "" {
+			t.Errorf("expected empty GELF_TCP_ADDR to stay empty, got %s", cfg.GelfTCPAddr)
+		}
+	})
 }
 
 // Step 2: This test function, TestLoadStages, uses a table-driven approach to test the dynamic stage loading.
@@ -129,6 +227,17 @@ func TestLoadStages(t *testing.T) {
 				{Type: "hostname_enrichment", AppliesTo: `\\.log$`, Params: make(map[string]interface{})},
 			},
 		},
+		{
+			name: "tenants are not included in params",
+			envVars: map[string]string{
+				"STAGE_0_TYPE":    "labels",
+				"STAGE_0_TENANTS": "team-a, team-b,",
+				"STAGE_0_DROP":    "pod",
+			},
+			expectedStages: []StageConfig{
+				{Type: "labels", Tenants: []string{"team-a", "team-b"}, Params: map[string]interface{}{"drop": "pod"}},
+			},
+		},
 		{
 			name: "single stage with params",
 			envVars: map[string]string{
@@ -189,3 +298,21 @@ func TestLoadStages(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadMultiline(t *testing.T) {
+	t.Setenv("MULTILINE_0_MODE", "start")
+	t.Setenv("MULTILINE_0_PATTERN", `^\d{4}-`)
+	t.Setenv("MULTILINE_0_APPLIES_TO", `java/`)
+	t.Setenv("MULTILINE_1_MODE", "indent")
+	t.Setenv("MULTILINE_1_MAX_LINES", "50")
+	t.Setenv("MULTILINE_1_FLUSH_TIMEOUT_MS", "250")
+	t.Setenv("MULTILINE_3_MODE", "continuation") // MULTILINE_2 is missing
+
+	expected := []MultilineConfig{
+		{AppliesTo: `java/`, Mode: "start", Pattern: `^\d{4}-`, MaxLines: 500, MaxBytes: 256 * 1024, FlushTimeoutMs: 1000},
+		{Mode: "indent", MaxLines: 50, MaxBytes: 256 * 1024, FlushTimeoutMs: 250},
+	}
+	if cfg := Load(); !reflect.DeepEqual(cfg.Multiline, expected) {
+		t.Errorf("expected multiline rules %#v, but got %#v", expected, cfg.Multiline)
+	}
+}
@@ -0,0 +1,247 @@
+package deadletter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/models"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Record is a single dead-lettered log entry together with the reason it failed.
+type Record struct {
+	Time      time.Time `json:"time"`
+	App       string    `json:"app"`
+	Source    string    `json:"source"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Line      string    `json:"line"`
+	Stage     string    `json:"stage,omitempty"`
+	Backend   string    `json:"backend,omitempty"`
+	Error     string    `json:"error"`
+}
+
+// Sink is the destination for dead-lettered records.
+type Sink interface {
+	Write(record Record) error
+	Close() error
+}
+
+type sinkHolder struct {
+	sink Sink
+}
+
+var current atomic.Pointer[sinkHolder]
+
+// SetSink installs the process-wide dead-letter sink. Passing nil disables dead-lettering.
+func SetSink(sink Sink) {
+	if sink == nil {
+		current.Store(nil)
+		return
+	}
+	current.Store(&sinkHolder{sink: sink})
+}
+
+// Enabled reports whether a dead-letter sink is configured.
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Write records a failed entry in the configured sink. It is a no-op when no sink is configured.
+// Failures to write are logged, since there is nowhere else to send the entry.
+func Write(record Record) {
+	holder := current.Load()
+	if holder == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	if err := holder.sink.Write(record); err != nil {
+		slog.Error("Failed to write dead-letter record", "error", err, "source_path", record.Source, "app", record.App)
+	}
+}
+
+// Close closes the configured sink and disables dead-lettering.
+func Close() {
+	holder := current.Swap(nil)
+	if holder == nil {
+		return
+	}
+	if err := holder.sink.Close(); err != nil {
+		slog.Error("Failed to close dead-letter sink", "error", err)
+	}
+}
+
+// FileSink appends dead-letter records as NDJSON to a local file.
+// The file is opened on the first write, so no empty file is created when nothing fails.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileSink creates a sink for the NDJSON dead-letter file at path, creating its directory if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for dead-letter file %s: %w", path, err)
+	}
+
+	return &FileSink{path: path}, nil
+}
+
+func (s *FileSink) Write(record Record) error {
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter record: %w", err)
+	}
+	buf = append(buf, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open dead-letter file %s: %w", s.path, err)
+		}
+		s.file = f
+	}
+	_, err = s.file.Write(buf)
+	return err
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// entrySender is the part of backends.Backend needed to forward records.
+// It is declared here so backends can dead-letter failed sends without an import cycle.
+type entrySender interface {
+	Send(entry *models.LogEntry) error
+}
+
+// MarkerLabel is the label, and field, that marks entries sent by a BackendSink as dead-lettered.
+const MarkerLabel = "dead_letter"
+
+// BackendSink forwards dead-letter records to a regular backend as structured entries. Entries keep the source
+// path and app of the failed entry, so they end up next to it and are routed the same way. Records of failed
+// backend sends, and records the backend does not accept, go to the fallback sink instead, since the backend that
+// just failed would most likely fail them as well.
+type BackendSink struct {
+	backend  entrySender
+	fallback Sink
+}
+
+// NewBackendSink creates a sink that sends records to backend, and records of backend failures to fallback.
+func NewBackendSink(backend entrySender, fallback Sink) *BackendSink {
+	return &BackendSink{backend: backend, fallback: fallback}
+}
+
+func (s *BackendSink) Write(record Record) error {
+	if record.Backend != "" {
+		return s.fallback.Write(record)
+	}
+	if err := s.backend.Send(backendSinkEntry(record)); err != nil {
+		slog.Warn("Failed to send dead-letter record to backend, writing it to the fallback sink", "error", err, "source_path", record.Source)
+		return s.fallback.Write(record)
+	}
+	return nil
+}
+
+// backendSinkEntry turns a record into the entry BackendSink sends.
+func backendSinkEntry(record Record) *models.LogEntry {
+	fields := map[string]interface{}{
+		"time":      record.Time.Format(time.RFC3339Nano),
+		"app":       record.App,
+		"source":    record.Source,
+		"timestamp": record.Timestamp.Format(time.RFC3339Nano),
+		"line":      record.Line,
+		"error":     record.Error,
+		MarkerLabel: true,
+	}
+	if record.Stage != "" {
+		fields["stage"] = record.Stage
+	}
+	if record.Backend != "" {
+		fields["backend"] = record.Backend
+	}
+	if record.Tenant != "" {
+		fields["tenant"] = record.Tenant
+	}
+
+	return &models.LogEntry{
+		Fields:     fields,
+		Timestamp:  record.Time,
+		SourcePath: record.Source,
+		App:        record.App,
+		Tenant:     record.Tenant,
+		Labels:     map[string]string{MarkerLabel: "true"},
+	}
+}
+
+// Close closes the fallback sink; the wrapped backend is shut down by its owner.
+func (s *BackendSink) Close() error {
+	return s.fallback.Close()
+}
+
+// ReadError is returned by ReadRecords when r could not be read to the end.
+type ReadError struct {
+	// Offset is where the first record that was not passed to fn starts.
+	Offset int64
+	Err    error
+}
+
+func (e *ReadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// ReadRecords decodes NDJSON dead-letter records from r and calls fn for each of them.
+// Iteration stops at the first error returned by fn, or at the first error encountered while reading or decoding,
+// which is returned as a *ReadError.
+func ReadRecords(r io.Reader, fn func(Record) error) error {
+	reader := bufio.NewReader(r)
+	lineNumber := 0
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		lineNumber++
+		if len(bytes.TrimSpace(line)) > 0 && (err == nil || errors.Is(err, io.EOF)) {
+			var record Record
+			if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+				return &ReadError{Offset: offset, Err: fmt.Errorf("invalid dead-letter record on line %d: %w", lineNumber, jsonErr)}
+			}
+			if fnErr := fn(record); fnErr != nil {
+				return fnErr
+			}
+		}
+		offset += int64(len(line))
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return &ReadError{Offset: offset - int64(len(line)), Err: err}
+		}
+	}
+}
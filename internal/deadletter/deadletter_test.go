@@ -0,0 +1,145 @@
+package deadletter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type captureBackend struct {
+	entries []*models.LogEntry
+	err     error
+}
+
+func (b *captureBackend) Send(entry *models.LogEntry) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.entries = append(b.entries, entry)
+	return nil
+}
+
+// captureSink records the records written to it.
+type captureSink struct {
+	records []Record
+	closed  bool
+}
+
+func (s *captureSink) Write(record Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *captureSink) Close() error {
+	s.closed = true
+	return nil
+}
+func (b *captureBackend) Shutdown()                     {}
+func (b *captureBackend) Name() string                  { return "capture" }
+func (b *captureBackend) CloseWriter(sourcePath string) {}
+
+func TestFileSink_WritesAndReadsRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "dlq.ndjson")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "file should only be created on first write")
+
+	SetSink(sink)
+	t.Cleanup(Close)
+	require.True(t, Enabled())
+
+	ts := time.Date(2026, time.March, 1, 2, 3, 4, 0, time.UTC)
+	Write(Record{App: "api", Source: "/logs/api.log", Timestamp: ts, Line: `{"msg":"a"}`, Stage: "template_resolver", Error: "boom"})
+	Write(Record{App: "api", Source: "/logs/api.log", Line: "plain", Backend: "loki", Error: "unavailable"})
+	Close()
+	assert.False(t, Enabled())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []Record
+	require.NoError(t, ReadRecords(f, func(r Record) error {
+		records = append(records, r)
+		return nil
+	}))
+
+	require.Len(t, records, 2)
+	assert.Equal(t, "template_resolver", records[0].Stage)
+	assert.Equal(t, `{"msg":"a"}`, records[0].Line)
+	assert.True(t, ts.Equal(records[0].Timestamp))
+	assert.False(t, records[0].Time.IsZero(), "write time should be filled in")
+	assert.Equal(t, "loki", records[1].Backend)
+	assert.Equal(t, "unavailable", records[1].Error)
+}
+
+func TestReadRecords_SkipsBlankLinesAndRejectsGarbage(t *testing.T) {
+	count := 0
+	err := ReadRecords(strings.NewReader("{\"line\":\"a\",\"error\":\"x\"}\n\n{\"line\":\"b\",\"error\":\"y\"}"), func(r Record) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	err = ReadRecords(strings.NewReader("{\"line\":\"a\"}\nnot-json\n"), func(r Record) error { return nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+	var readErr *ReadError
+	require.ErrorAs(t, err, &readErr)
+	assert.Equal(t, int64(len("{\"line\":\"a\"}\n")), readErr.Offset, "the offset points at the unread record")
+}
+
+func TestBackendSink_SendsStructuredEntry(t *testing.T) {
+	backend := &captureBackend{}
+	fallback := &captureSink{}
+	sink := NewBackendSink(backend, fallback)
+
+	require.NoError(t, sink.Write(Record{App: "api", Source: "/logs/api.log", Line: "x", Stage: "filter", Error: "bad"}))
+	require.Len(t, backend.entries, 1)
+
+	entry := backend.entries[0]
+	assert.Equal(t, "/logs/api.log", entry.SourcePath, "entries keep their source for routing")
+	assert.Equal(t, "api", entry.App)
+	assert.Equal(t, "true", entry.Labels[MarkerLabel])
+	assert.Equal(t, true, entry.Fields[MarkerLabel])
+	assert.Equal(t, "filter", entry.Fields["stage"])
+	assert.Equal(t, "bad", entry.Fields["error"])
+	assert.NotContains(t, entry.Fields, "backend")
+	assert.Empty(t, fallback.records)
+}
+
+func TestBackendSink_WritesBackendFailuresToFallback(t *testing.T) {
+	backend := &captureBackend{}
+	fallback := &captureSink{}
+	sink := NewBackendSink(backend, fallback)
+
+	require.NoError(t, sink.Write(Record{Source: "/logs/api.log", Line: "x", Backend: "loki", Error: "unavailable"}))
+	assert.Empty(t, backend.entries, "the backend that failed the entry is not used for its record")
+	require.Len(t, fallback.records, 1)
+	assert.Equal(t, "loki", fallback.records[0].Backend)
+
+	backend.err = errors.New("unavailable")
+	require.NoError(t, sink.Write(Record{Source: "/logs/api.log", Line: "y", Stage: "filter", Error: "bad"}))
+	require.Len(t, fallback.records, 2, "records the backend does not accept go to the fallback")
+	assert.Equal(t, "filter", fallback.records[1].Stage)
+
+	require.NoError(t, sink.Close())
+	assert.True(t, fallback.closed)
+}
+
+func TestWrite_NoopWithoutSink(t *testing.T) {
+	SetSink(nil)
+	assert.False(t, Enabled())
+	Write(Record{Line: "ignored"})
+}
@@ -0,0 +1,284 @@
+package fluentforward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+var errMessageTooLarge = errors.New("forward message exceeds size limit")
+
+func init() {
+	msgpack.RegisterExt(eventTimeExtID, (*eventTime)(nil))
+}
+
+// eventTimeExtID is the msgpack extension type Fluentd and Fluent Bit use for nanosecond timestamps.
+const eventTimeExtID = 0
+
+// eventTime is the EventTime extension: big-endian uint32 seconds followed by uint32 nanoseconds.
+type eventTime struct {
+	time.Time
+}
+
+func (t *eventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	return b, nil
+}
+
+func (t *eventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("invalid EventTime length %d", len(b))
+	}
+	t.Time = time.Unix(int64(binary.BigEndian.Uint32(b[:4])), int64(binary.BigEndian.Uint32(b[4:]))).UTC()
+	return nil
+}
+
+// event is a single record of a forward message.
+type event struct {
+	timestamp time.Time
+	record    map[string]interface{}
+}
+
+// message is a decoded forward message in any of the four modes.
+type message struct {
+	tag    string
+	events []event
+	// chunk is set if the client requested an acknowledgement.
+	chunk string
+}
+
+// messageReader caps the bytes a single message may consume, so a bogus length prefix cannot make the
+// decoder read without bound. It implements io.ByteScanner, so the msgpack decoder uses it without extra buffering.
+type messageReader struct {
+	r         *bufio.Reader
+	remaining int
+}
+
+func (m *messageReader) reset(limit int) {
+	m.remaining = limit
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.remaining <= 0 {
+		return 0, errMessageTooLarge
+	}
+	if len(p) > m.remaining {
+		p = p[:m.remaining]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= n
+	return n, err
+}
+
+func (m *messageReader) ReadByte() (byte, error) {
+	if m.remaining <= 0 {
+		return 0, errMessageTooLarge
+	}
+	b, err := m.r.ReadByte()
+	if err == nil {
+		m.remaining--
+	}
+	return b, err
+}
+
+func (m *messageReader) UnreadByte() error {
+	err := m.r.UnreadByte()
+	if err == nil {
+		m.remaining++
+	}
+	return err
+}
+
+func newDecoder(r io.Reader) *msgpack.Decoder {
+	dec := msgpack.NewDecoder(r)
+	// Nested values are decoded like DecodeInterfaceLoose: int64/uint64, float64, and strings for binary values.
+	dec.UseLooseInterfaceDecoding(true)
+	return dec
+}
+
+// decodeMessage decodes one of
+//
+//	Message:                 [tag, time, record, option?]
+//	Forward:                 [tag, [[time, record], ...], option?]
+//	PackedForward:           [tag, bin(msgpack stream of [time, record]), option?]
+//	CompressedPackedForward: PackedForward with option {"compressed": "gzip"}
+//
+// maxSize bounds the decompressed size of packed entries.
+func decodeMessage(dec *msgpack.Decoder, maxSize int) (*message, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if n < 2 || n > 4 {
+		return nil, fmt.Errorf("forward message must have 2 to 4 elements, got %d", n)
+	}
+
+	tag, err := dec.DecodeString()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag: %w", err)
+	}
+	msg := &message{tag: tag}
+
+	code, err := dec.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+
+	var optionIdx int
+	var packed []byte
+	switch {
+	case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+		count, err := dec.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < count; i++ {
+			ev, err := decodeEvent(dec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry %d: %w", i, err)
+			}
+			msg.events = append(msg.events, ev)
+		}
+		optionIdx = 2
+	case msgpcode.IsString(code) || msgpcode.IsBin(code):
+		if packed, err = dec.DecodeBytes(); err != nil {
+			return nil, err
+		}
+		optionIdx = 2
+	default:
+		if n < 3 {
+			return nil, fmt.Errorf("message mode requires a time and a record")
+		}
+		ev, err := decodeTimeAndRecord(dec)
+		if err != nil {
+			return nil, err
+		}
+		msg.events = append(msg.events, ev)
+		optionIdx = 3
+	}
+
+	var compressed string
+	for i := optionIdx; i < n; i++ {
+		option, err := dec.DecodeInterfaceLoose()
+		if err != nil {
+			return nil, fmt.Errorf("invalid option: %w", err)
+		}
+		if options, ok := option.(map[string]interface{}); ok {
+			msg.chunk, _ = options["chunk"].(string)
+			compressed, _ = options["compressed"].(string)
+		}
+	}
+
+	if packed != nil {
+		if compressed != "" {
+			if compressed != "gzip" {
+				return nil, fmt.Errorf("unsupported compression %q", compressed)
+			}
+			if packed, err = gunzip(packed, maxSize); err != nil {
+				return nil, err
+			}
+		}
+		if msg.events, err = decodePackedEvents(packed); err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+func decodePackedEvents(packed []byte) ([]event, error) {
+	reader := bytes.NewReader(packed)
+	dec := newDecoder(reader)
+
+	var events []event
+	for reader.Len() > 0 {
+		ev, err := decodeEvent(dec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packed entry %d: %w", len(events), err)
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// gunzip decompresses data, which may consist of several concatenated gzip members as Fluent Bit sends them.
+func gunzip(data []byte, maxSize int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip entries: %w", err)
+	}
+	defer zr.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(zr, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip entries: %w", err)
+	}
+	if len(decompressed) > maxSize {
+		return nil, errMessageTooLarge
+	}
+	return decompressed, nil
+}
+
+// decodeEvent decodes an entry [time, record].
+func decodeEvent(dec *msgpack.Decoder) (event, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return event{}, err
+	}
+	if n != 2 {
+		return event{}, fmt.Errorf("entry must have 2 elements, got %d", n)
+	}
+	return decodeTimeAndRecord(dec)
+}
+
+func decodeTimeAndRecord(dec *msgpack.Decoder) (event, error) {
+	rawTime, err := dec.DecodeInterfaceLoose()
+	if err != nil {
+		return event{}, fmt.Errorf("invalid time: %w", err)
+	}
+	// Fluent Bit 2.1+ may send [time, metadata] in place of the time.
+	if withMetadata, ok := rawTime.([]interface{}); ok && len(withMetadata) > 0 {
+		rawTime = withMetadata[0]
+	}
+	ts, err := parseTime(rawTime)
+	if err != nil {
+		return event{}, err
+	}
+
+	rawRecord, err := dec.DecodeInterfaceLoose()
+	if err != nil {
+		return event{}, fmt.Errorf("invalid record: %w", err)
+	}
+	record, ok := rawRecord.(map[string]interface{})
+	if !ok && rawRecord != nil {
+		return event{}, fmt.Errorf("record must be a map, got %T", rawRecord)
+	}
+	return event{timestamp: ts, record: record}, nil
+}
+
+func parseTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case *eventTime:
+		return v.Time, nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case uint64:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case float64:
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("unsupported time %T", value)
+	}
+}
@@ -0,0 +1,79 @@
+package fluentforward
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeFixture(t *testing.T, name string) *message {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+
+	reader := &messageReader{r: bufio.NewReader(bytes.NewReader(raw))}
+	reader.reset(len(raw))
+	msg, err := decodeMessage(newDecoder(reader), 1024*1024)
+	require.NoError(t, err)
+	return msg
+}
+
+func TestDecodeMessage_MessageMode(t *testing.T) {
+	msg := decodeFixture(t, "message_mode.msgpack")
+
+	assert.Equal(t, "docker.web", msg.tag)
+	assert.Equal(t, "p8n9gmxTQVC8/nh2wlKKeQ==", msg.chunk)
+	require.Len(t, msg.events, 1)
+	assert.Equal(t, time.Unix(1773141753, 123456789).UTC(), msg.events[0].timestamp)
+	assert.Equal(t, "/web", msg.events[0].record["container_name"])
+	assert.Equal(t, `{"level":"info","msg":"GET / 200"}`, msg.events[0].record["log"])
+}
+
+func TestDecodeMessage_ForwardMode(t *testing.T) {
+	msg := decodeFixture(t, "forward_mode.msgpack")
+
+	assert.Equal(t, "app.api", msg.tag)
+	assert.Empty(t, msg.chunk)
+	require.Len(t, msg.events, 2)
+	assert.Equal(t, time.Unix(1773141753, 0).UTC(), msg.events[0].timestamp)
+	assert.Equal(t, map[string]interface{}{"message": "first", "status": uint64(200)}, msg.events[0].record)
+	assert.Equal(t, "second", msg.events[1].record["message"])
+}
+
+func TestDecodeMessage_PackedForwardModes(t *testing.T) {
+	for _, fixture := range []string{"packed_forward.msgpack", "compressed_packed_forward.msgpack"} {
+		t.Run(fixture, func(t *testing.T) {
+			msg := decodeFixture(t, fixture)
+
+			assert.Equal(t, "kube.var.log.containers.api", msg.tag)
+			require.Len(t, msg.events, 2)
+			assert.Equal(t, time.Unix(1773141755, 0).UTC(), msg.events[0].timestamp)
+			assert.Equal(t, map[string]interface{}{"log": "packed one", "stream": "stderr"}, msg.events[0].record)
+			assert.Equal(t, time.Unix(1773141756, 500).UTC(), msg.events[1].timestamp)
+			assert.Equal(t, "packed two", msg.events[1].record["log"])
+		})
+	}
+}
+
+func TestDecodeMessage_EnforcesSizeLimits(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "message_mode.msgpack"))
+	require.NoError(t, err)
+
+	reader := &messageReader{r: bufio.NewReader(bytes.NewReader(raw))}
+	reader.reset(len(raw) / 2)
+	_, err = decodeMessage(newDecoder(reader), 1024)
+	assert.ErrorIs(t, err, errMessageTooLarge)
+
+	raw, err = os.ReadFile(filepath.Join("testdata", "compressed_packed_forward.msgpack"))
+	require.NoError(t, err)
+	reader = &messageReader{r: bufio.NewReader(bytes.NewReader(raw))}
+	reader.reset(len(raw))
+	_, err = decodeMessage(newDecoder(reader), 16)
+	assert.ErrorIs(t, err, errMessageTooLarge, "the decompressed size is limited as well")
+}
@@ -0,0 +1,269 @@
+package fluentforward
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/backends"
+	"log-enricher/internal/config"
+	"log-enricher/internal/netinput"
+	"log-enricher/internal/pipeline"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	defaultMaxMessageSize   = 16 * 1024 * 1024
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultSourceRoot       = "/cache/fluent"
+	fallbackSourceFile      = "fluent.log"
+	fallbackAppName         = "fluent"
+	handshakeNonceSize      = 16
+)
+
+// Server receives Fluent Forward protocol messages over TCP, as sent by Fluent Bit's forward output,
+// Fluentd and Docker's fluentd log driver, and runs their records through the pipeline.
+type Server struct {
+	appTemplate    *template.Template
+	sourceTemplate *template.Template
+	sourceRoot     string
+	maxMessageSize int
+	sharedKey      string
+	hostname       string
+	// handshakeTimeout limits how long a client may take to answer HELO, so idle connections cannot hold a slot.
+	handshakeTimeout time.Duration
+
+	addr     string
+	listener net.Listener
+
+	processors *netinput.Processors
+	conns      *netinput.Conns
+}
+
+func NewServer(cfg *config.Config, pm pipeline.Manager, backend backends.Backend) (*Server, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	if pm == nil {
+		return nil, fmt.Errorf("pipeline manager is required")
+	}
+	if backend == nil {
+		return nil, fmt.Errorf("backend is required")
+	}
+
+	appTemplate, err := template.New("fluent_app").Parse(cfg.FluentForwardAppTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fluent forward app template: %w", err)
+	}
+	sourceTemplate, err := template.New("fluent_source").Parse(cfg.FluentForwardSourceTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fluent forward source template: %w", err)
+	}
+
+	maxMessageSize := cfg.FluentForwardMaxMessageBytes
+	if maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+
+	sourceRoot := strings.TrimSpace(cfg.FluentForwardSourceRoot)
+	if sourceRoot == "" {
+		sourceRoot = defaultSourceRoot
+	}
+
+	handshakeTimeout := time.Duration(cfg.FluentForwardHandshakeTimeoutMs) * time.Millisecond
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = defaultHandshakeTimeout
+	}
+
+	hostname := strings.TrimSpace(cfg.FluentForwardHostname)
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	return &Server{
+		appTemplate:      appTemplate,
+		sourceTemplate:   sourceTemplate,
+		sourceRoot:       filepath.Clean(sourceRoot),
+		maxMessageSize:   maxMessageSize,
+		sharedKey:        cfg.FluentForwardSharedKey,
+		hostname:         hostname,
+		handshakeTimeout: handshakeTimeout,
+		addr:             cfg.FluentForwardAddr,
+		processors:       netinput.NewProcessors(pm, backend, cfg.InputMaxSources, time.Duration(cfg.InputSourceIdleTimeoutMs)*time.Millisecond),
+		conns:            netinput.NewConns("fluent forward"),
+	}, nil
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for fluent forward on %s: %w", s.addr, err)
+	}
+	s.listener = listener
+	slog.Info("Fluent Forward listener enabled", "addr", listener.Addr().String(), "shared_key", s.sharedKey != "")
+
+	s.conns.Serve(listener, s.serveConn)
+	return nil
+}
+
+// Shutdown closes the listener and open connections and waits for in-progress messages until ctx expires.
+// The backend writers of all sources are closed afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.conns.Shutdown(ctx, func() {
+		if s.listener != nil {
+			_ = s.listener.Close()
+		}
+	})
+	s.processors.Close()
+	return err
+}
+
+// Addr returns the bound listener address.
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.addr
+	}
+	return s.listener.Addr().String()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	remote := conn.RemoteAddr().String()
+	reader := &messageReader{r: bufio.NewReader(conn)}
+	dec := newDecoder(reader)
+	enc := msgpack.NewEncoder(conn)
+
+	if s.sharedKey != "" {
+		reader.reset(s.maxMessageSize)
+		_ = conn.SetReadDeadline(time.Now().Add(s.handshakeTimeout))
+		if err := s.handshake(dec, enc); err != nil {
+			slog.Warn("Fluent forward handshake failed", "remote_addr", remote, "error", err)
+			return
+		}
+		// Authenticated clients may keep the connection open between messages.
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+
+	for {
+		reader.reset(s.maxMessageSize)
+		msg, err := decodeMessage(dec, s.maxMessageSize)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !s.conns.Closing() && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("Closing fluent forward connection", "remote_addr", remote, "error", err)
+			}
+			return
+		}
+
+		// Only acknowledge chunks that were fully processed, so the client retries the others.
+		if err := s.handleMessage(msg, remote); err != nil {
+			slog.Error("Failed to process fluent forward message", "tag", msg.tag, "remote_addr", remote, "error", err)
+			continue
+		}
+		if msg.chunk != "" {
+			if err := enc.Encode(map[string]string{"ack": msg.chunk}); err != nil {
+				slog.Warn("Failed to acknowledge fluent forward chunk", "remote_addr", remote, "error", err)
+				return
+			}
+		}
+	}
+}
+
+// handshake performs the shared-key authentication of the forward protocol:
+//
+//	server: ["HELO", {"nonce": nonce, "auth": "", "keepalive": true}]
+//	client: ["PING", hostname, salt, hex(sha512(salt + hostname + nonce + key)), username, password]
+//	server: ["PONG", ok, reason, hostname, hex(sha512(salt + server hostname + nonce + key))]
+func (s *Server) handshake(dec *msgpack.Decoder, enc *msgpack.Encoder) error {
+	nonce := make([]byte, handshakeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if err := enc.Encode([]interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}}); err != nil {
+		return err
+	}
+
+	var ping []string
+	if err := dec.Decode(&ping); err != nil {
+		return fmt.Errorf("invalid PING: %w", err)
+	}
+	if len(ping) < 4 || ping[0] != "PING" {
+		return fmt.Errorf("expected PING message")
+	}
+	clientHostname, salt, digest := ping[1], ping[2], ping[3]
+
+	expected := s.sharedKeyDigest(salt, clientHostname, nonce)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(expected)) != 1 {
+		_ = enc.Encode([]interface{}{"PONG", false, "shared_key mismatch", s.hostname, ""})
+		return fmt.Errorf("shared key mismatch from %s", clientHostname)
+	}
+
+	return enc.Encode([]interface{}{"PONG", true, "", s.hostname, s.sharedKeyDigest(salt, s.hostname, nonce)})
+}
+
+func (s *Server) sharedKeyDigest(salt, hostname string, nonce []byte) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(s.sharedKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handleMessage processes every record of msg and returns the first processing error.
+func (s *Server) handleMessage(msg *message, remote string) error {
+	var firstErr error
+	for _, ev := range msg.events {
+		data := templateData(msg.tag, remote, ev.record)
+		app := strings.TrimSpace(netinput.ExecuteTemplate(s.appTemplate, data))
+		if app == "" {
+			app = fallbackAppName
+		}
+		source := netinput.SanitizeSourcePath(s.sourceRoot, netinput.ExecuteTemplate(s.sourceTemplate, data), fallbackSourceFile)
+
+		if err := s.processors.Get(app, source).ProcessLineWithFields(recordLine(ev.record), ev.timestamp, ev.record); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// recordLine returns the raw log line of a record: the "log" key written by Docker and tail inputs,
+// or "message", or else the whole record as JSON.
+func recordLine(record map[string]interface{}) []byte {
+	for _, key := range []string{"log", "message"} {
+		if line, ok := record[key].(string); ok {
+			return []byte(line)
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return []byte{}
+	}
+	return line
+}
+
+// templateData exposes the tag, the remote address and every top-level string value of the record to templates.
+func templateData(tag, remote string, record map[string]interface{}) map[string]string {
+	data := make(map[string]string, len(record)+2)
+	for k, v := range record {
+		if str, ok := v.(string); ok {
+			data[k] = str
+		}
+	}
+	data["tag"] = tag
+	data["remote_addr"] = remote
+	return data
+}
@@ -0,0 +1,172 @@
+package fluentforward
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+	"log-enricher/internal/netinput/netinputtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func newTestConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		FluentForwardAddr:            "127.0.0.1:0",
+		FluentForwardHostname:        "enricher",
+		FluentForwardAppTemplate:     "{{.tag}}",
+		FluentForwardSourceTemplate:  "{{.tag}}.log",
+		FluentForwardSourceRoot:      t.TempDir(),
+		FluentForwardMaxMessageBytes: 64 * 1024,
+	}
+}
+
+func startTestServer(t *testing.T, cfg *config.Config, backend *netinputtest.CaptureBackend) *Server {
+	t.Helper()
+	server, err := NewServer(cfg, &netinputtest.PipelineManager{}, backend)
+	require.NoError(t, err)
+	netinputtest.Start(t, server)
+	return server
+}
+
+func writeFixture(t *testing.T, conn net.Conn, name string) {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	_, err = conn.Write(raw)
+	require.NoError(t, err)
+}
+
+func TestServer_ProcessesRecordsAndAcknowledgesChunks(t *testing.T) {
+	cfg := newTestConfig(t)
+	// Top-level string values of the record are available to the templates.
+	cfg.FluentForwardSourceTemplate = `{{.tag}}{{if .source}}/{{.source}}{{end}}.log`
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("tcp", server.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	dec := msgpack.NewDecoder(conn)
+
+	writeFixture(t, conn, "message_mode.msgpack")
+	var ack map[string]string
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, dec.Decode(&ack))
+	assert.Equal(t, map[string]string{"ack": "p8n9gmxTQVC8/nh2wlKKeQ=="}, ack)
+
+	writeFixture(t, conn, "forward_mode.msgpack")
+	writeFixture(t, conn, "compressed_packed_forward.msgpack")
+
+	entries := backend.WaitFor(t, 5)
+	require.Len(t, entries, 5)
+
+	docker := entries[0]
+	assert.Equal(t, "docker.web", docker.App)
+	assert.Equal(t, filepath.Join(cfg.FluentForwardSourceRoot, "docker.web", "stdout.log"), docker.SourcePath)
+	assert.Equal(t, `{"level":"info","msg":"GET / 200"}`, string(docker.LogLine))
+	assert.Equal(t, time.Unix(1773141753, 123456789).UTC(), docker.Timestamp)
+	assert.Equal(t, "/web", docker.Fields["container_name"])
+
+	assert.Equal(t, "app.api", entries[1].App)
+	assert.Equal(t, filepath.Join(cfg.FluentForwardSourceRoot, "app.api.log"), entries[1].SourcePath)
+	assert.Equal(t, "first", string(entries[1].LogLine))
+	assert.Equal(t, uint64(200), entries[1].Fields["status"])
+	assert.Equal(t, "second", string(entries[2].LogLine))
+
+	assert.Equal(t, "kube.var.log.containers.api", entries[3].App)
+	assert.Equal(t, "packed one", string(entries[3].LogLine))
+	assert.Equal(t, "packed two", string(entries[4].LogLine))
+}
+
+func TestServer_SharedKeyHandshake(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.FluentForwardSharedKey = "s3cret"
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	handshake := func(t *testing.T, key string) (net.Conn, []interface{}) {
+		conn, err := net.Dial("tcp", server.Addr())
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		dec := msgpack.NewDecoder(conn)
+		enc := msgpack.NewEncoder(conn)
+
+		var helo []interface{}
+		require.NoError(t, dec.Decode(&helo))
+		require.Equal(t, "HELO", helo[0])
+		nonce := helo[1].(map[string]interface{})["nonce"].([]byte)
+
+		client := &Server{sharedKey: key}
+		require.NoError(t, enc.Encode([]string{"PING", "fluent-bit", "salt", client.sharedKeyDigest("salt", "fluent-bit", nonce), "", ""}))
+
+		var pong []interface{}
+		require.NoError(t, dec.Decode(&pong))
+		assert.Equal(t, client.sharedKeyDigest("salt", "enricher", nonce) == pong[4], pong[1], "the server proves it knows the key")
+		return conn, pong
+	}
+
+	t.Run("matching key", func(t *testing.T) {
+		conn, pong := handshake(t, "s3cret")
+		defer conn.Close()
+		assert.Equal(t, true, pong[1])
+		assert.Equal(t, "enricher", pong[3])
+
+		writeFixture(t, conn, "forward_mode.msgpack")
+		entries := backend.WaitFor(t, 2)
+		assert.Equal(t, "first", string(entries[0].LogLine))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		conn, pong := handshake(t, "guess")
+		defer conn.Close()
+		assert.Equal(t, false, pong[1])
+		assert.Equal(t, "shared_key mismatch", pong[2])
+
+		_, err := conn.Read(make([]byte, 1))
+		assert.Error(t, err, "the server closes the connection")
+	})
+}
+
+func TestServer_HandshakeTimesOut(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.FluentForwardSharedKey = "s3cret"
+	cfg.FluentForwardHandshakeTimeoutMs = 50
+	server := startTestServer(t, cfg, &netinputtest.CaptureBackend{})
+
+	conn, err := net.Dial("tcp", server.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	var helo []interface{}
+	require.NoError(t, msgpack.NewDecoder(conn).Decode(&helo))
+	// The client never sends PING, so the server gives up on the connection.
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	var netErr net.Error
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "the server closes the connection before the client's deadline")
+}
+
+func TestServer_ClosesConnectionOnOversizedMessage(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.FluentForwardMaxMessageBytes = 64
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("tcp", server.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	writeFixture(t, conn, "message_mode.msgpack")
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Empty(t, backend.Entries())
+}
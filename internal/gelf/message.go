@@ -0,0 +1,261 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/syslog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	chunkHeaderSize = 12
+	// maxChunks is the chunk count limit of the GELF specification.
+	maxChunks = 128
+)
+
+var (
+	errMessageTooLarge        = errors.New("gelf message exceeds size limit")
+	errTooManyChunkedMessages = errors.New("too many incomplete chunked gelf messages")
+)
+
+// Message is a parsed GELF payload.
+type Message struct {
+	Host         string
+	ShortMessage string
+	FullMessage  string
+	// Timestamp is zero if the message had no timestamp.
+	Timestamp time.Time
+	// Level is the syslog severity, or -1 if the message had no level.
+	Level int
+	// Additional holds the "_"-prefixed fields with the prefix removed, plus the deprecated facility, file and line.
+	Additional map[string]interface{}
+}
+
+// Line returns the full message if there is one and the short message otherwise.
+func (m *Message) Line() []byte {
+	if m.FullMessage != "" {
+		return []byte(m.FullMessage)
+	}
+	return []byte(m.ShortMessage)
+}
+
+// Fields returns the additional fields together with the mapped standard fields as LogEntry fields.
+// The standard fields win over additional fields of the same name.
+func (m *Message) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(m.Additional)+4)
+	for k, v := range m.Additional {
+		fields[k] = v
+	}
+	fields["message"] = m.ShortMessage
+	if m.FullMessage != "" {
+		fields["full_message"] = m.FullMessage
+	}
+	if m.Host != "" {
+		fields["host"] = m.Host
+	}
+	if m.Level >= 0 {
+		fields["level"] = syslog.SeverityName(m.Level)
+	}
+	return fields
+}
+
+// Parse decodes an uncompressed GELF JSON payload.
+func Parse(raw []byte) (*Message, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("invalid gelf JSON: %w", err)
+	}
+
+	msg := &Message{Level: -1, Additional: make(map[string]interface{})}
+	msg.Host, _ = doc["host"].(string)
+	msg.ShortMessage, _ = doc["short_message"].(string)
+	msg.FullMessage, _ = doc["full_message"].(string)
+	if msg.ShortMessage == "" && msg.FullMessage == "" {
+		return nil, fmt.Errorf("gelf message has no short_message")
+	}
+
+	if raw, ok := doc["timestamp"]; ok {
+		seconds, ok := number(raw)
+		if !ok {
+			return nil, fmt.Errorf("invalid gelf timestamp %v", raw)
+		}
+		sec, frac := math.Modf(seconds)
+		msg.Timestamp = time.Unix(int64(sec), int64(math.Round(frac*1e6))*int64(time.Microsecond)).UTC()
+	}
+	if raw, ok := doc["level"]; ok {
+		level, ok := number(raw)
+		if !ok {
+			return nil, fmt.Errorf("invalid gelf level %v", raw)
+		}
+		msg.Level = int(level)
+	}
+
+	for key, value := range doc {
+		switch {
+		case key == "facility" || key == "file" || key == "line":
+			msg.Additional[key] = value
+		// "_id" is reserved by the specification and dropped like Graylog does.
+		case strings.HasPrefix(key, "_") && key != "_id" && len(key) > 1:
+			msg.Additional[key[1:]] = value
+		}
+	}
+	return msg, nil
+}
+
+// number accepts JSON numbers and numeric strings, which some senders use for timestamp and level.
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// decompress detects gzip and zlib payloads by their magic bytes and inflates them up to maxSize bytes.
+// Anything else is returned as is.
+func decompress(payload []byte, maxSize int) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) >= 2 && payload[0]&0x0f == 0x08 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		if len(payload) > maxSize {
+			return nil, errMessageTooLarge
+		}
+		return payload, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed gelf payload: %w", err)
+	}
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed gelf payload: %w", err)
+	}
+	if len(decompressed) > maxSize {
+		return nil, errMessageTooLarge
+	}
+	return decompressed, nil
+}
+
+func isChunk(packet []byte) bool {
+	return len(packet) >= 2 && packet[0] == 0x1e && packet[1] == 0x0f
+}
+
+// chunkedMessage collects the chunks of one message id.
+type chunkedMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// assembler reassembles chunked UDP messages:
+//
+//	0x1e 0x0f | message id (8 bytes) | sequence number (1 byte) | sequence count (1 byte) | payload
+//
+// Incomplete messages are dropped after the timeout; the number of messages in flight and the size of
+// each message are bounded. It is not safe for concurrent use.
+type assembler struct {
+	timeout     time.Duration
+	maxMessages int
+	maxSize     int
+	pending     map[[8]byte]*chunkedMessage
+}
+
+func newAssembler(timeout time.Duration, maxMessages, maxSize int) *assembler {
+	return &assembler{
+		timeout:     timeout,
+		maxMessages: maxMessages,
+		maxSize:     maxSize,
+		pending:     make(map[[8]byte]*chunkedMessage),
+	}
+}
+
+// add stores a chunk and returns the reassembled payload once all chunks of its message have arrived, nil otherwise.
+func (a *assembler) add(packet []byte, now time.Time) ([]byte, error) {
+	if len(packet) < chunkHeaderSize {
+		return nil, fmt.Errorf("gelf chunk of %d bytes is shorter than its header", len(packet))
+	}
+	var id [8]byte
+	copy(id[:], packet[2:10])
+	seq, count := int(packet[10]), int(packet[11])
+	if count == 0 || count > maxChunks {
+		return nil, fmt.Errorf("invalid gelf chunk count %d", count)
+	}
+	if seq >= count {
+		return nil, fmt.Errorf("gelf chunk sequence number %d out of range for %d chunks", seq, count)
+	}
+
+	msg, ok := a.pending[id]
+	if ok && now.Sub(msg.started) > a.timeout {
+		delete(a.pending, id)
+		ok = false
+	}
+	if !ok {
+		if len(a.pending) >= a.maxMessages {
+			a.expire(now)
+			if len(a.pending) >= a.maxMessages {
+				return nil, errTooManyChunkedMessages
+			}
+		}
+		msg = &chunkedMessage{chunks: make([][]byte, count), started: now}
+		a.pending[id] = msg
+	}
+	if len(msg.chunks) != count {
+		delete(a.pending, id)
+		return nil, fmt.Errorf("gelf chunk count changed from %d to %d", len(msg.chunks), count)
+	}
+	if msg.chunks[seq] != nil {
+		return nil, nil // Duplicate datagram.
+	}
+
+	payload := packet[chunkHeaderSize:]
+	msg.size += len(payload)
+	if msg.size > a.maxSize {
+		delete(a.pending, id)
+		return nil, errMessageTooLarge
+	}
+	// The packet buffer is reused for the next datagram.
+	chunk := make([]byte, len(payload))
+	copy(chunk, payload)
+	msg.chunks[seq] = chunk
+	msg.received++
+	if msg.received < count {
+		return nil, nil
+	}
+
+	delete(a.pending, id)
+	return bytes.Join(msg.chunks, nil), nil
+}
+
+// expire drops incomplete messages older than the timeout and returns how many were dropped.
+func (a *assembler) expire(now time.Time) int {
+	dropped := 0
+	for id, msg := range a.pending {
+		if now.Sub(msg.started) > a.timeout {
+			delete(a.pending, id)
+			dropped++
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,131 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	msg, err := Parse([]byte(`{"version":"1.1","host":"web-1","short_message":"request failed","full_message":"request failed\nstack trace",
+		"timestamp":1778051289.125,"level":3,"facility":"api","_user_id":42,"_container_name":"web","_id":"dropped","_":"dropped","ignored":"x"}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "web-1", msg.Host)
+	assert.Equal(t, time.Unix(1778051289, 125000000).UTC(), msg.Timestamp)
+	assert.Equal(t, 3, msg.Level)
+	assert.Equal(t, "request failed\nstack trace", string(msg.Line()))
+	assert.Equal(t, map[string]interface{}{
+		"message":        "request failed",
+		"full_message":   "request failed\nstack trace",
+		"host":           "web-1",
+		"level":          "err",
+		"facility":       "api",
+		"user_id":        float64(42),
+		"container_name": "web",
+	}, msg.Fields())
+}
+
+func TestParse_DefaultsAndErrors(t *testing.T) {
+	msg, err := Parse([]byte(`{"short_message":"hello","timestamp":"1778051289","level":"6","_host":"additional"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(msg.Line()))
+	assert.Equal(t, time.Unix(1778051289, 0).UTC(), msg.Timestamp)
+	assert.Equal(t, "info", msg.Fields()["level"])
+	assert.Equal(t, "additional", msg.Fields()["host"], "additional fields are kept when the standard field is absent")
+
+	msg, err = Parse([]byte(`{"short_message":"no level"}`))
+	require.NoError(t, err)
+	assert.True(t, msg.Timestamp.IsZero())
+	assert.NotContains(t, msg.Fields(), "level")
+
+	for _, raw := range []string{
+		`{"short_message":`,
+		`{"host":"web-1"}`,
+		`{"short_message":"x","timestamp":"yesterday"}`,
+		`{"short_message":"x","level":true}`,
+	} {
+		_, err := Parse([]byte(raw))
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	payload := []byte(`{"short_message":"compressed"}`)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write(payload)
+	require.NoError(t, gw.Close())
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	_, _ = zw.Write(payload)
+	require.NoError(t, zw.Close())
+
+	for name, data := range map[string][]byte{"plain": payload, "gzip": gz.Bytes(), "zlib": zl.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			out, err := decompress(data, 1024)
+			require.NoError(t, err)
+			assert.Equal(t, payload, out)
+
+			_, err = decompress(data, 10)
+			assert.ErrorIs(t, err, errMessageTooLarge)
+		})
+	}
+
+	_, err := decompress([]byte{0x1f, 0x8b, 0x00}, 1024)
+	assert.Error(t, err)
+}
+
+func chunk(id string, seq, count byte, payload string) []byte {
+	packet := append([]byte{0x1e, 0x0f}, []byte(id)...)
+	packet = append(packet, seq, count)
+	return append(packet, payload...)
+}
+
+func TestAssembler(t *testing.T) {
+	now := time.Now()
+	a := newAssembler(time.Second, 2, 16)
+
+	out, err := a.add(chunk("msgid-01", 1, 3, "cd"), now)
+	require.NoError(t, err)
+	assert.Nil(t, out)
+	out, err = a.add(chunk("msgid-01", 0, 3, "ab"), now)
+	require.NoError(t, err)
+	assert.Nil(t, out)
+	out, err = a.add(chunk("msgid-01", 0, 3, "xx"), now)
+	require.NoError(t, err)
+	assert.Nil(t, out, "duplicate chunks are ignored")
+	out, err = a.add(chunk("msgid-01", 2, 3, "ef"), now)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(out))
+	assert.Empty(t, a.pending)
+
+	_, err = a.add(chunk("msgid-02", 0, 2, "a"), now)
+	require.NoError(t, err)
+	_, err = a.add(chunk("msgid-03", 0, 2, "a"), now)
+	require.NoError(t, err)
+	_, err = a.add(chunk("msgid-04", 0, 2, "a"), now)
+	assert.ErrorIs(t, err, errTooManyChunkedMessages)
+
+	later := now.Add(2 * time.Second)
+	_, err = a.add(chunk("msgid-04", 0, 2, "a"), later)
+	require.NoError(t, err, "expired messages make room for new ones")
+	assert.Len(t, a.pending, 1)
+	assert.Equal(t, 1, a.expire(later.Add(2*time.Second)))
+
+	_, err = a.add(chunk("msgid-05", 0, 2, "0123456789abcdef!"), now)
+	assert.ErrorIs(t, err, errMessageTooLarge)
+	_, err = a.add(chunk("msgid-06", 0, 129, "a"), now)
+	assert.Error(t, err)
+	_, err = a.add(chunk("msgid-06", 2, 2, "a"), now)
+	assert.Error(t, err)
+	_, err = a.add([]byte{0x1e, 0x0f, 1}, now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,295 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/backends"
+	"log-enricher/internal/config"
+	"log-enricher/internal/netinput"
+	"log-enricher/internal/pipeline"
+	"log-enricher/internal/syslog"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultMaxMessageSize     = 1024 * 1024
+	defaultChunkTimeout       = 5 * time.Second
+	defaultMaxChunkedMessages = 1000
+	defaultSourceRoot         = "/cache/gelf"
+	fallbackSourceFile        = "gelf.log"
+	fallbackAppName           = "gelf"
+	// maxDatagramSize is the largest possible UDP payload.
+	maxDatagramSize = 65535
+)
+
+// Server receives GELF messages over UDP, chunked and optionally gzip or zlib compressed, and over
+// null-delimited TCP, as sent by Docker's gelf log driver and Graylog client libraries.
+type Server struct {
+	appTemplate        *template.Template
+	sourceTemplate     *template.Template
+	sourceRoot         string
+	maxMessageSize     int
+	chunkTimeout       time.Duration
+	maxChunkedMessages int
+
+	udpAddr string
+	tcpAddr string
+
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+
+	processors *netinput.Processors
+	conns      *netinput.Conns
+}
+
+func NewServer(cfg *config.Config, pm pipeline.Manager, backend backends.Backend) (*Server, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	if pm == nil {
+		return nil, fmt.Errorf("pipeline manager is required")
+	}
+	if backend == nil {
+		return nil, fmt.Errorf("backend is required")
+	}
+	if cfg.GelfUDPAddr == "" && cfg.GelfTCPAddr == "" {
+		return nil, fmt.Errorf("at least one of GELF_UDP_ADDR or GELF_TCP_ADDR must be configured")
+	}
+
+	appTemplate, err := template.New("gelf_app").Parse(cfg.GelfAppTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gelf app template: %w", err)
+	}
+	sourceTemplate, err := template.New("gelf_source").Parse(cfg.GelfSourceTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gelf source template: %w", err)
+	}
+
+	maxMessageSize := cfg.GelfMaxMessageBytes
+	if maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+	chunkTimeout := time.Duration(cfg.GelfChunkTimeoutMs) * time.Millisecond
+	if chunkTimeout <= 0 {
+		chunkTimeout = defaultChunkTimeout
+	}
+	maxChunkedMessages := cfg.GelfMaxChunkedMessages
+	if maxChunkedMessages <= 0 {
+		maxChunkedMessages = defaultMaxChunkedMessages
+	}
+
+	sourceRoot := strings.TrimSpace(cfg.GelfSourceRoot)
+	if sourceRoot == "" {
+		sourceRoot = defaultSourceRoot
+	}
+
+	return &Server{
+		appTemplate:        appTemplate,
+		sourceTemplate:     sourceTemplate,
+		sourceRoot:         filepath.Clean(sourceRoot),
+		maxMessageSize:     maxMessageSize,
+		chunkTimeout:       chunkTimeout,
+		maxChunkedMessages: maxChunkedMessages,
+		udpAddr:            cfg.GelfUDPAddr,
+		tcpAddr:            cfg.GelfTCPAddr,
+		processors:         netinput.NewProcessors(pm, backend, cfg.InputMaxSources, time.Duration(cfg.InputSourceIdleTimeoutMs)*time.Millisecond),
+		conns:              netinput.NewConns("GELF TCP"),
+	}, nil
+}
+
+// Start opens all configured listeners. If one of them fails, the ones already opened are closed again.
+func (s *Server) Start() error {
+	if s.udpAddr != "" {
+		conn, err := net.ListenPacket("udp", s.udpAddr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen for gelf on udp %s: %w", s.udpAddr, err)
+		}
+		s.udpConn = conn
+		slog.Info("GELF UDP listener enabled", "addr", conn.LocalAddr().String())
+	}
+
+	if s.tcpAddr != "" {
+		listener, err := net.Listen("tcp", s.tcpAddr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen for gelf on tcp %s: %w", s.tcpAddr, err)
+		}
+		s.tcpListener = listener
+		slog.Info("GELF TCP listener enabled", "addr", listener.Addr().String())
+	}
+
+	if s.udpConn != nil {
+		s.conns.Go(s.serveUDP)
+	}
+	if s.tcpListener != nil {
+		s.conns.Serve(s.tcpListener, s.serveConn)
+	}
+	return nil
+}
+
+// Shutdown closes all listeners and open connections and waits for in-progress messages until ctx expires.
+// The backend writers of all sources are closed afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.conns.Shutdown(ctx, s.closeListeners)
+	s.processors.Close()
+	return err
+}
+
+func (s *Server) closeListeners() {
+	if s.udpConn != nil {
+		_ = s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		_ = s.tcpListener.Close()
+	}
+}
+
+// UDPAddr returns the bound UDP address, or "" if UDP is disabled.
+func (s *Server) UDPAddr() string {
+	if s.udpConn == nil {
+		return ""
+	}
+	return s.udpConn.LocalAddr().String()
+}
+
+// TCPAddr returns the bound TCP address, or "" if TCP is disabled.
+func (s *Server) TCPAddr() string {
+	if s.tcpListener == nil {
+		return ""
+	}
+	return s.tcpListener.Addr().String()
+}
+
+// serveUDP handles datagrams, which are either a complete, possibly compressed message or one chunk of it.
+// Reads time out periodically so incomplete chunked messages expire even when no traffic arrives.
+func (s *Server) serveUDP() {
+	chunks := newAssembler(s.chunkTimeout, s.maxChunkedMessages, s.maxMessageSize)
+	sweepInterval := min(s.chunkTimeout, time.Second)
+	lastSweep := time.Now()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		_ = s.udpConn.SetReadDeadline(time.Now().Add(sweepInterval))
+		n, addr, err := s.udpConn.ReadFrom(buf)
+		now := time.Now()
+		if now.Sub(lastSweep) >= sweepInterval {
+			if dropped := chunks.expire(now); dropped > 0 {
+				slog.Warn("Dropped incomplete chunked GELF messages", "count", dropped, "timeout", s.chunkTimeout)
+			}
+			lastSweep = now
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if s.conns.Closing() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("Failed to read GELF datagram", "error", err)
+			continue
+		}
+
+		remote := remoteHost(addr)
+		payload := buf[:n]
+		if isChunk(payload) {
+			if payload, err = chunks.add(payload, now); err != nil {
+				slog.Warn("Dropping GELF chunk", "remote_addr", remote, "error", err)
+				continue
+			}
+			if payload == nil {
+				continue
+			}
+		}
+
+		raw, err := decompress(payload, s.maxMessageSize)
+		if err != nil {
+			slog.Warn("Dropping GELF datagram", "remote_addr", remote, "error", err)
+			continue
+		}
+		s.handleMessage(raw, "udp", remote)
+	}
+}
+
+// serveConn reads null-byte delimited, uncompressed messages. A final unterminated frame is processed at EOF.
+func (s *Server) serveConn(conn net.Conn) {
+	remote := remoteHost(conn.RemoteAddr())
+	reader := bufio.NewReaderSize(conn, s.maxMessageSize+1)
+	for {
+		frame, err := reader.ReadSlice(0)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			slog.Warn("Closing GELF TCP connection", "remote_addr", remote, "error", errMessageTooLarge)
+			return
+		}
+		s.handleMessage(bytes.TrimSuffix(frame, []byte{0}), "tcp", remote)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !s.conns.Closing() && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("Closing GELF TCP connection", "remote_addr", remote, "error", err)
+			}
+			return
+		}
+	}
+}
+
+func remoteHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (s *Server) handleMessage(raw []byte, transport, remote string) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return
+	}
+	msg, err := Parse(raw)
+	if err != nil {
+		slog.Warn("Dropping invalid GELF message", "transport", transport, "remote_addr", remote, "error", err)
+		return
+	}
+	if msg.Host == "" {
+		msg.Host = remote
+	}
+
+	data := templateData(msg, transport, remote)
+	app := strings.TrimSpace(netinput.ExecuteTemplate(s.appTemplate, data))
+	if app == "" {
+		app = fallbackAppName
+	}
+	source := netinput.SanitizeSourcePath(s.sourceRoot, netinput.ExecuteTemplate(s.sourceTemplate, data), fallbackSourceFile)
+
+	if err := s.processors.Get(app, source).ProcessLineWithFields(msg.Line(), msg.Timestamp, msg.Fields()); err != nil {
+		slog.Error("Failed to process GELF message", "transport", transport, "remote_addr", remote, "error", err)
+	}
+}
+
+// templateData exposes the string additional fields, host, level, transport and remote address to templates.
+func templateData(msg *Message, transport, remote string) map[string]string {
+	data := make(map[string]string, len(msg.Additional)+4)
+	for k, v := range msg.Additional {
+		if str, ok := v.(string); ok {
+			data[k] = str
+		}
+	}
+	data["host"] = msg.Host
+	if msg.Level >= 0 {
+		data["level"] = syslog.SeverityName(msg.Level)
+	}
+	data["transport"] = transport
+	data["remote_addr"] = remote
+	return data
+}
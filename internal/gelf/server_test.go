@@ -0,0 +1,160 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+	"log-enricher/internal/netinput/netinputtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		GelfUDPAddr:            "127.0.0.1:0",
+		GelfTCPAddr:            "127.0.0.1:0",
+		GelfAppTemplate:        `{{if .container_name}}{{.container_name}}{{else}}gelf{{end}}`,
+		GelfSourceTemplate:     `{{.host}}/{{if .container_name}}{{.container_name}}{{else}}gelf{{end}}.log`,
+		GelfSourceRoot:         t.TempDir(),
+		GelfMaxMessageBytes:    4096,
+		GelfChunkTimeoutMs:     100,
+		GelfMaxChunkedMessages: 10,
+	}
+}
+
+func startTestServer(t *testing.T, cfg *config.Config, backend *netinputtest.CaptureBackend) *Server {
+	t.Helper()
+	server, err := NewServer(cfg, &netinputtest.PipelineManager{}, backend)
+	require.NoError(t, err)
+	netinputtest.Start(t, server)
+	return server
+}
+
+func TestServer_UDPChunkedGzip(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.GelfTCPAddr = ""
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte(`{"version":"1.1","host":"docker-1","short_message":"container started","timestamp":1778051289.5,"level":6,"_container_name":"web","_image_name":"nginx"}`))
+	require.NoError(t, gw.Close())
+	payload := gz.Bytes()
+	half := len(payload) / 2
+
+	conn, err := net.Dial("udp", server.UDPAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	// Chunks may arrive out of order.
+	_, err = conn.Write(chunk("abcdefgh", 1, 2, string(payload[half:])))
+	require.NoError(t, err)
+	_, err = conn.Write(chunk("abcdefgh", 0, 2, string(payload[:half])))
+	require.NoError(t, err)
+
+	entries := backend.WaitFor(t, 1)
+	entry := entries[0]
+	assert.Equal(t, "web", entry.App)
+	assert.Equal(t, filepath.Join(cfg.GelfSourceRoot, "docker-1", "web.log"), entry.SourcePath)
+	assert.Equal(t, "container started", string(entry.LogLine))
+	assert.Equal(t, time.Unix(1778051289, 500000000).UTC(), entry.Timestamp)
+	assert.Equal(t, "info", entry.Fields["level"])
+	assert.Equal(t, "nginx", entry.Fields["image_name"])
+	assert.Equal(t, "docker-1", entry.Fields["host"])
+}
+
+func TestServer_UDPIncompleteChunksExpire(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.GelfTCPAddr = ""
+	cfg.GelfMaxChunkedMessages = 1
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("udp", server.UDPAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(chunk("expired1", 0, 2, `{"short_message":`))
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	// The expired message no longer blocks the single reassembly slot.
+	_, err = conn.Write(chunk("complete", 0, 2, `{"short_message":`))
+	require.NoError(t, err)
+	_, err = conn.Write(chunk("complete", 1, 2, `"after expiry"}`))
+	require.NoError(t, err)
+	_, err = conn.Write(chunk("expired1", 1, 2, `"stale"}`))
+	require.NoError(t, err)
+
+	entries := backend.WaitFor(t, 1)
+	assert.Equal(t, "after expiry", string(entries[0].LogLine))
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, backend.WaitFor(t, 1), 1, "the late chunk of the expired message must not complete it")
+}
+
+func TestServer_TCPNullDelimited(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.GelfUDPAddr = ""
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("tcp", server.TCPAddr())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("{\"short_message\":\"first\",\"_container_name\":\"api\"}\x00" +
+		"not json\x00" +
+		"{\"short_message\":\"second\",\"full_message\":\"second\\nwith details\"}"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	entries := backend.WaitFor(t, 2)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "api", entries[0].App)
+	assert.Equal(t, filepath.Join(cfg.GelfSourceRoot, "127.0.0.1", "api.log"), entries[0].SourcePath, "the remote address is the host fallback")
+	assert.Equal(t, "first", string(entries[0].LogLine))
+	assert.Equal(t, "gelf", entries[1].App)
+	assert.Equal(t, "second\nwith details", string(entries[1].LogLine))
+	assert.Equal(t, "second", entries[1].Fields["message"])
+}
+
+func TestServer_TCPMessageTooLarge(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.GelfUDPAddr = ""
+	cfg.GelfMaxMessageBytes = 64
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("tcp", server.TCPAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(`{"short_message":"` + string(bytes.Repeat([]byte("x"), 128)) + `"}` + "\x00"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err, "the server closes the connection")
+	assert.Empty(t, backend.Entries())
+}
+
+func TestNewServer_Validation(t *testing.T) {
+	cfg := newTestConfig(t)
+	_, err := NewServer(nil, &netinputtest.PipelineManager{}, &netinputtest.CaptureBackend{})
+	assert.Error(t, err)
+	_, err = NewServer(cfg, nil, &netinputtest.CaptureBackend{})
+	assert.Error(t, err)
+	_, err = NewServer(cfg, &netinputtest.PipelineManager{}, nil)
+	assert.Error(t, err)
+
+	cfg.GelfUDPAddr, cfg.GelfTCPAddr = "", ""
+	_, err = NewServer(cfg, &netinputtest.PipelineManager{}, &netinputtest.CaptureBackend{})
+	assert.Error(t, err)
+
+	cfg = newTestConfig(t)
+	cfg.GelfAppTemplate = "{{"
+	_, err = NewServer(cfg, &netinputtest.PipelineManager{}, &netinputtest.CaptureBackend{})
+	assert.Error(t, err)
+}
@@ -52,6 +52,15 @@ func (m *mockBackend) Shutdown() {}
 
 func (m *mockBackend) CloseWriter(sourcePath string) {}
 
+func (m *mockBackend) SendBatch(entries []*models.LogEntry) error {
+	for _, entry := range entries {
+		if err := m.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // captureOutput captures everything written to os.Stdout during the execution of a function.
 func captureOutput(f func()) string {
 	var mu sync.Mutex
@@ -8,6 +8,15 @@ type LogEntry struct {
 	Timestamp  time.Time
 	SourcePath string
 	App        string
+	// Labels are the stream labels an input received with the line, e.g. from a Promtail push. Stages may
+	// change them; the Loki backend sends them as stream labels.
+	Labels map[string]string
+	// StructuredMetadata is the per-line metadata an input received with the line, e.g. a trace ID in a Promtail
+	// push. The Loki backend sends it as structured metadata, so it does not create streams.
+	StructuredMetadata map[string]string
+	// Tenant is the receiver tenant the entry was pushed for; empty for single-tenant inputs. It selects
+	// tenant-restricted stages and the Loki tenant.
+	Tenant string
 }
 
 type DeviceInfo struct {
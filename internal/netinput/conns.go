@@ -0,0 +1,120 @@
+// Package netinput holds what the network inputs (syslog, Fluent Forward and GELF) have in common: serving
+// listeners with a graceful shutdown, a bounded set of per-source processors, and source paths that stay below
+// the input's source root.
+package netinput
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Conns tracks the serving goroutines and open connections of an input, so Shutdown can close them and wait for
+// in-progress messages.
+type Conns struct {
+	name string // Input name used in log messages, e.g. "syslog TCP".
+
+	mu      sync.Mutex // Protects conns and closing
+	conns   map[net.Conn]struct{}
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// NewConns creates the connection tracker of the input called name.
+func NewConns(name string) *Conns {
+	return &Conns{name: name, conns: make(map[net.Conn]struct{})}
+}
+
+// Go runs fn on a goroutine that Shutdown waits for.
+func (c *Conns) Go(fn func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn()
+	}()
+}
+
+// Serve accepts connections from listener until it is closed, and runs serveConn for each of them on its own
+// goroutine. The connection is closed once serveConn returns.
+func (c *Conns) Serve(listener net.Listener, serveConn func(net.Conn)) {
+	c.Go(func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if c.Closing() || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				slog.Error("Failed to accept "+c.name+" connection", "error", err)
+				continue
+			}
+
+			c.mu.Lock()
+			if c.closing {
+				c.mu.Unlock()
+				_ = conn.Close()
+				return
+			}
+			c.conns[conn] = struct{}{}
+			c.wg.Add(1)
+			c.mu.Unlock()
+
+			go func() {
+				defer c.wg.Done()
+				defer func() {
+					c.mu.Lock()
+					delete(c.conns, conn)
+					c.mu.Unlock()
+					_ = conn.Close()
+				}()
+				serveConn(conn)
+			}()
+		}
+	})
+}
+
+// Closing reports whether Shutdown was called. Read errors after that are expected and need not be logged.
+func (c *Conns) Closing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+// Shutdown closes all open connections, calls closeListeners and waits for the serving goroutines until ctx
+// expires.
+func (c *Conns) Shutdown(ctx context.Context, closeListeners func()) error {
+	c.mu.Lock()
+	c.closing = true
+	for conn := range c.conns {
+		_ = conn.Close()
+	}
+	c.mu.Unlock()
+	closeListeners()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ExecuteTemplate renders an app or source template. A template that fails renders as "", so the input falls back
+// to its default.
+func ExecuteTemplate(tmpl *template.Template, data map[string]string) string {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		slog.Debug("Failed to execute input template", "template", tmpl.Name(), "error", err)
+		return ""
+	}
+	return b.String()
+}
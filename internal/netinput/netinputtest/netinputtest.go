@@ -0,0 +1,102 @@
+// Package netinputtest provides what the server tests of the network inputs (syslog, Fluent Forward and GELF)
+// share: a pass-through pipeline, a backend that captures entries, and starting a server for one test.
+package netinputtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+	"log-enricher/internal/pipeline"
+
+	"github.com/stretchr/testify/require"
+)
+
+// PipelineManager returns a pipeline that keeps every entry unchanged.
+type PipelineManager struct{}
+
+func (m *PipelineManager) GetProcessPipeline(filePath string) pipeline.ProcessPipeline {
+	return &processPipeline{}
+}
+
+func (m *PipelineManager) GetTenantProcessPipeline(tenant, filePath string) pipeline.ProcessPipeline {
+	return m.GetProcessPipeline(filePath)
+}
+
+type processPipeline struct{}
+
+func (p *processPipeline) Process(entry *models.LogEntry) bool {
+	return true
+}
+
+// CaptureBackend keeps a copy of every entry sent to it.
+type CaptureBackend struct {
+	mu      sync.Mutex
+	entries []*models.LogEntry
+}
+
+func (b *CaptureBackend) Send(entry *models.LogEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fields := make(map[string]interface{}, len(entry.Fields))
+	for k, v := range entry.Fields {
+		fields[k] = v
+	}
+	b.entries = append(b.entries, &models.LogEntry{
+		Fields:     fields,
+		LogLine:    append([]byte(nil), entry.LogLine...),
+		Timestamp:  entry.Timestamp,
+		SourcePath: entry.SourcePath,
+		App:        entry.App,
+	})
+	return nil
+}
+
+func (b *CaptureBackend) SendBatch(entries []*models.LogEntry) error {
+	for _, entry := range entries {
+		if err := b.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *CaptureBackend) Shutdown()                     {}
+func (b *CaptureBackend) Name() string                  { return "capture" }
+func (b *CaptureBackend) CloseWriter(sourcePath string) {}
+
+// Entries returns the entries captured so far.
+func (b *CaptureBackend) Entries() []*models.LogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*models.LogEntry(nil), b.entries...)
+}
+
+// WaitFor waits until at least n entries were captured and returns them.
+func (b *CaptureBackend) WaitFor(t *testing.T, n int) []*models.LogEntry {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return len(b.Entries()) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	return b.Entries()
+}
+
+// Server is a network input server.
+type Server interface {
+	Start() error
+	Shutdown(ctx context.Context) error
+}
+
+// Start starts server and shuts it down when the test ends.
+func Start(t *testing.T, server Server) {
+	t.Helper()
+	require.NoError(t, server.Start())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, server.Shutdown(ctx))
+	})
+}
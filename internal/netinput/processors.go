@@ -0,0 +1,120 @@
+package netinput
+
+import (
+	"container/list"
+	"log-enricher/internal/backends"
+	"log-enricher/internal/pipeline"
+	"log-enricher/internal/processor"
+	"sync"
+	"time"
+)
+
+// Processors keeps one processor per app and source path. Senders choose both through templates, so the set is
+// bounded: the least recently used processor is evicted once there are maxSize of them, and processors that were
+// not used for idleTimeout are evicted on the next lookup. Evicting a processor closes its source's backend writer.
+type Processors struct {
+	pm          pipeline.Manager
+	backend     backends.Backend
+	maxSize     int
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Of *processorEntry, most recently used first.
+	now     func() time.Time
+}
+
+type processorEntry struct {
+	key      string
+	source   string
+	lp       *processor.LogProcessorImpl
+	lastUsed time.Time
+}
+
+// defaultMaxProcessors is used when no positive maximum is configured.
+const defaultMaxProcessors = 1000
+
+// NewProcessors creates an empty set. An idleTimeout of 0 disables idle eviction.
+func NewProcessors(pm pipeline.Manager, backend backends.Backend, maxSize int, idleTimeout time.Duration) *Processors {
+	if maxSize <= 0 {
+		maxSize = defaultMaxProcessors
+	}
+	return &Processors{
+		pm:          pm,
+		backend:     backend,
+		maxSize:     maxSize,
+		idleTimeout: idleTimeout,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		now:         time.Now,
+	}
+}
+
+// Get returns the processor of app and source, creating it if needed.
+func (p *Processors) Get(app, source string) *processor.LogProcessorImpl {
+	key := app + "\x00" + source
+
+	p.mu.Lock()
+	now := p.now()
+	var lp *processor.LogProcessorImpl
+	if element, ok := p.entries[key]; ok {
+		entry := element.Value.(*processorEntry)
+		entry.lastUsed = now
+		p.lru.MoveToFront(element)
+		lp = entry.lp
+	} else {
+		lp = processor.NewLogProcessor(app, source, p.pm.GetProcessPipeline(source), p.backend)
+		p.entries[key] = p.lru.PushFront(&processorEntry{key: key, source: source, lp: lp, lastUsed: now})
+	}
+	evicted := p.evict(now)
+	p.mu.Unlock()
+
+	p.closeWriters(evicted)
+	return lp
+}
+
+// evict removes processors beyond maxSize and idle ones, and returns their source paths.
+func (p *Processors) evict(now time.Time) []string {
+	var evicted []string
+	for element := p.lru.Back(); element != nil; element = p.lru.Back() {
+		entry := element.Value.(*processorEntry)
+		idle := p.idleTimeout > 0 && now.Sub(entry.lastUsed) >= p.idleTimeout
+		if p.lru.Len() <= p.maxSize && !idle {
+			break
+		}
+		p.lru.Remove(element)
+		delete(p.entries, entry.key)
+		evicted = append(evicted, entry.source)
+	}
+	return evicted
+}
+
+// Len returns the number of processors.
+func (p *Processors) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// Close removes all processors and closes their backend writers. Call it once the input no longer processes
+// messages.
+func (p *Processors) Close() {
+	p.mu.Lock()
+	var sources []string
+	for element := p.lru.Front(); element != nil; element = element.Next() {
+		sources = append(sources, element.Value.(*processorEntry).source)
+	}
+	p.lru.Init()
+	clear(p.entries)
+	p.mu.Unlock()
+
+	p.closeWriters(sources)
+}
+
+// closeWriters closes the backend writers of sources outside the lock, since the backend may block. Several apps
+// can share a source; a writer closed while another processor still uses it is reopened by the next send.
+func (p *Processors) closeWriters(sources []string) {
+	for _, source := range sources {
+		p.backend.CloseWriter(source)
+	}
+}
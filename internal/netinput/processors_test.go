@@ -0,0 +1,71 @@
+package netinput
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+	"log-enricher/internal/netinput/netinputtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closeRecordingBackend records the sources whose writers were closed.
+type closeRecordingBackend struct {
+	mu     sync.Mutex
+	closed []string
+}
+
+func (b *closeRecordingBackend) Send(entry *models.LogEntry) error          { return nil }
+func (b *closeRecordingBackend) SendBatch(entries []*models.LogEntry) error { return nil }
+func (b *closeRecordingBackend) Shutdown()                                  {}
+func (b *closeRecordingBackend) Name() string                               { return "close-recording" }
+
+func (b *closeRecordingBackend) CloseWriter(sourcePath string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = append(b.closed, sourcePath)
+}
+
+func (b *closeRecordingBackend) takeClosed() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	closed := b.closed
+	b.closed = nil
+	return closed
+}
+
+func TestProcessors_EvictsLeastRecentlyUsed(t *testing.T) {
+	backend := &closeRecordingBackend{}
+	processors := NewProcessors(&netinputtest.PipelineManager{}, backend, 2, 0)
+
+	first := processors.Get("web", "/cache/a.log")
+	processors.Get("web", "/cache/b.log")
+	assert.Same(t, first, processors.Get("web", "/cache/a.log"), "processors are reused")
+	assert.Empty(t, backend.takeClosed())
+
+	processors.Get("api", "/cache/c.log")
+	assert.Equal(t, []string{"/cache/b.log"}, backend.takeClosed(), "the least recently used source is closed")
+	assert.Equal(t, 2, processors.Len())
+
+	processors.Close()
+	assert.ElementsMatch(t, []string{"/cache/a.log", "/cache/c.log"}, backend.takeClosed())
+	assert.Zero(t, processors.Len())
+}
+
+func TestProcessors_EvictsIdleProcessors(t *testing.T) {
+	backend := &closeRecordingBackend{}
+	processors := NewProcessors(&netinputtest.PipelineManager{}, backend, 10, time.Minute)
+	now := time.Unix(1700000000, 0)
+	processors.now = func() time.Time { return now }
+
+	processors.Get("web", "/cache/a.log")
+	now = now.Add(30 * time.Second)
+	processors.Get("web", "/cache/b.log")
+	now = now.Add(40 * time.Second)
+	processors.Get("web", "/cache/c.log")
+
+	assert.Equal(t, []string{"/cache/a.log"}, backend.takeClosed())
+	assert.Equal(t, 2, processors.Len())
+}
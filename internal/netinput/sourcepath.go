@@ -0,0 +1,78 @@
+package netinput
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultFallbackFile is used below root when neither the candidate nor the fallback is a usable path.
+const defaultFallbackFile = "stream.log"
+
+// SanitizeSourcePath resolves candidate below root, falling back to root/fallback if it would escape root.
+// The inputs use it to derive source paths from client-supplied values.
+func SanitizeSourcePath(root, candidate, fallback string) string {
+	cleanRoot := filepath.Clean(strings.TrimSpace(root))
+	if cleanRoot == "" {
+		cleanRoot = "."
+	}
+
+	safeFallback := sanitizeRelativePath(fallback)
+	if safeFallback == "" {
+		safeFallback = defaultFallbackFile
+	}
+
+	relativePath := sanitizeRelativePath(candidate)
+	if relativePath == "" {
+		relativePath = safeFallback
+	}
+
+	candidatePath := filepath.Clean(filepath.Join(cleanRoot, relativePath))
+	if isPathEscapingRoot(cleanRoot, candidatePath) {
+		return filepath.Join(cleanRoot, safeFallback)
+	}
+
+	return candidatePath
+}
+
+func sanitizeRelativePath(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+
+	value = strings.ReplaceAll(value, "\\", "/")
+	if len(value) >= 2 && ((value[0] >= 'a' && value[0] <= 'z') || (value[0] >= 'A' && value[0] <= 'Z')) && value[1] == ':' {
+		value = value[2:]
+	}
+	value = strings.TrimLeft(value, "/")
+
+	parts := strings.Split(value, "/")
+	safeParts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		safeParts = append(safeParts, part)
+	}
+
+	if len(safeParts) == 0 {
+		return ""
+	}
+	return filepath.Join(safeParts...)
+}
+
+func isPathEscapingRoot(root, candidate string) bool {
+	root = filepath.Clean(root)
+	candidate = filepath.Clean(candidate)
+
+	rel, err := filepath.Rel(root, candidate)
+	if err != nil {
+		return true
+	}
+	if rel == ".." {
+		return true
+	}
+	return strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
@@ -0,0 +1,28 @@
+package netinput
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeSourcePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		candidate string
+		fallback  string
+		want      string
+	}{
+		{name: "relative path", candidate: "app/current.log", fallback: "fallback.log", want: "/logs/app/current.log"},
+		{name: "absolute path stays below root", candidate: "/var/log/app.log", fallback: "fallback.log", want: "/logs/var/log/app.log"},
+		{name: "parent directories are dropped", candidate: "../../etc/passwd", fallback: "fallback.log", want: "/logs/etc/passwd"},
+		{name: "windows path", candidate: `C:\logs\app.log`, fallback: "fallback.log", want: "/logs/logs/app.log"},
+		{name: "empty candidate uses fallback", candidate: " ", fallback: "syslog/host.log", want: "/logs/syslog/host.log"},
+		{name: "empty fallback uses default", candidate: "..", fallback: "..", want: "/logs/stream.log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizeSourcePath("/logs", tt.candidate, tt.fallback))
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package pipeline
+
+import (
+	"fmt"
+	"log-enricher/internal/models"
+	"log/slog"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+	caddyPreset         = "caddy"
+
+	// Parts of the preset regexes. Quoted values may contain escaped quotes.
+	clfPrefix      = `(?P<client_ip>\S+) \S+ (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<request>(?:[^"\\]|\\.)*)" (?P<status>\d{3}) (?P<bytes>\d+|-)`
+	combinedSuffix = ` "(?P<referer>(?:[^"\\]|\\.)*)" "(?P<user_agent>(?:[^"\\]|\\.)*)"`
+)
+
+// accessLogPresets are the regexes of the text log formats. Their group names are the fields they produce, apart
+// from time, request and request_time_ms, which are converted by the stage.
+var accessLogPresets = map[string]*regexp.Regexp{
+	// Common Log Format, `%h %l %u %t "%r" %>s %b`.
+	"clf": regexp.MustCompile(`^` + clfPrefix + `$`),
+	// Combined Log Format, CLF followed by the referer and user agent.
+	"combined": regexp.MustCompile(`^` + clfPrefix + combinedSuffix + `$`),
+	// Nginx's combined format, optionally followed by "$http_x_forwarded_for" as in the official image's main format.
+	"nginx_default": regexp.MustCompile(`^` + clfPrefix + combinedSuffix + `(?: "(?P<forwarded_for>(?:[^"\\]|\\.)*)")?$`),
+	// Apache's vhost_combined format, `%v:%p %h %l %u %t "%r" %>s %O "%{Referer}i" "%{User-Agent}i"`.
+	"apache_vhost_combined": regexp.MustCompile(`^(?P<vhost>[^\s:]+):(?P<port>\d+) ` + clfPrefix + combinedSuffix + `$`),
+	// Traefik's CLF access log, the combined format followed by the request count, router, server URL and duration.
+	"traefik_clf": regexp.MustCompile(`^` + clfPrefix + combinedSuffix +
+		` (?P<request_count>\d+) "(?P<router>(?:[^"\\]|\\.)*)" "(?P<server_url>(?:[^"\\]|\\.)*)" (?P<request_time_ms>\d+)ms$`),
+}
+
+// accessLogIntFields are converted to ints; the other captures are strings.
+var accessLogIntFields = map[string]bool{"status": true, "bytes": true, "port": true, "request_count": true}
+
+// AccessLogParserConfig holds the configuration for the access_log_parser stage.
+type AccessLogParserConfig struct {
+	// Preset is the log format: clf, combined, nginx_default, apache_vhost_combined, traefik_clf or caddy.
+	Preset string `mapstructure:"preset"`
+}
+
+// caddyAccessLog is the part of a Caddy JSON access log entry the stage reads. ts and duration are numbers by
+// default, and strings if Caddy's time or duration format is changed.
+type caddyAccessLog struct {
+	TS      interface{} `json:"ts"`
+	Request *struct {
+		RemoteIP string              `json:"remote_ip"`
+		ClientIP string              `json:"client_ip"`
+		Proto    string              `json:"proto"`
+		Method   string              `json:"method"`
+		Host     string              `json:"host"`
+		URI      string              `json:"uri"`
+		Headers  map[string][]string `json:"headers"`
+	} `json:"request"`
+	UserID   string      `json:"user_id"`
+	Duration interface{} `json:"duration"`
+	Size     int64       `json:"size"`
+	Status   int         `json:"status"`
+}
+
+// AccessLogParser parses web server access logs into standardized fields: client_ip, user, method, path, protocol,
+// status, bytes, referer, user_agent and request_time (in seconds) where the format has them, plus format-specific
+// fields such as vhost. The request time becomes the entry's timestamp.
+type AccessLogParser struct {
+	regex *regexp.Regexp // Nil for the caddy preset.
+}
+
+func (p *AccessLogParser) Name() string {
+	return "access_log_parser"
+}
+
+// NewAccessLogParser creates a new access_log_parser stage.
+func NewAccessLogParser(params map[string]interface{}) (Stage, error) {
+	var cfg AccessLogParserConfig
+	if err := mapstructure.WeakDecode(params, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode access log parser config: %w", err)
+	}
+
+	parser := &AccessLogParser{regex: accessLogPresets[cfg.Preset]}
+	if parser.regex == nil && cfg.Preset != caddyPreset {
+		presets := []string{caddyPreset}
+		for name := range accessLogPresets {
+			presets = append(presets, name)
+		}
+		sort.Strings(presets)
+		return nil, fmt.Errorf("invalid access log parser 'preset' value %q (expected one of %s)", cfg.Preset, strings.Join(presets, ", "))
+	}
+
+	slog.Info("Access log parser stage initialized", "preset", cfg.Preset)
+	return parser, nil
+}
+
+// Process parses the line into the entry's fields and sets its timestamp. Lines that do not match the preset are
+// left unchanged.
+func (p *AccessLogParser) Process(logEntry *models.LogEntry) (bool, error) {
+	// Skip any log lines that already have fields (another parser was successful)
+	if len(logEntry.Fields) > 0 {
+		return true, nil
+	}
+	if logEntry.Fields == nil {
+		logEntry.Fields = make(map[string]interface{})
+	}
+
+	if p.regex == nil {
+		p.parseCaddy(logEntry)
+	} else {
+		p.parseText(logEntry)
+	}
+
+	// Always keep the log line
+	return true, nil
+}
+
+func (p *AccessLogParser) parseText(logEntry *models.LogEntry) {
+	match := p.regex.FindSubmatch(logEntry.LogLine)
+	if match == nil {
+		return
+	}
+
+	for i, name := range p.regex.SubexpNames() {
+		// Index 0 is the full match, and unmatched optional groups are nil.
+		if i == 0 || match[i] == nil {
+			continue
+		}
+		value := string(match[i])
+		switch {
+		case name == "time":
+			if timestamp, err := time.Parse(accessLogTimeLayout, value); err == nil {
+				logEntry.Timestamp = timestamp
+			}
+		case name == "request":
+			setRequestLine(logEntry.Fields, value)
+		case name == "request_time_ms":
+			if ms, err := strconv.Atoi(value); err == nil {
+				logEntry.Fields["request_time"] = float64(ms) / 1000
+			}
+		case name == "bytes" && value == "-":
+			// CLF logs a response without a body as -.
+			logEntry.Fields[name] = 0
+		case accessLogIntFields[name]:
+			if n, err := strconv.Atoi(value); err == nil {
+				logEntry.Fields[name] = n
+			}
+		default:
+			setAccessLogField(logEntry.Fields, name, value)
+		}
+	}
+}
+
+func (p *AccessLogParser) parseCaddy(logEntry *models.LogEntry) {
+	if len(logEntry.LogLine) == 0 || logEntry.LogLine[0] != '{' {
+		return
+	}
+	var record caddyAccessLog
+	if err := json.Unmarshal(logEntry.LogLine, &record); err != nil || record.Request == nil || record.Request.Method == "" {
+		return
+	}
+
+	request := record.Request
+	clientIP := request.ClientIP
+	if clientIP == "" {
+		clientIP = request.RemoteIP
+	}
+	fields := logEntry.Fields
+	setAccessLogField(fields, "client_ip", clientIP)
+	setAccessLogField(fields, "user", record.UserID)
+	setAccessLogField(fields, "method", request.Method)
+	setAccessLogField(fields, "path", request.URI)
+	setAccessLogField(fields, "protocol", request.Proto)
+	setAccessLogField(fields, "vhost", request.Host)
+	if len(request.Headers["Referer"]) > 0 {
+		setAccessLogField(fields, "referer", request.Headers["Referer"][0])
+	}
+	if len(request.Headers["User-Agent"]) > 0 {
+		setAccessLogField(fields, "user_agent", request.Headers["User-Agent"][0])
+	}
+	fields["status"] = record.Status
+	fields["bytes"] = int(record.Size)
+
+	switch duration := record.Duration.(type) {
+	case float64:
+		fields["request_time"] = duration
+	case string:
+		if d, err := time.ParseDuration(duration); err == nil {
+			fields["request_time"] = d.Seconds()
+		}
+	}
+	switch ts := record.TS.(type) {
+	case float64:
+		seconds, fraction := math.Modf(ts)
+		logEntry.Timestamp = time.Unix(int64(seconds), int64(fraction*1e9))
+	case string:
+		if timestamp, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			logEntry.Timestamp = timestamp
+		}
+	}
+}
+
+// setRequestLine stores method, path and protocol of a request line such as `GET /index.html HTTP/1.1`. Request
+// lines that do not have these parts, e.g. from clients that do not speak HTTP, are stored as request.
+func setRequestLine(fields map[string]interface{}, request string) {
+	parts := strings.Split(request, " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || !strings.HasPrefix(parts[2], "HTTP/") {
+		setAccessLogField(fields, "request", request)
+		return
+	}
+	fields["method"] = parts[0]
+	fields["path"] = parts[1]
+	fields["protocol"] = parts[2]
+}
+
+// setAccessLogField stores a value unless it is empty or -, which access logs use for missing values.
+func setAccessLogField(fields map[string]interface{}, name, value string) {
+	if value != "" && value != "-" {
+		fields[name] = value
+	}
+}
@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogParser_Presets(t *testing.T) {
+	timestamp := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+	combined := map[string]interface{}{
+		"client_ip": "203.0.113.9", "user": "frank", "method": "GET", "path": "/apache_pb.gif?x=1", "protocol": "HTTP/1.1",
+		"status": 200, "bytes": 2326, "referer": "http://www.example.com/start.html", "user_agent": `Mozilla/5.0 (X11; \"quoted\")`,
+	}
+	withFields := func(extra map[string]interface{}) map[string]interface{} {
+		fields := make(map[string]interface{}, len(combined)+len(extra))
+		for k, v := range combined {
+			fields[k] = v
+		}
+		for k, v := range extra {
+			fields[k] = v
+		}
+		return fields
+	}
+	const request = `203.0.113.9 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?x=1 HTTP/1.1" 200 2326 "http://www.example.com/start.html" "Mozilla/5.0 (X11; \"quoted\")"`
+
+	tests := []struct {
+		name      string
+		preset    string
+		line      string
+		want      map[string]interface{}
+		timestamp time.Time
+	}{
+		{
+			name:      "clf with missing user and body",
+			preset:    "clf",
+			line:      `2001:db8::1 - - [10/Oct/2000:13:55:36 -0700] "HEAD / HTTP/1.0" 304 -`,
+			want:      map[string]interface{}{"client_ip": "2001:db8::1", "method": "HEAD", "path": "/", "protocol": "HTTP/1.0", "status": 304, "bytes": 0},
+			timestamp: timestamp,
+		},
+		{name: "combined", preset: "combined", line: request, want: combined, timestamp: timestamp},
+		{name: "nginx without forwarded for", preset: "nginx_default", line: request, want: combined, timestamp: timestamp},
+		{
+			name:      "nginx main format",
+			preset:    "nginx_default",
+			line:      request + ` "198.51.100.1, 10.0.0.1"`,
+			want:      withFields(map[string]interface{}{"forwarded_for": "198.51.100.1, 10.0.0.1"}),
+			timestamp: timestamp,
+		},
+		{
+			name:      "apache vhost combined",
+			preset:    "apache_vhost_combined",
+			line:      "www.example.com:443 " + request,
+			want:      withFields(map[string]interface{}{"vhost": "www.example.com", "port": 443}),
+			timestamp: timestamp,
+		},
+		{
+			name:   "traefik clf",
+			preset: "traefik_clf",
+			line:   request + ` 42 "web@docker" "http://172.17.0.3:80" 12ms`,
+			want: withFields(map[string]interface{}{
+				"request_count": 42, "router": "web@docker", "server_url": "http://172.17.0.3:80", "request_time": 0.012,
+			}),
+			timestamp: timestamp,
+		},
+		{
+			name:   "non-http request line",
+			preset: "clf",
+			line:   `198.51.100.7 - - [10/Oct/2000:13:55:36 -0700] "\x16\x03\x01" 400 157`,
+			want: map[string]interface{}{
+				"client_ip": "198.51.100.7", "request": `\x16\x03\x01`, "status": 400, "bytes": 157,
+			},
+			timestamp: timestamp,
+		},
+		{
+			name:   "caddy",
+			preset: "caddy",
+			line: `{"level":"info","ts":1646861401.5,"logger":"http.log.access","msg":"handled request","request":{"remote_ip":"10.0.0.2","remote_port":"41342",` +
+				`"client_ip":"203.0.113.9","proto":"HTTP/2.0","method":"GET","host":"example.com","uri":"/?q=1","headers":{"User-Agent":["curl/8.0"],"Referer":["-"]}},` +
+				`"bytes_read":0,"user_id":"","duration":0.25,"size":10900,"status":200}`,
+			want: map[string]interface{}{
+				"client_ip": "203.0.113.9", "method": "GET", "path": "/?q=1", "protocol": "HTTP/2.0", "vhost": "example.com",
+				"user_agent": "curl/8.0", "status": 200, "bytes": 10900, "request_time": 0.25,
+			},
+			timestamp: time.Unix(1646861401, 5e8),
+		},
+		{name: "combined line for clf", preset: "clf", line: request, want: map[string]interface{}{}},
+		{name: "plain text", preset: "combined", line: "server started", want: map[string]interface{}{}},
+		{name: "caddy non-access log", preset: "caddy", line: `{"level":"info","msg":"serving"}`, want: map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stage, err := NewAccessLogParser(map[string]interface{}{"preset": tt.preset})
+			require.NoError(t, err)
+
+			entry := &models.LogEntry{LogLine: []byte(tt.line), Fields: map[string]interface{}{}}
+			keep, err := stage.Process(entry)
+			require.NoError(t, err)
+			assert.True(t, keep)
+			assert.Equal(t, tt.want, entry.Fields)
+			assert.True(t, tt.timestamp.Equal(entry.Timestamp), "timestamp %v, want %v", entry.Timestamp, tt.timestamp)
+		})
+	}
+}
+
+func TestAccessLogParser_SkipsParsedEntriesAndRejectsUnknownPresets(t *testing.T) {
+	stage, err := NewAccessLogParser(map[string]interface{}{"preset": "combined"})
+	require.NoError(t, err)
+	entry := &models.LogEntry{LogLine: []byte(`1.2.3.4 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 1 "-" "-"`), Fields: map[string]interface{}{"existing": "value"}}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"existing": "value"}, entry.Fields)
+
+	_, err = NewAccessLogParser(map[string]interface{}{"preset": "iis"})
+	assert.ErrorContains(t, err, "apache_vhost_combined, caddy, clf, combined, nginx_default, traefik_clf")
+	_, err = NewAccessLogParser(map[string]interface{}{})
+	assert.Error(t, err)
+}
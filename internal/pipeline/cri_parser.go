@@ -0,0 +1,170 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"log-enricher/internal/models"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	criPathMetadataFields = "fields"
+	criPathMetadataLabels = "labels"
+)
+
+// podLogPath matches the kubelet's log paths, /var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart count>.log.
+// Namespaces, pod names and UIDs cannot contain underscores.
+var podLogPath = regexp.MustCompile(`(?:^|/)([^/_]+)_([^/_]+)_([^/_]+)/([^/]+)/(\d+)\.log$`)
+
+// CRIParserConfig holds the configuration for the cri_parser stage.
+type CRIParserConfig struct {
+	// StreamLabel is the label the line's stream (stdout or stderr) is stored in.
+	StreamLabel string `mapstructure:"stream_label"`
+	// MaxBytes limits a line joined from partial lines; a longer line is emitted once it reaches the limit.
+	MaxBytes int `mapstructure:"max_bytes"`
+	// PartialTimeoutMs is how long a line may wait for its next partial line before its parts are dropped.
+	PartialTimeoutMs int `mapstructure:"partial_timeout_ms"`
+	// PathMetadata is "fields" or "labels" to store the Kubernetes metadata of /var/log/pods paths there. Labels
+	// only get namespace, pod and container; the per-instance pod_uid and restart_count become structured metadata.
+	PathMetadata string `mapstructure:"path_metadata"`
+}
+
+// CRIParserStage parses the log format of CRI runtimes such as containerd and CRI-O,
+// `<RFC3339Nano timestamp> <stream> <P|F> <message>`. Partial (P) lines are held back and joined with the full (F)
+// line that ends them. Like with docker_json, held back parts are lost on restart.
+type CRIParserStage struct {
+	streamLabel  string
+	pathMetadata string
+	partials     *partialLines
+}
+
+// NewCRIParserStage creates a new cri_parser stage.
+func NewCRIParserStage(params map[string]interface{}) (Stage, error) {
+	cfg := CRIParserConfig{StreamLabel: "stream", MaxBytes: 1024 * 1024, PartialTimeoutMs: defaultPartialTimeoutMs}
+	if err := mapstructure.WeakDecode(params, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode cri_parser stage config: %w", err)
+	}
+	if cfg.MaxBytes <= 0 {
+		return nil, fmt.Errorf("cri_parser stage 'max_bytes' must be positive")
+	}
+	if cfg.PartialTimeoutMs <= 0 {
+		return nil, fmt.Errorf("cri_parser stage 'partial_timeout_ms' must be positive")
+	}
+	switch cfg.PathMetadata {
+	case "", criPathMetadataFields, criPathMetadataLabels:
+	default:
+		return nil, fmt.Errorf("invalid cri_parser 'path_metadata' value %q (expected fields or labels)", cfg.PathMetadata)
+	}
+
+	slog.Info("CRI parser stage initialized", "stream_label", cfg.StreamLabel, "max_bytes", cfg.MaxBytes, "partial_timeout_ms", cfg.PartialTimeoutMs, "path_metadata", cfg.PathMetadata)
+	return &CRIParserStage{
+		streamLabel:  cfg.StreamLabel,
+		pathMetadata: cfg.PathMetadata,
+		partials:     newPartialLines(cfg.MaxBytes, time.Duration(cfg.PartialTimeoutMs)*time.Millisecond),
+	}, nil
+}
+
+func (s *CRIParserStage) Name() string {
+	return "cri_parser"
+}
+
+func (s *CRIParserStage) joinsPartialLines() {}
+
+// Process replaces the entry's line with the CRI message and sets its timestamp and stream label. Partial lines
+// are dropped after their content was stored; the full line that ends them carries the joined message. Lines that
+// are not in the CRI format are left unchanged, like the parsers do with lines they do not match.
+func (s *CRIParserStage) Process(entry *models.LogEntry) (bool, error) {
+	if s.pathMetadata != "" {
+		s.setPathMetadata(entry)
+	}
+
+	parts := bytes.SplitN(entry.LogLine, []byte(" "), 4)
+	if len(parts) < 3 {
+		return true, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, string(parts[0]))
+	if err != nil {
+		return true, nil
+	}
+	stream := string(parts[1])
+	// The tag may carry further colon-separated tags after P or F.
+	tag, _, _ := bytes.Cut(parts[2], []byte(":"))
+	if len(tag) != 1 || (tag[0] != 'P' && tag[0] != 'F') {
+		return true, nil
+	}
+	var message string
+	if len(parts) == 4 {
+		message = string(parts[3])
+	}
+
+	line, complete := s.partials.add(entry.SourcePath+"\x00"+stream, message, tag[0] == 'F')
+	if !complete {
+		return false, nil
+	}
+
+	entry.LogLine = []byte(line)
+	entry.Timestamp = timestamp
+	if s.streamLabel != "" {
+		if entry.Labels == nil {
+			entry.Labels = make(map[string]string)
+		}
+		entry.Labels[s.streamLabel] = stream
+	}
+	return true, nil
+}
+
+// setPathMetadata uses the container of a /var/log/pods path as the entry's app and, in labels mode, stores
+// namespace, pod and container as labels. pod_uid and restart_count change with every pod and restart, so they
+// are structured metadata instead of labels, which would create a stream per pod instance. Other paths are left
+// alone. Fields are only added by finish, after the parsers ran.
+func (s *CRIParserStage) setPathMetadata(entry *models.LogEntry) {
+	match := podLogPath.FindStringSubmatch(entry.SourcePath)
+	if match == nil {
+		return
+	}
+	entry.App = match[4]
+	if s.pathMetadata != criPathMetadataLabels {
+		return
+	}
+
+	if entry.Labels == nil {
+		entry.Labels = make(map[string]string, 3)
+	}
+	entry.Labels["namespace"] = match[1]
+	entry.Labels["pod"] = match[2]
+	entry.Labels["container"] = match[4]
+	if entry.StructuredMetadata == nil {
+		entry.StructuredMetadata = make(map[string]string, 2)
+	}
+	entry.StructuredMetadata["pod_uid"] = match[3]
+	entry.StructuredMetadata["restart_count"] = match[5]
+}
+
+// finish stores namespace, pod, pod_uid, container and restart_count of a /var/log/pods path as fields in fields
+// mode. It runs after the parsers, which would skip the line if it already had fields.
+func (s *CRIParserStage) finish(entry *models.LogEntry) {
+	if s.pathMetadata != criPathMetadataFields {
+		return
+	}
+	match := podLogPath.FindStringSubmatch(entry.SourcePath)
+	if match == nil {
+		return
+	}
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]interface{}, 5)
+	}
+	entry.Fields["namespace"] = match[1]
+	entry.Fields["pod"] = match[2]
+	entry.Fields["pod_uid"] = match[3]
+	entry.Fields["container"] = match[4]
+	if restarts, err := strconv.Atoi(match[5]); err == nil {
+		entry.Fields["restart_count"] = restarts
+	} else {
+		entry.Fields["restart_count"] = match[5]
+	}
+}
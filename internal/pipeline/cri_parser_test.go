@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const podLog = "/var/log/pods/shop_api-7d9f8-xk2_0f1e2d3c-aaaa-bbbb-cccc-1234567890ab/api/2.log"
+
+func TestNewCRIParserStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "defaults", params: map[string]interface{}{}},
+		{name: "path metadata as fields", params: map[string]interface{}{"path_metadata": "fields"}},
+		{name: "path metadata as labels", params: map[string]interface{}{"path_metadata": "labels"}},
+		{name: "invalid path metadata", params: map[string]interface{}{"path_metadata": "yes"}, wantErr: true},
+		{name: "invalid max_bytes", params: map[string]interface{}{"max_bytes": "-1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCRIParserStage(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewCRIParserStage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCRIParserStage_ParsesAndJoinsPartialLines(t *testing.T) {
+	stage, err := NewCRIParserStage(map[string]interface{}{})
+	require.NoError(t, err)
+	process := func(line string) (*models.LogEntry, bool) {
+		entry := &models.LogEntry{LogLine: []byte(line), SourcePath: podLog, Fields: map[string]interface{}{}}
+		keep, err := stage.Process(entry)
+		require.NoError(t, err)
+		return entry, keep
+	}
+
+	entry, keep := process(`2024-01-02T03:04:05.123456789Z stdout F {"level":"info"}`)
+	require.True(t, keep)
+	assert.Equal(t, `{"level":"info"}`, string(entry.LogLine))
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC), entry.Timestamp.UTC())
+	assert.Equal(t, map[string]string{"stream": "stdout"}, entry.Labels)
+	assert.Empty(t, entry.Fields, "later parsers still run")
+
+	_, keep = process("2024-01-02T03:04:06Z stdout P first ")
+	assert.False(t, keep, "partial lines are held back")
+	entry, keep = process("2024-01-02T03:04:06Z stderr F")
+	require.True(t, keep)
+	assert.Empty(t, entry.LogLine, "streams are joined separately")
+	entry, keep = process("2024-01-02T03:04:07+01:00 stdout F:x half")
+	require.True(t, keep)
+	assert.Equal(t, "first half", string(entry.LogLine))
+	assert.Equal(t, time.Date(2024, 1, 2, 2, 4, 7, 0, time.UTC), entry.Timestamp.UTC())
+
+	for _, line := range []string{"plain text", "yesterday stdout F message", "2024-01-02T03:04:05Z stdout X message"} {
+		entry := &models.LogEntry{LogLine: []byte(line)}
+		keep, err := stage.Process(entry)
+		assert.NoError(t, err, line)
+		assert.True(t, keep)
+		assert.Equal(t, line, string(entry.LogLine))
+	}
+}
+
+func TestCRIParserStage_PathMetadata(t *testing.T) {
+	line := "2024-01-02T03:04:05Z stdout F hello"
+
+	stage, err := NewCRIParserStage(map[string]interface{}{"path_metadata": "fields"})
+	require.NoError(t, err)
+	entry := &models.LogEntry{LogLine: []byte(line), SourcePath: podLog, App: "pods"}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Empty(t, entry.Fields, "fields are added after the parsers ran")
+	stage.(*CRIParserStage).finish(entry)
+	assert.Equal(t, map[string]interface{}{
+		"namespace":     "shop",
+		"pod":           "api-7d9f8-xk2",
+		"pod_uid":       "0f1e2d3c-aaaa-bbbb-cccc-1234567890ab",
+		"container":     "api",
+		"restart_count": 2,
+	}, entry.Fields)
+	assert.Equal(t, "api", entry.App)
+
+	stage, err = NewCRIParserStage(map[string]interface{}{"path_metadata": "labels", "stream_label": ""})
+	require.NoError(t, err)
+	entry = &models.LogEntry{LogLine: []byte(line), SourcePath: "/logs/pods/kube-system_dns_uid/coredns/0.log"}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"namespace": "kube-system",
+		"pod":       "dns",
+		"container": "coredns",
+	}, entry.Labels, "labels only get metadata that is stable across pod instances")
+	assert.Equal(t, map[string]string{
+		"pod_uid":       "uid",
+		"restart_count": "0",
+	}, entry.StructuredMetadata)
+	stage.(*CRIParserStage).finish(entry)
+	assert.Empty(t, entry.Fields)
+
+	entry = &models.LogEntry{LogLine: []byte(line), SourcePath: "/var/log/containers/api.log", App: "containers"}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, "containers", entry.App, "other paths keep their app")
+	assert.Nil(t, entry.Labels)
+}
+
+func TestCRIParserStage_PathMetadataKeepsLineParseable(t *testing.T) {
+	for _, mode := range []string{"fields", "labels"} {
+		t.Run(mode, func(t *testing.T) {
+			m, err := NewManager(&config.Config{Stages: []config.StageConfig{
+				{Type: "cri_parser", Params: map[string]interface{}{"path_metadata": mode}},
+				{Type: "json_parser"},
+			}}, context.Background())
+			require.NoError(t, err)
+
+			entry := &models.LogEntry{LogLine: []byte(`2024-01-02T03:04:05Z stdout F {"level":"warn"}`), SourcePath: podLog}
+			require.True(t, m.GetProcessPipeline(podLog).Process(entry))
+			assert.Equal(t, "warn", entry.Fields["level"], "the parser after cri_parser still parses the line")
+			if mode == "fields" {
+				assert.Equal(t, "shop", entry.Fields["namespace"])
+				assert.Equal(t, 2, entry.Fields["restart_count"])
+			} else {
+				assert.Equal(t, "shop", entry.Labels["namespace"])
+				assert.Equal(t, "2", entry.StructuredMetadata["restart_count"])
+			}
+		})
+	}
+}
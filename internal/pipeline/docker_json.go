@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"fmt"
+	"log-enricher/internal/models"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/mitchellh/mapstructure"
+)
+
+// DockerJSONConfig holds the configuration for the docker_json stage.
+type DockerJSONConfig struct {
+	// StreamLabel is the label the record's stream (stdout or stderr) is stored in.
+	StreamLabel string `mapstructure:"stream_label"`
+	// MaxBytes limits a line reassembled from partial records; a longer line is emitted once it reaches the limit.
+	MaxBytes int `mapstructure:"max_bytes"`
+	// PartialTimeoutMs is how long a line may wait for its next record before its parts are dropped.
+	PartialTimeoutMs int `mapstructure:"partial_timeout_ms"`
+}
+
+// dockerJSONRecord is a record of Docker's json-file log driver.
+type dockerJSONRecord struct {
+	Log    *string   `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// DockerJSONStage unwraps records of Docker's json-file log driver, so later stages see the container's log line.
+// Docker splits lines longer than 16KB into records without a trailing newline; these are held back and joined
+// with the record that ends the line. Held back parts are only kept in memory: inputs count their records as
+// processed, so after a restart the record that ends the line is emitted without them.
+type DockerJSONStage struct {
+	streamLabel string
+	partials    *partialLines
+}
+
+// NewDockerJSONStage creates a new docker_json stage.
+func NewDockerJSONStage(params map[string]interface{}) (Stage, error) {
+	cfg := DockerJSONConfig{StreamLabel: "stream", MaxBytes: 1024 * 1024, PartialTimeoutMs: defaultPartialTimeoutMs}
+	if err := mapstructure.WeakDecode(params, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode docker_json stage config: %w", err)
+	}
+	if cfg.MaxBytes <= 0 {
+		return nil, fmt.Errorf("docker_json stage 'max_bytes' must be positive")
+	}
+	if cfg.PartialTimeoutMs <= 0 {
+		return nil, fmt.Errorf("docker_json stage 'partial_timeout_ms' must be positive")
+	}
+
+	slog.Info("Docker JSON stage initialized", "stream_label", cfg.StreamLabel, "max_bytes", cfg.MaxBytes, "partial_timeout_ms", cfg.PartialTimeoutMs)
+	return &DockerJSONStage{
+		streamLabel: cfg.StreamLabel,
+		partials:    newPartialLines(cfg.MaxBytes, time.Duration(cfg.PartialTimeoutMs)*time.Millisecond),
+	}, nil
+}
+
+func (s *DockerJSONStage) Name() string {
+	return "docker_json"
+}
+
+func (s *DockerJSONStage) joinsPartialLines() {}
+
+// Process replaces the entry's line with the record's log line and sets its timestamp and stream label.
+// Partial records are dropped after their content was stored; the record that completes the line carries it.
+// Lines that are not json-file records are left unchanged, like the parsers do with lines they do not match.
+func (s *DockerJSONStage) Process(entry *models.LogEntry) (bool, error) {
+	if len(entry.LogLine) == 0 || entry.LogLine[0] != '{' {
+		return true, nil
+	}
+	var record dockerJSONRecord
+	if err := json.Unmarshal(entry.LogLine, &record); err != nil || record.Log == nil {
+		return true, nil
+	}
+
+	line, complete := s.partials.add(entry.SourcePath+"\x00"+record.Stream, *record.Log, strings.HasSuffix(*record.Log, "\n"))
+	if !complete {
+		return false, nil
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	entry.LogLine = []byte(strings.TrimSuffix(line, "\r"))
+	if !record.Time.IsZero() {
+		entry.Timestamp = record.Time
+	}
+	if s.streamLabel != "" && record.Stream != "" {
+		if entry.Labels == nil {
+			entry.Labels = make(map[string]string)
+		}
+		entry.Labels[s.streamLabel] = record.Stream
+	}
+	return true, nil
+}
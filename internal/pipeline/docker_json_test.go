@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDockerJSONStage(t *testing.T) {
+	_, err := NewDockerJSONStage(map[string]interface{}{"max_bytes": "0"})
+	assert.Error(t, err)
+	_, err = NewDockerJSONStage(map[string]interface{}{"max_bytes": "big"})
+	assert.Error(t, err)
+	_, err = NewDockerJSONStage(map[string]interface{}{"partial_timeout_ms": "0"})
+	assert.Error(t, err)
+
+	stage, err := NewDockerJSONStage(map[string]interface{}{"stream_label": "docker_stream", "max_bytes": "64", "partial_timeout_ms": "5000"})
+	require.NoError(t, err)
+	assert.Equal(t, "docker_stream", stage.(*DockerJSONStage).streamLabel)
+	assert.Equal(t, 64, stage.(*DockerJSONStage).partials.maxBytes)
+	assert.Equal(t, 5*time.Second, stage.(*DockerJSONStage).partials.maxAge)
+}
+
+func TestDockerJSONStage_UnwrapsRecordForLaterParsers(t *testing.T) {
+	stage, err := NewDockerJSONStage(map[string]interface{}{})
+	require.NoError(t, err)
+
+	entry := &models.LogEntry{
+		LogLine:    []byte(`{"log":"{\"level\":\"warn\",\"msg\":\"slow\"}\r\n","stream":"stderr","time":"2024-01-02T03:04:05.123456789Z"}`),
+		SourcePath: "/var/lib/docker/containers/abc/abc-json.log",
+		Fields:     map[string]interface{}{},
+	}
+	keep, err := stage.Process(entry)
+	require.NoError(t, err)
+	assert.True(t, keep)
+	assert.Equal(t, `{"level":"warn","msg":"slow"}`, string(entry.LogLine))
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC), entry.Timestamp.UTC())
+	assert.Equal(t, map[string]string{"stream": "stderr"}, entry.Labels)
+	assert.Empty(t, entry.Fields, "the stream is not a field, so later parsers still run")
+
+	_, err = NewJSONParser().Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, "warn", entry.Fields["level"])
+}
+
+func TestDockerJSONStage_ReassemblesPartialRecords(t *testing.T) {
+	stage, err := NewDockerJSONStage(map[string]interface{}{"max_bytes": "12"})
+	require.NoError(t, err)
+	process := func(source, line string) (*models.LogEntry, bool) {
+		entry := &models.LogEntry{LogLine: []byte(line), SourcePath: source}
+		keep, err := stage.Process(entry)
+		require.NoError(t, err)
+		return entry, keep
+	}
+
+	_, keep := process("a.log", `{"log":"first ","stream":"stdout"}`)
+	assert.False(t, keep, "partial records are held back")
+	entry, keep := process("a.log", `{"log":"stderr\n","stream":"stderr"}`)
+	require.True(t, keep)
+	assert.Equal(t, "stderr", string(entry.LogLine), "streams are reassembled separately")
+	_, keep = process("b.log", `{"log":"other ","stream":"stdout"}`)
+	assert.False(t, keep)
+	entry, keep = process("a.log", `{"log":"half\n","stream":"stdout","time":"2024-01-02T03:04:05Z"}`)
+	require.True(t, keep)
+	assert.Equal(t, "first half", string(entry.LogLine))
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), entry.Timestamp.UTC())
+	entry, _ = process("b.log", `{"log":"file\n","stream":"stdout"}`)
+	assert.Equal(t, "other file", string(entry.LogLine), "files are reassembled separately")
+
+	_, keep = process("a.log", `{"log":"`+strings.Repeat("x", 8)+`","stream":"stdout"}`)
+	assert.False(t, keep)
+	entry, keep = process("a.log", `{"log":"`+strings.Repeat("y", 8)+`","stream":"stdout"}`)
+	require.True(t, keep, "lines reaching max_bytes are emitted without their end")
+	assert.Equal(t, strings.Repeat("x", 8)+strings.Repeat("y", 8), string(entry.LogLine))
+	assert.Empty(t, stage.(*DockerJSONStage).partials.parts)
+}
+
+func TestDockerJSONStage_LeavesOtherLinesUnchanged(t *testing.T) {
+	stage, err := NewDockerJSONStage(map[string]interface{}{})
+	require.NoError(t, err)
+
+	for _, line := range []string{"plain text", `{"log":`, `{"msg":"no log key"}`} {
+		entry := &models.LogEntry{LogLine: []byte(line)}
+		keep, err := stage.Process(entry)
+		assert.NoError(t, err, line)
+		assert.True(t, keep)
+		assert.Equal(t, line, string(entry.LogLine), "the line is left unchanged")
+	}
+}
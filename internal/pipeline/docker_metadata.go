@@ -0,0 +1,286 @@
+package pipeline
+
+import (
+	"fmt"
+	"log-enricher/internal/models"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	dockerConfigFile = "config.v2.json"
+	// dockerMetadataPruneInterval is how often the cache is checked for removed containers, unless the check
+	// interval is longer.
+	dockerMetadataPruneInterval = time.Minute
+
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// DockerMetadataConfig holds the configuration for the docker_metadata stage.
+type DockerMetadataConfig struct {
+	// ContainersDir is Docker's containers directory, as seen by log-enricher.
+	ContainersDir string `mapstructure:"containers_dir"`
+	// Labels is a JSON map of field name to container label, e.g. {"team":"com.example.team"}.
+	Labels string `mapstructure:"labels"`
+	// Target is "fields" or "labels": where the metadata is stored.
+	Target string `mapstructure:"target"`
+	// ContainerIDLabel adds container_id with target labels too. Every container gets a new ID, so it is left out
+	// by default to keep the number of Loki streams down.
+	ContainerIDLabel bool `mapstructure:"container_id_label"`
+	// OverrideApp replaces the entry's app with the compose service, or the container name outside of compose.
+	OverrideApp bool `mapstructure:"override_app"`
+	// CheckIntervalMs is how long a container's config is used before its file is checked for changes.
+	CheckIntervalMs int `mapstructure:"check_interval_ms"`
+}
+
+// dockerContainerConfig is the part of a container's config.v2.json the stage reads.
+type dockerContainerConfig struct {
+	ID     string `json:"ID"`
+	Name   string `json:"Name"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// dockerMetadata is what the stage adds to the entries of one container.
+type dockerMetadata struct {
+	values map[string]string
+	app    string
+}
+
+type dockerMetadataCacheEntry struct {
+	metadata     *dockerMetadata // Nil if the config could not be read.
+	modTime      time.Time
+	size         int64
+	checked      time.Time
+	missingSince time.Time // When the config was first found missing; zero while it exists.
+}
+
+// DockerMetadataStage adds the name, image, compose project and service, and selected labels of the container a
+// log file belongs to. It reads them from the container's config.v2.json in Docker's data directory, so it needs no
+// access to the Docker API. Containers whose config has been gone for longer than the check interval are dropped
+// from the cache.
+type DockerMetadataStage struct {
+	containersDir    string
+	labels           map[string]string
+	toLabels         bool
+	containerIDLabel bool
+	overrideApp      bool
+	checkInterval    time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]*dockerMetadataCacheEntry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewDockerMetadataStage creates a new docker_metadata stage.
+func NewDockerMetadataStage(params map[string]interface{}) (Stage, error) {
+	cfg := DockerMetadataConfig{ContainersDir: "/var/lib/docker/containers", Target: "fields", CheckIntervalMs: 5000}
+	if err := mapstructure.WeakDecode(params, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode docker_metadata stage config: %w", err)
+	}
+	if cfg.Target != "fields" && cfg.Target != "labels" {
+		return nil, fmt.Errorf("invalid docker_metadata 'target' value %q (expected fields or labels)", cfg.Target)
+	}
+	if cfg.CheckIntervalMs < 0 {
+		return nil, fmt.Errorf("docker_metadata stage 'check_interval_ms' must not be negative")
+	}
+
+	stage := &DockerMetadataStage{
+		containersDir:    filepath.Clean(cfg.ContainersDir),
+		toLabels:         cfg.Target == "labels",
+		containerIDLabel: cfg.ContainerIDLabel,
+		overrideApp:      cfg.OverrideApp,
+		checkInterval:    time.Duration(cfg.CheckIntervalMs) * time.Millisecond,
+		cache:            make(map[string]*dockerMetadataCacheEntry),
+		now:              time.Now,
+	}
+	if cfg.Labels != "" {
+		if err := json.Unmarshal([]byte(cfg.Labels), &stage.labels); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal docker_metadata stage labels: %w", err)
+		}
+	}
+
+	slog.Info("Docker metadata stage initialized", "containers_dir", stage.containersDir, "target", cfg.Target, "container_id_label", cfg.ContainerIDLabel, "override_app", cfg.OverrideApp)
+	return stage, nil
+}
+
+func (s *DockerMetadataStage) Name() string {
+	return "docker_metadata"
+}
+
+// Process adds the metadata of the entry's container. Entries of other paths, or of containers whose config
+// cannot be read, are left unchanged.
+func (s *DockerMetadataStage) Process(entry *models.LogEntry) (bool, error) {
+	id := s.containerID(entry.SourcePath)
+	if id == "" {
+		return true, nil
+	}
+	metadata := s.lookup(id)
+	if metadata == nil {
+		return true, nil
+	}
+
+	for name, value := range metadata.values {
+		if s.toLabels {
+			if name == "container_id" && !s.containerIDLabel {
+				continue
+			}
+			if entry.Labels == nil {
+				entry.Labels = make(map[string]string, len(metadata.values))
+			}
+			entry.Labels[name] = value
+		} else {
+			if entry.Fields == nil {
+				entry.Fields = make(map[string]interface{}, len(metadata.values))
+			}
+			entry.Fields[name] = value
+		}
+	}
+	if s.overrideApp && metadata.app != "" {
+		entry.App = metadata.app
+	}
+	return true, nil
+}
+
+// containerID returns the container directory a path is in, e.g. <id> for <containers_dir>/<id>/<id>-json.log.
+func (s *DockerMetadataStage) containerID(path string) string {
+	rel, err := filepath.Rel(s.containersDir, filepath.Clean(path))
+	if err != nil {
+		return ""
+	}
+	id, rest, found := strings.Cut(filepath.ToSlash(rel), "/")
+	if !found || rest == "" || id == ".." || id == "." {
+		return ""
+	}
+	return id
+}
+
+// lookup returns the metadata of a container. Cached metadata is used for the check interval; after that, the
+// config is read again if its modification time or size changed.
+func (s *DockerMetadataStage) lookup(id string) *dockerMetadata {
+	now := s.now()
+	s.prune(now)
+
+	s.mu.Lock()
+	cached := s.cache[id]
+	s.mu.Unlock()
+	if cached != nil && now.Sub(cached.checked) < s.checkInterval {
+		return cached.metadata
+	}
+
+	path := s.configPath(id)
+	next := &dockerMetadataCacheEntry{checked: now}
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		// The container was removed; its remaining lines keep the metadata known so far until it is pruned.
+		next.missingSince = now
+		if cached != nil {
+			next.metadata, next.modTime, next.size = cached.metadata, cached.modTime, cached.size
+			if !cached.missingSince.IsZero() {
+				next.missingSince = cached.missingSince
+			}
+		}
+	case cached != nil && info.ModTime().Equal(cached.modTime) && info.Size() == cached.size:
+		next.metadata, next.modTime, next.size = cached.metadata, cached.modTime, cached.size
+	default:
+		next.modTime, next.size = info.ModTime(), info.Size()
+		next.metadata, err = s.readMetadata(id, path)
+		if err != nil {
+			slog.Warn("Failed to read container config", "path", path, "error", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[id] = next
+	s.mu.Unlock()
+	return next.metadata
+}
+
+// prune drops the containers whose config has been missing for longer than the check interval. Containers that
+// were not looked up within the check interval are checked here, so removed containers whose files are no longer
+// read are dropped too. It runs at most once per prune interval.
+func (s *DockerMetadataStage) prune(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPrune) < max(s.checkInterval, dockerMetadataPruneInterval) {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	var unchecked []string
+	for id, entry := range s.cache {
+		switch {
+		case !entry.missingSince.IsZero():
+			if now.Sub(entry.missingSince) >= s.checkInterval {
+				delete(s.cache, id)
+			}
+		case now.Sub(entry.checked) >= s.checkInterval:
+			unchecked = append(unchecked, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range unchecked {
+		if _, err := os.Stat(s.configPath(id)); err == nil {
+			continue
+		}
+		// Entries are shared with lookups outside the lock, so they are replaced instead of modified.
+		s.mu.Lock()
+		if entry, ok := s.cache[id]; ok && entry.missingSince.IsZero() {
+			missing := *entry
+			missing.missingSince = now
+			s.cache[id] = &missing
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *DockerMetadataStage) configPath(id string) string {
+	return filepath.Join(s.containersDir, id, dockerConfigFile)
+}
+
+func (s *DockerMetadataStage) readMetadata(id, path string) (*dockerMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var container dockerContainerConfig
+	if err := json.Unmarshal(data, &container); err != nil {
+		return nil, err
+	}
+
+	if container.ID == "" {
+		container.ID = id
+	}
+	metadata := &dockerMetadata{values: map[string]string{"container_id": container.ID}}
+	setIfNotEmpty := func(name, value string) {
+		if value != "" {
+			metadata.values[name] = value
+		}
+	}
+	name := strings.TrimPrefix(container.Name, "/")
+	setIfNotEmpty("container_name", name)
+	setIfNotEmpty("container_image", container.Config.Image)
+	setIfNotEmpty("compose_project", container.Config.Labels[composeProjectLabel])
+	setIfNotEmpty("compose_service", container.Config.Labels[composeServiceLabel])
+	for field, label := range s.labels {
+		setIfNotEmpty(field, container.Config.Labels[label])
+	}
+
+	metadata.app = container.Config.Labels[composeServiceLabel]
+	if metadata.app == "" {
+		metadata.app = name
+	}
+	return metadata, nil
+}
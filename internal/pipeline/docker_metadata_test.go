@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const containerID = "3f4e5d6c7b8a9f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5b6a7f8e9d0c1b2a3f4e"
+
+func writeContainerConfig(t *testing.T, dir, config string, modTime time.Time) {
+	t.Helper()
+	path := filepath.Join(dir, containerID, dockerConfigFile)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestNewDockerMetadataStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "defaults", params: map[string]interface{}{}},
+		{name: "labels target", params: map[string]interface{}{"target": "labels", "override_app": "true", "container_id_label": "true"}},
+		{name: "selected labels", params: map[string]interface{}{"labels": `{"team":"com.example.team"}`}},
+		{name: "invalid labels", params: map[string]interface{}{"labels": "com.example.team"}, wantErr: true},
+		{name: "invalid target", params: map[string]interface{}{"target": "both"}, wantErr: true},
+		{name: "negative check interval", params: map[string]interface{}{"check_interval_ms": "-1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDockerMetadataStage(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewDockerMetadataStage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDockerMetadataStage_AddsContainerMetadata(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writeContainerConfig(t, dir, `{"ID":"`+containerID+`","Name":"/shop-web-1","Config":{"Image":"nginx:1.25","Labels":{
+		"com.docker.compose.project":"shop","com.docker.compose.service":"web","com.example.team":"payments"}}}`, modTime)
+
+	stage, err := NewDockerMetadataStage(map[string]interface{}{
+		"containers_dir":    dir,
+		"labels":            `{"team":"com.example.team","missing":"com.example.missing"}`,
+		"override_app":      "true",
+		"check_interval_ms": "0",
+	})
+	require.NoError(t, err)
+	process := func(source string) *models.LogEntry {
+		entry := &models.LogEntry{SourcePath: source, App: containerID}
+		keep, err := stage.Process(entry)
+		require.NoError(t, err)
+		assert.True(t, keep)
+		return entry
+	}
+
+	entry := process(filepath.Join(dir, containerID, containerID+"-json.log"))
+	assert.Equal(t, map[string]interface{}{
+		"container_id":    containerID,
+		"container_name":  "shop-web-1",
+		"container_image": "nginx:1.25",
+		"compose_project": "shop",
+		"compose_service": "web",
+		"team":            "payments",
+	}, entry.Fields)
+	assert.Equal(t, "web", entry.App, "the compose service replaces the app")
+
+	// A changed config is read again; a plain container is named after itself.
+	writeContainerConfig(t, dir, `{"ID":"`+containerID+`","Name":"/renamed","Config":{"Image":"nginx:1.26"}}`, modTime.Add(time.Minute))
+	entry = process(filepath.Join(dir, containerID, containerID+"-json.log.1"))
+	assert.Equal(t, "renamed", entry.Fields["container_name"])
+	assert.Equal(t, "renamed", entry.App)
+	assert.NotContains(t, entry.Fields, "compose_service")
+
+	// A removed container keeps the metadata known so far.
+	require.NoError(t, os.Remove(filepath.Join(dir, containerID, dockerConfigFile)))
+	assert.Equal(t, "renamed", process(filepath.Join(dir, containerID, containerID+"-json.log")).App)
+
+	entry = process("/var/log/app.log")
+	assert.Nil(t, entry.Fields, "paths outside the containers directory are left alone")
+	entry = process(filepath.Join(dir, "other", "other-json.log"))
+	assert.Nil(t, entry.Fields, "containers without a config are left alone")
+}
+
+func TestDockerMetadataStage_CachesUntilCheckInterval(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writeContainerConfig(t, dir, `{"Name":"/first","Config":{"Labels":{"com.docker.compose.service":"api"}}}`, modTime)
+
+	stage, err := NewDockerMetadataStage(map[string]interface{}{"containers_dir": dir, "target": "labels"})
+	require.NoError(t, err)
+	source := filepath.Join(dir, containerID, containerID+"-json.log")
+
+	entry := &models.LogEntry{SourcePath: source, App: "containers"}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"container_name": "first", "compose_service": "api"}, entry.Labels,
+		"the container ID is only a label with container_id_label")
+	assert.Equal(t, "containers", entry.App, "the app is only replaced with override_app")
+	assert.Nil(t, entry.Fields)
+
+	writeContainerConfig(t, dir, `{"Name":"/second"}`, modTime.Add(time.Minute))
+	entry = &models.LogEntry{SourcePath: source}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, "first", entry.Labels["container_name"], "the config is not checked again within the interval")
+}
+
+func TestDockerMetadataStage_ContainerIDLabelIsOptIn(t *testing.T) {
+	dir := t.TempDir()
+	writeContainerConfig(t, dir, `{"Name":"/web"}`, time.Now().Add(-time.Hour))
+
+	stage, err := NewDockerMetadataStage(map[string]interface{}{"containers_dir": dir, "target": "labels", "container_id_label": "true"})
+	require.NoError(t, err)
+	entry := &models.LogEntry{SourcePath: filepath.Join(dir, containerID, containerID+"-json.log")}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"container_id": containerID, "container_name": "web"}, entry.Labels)
+}
+
+func TestDockerMetadataStage_PrunesRemovedContainers(t *testing.T) {
+	dir := t.TempDir()
+	writeContainerConfig(t, dir, `{"Name":"/web"}`, time.Now().Add(-time.Hour))
+	const otherID = "other"
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, otherID), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, otherID, dockerConfigFile), []byte(`{"Name":"/api"}`), 0o644))
+
+	stage, err := NewDockerMetadataStage(map[string]interface{}{"containers_dir": dir, "check_interval_ms": "1000"})
+	require.NoError(t, err)
+	metadataStage := stage.(*DockerMetadataStage)
+	now := time.Now()
+	metadataStage.now = func() time.Time { return now }
+	process := func(id string) *models.LogEntry {
+		entry := &models.LogEntry{SourcePath: filepath.Join(dir, id, id+"-json.log")}
+		_, err := stage.Process(entry)
+		require.NoError(t, err)
+		return entry
+	}
+	cached := func(id string) bool {
+		metadataStage.mu.Lock()
+		defer metadataStage.mu.Unlock()
+		_, ok := metadataStage.cache[id]
+		return ok
+	}
+
+	process(containerID)
+	process(otherID)
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, containerID)))
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, otherID)))
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, "web", process(containerID).Fields["container_name"], "the last lines of a removed container keep its metadata")
+
+	// The first prune drops the container that was found missing and marks the one that was not looked up again.
+	now = now.Add(dockerMetadataPruneInterval)
+	process("unknown")
+	assert.False(t, cached(containerID))
+	assert.True(t, cached(otherID))
+
+	now = now.Add(dockerMetadataPruneInterval)
+	process("unknown")
+	assert.False(t, cached(otherID), "containers whose files are no longer read are pruned too")
+}
@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/models"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errorPolicy decides what happens to an entry when a stage returns an error.
+type errorPolicy string
+
+// Stages without an 'on_error' policy continue; dead-lettering is only done for stages that ask for it.
+const (
+	errorPolicyContinue   errorPolicy = "continue"
+	errorPolicyDrop       errorPolicy = "drop"
+	errorPolicyTag        errorPolicy = "tag"
+	errorPolicyDeadLetter errorPolicy = "dead_letter"
+)
+
+// enricherErrorsField is the field that collects stage errors for the "tag" policy.
+const enricherErrorsField = "_enricher_errors"
+
+const errorStatsInterval = 10 * time.Minute
+
+func parseErrorPolicy(params map[string]interface{}) (errorPolicy, error) {
+	raw, ok := params["on_error"]
+	if !ok {
+		return errorPolicyContinue, nil
+	}
+
+	value, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("'on_error' must be a string, got %T", raw)
+	}
+
+	switch policy := errorPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case errorPolicyContinue, errorPolicyDrop, errorPolicyTag:
+		return policy, nil
+	case errorPolicyDeadLetter:
+		if !deadletter.Enabled() {
+			return "", fmt.Errorf("'on_error=dead_letter' requires DEAD_LETTER_SINK to be configured")
+		}
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid 'on_error' value %q (expected continue, drop, tag or dead_letter)", value)
+	}
+}
+
+// handleStageError applies the stage's error policy and reports whether the entry should continue through the pipeline.
+// For continue and tag, the keep value returned by the stage is still honored.
+func (s *appliedToStage) handleStageError(entry *models.LogEntry, originalLine []byte, keep bool, err error) bool {
+	if s.errors != nil {
+		s.errors.Add(1)
+	}
+
+	slog.Error("Error during stage", "stage", s.stage.Name(), "on_error", s.onError, "error", err)
+
+	switch s.onError {
+	case errorPolicyDrop:
+		return false
+	case errorPolicyTag:
+		tagStageError(entry, s.stage.Name(), err)
+		return keep
+	case errorPolicyDeadLetter:
+		deadletter.Write(deadletter.Record{
+			App:       entry.App,
+			Source:    entry.SourcePath,
+			Tenant:    entry.Tenant,
+			Timestamp: entry.Timestamp,
+			Line:      string(originalLine),
+			Stage:     s.stage.Name(),
+			Error:     err.Error(),
+		})
+		return false
+	default:
+		return keep
+	}
+}
+
+// tagStageError appends {"stage": ..., "error": ...} to the entry's _enricher_errors array.
+func tagStageError(entry *models.LogEntry, stageName string, err error) {
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]interface{})
+	}
+
+	tag := map[string]interface{}{"stage": stageName, "error": err.Error()}
+	existing, _ := entry.Fields[enricherErrorsField].([]interface{})
+	entry.Fields[enricherErrorsField] = append(existing, tag)
+}
+
+// StageErrorCount is the number of errors a configured stage returned since startup.
+type StageErrorCount struct {
+	Index   int
+	Stage   string
+	OnError string
+	Errors  uint64
+}
+
+// ErrorCounts returns the per-stage error counters in configuration order.
+func (m *manager) ErrorCounts() []StageErrorCount {
+	counts := make([]StageErrorCount, 0, len(m.stages))
+	for i := range m.stages {
+		s := &m.stages[i]
+		count := StageErrorCount{
+			Index:   i,
+			Stage:   s.stage.Name(),
+			OnError: string(s.onError),
+		}
+		if s.errors != nil {
+			count.Errors = s.errors.Load()
+		}
+		counts = append(counts, count)
+	}
+	return counts
+}
+
+// reportErrorCounts periodically logs the per-stage error counters, similar to the cache stats.
+func (m *manager) reportErrorCounts(ctx context.Context) {
+	ticker := time.NewTicker(errorStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, count := range m.ErrorCounts() {
+				if count.Errors > 0 {
+					slog.Info("Stage Error Stats", "index", count.Index, "stage", count.Stage, "on_error", count.OnError, "errors", count.Errors)
+				}
+			}
+		}
+	}
+}
+
+func newErrorCounter() *atomic.Uint64 {
+	return &atomic.Uint64{}
+}
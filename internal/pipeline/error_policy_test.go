@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"errors"
+	"log-enricher/internal/config"
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func failingStage(name string) *mockStage {
+	return &mockStage{
+		name: name,
+		process: func(entry *models.LogEntry) (bool, error) {
+			return true, errors.New(name + " failed")
+		},
+	}
+}
+
+func TestProcessPipeline_ErrorPolicies(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        errorPolicy
+		expectKeep    bool
+		expectNextRun bool
+		expectTags    []interface{}
+	}{
+		{name: "continue keeps entry and runs next stage", policy: errorPolicyContinue, expectKeep: true, expectNextRun: true},
+		{name: "drop stops processing", policy: errorPolicyDrop, expectKeep: false, expectNextRun: false},
+		{
+			name:          "tag records stage and error",
+			policy:        errorPolicyTag,
+			expectKeep:    true,
+			expectNextRun: true,
+			expectTags:    []interface{}{map[string]interface{}{"stage": "broken", "error": "broken failed"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadletter.SetSink(nil)
+			next := &mockStage{name: "next"}
+			m := &manager{stages: []appliedToStage{
+				{stage: failingStage("broken"), onError: tt.policy, errors: newErrorCounter()},
+				{stage: next},
+			}}
+
+			entry := &models.LogEntry{Fields: map[string]interface{}{"msg": "x"}}
+			keep := m.GetProcessPipeline("app.log").Process(entry)
+
+			assert.Equal(t, tt.expectKeep, keep)
+			assert.Equal(t, tt.expectNextRun, next.callCount == 1)
+			if tt.expectTags != nil {
+				assert.Equal(t, tt.expectTags, entry.Fields[enricherErrorsField])
+			} else {
+				assert.NotContains(t, entry.Fields, enricherErrorsField)
+			}
+			assert.Equal(t, uint64(1), m.ErrorCounts()[0].Errors)
+		})
+	}
+}
+
+func TestProcessPipeline_TagPolicyAppendsErrorsFromMultipleStages(t *testing.T) {
+	m := &manager{stages: []appliedToStage{
+		{stage: failingStage("first"), onError: errorPolicyTag},
+		{stage: failingStage("second"), onError: errorPolicyTag},
+	}}
+
+	entry := &models.LogEntry{}
+	require.True(t, m.GetProcessPipeline("app.log").Process(entry))
+
+	tags, ok := entry.Fields[enricherErrorsField].([]interface{})
+	require.True(t, ok)
+	require.Len(t, tags, 2)
+	assert.Equal(t, "first", tags[0].(map[string]interface{})["stage"])
+	assert.Equal(t, "second", tags[1].(map[string]interface{})["stage"])
+}
+
+func TestProcessPipeline_DeadLetterPolicy(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	sink, err := deadletter.NewFileSink(dlqPath)
+	require.NoError(t, err)
+	deadletter.SetSink(sink)
+	defer deadletter.Close()
+
+	next := &mockStage{name: "next"}
+	m := &manager{stages: []appliedToStage{
+		{stage: failingStage("broken"), onError: errorPolicyDeadLetter},
+		{stage: next},
+	}}
+
+	entry := &models.LogEntry{LogLine: []byte("raw"), SourcePath: "/logs/app.log", App: "app"}
+	assert.False(t, m.GetProcessPipeline("app.log").Process(entry))
+	assert.Equal(t, 0, next.callCount)
+	deadletter.Close()
+
+	content, err := os.ReadFile(dlqPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"stage":"broken"`)
+	assert.Contains(t, string(content), `"line":"raw"`)
+}
+
+func TestNewManager_DefaultPolicyContinuesWithDeadLetterSink(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	sink, err := deadletter.NewFileSink(dlqPath)
+	require.NoError(t, err)
+	deadletter.SetSink(sink)
+	defer deadletter.Close()
+
+	policy, err := parseErrorPolicy(map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, errorPolicyContinue, policy, "dead_letter must be chosen per stage")
+
+	next := &mockStage{name: "next"}
+	m := &manager{stages: []appliedToStage{
+		{stage: failingStage("broken"), onError: policy},
+		{stage: next},
+	}}
+	assert.True(t, m.GetProcessPipeline("app.log").Process(&models.LogEntry{LogLine: []byte("raw")}))
+	assert.Equal(t, 1, next.callCount)
+	deadletter.Close()
+
+	_, err = os.Stat(dlqPath)
+	assert.ErrorIs(t, err, os.ErrNotExist, "nothing was dead-lettered")
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	deadletter.SetSink(nil)
+
+	policy, err := parseErrorPolicy(map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, errorPolicyContinue, policy)
+
+	policy, err = parseErrorPolicy(map[string]interface{}{"on_error": " TAG "})
+	require.NoError(t, err)
+	assert.Equal(t, errorPolicyTag, policy)
+
+	_, err = parseErrorPolicy(map[string]interface{}{"on_error": "explode"})
+	require.Error(t, err)
+
+	_, err = parseErrorPolicy(map[string]interface{}{"on_error": "dead_letter"})
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "DEAD_LETTER_SINK"))
+}
+
+func TestNewManager_RejectsPartialLineJoiningWithWorkers(t *testing.T) {
+	for _, stageType := range []string{"docker_json", "cri_parser"} {
+		cfg := &config.Config{Stages: []config.StageConfig{{Type: stageType}}}
+		_, err := NewManager(cfg, t.Context())
+		require.NoError(t, err, stageType)
+
+		cfg.PipelineWorkers = 4
+		_, err = NewManager(cfg, t.Context())
+		require.Error(t, err, stageType)
+		assert.Contains(t, err.Error(), "PIPELINE_WORKERS")
+	}
+}
+
+func TestNewManager_RejectsInvalidErrorPolicy(t *testing.T) {
+	initParserState(t)
+	cfg := &config.Config{Stages: []config.StageConfig{
+		{Type: "json_parser", Params: map[string]interface{}{"on_error": "sometimes"}},
+	}}
+
+	_, err := NewManager(cfg, t.Context())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "on_error")
+}
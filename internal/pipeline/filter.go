@@ -75,6 +75,18 @@ func (r *JSONFieldRule) Matches(entry *models.LogEntry) bool {
 	return false
 }
 
+// LabelRule implements FilterRule for matching a regex against one of the entry's labels.
+type LabelRule struct {
+	label         string
+	labelValRegex *regexp.Regexp
+}
+
+// Matches returns true if the label exists and its value matches the configured regex.
+func (r *LabelRule) Matches(entry *models.LogEntry) bool {
+	value, ok := entry.Labels[r.label]
+	return ok && r.labelValRegex.MatchString(value)
+}
+
 // FilterStage drops or keeps logs based on configurable rules.
 type FilterStage struct {
 	config FilterStageConfig
@@ -83,14 +95,16 @@ type FilterStage struct {
 
 // FilterStageConfig defines the rules for a filter stage.
 type FilterStageConfig struct {
-	Action    string `mapstructure:"action"`     // "keep" or "drop" on match.
-	Match     string `mapstructure:"match"`      // "all" or "any" of the defined rules.
-	Regex     string `mapstructure:"regex"`      // Regex pattern to match against the raw line.
-	JSONField string `mapstructure:"json_field"` // Field to check in a JSON log.
-	JSONValue string `mapstructure:"json_value"` // Regex to match against the JSON field's value.
-	MinSize   int    `mapstructure:"min_size"`   // Minimum line size in characters.
-	MaxSize   int    `mapstructure:"max_size"`   // Maximum line size in characters.
-	MaxAge    int    `mapstructure:"max_age"`    // Maximum age of a log in seconds.
+	Action     string `mapstructure:"action"`      // "keep" or "drop" on match.
+	Match      string `mapstructure:"match"`       // "all" or "any" of the defined rules.
+	Regex      string `mapstructure:"regex"`       // Regex pattern to match against the raw line.
+	JSONField  string `mapstructure:"json_field"`  // Field to check in a JSON log.
+	JSONValue  string `mapstructure:"json_value"`  // Regex to match against the JSON field's value.
+	Label      string `mapstructure:"label"`       // Label to check, e.g. a Promtail stream label.
+	LabelValue string `mapstructure:"label_value"` // Regex to match against the label's value.
+	MinSize    int    `mapstructure:"min_size"`    // Minimum line size in characters.
+	MaxSize    int    `mapstructure:"max_size"`    // Maximum line size in characters.
+	MaxAge     int    `mapstructure:"max_age"`     // Maximum age of a log in seconds.
 }
 
 // NewFilterStage creates a new instance of a filter stage from its config.
@@ -132,6 +146,14 @@ func NewFilterStage(params map[string]interface{}) (*FilterStage, error) {
 		rules = append(rules, &JSONFieldRule{jsonField: config.JSONField, jsonValRegex: jsonValRegex})
 	}
 
+	if config.Label != "" && config.LabelValue != "" {
+		labelValRegex, err := regexp.Compile(config.LabelValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label_value regex for filter stage: %w", err)
+		}
+		rules = append(rules, &LabelRule{label: config.Label, labelValRegex: labelValRegex})
+	}
+
 	if config.MaxAge > 0 {
 		duration := time.Duration(config.MaxAge) * time.Second
 		rules = append(rules, &MaxAgeRule{duration: duration})
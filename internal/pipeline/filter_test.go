@@ -46,6 +46,12 @@ func runFilterProcessTest(t *testing.T, tc filterTestCase) {
 	if tc.config.JSONValue != "" {
 		params["json_value"] = tc.config.JSONValue
 	}
+	if tc.config.Label != "" {
+		params["label"] = tc.config.Label
+	}
+	if tc.config.LabelValue != "" {
+		params["label_value"] = tc.config.LabelValue
+	}
 	params["action"] = tc.config.Action
 	params["match"] = tc.config.Match
 
@@ -394,6 +400,54 @@ func TestFilterStage_Process_JSONField(t *testing.T) {
 	}
 }
 
+func TestFilterStage_Process_Label(t *testing.T) {
+	labeledEntry := newTestLogEntry(time.Now(), nil)
+	labeledEntry.Labels = map[string]string{"namespace": "kube-system"}
+	unlabeledEntry := newTestLogEntry(time.Now(), nil)
+
+	tests := []filterTestCase{
+		{
+			name: "Drop on label match",
+			config: FilterStageConfig{
+				Label:      "namespace",
+				LabelValue: "^kube-",
+				Action:     "drop",
+				Match:      "any",
+			},
+			entry:    labeledEntry,
+			wantKeep: false,
+		},
+		{
+			name: "Keep on no label match",
+			config: FilterStageConfig{
+				Label:      "namespace",
+				LabelValue: "^default$",
+				Action:     "drop",
+				Match:      "any",
+			},
+			entry:    labeledEntry,
+			wantKeep: true,
+		},
+		{
+			name: "Keep if entry has no labels",
+			config: FilterStageConfig{
+				Label:      "namespace",
+				LabelValue: ".*",
+				Action:     "drop",
+				Match:      "any",
+			},
+			entry:    unlabeledEntry,
+			wantKeep: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runFilterProcessTest(t, tt)
+		})
+	}
+}
+
 func TestFilterStage_Process_MultipleRules_AnyMatch(t *testing.T) {
 	now := time.Now()
 	recentErrorLine := []byte("This is a recent ERROR log.") // len = 27
@@ -0,0 +1,307 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"log-enricher/internal/cache"
+	"log-enricher/internal/models"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash"
+	"github.com/goccy/go-json"
+	"github.com/mitchellh/mapstructure"
+)
+
+// grokReference matches %{NAME}, %{NAME:field} and %{NAME:field:type}.
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::([^:}]+))?\}`)
+
+// GrokParserConfig holds the configuration for the grok_parser stage.
+type GrokParserConfig struct {
+	// Patterns is a JSON array of Grok expressions, tried in order.
+	Patterns string `mapstructure:"patterns"`
+	// PatternFiles is a comma-separated list of pattern files, or directories of them, with `NAME regex` lines.
+	PatternFiles string `mapstructure:"pattern_files"`
+	// PatternDefinitions is a JSON map of pattern name to regex, e.g. {"ORDER_ID":"ord-[0-9]+"}.
+	PatternDefinitions string `mapstructure:"pattern_definitions"`
+}
+
+// grokCapture is the field a named group of an expanded expression is stored in.
+type grokCapture struct {
+	field     string
+	valueType string // "", "int" or "float"
+}
+
+type grokPattern struct {
+	expression string
+	regex      *regexp.Regexp
+	captures   []*grokCapture // By group index; nil for unnamed groups.
+}
+
+// GrokParser parses log lines with Grok expressions such as `%{IPORHOST:client} %{HTTPDATE:ts}`, which are built
+// from the standard pattern library and custom patterns.
+type GrokParser struct {
+	patterns       []*grokPattern
+	patternIndex   map[string]int
+	lastMatchCache *cache.PersistedCache[string]
+}
+
+func (p *GrokParser) Name() string {
+	return "grok_parser"
+}
+
+// NewGrokParser creates a new grok_parser stage. It returns an error if a pattern cannot be loaded or an expression
+// does not compile.
+func NewGrokParser(params map[string]interface{}) (Stage, error) {
+	var cfg GrokParserConfig
+	if err := mapstructure.WeakDecode(params, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode grok parser config: %w", err)
+	}
+
+	var expressions []string
+	if cfg.Patterns != "" {
+		if err := json.Unmarshal([]byte(cfg.Patterns), &expressions); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal grok parser patterns: %w", err)
+		}
+	}
+	if len(expressions) == 0 {
+		return nil, fmt.Errorf("grok parser requires at least one pattern in 'patterns'")
+	}
+
+	definitions := make(map[string]string, len(builtinGrokPatterns))
+	for name, definition := range builtinGrokPatterns {
+		definitions[name] = definition
+	}
+	for _, path := range strings.Split(cfg.PatternFiles, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := loadGrokPatternPath(path, definitions); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PatternDefinitions != "" {
+		var custom map[string]string
+		if err := json.Unmarshal([]byte(cfg.PatternDefinitions), &custom); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal grok parser pattern definitions: %w", err)
+		}
+		for name, definition := range custom {
+			definitions[name] = definition
+		}
+	}
+
+	parser := &GrokParser{patternIndex: make(map[string]int, len(expressions))}
+	for i, expression := range expressions {
+		pattern, err := compileGrokPattern(expression, definitions)
+		if err != nil {
+			return nil, err
+		}
+		parser.patterns = append(parser.patterns, pattern)
+		parser.patternIndex[expression] = i
+	}
+
+	hash := strconv.FormatUint(xxhash.Sum64String(strings.Join(expressions, "\x00")), 10)
+	parser.lastMatchCache = cache.NewPersistedCache[string]("grok_parser_"+hash, 10, 100, true)
+
+	slog.Info("Grok parser stage initialized", "patterns", len(expressions), "definitions", len(definitions))
+	return parser, nil
+}
+
+// loadGrokPatternPath adds the patterns of a file, or of every file in a directory, to definitions.
+func loadGrokPatternPath(path string, definitions map[string]string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read grok pattern path: %w", err)
+	}
+	if !info.IsDir() {
+		return loadGrokPatternFile(path, definitions)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("failed to read grok pattern directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := loadGrokPatternFile(filepath.Join(path, entry.Name()), definitions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadGrokPatternFile adds the `NAME regex` lines of a pattern file to definitions. Blank lines and lines starting
+// with # are skipped.
+func loadGrokPatternFile(path string, definitions map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open grok pattern file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, definition, found := strings.Cut(line, " ")
+		if !found || strings.TrimSpace(definition) == "" {
+			return fmt.Errorf("invalid grok pattern in %s line %d: expected 'NAME regex'", path, lineNumber)
+		}
+		definitions[name] = strings.TrimSpace(definition)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read grok pattern file: %w", err)
+	}
+	return nil
+}
+
+// compileGrokPattern expands the pattern references of a Grok expression and compiles the resulting regex.
+func compileGrokPattern(expression string, definitions map[string]string) (*grokPattern, error) {
+	groups := make(map[string]*grokCapture)
+	expanded, err := expandGrok(expression, definitions, groups, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grok pattern %q: %w", expression, err)
+	}
+	regex, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grok pattern %q: %w", expression, err)
+	}
+
+	pattern := &grokPattern{expression: expression, regex: regex, captures: make([]*grokCapture, regex.NumSubexp()+1)}
+	for i, name := range regex.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		if capture, ok := groups[name]; ok {
+			pattern.captures[i] = capture
+		} else {
+			// A named group written as plain regex, e.g. (?P<user>\w+).
+			pattern.captures[i] = &grokCapture{field: name}
+		}
+	}
+	return pattern, nil
+}
+
+// expandGrok replaces the pattern references of expression with their definitions. References with a field become
+// groups named grok<n>, recorded in groups; stack holds the patterns being expanded to detect recursion.
+func expandGrok(expression string, definitions map[string]string, groups map[string]*grokCapture, stack []string) (string, error) {
+	var expandErr error
+	expanded := grokReference.ReplaceAllStringFunc(expression, func(reference string) string {
+		if expandErr != nil {
+			return ""
+		}
+		match := grokReference.FindStringSubmatch(reference)
+		name, field, valueType := match[1], match[2], match[3]
+
+		definition, ok := definitions[name]
+		if !ok {
+			expandErr = fmt.Errorf("unknown pattern %q", name)
+			return ""
+		}
+		for _, parent := range stack {
+			if parent == name {
+				expandErr = fmt.Errorf("pattern %q references itself", name)
+				return ""
+			}
+		}
+		switch valueType {
+		case "", "int", "float":
+		default:
+			expandErr = fmt.Errorf("unknown type %q for field %q (expected int or float)", valueType, field)
+			return ""
+		}
+
+		inner, err := expandGrok(definition, definitions, groups, append(stack, name))
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+		if field == "" {
+			return "(?:" + inner + ")"
+		}
+		group := "grok" + strconv.Itoa(len(groups))
+		groups[group] = &grokCapture{field: field, valueType: valueType}
+		return "(?P<" + group + ">" + inner + ")"
+	})
+	return expanded, expandErr
+}
+
+// Process parses the line with the first pattern that matches. The pattern that last matched the entry's source is
+// tried first, since a file usually has a single format.
+func (p *GrokParser) Process(logEntry *models.LogEntry) (bool, error) {
+	// Skip any log lines that already have fields (another parser was successful)
+	if len(logEntry.Fields) > 0 {
+		return true, nil
+	}
+	if logEntry.Fields == nil {
+		logEntry.Fields = make(map[string]interface{})
+	}
+
+	cacheKey := logEntry.SourcePath
+	first := -1
+	lastMatch, ok := p.lastMatchCache.Get(cacheKey)
+	if ok {
+		if index, known := p.patternIndex[lastMatch]; known {
+			first = index
+			if p.match(p.patterns[index], logEntry) {
+				p.lastMatchCache.Hit()
+				return true, nil
+			}
+		}
+		p.lastMatchCache.Miss()
+	}
+
+	for i, pattern := range p.patterns {
+		if i != first && p.match(pattern, logEntry) {
+			p.lastMatchCache.Set(cacheKey, pattern.expression)
+			return true, nil
+		}
+	}
+
+	// Always keep the log line
+	return true, nil
+}
+
+// match stores the captures of pattern in the entry's fields and reports whether the line matched.
+func (p *GrokParser) match(pattern *grokPattern, logEntry *models.LogEntry) bool {
+	indexes := pattern.regex.FindSubmatchIndex(logEntry.LogLine)
+	if indexes == nil {
+		return false
+	}
+
+	for i, capture := range pattern.captures {
+		start := indexes[2*i]
+		if capture == nil || start < 0 {
+			continue
+		}
+		// The same field may appear in several alternatives; the first one that matched wins.
+		if _, exists := logEntry.Fields[capture.field]; exists {
+			continue
+		}
+		logEntry.Fields[capture.field] = convertGrokValue(string(logEntry.LogLine[start:indexes[2*i+1]]), capture.valueType)
+	}
+	return true
+}
+
+// convertGrokValue converts a captured value to its type. Values that do not convert are kept as strings.
+func convertGrokValue(value, valueType string) interface{} {
+	switch valueType {
+	case "int":
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
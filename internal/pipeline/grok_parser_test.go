@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func grokParse(t *testing.T, stage Stage, line string) map[string]interface{} {
+	t.Helper()
+	entry := &models.LogEntry{LogLine: []byte(line), Fields: map[string]interface{}{}, SourcePath: "app.log"}
+	keep, err := stage.Process(entry)
+	require.NoError(t, err)
+	assert.True(t, keep)
+	return entry.Fields
+}
+
+func TestGrokParser_BuiltinPatterns(t *testing.T) {
+	initParserState(t)
+
+	for name := range builtinGrokPatterns {
+		_, err := compileGrokPattern("%{"+name+"}", builtinGrokPatterns)
+		assert.NoError(t, err, name)
+	}
+
+	stage, err := NewGrokParser(map[string]interface{}{
+		"patterns": `["%{COMBINEDAPACHELOG}", "%{SYSLOGLINE}"]`,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"clientip": "127.0.0.1", "ident": "-", "auth": "frank", "timestamp": "10/Oct/2000:13:55:36 -0700",
+		"verb": "GET", "request": "/apache_pb.gif", "httpversion": "1.0", "response": "200", "bytes": "2326",
+		"referrer": `"http://www.example.com/start.html"`, "agent": `"Mozilla/4.08 [en] (Win98; I ;Nav)"`,
+	}, grokParse(t, stage, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`))
+
+	assert.Equal(t, map[string]interface{}{
+		"timestamp": "Mar  7 04:02:16", "logsource": "web-1", "program": "sshd", "pid": "4120",
+		"message": "Accepted publickey for deploy from 2001:db8::1 port 50022",
+	}, grokParse(t, stage, "Mar  7 04:02:16 web-1 sshd[4120]: Accepted publickey for deploy from 2001:db8::1 port 50022"))
+
+	assert.Empty(t, grokParse(t, stage, "plain text"), "lines no pattern matches get no fields")
+}
+
+func TestGrokParser_CustomPatternsAndTypes(t *testing.T) {
+	initParserState(t)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orders"), []byte("# Order service patterns\n\nORDER_ID ord-[0-9]+\nDURATION %{NUMBER}ms\n"), 0o644))
+
+	stage, err := NewGrokParser(map[string]interface{}{
+		"patterns":            `["%{ORDER_ID:order} %{STATUS:status} %{INT:items:int} %{DURATION:took:float}", "%{ORDER_ID:order} %{STATUS:status}"]`,
+		"pattern_files":       dir,
+		"pattern_definitions": `{"STATUS": "(?:paid|refunded)"}`,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{"order": "ord-42", "status": "paid", "items": int64(3), "took": "12.5ms"},
+		grokParse(t, stage, "ord-42 paid 3 12.5ms"), "values that do not convert stay strings")
+	assert.Equal(t, map[string]interface{}{"order": "ord-7", "status": "refunded"},
+		grokParse(t, stage, "ord-7 refunded"), "the next pattern is tried when the last one does not match")
+	assert.Equal(t, map[string]interface{}{"order": "ord-8", "status": "paid"},
+		grokParse(t, stage, "ord-8 paid 1 2ms"), "the pattern that last matched the source is tried first")
+
+	entry := &models.LogEntry{LogLine: []byte("ord-9 paid"), Fields: map[string]interface{}{"existing": "value"}}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"existing": "value"}, entry.Fields, "entries that already have fields are skipped")
+
+	typed, err := NewGrokParser(map[string]interface{}{"patterns": `["%{NUMBER:ratio:float} (?P<unit>\\w+)"]`})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"ratio": 0.25, "unit": "pct"}, grokParse(t, typed, "0.25 pct"))
+}
+
+func TestGrokParser_InvalidConfig(t *testing.T) {
+	initParserState(t)
+
+	badFile := filepath.Join(t.TempDir(), "bad")
+	require.NoError(t, os.WriteFile(badFile, []byte("JUSTANAME\n"), 0o644))
+
+	invalid := map[string]map[string]interface{}{
+		"no patterns":        {},
+		"patterns not json":  {"patterns": "%{WORD}"},
+		"unknown pattern":    {"patterns": `["%{NOPE:x}"]`},
+		"unknown type":       {"patterns": `["%{INT:x:bool}"]`},
+		"recursive pattern":  {"patterns": `["%{LOOP}"]`, "pattern_definitions": `{"LOOP": "a%{LOOP}"}`},
+		"invalid regex":      {"patterns": `["%{BROKEN}"]`, "pattern_definitions": `{"BROKEN": "("}`},
+		"missing file":       {"patterns": `["%{WORD}"]`, "pattern_files": filepath.Join(t.TempDir(), "missing")},
+		"invalid definition": {"patterns": `["%{WORD}"]`, "pattern_files": badFile},
+	}
+	for name, params := range invalid {
+		_, err := NewGrokParser(params)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"fmt"
+	"log-enricher/internal/models"
+	"log/slog"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/mitchellh/mapstructure"
+)
+
+// LabelsConfig holds the configuration for the labels stage.
+type LabelsConfig struct {
+	// FromFields is a JSON map of label name to field path, e.g. {"level":"log.level"}.
+	FromFields string `mapstructure:"from_fields"`
+	// ToFields is a JSON map of field name to label name, e.g. {"namespace":"namespace"}.
+	ToFields string `mapstructure:"to_fields"`
+	// Drop is a comma-separated list of labels to remove.
+	Drop string `mapstructure:"drop"`
+}
+
+// LabelsStage moves values between an entry's fields and its labels.
+type LabelsStage struct {
+	fromFields map[string][]string
+	toFields   map[string]string
+	drop       []string
+}
+
+// NewLabelsStage creates a new labels stage.
+func NewLabelsStage(params map[string]interface{}) (Stage, error) {
+	var cfg LabelsConfig
+	if err := mapstructure.Decode(params, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode labels stage config: %w", err)
+	}
+
+	stage := &LabelsStage{}
+	if cfg.FromFields != "" {
+		var fromFields map[string]string
+		if err := json.Unmarshal([]byte(cfg.FromFields), &fromFields); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal labels stage from_fields: %w", err)
+		}
+		stage.fromFields = make(map[string][]string, len(fromFields))
+		for label, path := range fromFields {
+			stage.fromFields[label] = strings.Split(path, ".")
+		}
+	}
+	if cfg.ToFields != "" {
+		if err := json.Unmarshal([]byte(cfg.ToFields), &stage.toFields); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal labels stage to_fields: %w", err)
+		}
+	}
+	for _, label := range strings.Split(cfg.Drop, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			stage.drop = append(stage.drop, label)
+		}
+	}
+
+	if len(stage.fromFields) == 0 && len(stage.toFields) == 0 && len(stage.drop) == 0 {
+		return nil, fmt.Errorf("labels stage requires 'from_fields', 'to_fields' or 'drop'")
+	}
+
+	slog.Info("Labels stage initialized", "from_fields", len(stage.fromFields), "to_fields", len(stage.toFields), "drop", len(stage.drop))
+	return stage, nil
+}
+
+func (s *LabelsStage) Name() string {
+	return "labels"
+}
+
+// Process copies labels into fields, then sets labels from fields, then drops labels.
+// Fields that are missing, null, objects or arrays do not set a label.
+func (s *LabelsStage) Process(entry *models.LogEntry) (bool, error) {
+	for field, label := range s.toFields {
+		if value, ok := entry.Labels[label]; ok {
+			if entry.Fields == nil {
+				entry.Fields = make(map[string]interface{})
+			}
+			entry.Fields[field] = value
+		}
+	}
+
+	for label, path := range s.fromFields {
+		value, ok := getValueAtPath(entry.Fields, path)
+		if !ok {
+			continue
+		}
+		switch value.(type) {
+		case nil, map[string]interface{}, []interface{}:
+			continue
+		}
+		if entry.Labels == nil {
+			entry.Labels = make(map[string]string)
+		}
+		entry.Labels[label] = fmt.Sprint(value)
+	}
+
+	for _, label := range s.drop {
+		delete(entry.Labels, label)
+	}
+	return true, nil
+}
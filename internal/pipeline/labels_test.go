@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"testing"
+
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLabelsStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "empty config", params: map[string]interface{}{}, wantErr: true},
+		{name: "from_fields", params: map[string]interface{}{"from_fields": `{"level":"log.level"}`}},
+		{name: "to_fields", params: map[string]interface{}{"to_fields": `{"pod":"pod"}`}},
+		{name: "drop", params: map[string]interface{}{"drop": "filename, stream"}},
+		{name: "invalid from_fields", params: map[string]interface{}{"from_fields": "level"}, wantErr: true},
+		{name: "invalid to_fields", params: map[string]interface{}{"to_fields": `["pod"]`}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLabelsStage(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewLabelsStage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLabelsStage_Process(t *testing.T) {
+	stage, err := NewLabelsStage(map[string]interface{}{
+		"to_fields":   `{"k8s_pod":"pod"}`,
+		"from_fields": `{"level":"log.level","status":"status","request":"request","missing":"nope"}`,
+		"drop":        "filename",
+	})
+	require.NoError(t, err)
+
+	entry := &models.LogEntry{
+		Fields: map[string]interface{}{
+			"log":     map[string]interface{}{"level": "warn"},
+			"status":  float64(503),
+			"request": map[string]interface{}{"path": "/"},
+		},
+		Labels: map[string]string{"pod": "api-7d9", "filename": "/var/log/pods/api.log"},
+	}
+
+	keep, err := stage.Process(entry)
+	require.NoError(t, err)
+	assert.True(t, keep)
+	assert.Equal(t, map[string]string{"pod": "api-7d9", "level": "warn", "status": "503"}, entry.Labels)
+	assert.Equal(t, "api-7d9", entry.Fields["k8s_pod"])
+}
+
+func TestLabelsStage_ProcessWithoutLabelsOrFields(t *testing.T) {
+	stage, err := NewLabelsStage(map[string]interface{}{
+		"to_fields":   `{"pod":"pod"}`,
+		"from_fields": `{"level":"level"}`,
+	})
+	require.NoError(t, err)
+
+	entry := &models.LogEntry{Fields: map[string]interface{}{"level": "info"}}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"level": "info"}, entry.Labels)
+	assert.NotContains(t, entry.Fields, "pod")
+
+	entry = &models.LogEntry{}
+	_, err = stage.Process(entry)
+	require.NoError(t, err)
+	assert.Nil(t, entry.Labels)
+}
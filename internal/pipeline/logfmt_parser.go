@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"log-enricher/internal/models"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// LogfmtParserConfig holds the configuration for the logfmt_parser stage.
+type LogfmtParserConfig struct {
+	// InferTypes converts unquoted values that are booleans or numbers; otherwise all values are strings.
+	InferTypes bool `mapstructure:"infer_types"`
+}
+
+// LogfmtParser parses logfmt lines, e.g. `level=info msg="request done" duration=1.5 cached`, into fields.
+// Keys are any characters up to `=`, `"` or whitespace, quoted values may contain escapes, and bare keys are
+// true. A line needs at least one key=value pair to count as logfmt, so plain text does not become fields.
+type LogfmtParser struct {
+	inferTypes bool
+}
+
+func (p *LogfmtParser) Name() string {
+	return "logfmt_parser"
+}
+
+// NewLogfmtParser creates a new logfmt_parser stage.
+func NewLogfmtParser(params map[string]interface{}) (Stage, error) {
+	var cfg LogfmtParserConfig
+	if err := mapstructure.WeakDecode(params, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode logfmt parser config: %w", err)
+	}
+
+	slog.Info("Logfmt parser stage initialized", "infer_types", cfg.InferTypes)
+	return &LogfmtParser{inferTypes: cfg.InferTypes}, nil
+}
+
+// Process parses the line into the entry's fields. Unlike json_parser it keeps no per-source parse cache: the
+// first character of a line does not tell logfmt apart from plain text, and parsing fails fast on plain text.
+func (p *LogfmtParser) Process(logEntry *models.LogEntry) (bool, error) {
+	// Skip any log lines that already have fields (another parser was successful)
+	if len(logEntry.Fields) > 0 {
+		return true, nil
+	}
+	if logEntry.Fields == nil {
+		logEntry.Fields = make(map[string]interface{})
+	}
+
+	if !p.parse(logEntry.LogLine, logEntry.Fields) {
+		// Clear any fields that were parsed before the line turned out not to be logfmt
+		for k := range logEntry.Fields {
+			delete(logEntry.Fields, k)
+		}
+	}
+
+	// Always keep the log line
+	return true, nil
+}
+
+// parse adds the pairs of line to fields and reports whether line is valid logfmt with at least one key=value pair.
+func (p *LogfmtParser) parse(line []byte, fields map[string]interface{}) bool {
+	pairs := 0
+	i, n := 0, len(line)
+	for {
+		for i < n && line[i] <= ' ' {
+			i++
+		}
+		if i >= n {
+			return pairs > 0
+		}
+
+		start := i
+		for i < n && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		if i == start {
+			// A pair must start with a key.
+			return false
+		}
+		key := string(line[start:i])
+
+		switch {
+		case i >= n || line[i] <= ' ':
+			fields[key] = true
+			continue
+		case line[i] == '"':
+			return false
+		}
+
+		// line[i] is '='.
+		i++
+		pairs++
+		switch {
+		case i >= n || line[i] <= ' ':
+			fields[key] = ""
+		case line[i] == '"':
+			value, next, ok := unquoteLogfmtValue(line, i)
+			if !ok || (next < n && line[next] > ' ') {
+				return false
+			}
+			fields[key] = value
+			i = next
+		default:
+			start = i
+			for i < n && line[i] > ' ' && line[i] != '"' {
+				i++
+			}
+			if i < n && line[i] == '"' {
+				return false
+			}
+			fields[key] = p.value(line[start:i])
+		}
+	}
+}
+
+// unquoteLogfmtValue returns the value of the quoted string starting at line[start] and the index after it.
+func unquoteLogfmtValue(line []byte, start int) (string, int, bool) {
+	escaped := false
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			if !escaped {
+				return string(line[start+1 : i]), i + 1, true
+			}
+			value, err := strconv.Unquote(string(line[start : i+1]))
+			return value, i + 1, err == nil
+		}
+	}
+	return "", 0, false
+}
+
+// value returns an unquoted value, converted to a bool, int64 or float64 if types are inferred.
+func (p *LogfmtParser) value(raw []byte) interface{} {
+	if p.inferTypes {
+		switch {
+		case bytes.Equal(raw, []byte("true")):
+			return true
+		case bytes.Equal(raw, []byte("false")):
+			return false
+		case (raw[0] >= '0' && raw[0] <= '9') || raw[0] == '-' || raw[0] == '.':
+			s := string(raw)
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i
+			}
+			// ParseFloat also accepts "-inf" and "-nan", which are no numbers in a log line.
+			if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+				return f
+			}
+			return s
+		}
+	}
+	return string(raw)
+}
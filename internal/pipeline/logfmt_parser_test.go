@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"testing"
+
+	"log-enricher/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseLogfmt(t *testing.T, params map[string]interface{}, line string, fields map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	stage, err := NewLogfmtParser(params)
+	require.NoError(t, err)
+	entry := &models.LogEntry{LogLine: []byte(line), Fields: fields, SourcePath: "app.log"}
+	keep, err := stage.Process(entry)
+	require.NoError(t, err)
+	assert.True(t, keep)
+	assert.Equal(t, line, string(entry.LogLine), "the line is kept")
+	return entry.Fields
+}
+
+func TestLogfmtParser_Process(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		line   string
+		fields map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{
+			name: "parses pairs, bare keys and escapes",
+			line: `ts=2024-01-02T03:04:05Z level=info msg="request \"done\"\tok" http.status=200 trace-id=abc= cached empty= path="C:\\tmp" plain=""`,
+			want: map[string]interface{}{
+				"ts": "2024-01-02T03:04:05Z", "level": "info", "msg": "request \"done\"\tok", "http.status": "200",
+				"trace-id": "abc=", "cached": true, "empty": "", "path": `C:\tmp`, "plain": "",
+			},
+		},
+		{
+			name:   "infers types of unquoted values",
+			params: map[string]interface{}{"infer_types": "true"},
+			line:   `count=42 ratio=-0.5 big=1e3 ok=true failed=false quoted="7" neg=-inf dash=- version=1.2.3`,
+			want: map[string]interface{}{
+				"count": int64(42), "ratio": -0.5, "big": float64(1000), "ok": true, "failed": false, "quoted": "7",
+				"neg": "-inf", "dash": "-", "version": "1.2.3",
+			},
+		},
+		{
+			name:   "skips parsing when fields already exist",
+			line:   "level=info",
+			fields: map[string]interface{}{"existing": "value"},
+			want:   map[string]interface{}{"existing": "value"},
+		},
+		{name: "plain text without pairs", line: "listening on :8080", want: map[string]interface{}{}},
+		{name: "unterminated quote", line: `level=info msg="broken`, want: map[string]interface{}{}},
+		{name: "missing key", line: `level=info =value`, want: map[string]interface{}{}},
+		{name: "quote in key", line: `lev"el=info`, want: map[string]interface{}{}},
+		{name: "text after quoted value", line: `msg="a"b`, want: map[string]interface{}{}},
+		{name: "invalid escape", line: `msg="\q"`, want: map[string]interface{}{}},
+		{name: "empty line", line: "", want: map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			if params == nil {
+				params = map[string]interface{}{}
+			}
+			fields := tt.fields
+			if fields == nil {
+				fields = map[string]interface{}{}
+			}
+			assert.Equal(t, tt.want, parseLogfmt(t, params, tt.line, fields))
+		})
+	}
+}
+
+func TestLogfmtParser_NilFields(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"a": "1"}, parseLogfmt(t, map[string]interface{}{}, "a=1", nil))
+}
+
+func BenchmarkLogfmtParser_Process(b *testing.B) {
+	stage, err := NewLogfmtParser(map[string]interface{}{"infer_types": true})
+	require.NoError(b, err)
+	line := []byte(`ts=2024-01-02T03:04:05Z level=info msg="request done" method=GET path=/api/v1/items status=200 duration_ms=12.5 cached`)
+	entry := &models.LogEntry{LogLine: line, Fields: make(map[string]interface{}, 8)}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		clear(entry.Fields)
+		if _, err := stage.Process(entry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// partialLines joins lines that a container runtime split into several records. Parts are kept by key, e.g.
+// source path and stream, since the records of several files and streams pass through the same stage.
+// A line whose end did not arrive within maxAge, e.g. because the container was killed mid-line, is dropped.
+type partialLines struct {
+	maxBytes int
+	maxAge   time.Duration
+
+	mu        sync.Mutex
+	parts     map[string]*partialLine
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// defaultPartialTimeoutMs is how long a partial line waits for its next part unless configured otherwise.
+const defaultPartialTimeoutMs = 60000
+
+// partialJoiner is implemented by stages that join partial lines. They rely on the records of a source being
+// processed one at a time and in order, which the worker pool does not guarantee.
+type partialJoiner interface {
+	joinsPartialLines()
+}
+
+type partialLine struct {
+	line    string
+	updated time.Time
+}
+
+func newPartialLines(maxBytes int, maxAge time.Duration) *partialLines {
+	return &partialLines{maxBytes: maxBytes, maxAge: maxAge, parts: make(map[string]*partialLine), now: time.Now}
+}
+
+// add appends part to the line of key. It returns the whole line once final is set or the line reached
+// maxBytes, and false while the line is still incomplete.
+func (p *partialLines) add(key, part string, final bool) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.expire(now)
+
+	line := part
+	if pending, ok := p.parts[key]; ok {
+		line = pending.line + part
+	}
+	if !final && len(line) < p.maxBytes {
+		p.parts[key] = &partialLine{line: line, updated: now}
+		return "", false
+	}
+	delete(p.parts, key)
+	return line, true
+}
+
+// expire drops lines that were not continued for maxAge. The map is only scanned once per maxAge, so a line is
+// kept for up to twice as long. It must be called with mu held.
+func (p *partialLines) expire(now time.Time) {
+	if now.Sub(p.lastSweep) < p.maxAge {
+		return
+	}
+	p.lastSweep = now
+	for key, pending := range p.parts {
+		if now.Sub(pending.updated) >= p.maxAge {
+			slog.Warn("Dropping partial line that was not completed", "key", key, "bytes", len(pending.line), "age", now.Sub(pending.updated))
+			delete(p.parts, key)
+		}
+	}
+}
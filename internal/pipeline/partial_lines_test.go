@@ -0,0 +1,37 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartialLines_DropsExpiredParts(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	partials := newPartialLines(1024, time.Minute)
+	partials.now = func() time.Time { return now }
+
+	_, complete := partials.add("a.log", "abandoned ", false)
+	require.False(t, complete)
+	_, complete = partials.add("b.log", "continued ", false)
+	require.False(t, complete)
+
+	now = now.Add(50 * time.Second)
+	_, complete = partials.add("b.log", "twice ", false)
+	require.False(t, complete)
+	now = now.Add(20 * time.Second)
+	line, complete := partials.add("c.log", "single", true)
+	require.True(t, complete)
+	assert.Equal(t, "single", line)
+	assert.NotContains(t, partials.parts, "a.log", "parts not continued for maxAge are dropped")
+
+	line, complete = partials.add("b.log", "done", true)
+	require.True(t, complete)
+	assert.Equal(t, "continued twice done", line, "continued lines are kept")
+	line, complete = partials.add("a.log", "new", true)
+	require.True(t, complete)
+	assert.Equal(t, "new", line)
+	assert.Empty(t, partials.parts)
+}
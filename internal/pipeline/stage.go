@@ -6,6 +6,7 @@ import (
 	"log-enricher/internal/models"
 	"log/slog"
 	"regexp"
+	"sync/atomic"
 
 	"log-enricher/internal/config"
 )
@@ -18,17 +19,29 @@ type Stage interface {
 	Process(entry *models.LogEntry) (keep bool, err error)
 }
 
+// finisher is implemented by stages that complete an entry once every stage ran. Parsers skip entries that already
+// have fields, so fields that do not come from the line itself are only added at the end.
+type finisher interface {
+	finish(entry *models.LogEntry)
+}
+
 type ProcessPipeline interface {
 	Process(entry *models.LogEntry) bool
 }
 
 type Manager interface {
 	GetProcessPipeline(filePath string) ProcessPipeline
+	// GetTenantProcessPipeline is GetProcessPipeline for entries of a receiver tenant. Stages restricted to
+	// other tenants are left out; an empty tenant gets only the unrestricted stages.
+	GetTenantProcessPipeline(tenant, filePath string) ProcessPipeline
 }
 
 type appliedToStage struct {
 	stage     Stage
 	appliesTo *regexp.Regexp
+	tenants   map[string]struct{} // Empty for stages that apply to every tenant.
+	onError   errorPolicy
+	errors    *atomic.Uint64
 }
 
 // Manager holds and executes the configured processing stages.
@@ -45,51 +58,89 @@ func NewManager(cfg *config.Config, ctx context.Context) (Manager, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error creating stage %d (%s): %w", i, stageCfg.Type, err)
 		}
+		onError, err := parseErrorPolicy(stageCfg.Params)
+		if err != nil {
+			return nil, fmt.Errorf("error creating stage %d (%s): %w", i, stageCfg.Type, err)
+		}
+		if _, ok := stage.(partialJoiner); ok && cfg.PipelineWorkers > 0 {
+			return nil, fmt.Errorf("error creating stage %d (%s): partial lines are joined in processing order, which PIPELINE_WORKERS does not keep; set PIPELINE_WORKERS=0", i, stageCfg.Type)
+		}
 		if stage != nil {
-			stages = append(stages, appliedToStage{appliesTo: appliesTo, stage: stage})
-			slog.Debug("Enabled pipeline stage", "stage", stage.Name())
+			var tenants map[string]struct{}
+			for _, tenant := range stageCfg.Tenants {
+				if tenants == nil {
+					tenants = make(map[string]struct{}, len(stageCfg.Tenants))
+				}
+				tenants[tenant] = struct{}{}
+			}
+			stages = append(stages, appliedToStage{appliesTo: appliesTo, tenants: tenants, stage: stage, onError: onError, errors: newErrorCounter()})
+			slog.Debug("Enabled pipeline stage", "stage", stage.Name(), "on_error", onError)
 		}
 	}
 
-	return &manager{stages: stages}, nil
+	m := &manager{stages: stages}
+	go m.reportErrorCounts(ctx)
+	return m, nil
 }
 
 func (m *manager) GetProcessPipeline(filePath string) ProcessPipeline {
+	return m.GetTenantProcessPipeline("", filePath)
+}
+
+func (m *manager) GetTenantProcessPipeline(tenant, filePath string) ProcessPipeline {
 	var stages []Stage
-	for _, stage := range m.stages {
-		if stage.appliesTo != nil {
-			if stage.appliesTo.MatchString(filePath) {
-				stages = append(stages, stage.stage)
-			} else {
-				slog.Debug("Ignoring stage due to 'applies_to' regex", "stage", stage.stage.Name(), "regex", stage.appliesTo, "path", filePath)
+	var handlers []*appliedToStage
+	var finishers []finisher
+	for i := range m.stages {
+		stage := &m.stages[i]
+		if len(stage.tenants) > 0 {
+			if _, ok := stage.tenants[tenant]; !ok {
+				slog.Debug("Ignoring stage restricted to other tenants", "stage", stage.stage.Name(), "tenant", tenant, "path", filePath)
+				continue
 			}
-		} else {
-			stages = append(stages, stage.stage)
+		}
+		if stage.appliesTo != nil && !stage.appliesTo.MatchString(filePath) {
+			slog.Debug("Ignoring stage due to 'applies_to' regex", "stage", stage.stage.Name(), "regex", stage.appliesTo, "path", filePath)
+			continue
+		}
+		stages = append(stages, stage.stage)
+		handlers = append(handlers, stage)
+		if f, ok := stage.stage.(finisher); ok {
+			finishers = append(finishers, f)
 		}
 	}
 
-	return &processPipeline{stages: stages}
+	return &processPipeline{stages: stages, handlers: handlers, finishers: finishers}
 }
 
 type processPipeline struct {
 	stages []Stage
+	// handlers holds the configured stage (with its error policy) for each entry of stages.
+	handlers  []*appliedToStage
+	finishers []finisher
 }
 
 // Process runs a log entry through the entire pipeline.
+// Stage errors are handled according to the stage's 'on_error' policy.
 func (m *processPipeline) Process(entry *models.LogEntry) bool {
 	keep := true
 	var err error
+	// Keep the original line so a dead-lettered entry can be replayed even if a stage replaced it.
+	originalLine := entry.LogLine
 
-	for _, stage := range m.stages {
+	for i, stage := range m.stages {
 		keep, err = stage.Process(entry)
 		if err != nil {
-			slog.Error("Error during stage", "stage", stage.Name(), "error", err)
+			keep = m.handlers[i].handleStageError(entry, originalLine, keep, err)
 		}
 		if !keep {
 			// Stage decided to drop the log, so we stop processing.
 			return false
 		}
 	}
+	for _, f := range m.finishers {
+		f.finish(entry)
+	}
 	return true
 }
 
@@ -117,8 +168,22 @@ func newStage(stageCfg config.StageConfig, ctx context.Context) (Stage, *regexp.
 		stage = NewJSONParser()
 	case "structured_parser":
 		stage, err = NewStructuredParser(stageCfg.Params)
+	case "logfmt_parser":
+		stage, err = NewLogfmtParser(stageCfg.Params)
+	case "grok_parser":
+		stage, err = NewGrokParser(stageCfg.Params)
+	case "access_log_parser":
+		stage, err = NewAccessLogParser(stageCfg.Params)
 	case "field_rewrite":
 		stage, err = NewFieldRewriteStage(stageCfg.Params)
+	case "labels":
+		stage, err = NewLabelsStage(stageCfg.Params)
+	case "docker_json":
+		stage, err = NewDockerJSONStage(stageCfg.Params)
+	case "cri_parser":
+		stage, err = NewCRIParserStage(stageCfg.Params)
+	case "docker_metadata":
+		stage, err = NewDockerMetadataStage(stageCfg.Params)
 	default:
 		return nil, nil, fmt.Errorf("unknown stage type: %s", stageCfg.Type)
 	}
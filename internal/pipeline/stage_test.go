@@ -1,13 +1,18 @@
 package pipeline
 
 import (
+	"bytes"
 	"errors"
 	"log-enricher/internal/bufferpool"
+	"log-enricher/internal/deadletter"
 	"log-enricher/internal/models"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // mockStage is a configurable mock for the Stage interface.
@@ -145,6 +150,49 @@ func TestProcessPipeline_Process(t *testing.T) {
 		assert.Equal(t, 1, stage1.callCount)
 		assert.Equal(t, 0, stage2.callCount, "second stage should not have been called")
 	})
+
+	t.Run("Stage error is dead-lettered with on_error=dead_letter", func(t *testing.T) {
+		dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+		sink, err := deadletter.NewFileSink(dlqPath)
+		require.NoError(t, err)
+		deadletter.SetSink(sink)
+		defer deadletter.Close()
+
+		stage1 := &mockStage{
+			name: "error_producer",
+			process: func(entry *models.LogEntry) (bool, error) {
+				entry.LogLine = []byte("rewritten")
+				return true, errors.New("something went wrong")
+			},
+		}
+		stage2 := &mockStage{name: "never_called"}
+		m := &manager{stages: []appliedToStage{{stage: stage1, onError: errorPolicyDeadLetter}, {stage: stage2}}}
+		inputEntry := bufferpool.LogEntryPool.Acquire()
+		defer bufferpool.LogEntryPool.Release(inputEntry)
+		inputEntry.LogLine = []byte("original")
+		inputEntry.SourcePath = "/logs/app.log"
+		inputEntry.App = "app"
+
+		keep := m.GetProcessPipeline("").Process(inputEntry)
+		deadletter.Close()
+
+		assert.False(t, keep, "dead-lettered entries are not sent to the backend")
+		assert.Equal(t, 0, stage2.callCount)
+
+		content, err := os.ReadFile(dlqPath)
+		require.NoError(t, err)
+		var records []deadletter.Record
+		require.NoError(t, deadletter.ReadRecords(bytes.NewReader(content), func(r deadletter.Record) error {
+			records = append(records, r)
+			return nil
+		}))
+		require.Len(t, records, 1)
+		assert.Equal(t, "original", records[0].Line)
+		assert.Equal(t, "error_producer", records[0].Stage)
+		assert.Equal(t, "something went wrong", records[0].Error)
+		assert.Equal(t, "/logs/app.log", records[0].Source)
+		assert.Equal(t, "app", records[0].App)
+	})
 }
 
 func TestManager_GetProcessPipeline(t *testing.T) {
@@ -214,4 +262,27 @@ func TestManager_GetProcessPipeline(t *testing.T) {
 		assert.Equal(t, 0, stage2.callCount) // txt_stage should NOT run
 		assert.Equal(t, 1, stage3.callCount) // always_run should run
 	})
+
+	t.Run("Tenant-restricted stages only apply to their tenants", func(t *testing.T) {
+		appliesToLog, _ := regexp.Compile(`\.log$`)
+		m := &manager{
+			stages: []appliedToStage{
+				{stage: &mockStage{name: "team_a_stage"}, tenants: map[string]struct{}{"team-a": {}}},
+				{stage: &mockStage{name: "team_a_log_stage"}, appliesTo: appliesToLog, tenants: map[string]struct{}{"team-a": {}, "team-c": {}}},
+				{stage: &mockStage{name: "always_run"}},
+			},
+		}
+
+		names := func(p ProcessPipeline) []string {
+			var result []string
+			for _, stage := range p.(*processPipeline).stages {
+				result = append(result, stage.Name())
+			}
+			return result
+		}
+		assert.Equal(t, []string{"team_a_stage", "team_a_log_stage", "always_run"}, names(m.GetTenantProcessPipeline("team-a", "app.log")))
+		assert.Equal(t, []string{"team_a_stage", "always_run"}, names(m.GetTenantProcessPipeline("team-a", "app.txt")))
+		assert.Equal(t, []string{"always_run"}, names(m.GetTenantProcessPipeline("team-b", "app.log")))
+		assert.Equal(t, []string{"always_run"}, names(m.GetProcessPipeline("app.log")), "entries without a tenant skip restricted stages")
+	})
 }
@@ -119,8 +119,9 @@ func (s *TemplateResolverStage) Process(entry *models.LogEntry) (keep bool, err
 		goTemplateStr := s.placeholderRegex.ReplaceAllString(templateStr, "{{index . \"indexed_$1\"}}")
 		tmpl, err = template.New("log_template").Parse(goTemplateStr)
 		if err != nil {
-			// Include both original and converted string in error for better debugging
-			return false, fmt.Errorf("failed to parse template from field '%s' (original: '%s', converted: '%s'): %w", s.cfg.TemplateField, templateStr, goTemplateStr, err)
+			// Include both original and converted string in error for better debugging.
+			// The entry is kept; the stage's 'on_error' policy decides what happens to it.
+			return true, fmt.Errorf("failed to parse template from field '%s' (original: '%s', converted: '%s'): %w", s.cfg.TemplateField, templateStr, goTemplateStr, err)
 		}
 		s.templateCache.Set(cacheKey, tmpl)
 	}
@@ -174,7 +175,7 @@ func (s *TemplateResolverStage) Process(entry *models.LogEntry) (keep bool, err
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, templateData); err != nil {
 		// Include both original and converted string in error for better debugging
-		return false, fmt.Errorf("failed to execute template from field '%s': %w", s.cfg.TemplateField, err)
+		return true, fmt.Errorf("failed to execute template from field '%s': %w", s.cfg.TemplateField, err)
 	}
 
 	// 6. Store the rendered output in the specified field
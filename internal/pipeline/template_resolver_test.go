@@ -250,7 +250,7 @@ func TestTemplateResolverStage_Process(t *testing.T) {
 					"Properties.0":    "Value",
 				},
 			},
-			expectedKeep: false, // Stage drops the log due to template parsing error
+			expectedKeep: true, // Stage keeps the log; the pipeline's on_error policy decides
 			expectedErr:  "failed to parse template from field 'MessageTemplate' (original: '{{.0', converted: '{{.0'): template: log_template:1: unclosed action",
 		},
 		{
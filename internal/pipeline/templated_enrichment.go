@@ -64,12 +64,13 @@ func (s *TemplatedEnrichmentStage) Process(entry *models.LogEntry) (bool, error)
 			types[k] = reflect.TypeOf(v).Name()
 		}
 
-		slog.Error("failed to execute template", "fields", entry.Fields, "file", entry.SourcePath, "types", types, "error", err)
-		return true, nil
+		slog.Debug("failed to execute template", "fields", entry.Fields, "file", entry.SourcePath, "types", types, "error", err)
+		// Keep the entry; the stage's 'on_error' policy decides what happens to it.
+		return true, fmt.Errorf("failed to execute template for field '%s': %w", s.config.Field, err)
 	}
 
 	// Store the template's output in the configured field.
 	entry.Fields[s.config.Field] = buf.String()
 
-	return true, nil // Always keep the log entry
+	return true, nil
 }
@@ -3,8 +3,11 @@ package processor
 import (
 	"log-enricher/internal/backends"
 	"log-enricher/internal/bufferpool"
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/models"
 	"log-enricher/internal/pipeline"
 	"log/slog"
+	"maps"
 	"time"
 )
 
@@ -14,14 +17,24 @@ type LogProcessor interface {
 }
 
 type LogProcessorImpl struct {
+	tenant     string
 	appName    string
 	sourcePath string
 	pipeline   pipeline.ProcessPipeline
 	backend    backends.Backend
+	// noDeadLetter is set for callers that retry failed entries and dead-letter them themselves.
+	noDeadLetter bool
 }
 
 func NewLogProcessor(appName string, sourcePath string, processPipeline pipeline.ProcessPipeline, bb backends.Backend) *LogProcessorImpl {
+	return NewTenantLogProcessor("", appName, sourcePath, processPipeline, bb)
+}
+
+// NewTenantLogProcessor creates a processor whose entries belong to tenant. The pipeline should be the tenant's,
+// see pipeline.Manager.GetTenantProcessPipeline.
+func NewTenantLogProcessor(tenant, appName string, sourcePath string, processPipeline pipeline.ProcessPipeline, bb backends.Backend) *LogProcessorImpl {
 	return &LogProcessorImpl{
+		tenant:     tenant,
 		appName:    appName,
 		sourcePath: sourcePath,
 		pipeline:   processPipeline,
@@ -29,24 +42,61 @@ func NewLogProcessor(appName string, sourcePath string, processPipeline pipeline
 	}
 }
 
+// DisableDeadLetter stops the processor from dead-lettering entries the backend rejects. The error is still
+// returned, so the caller can retry the line and dead-letter it once it gives up.
+func (p *LogProcessorImpl) DisableDeadLetter() {
+	p.noDeadLetter = true
+}
+
 func (p *LogProcessorImpl) ProcessLine(line []byte) error {
-	return p.processLine(line, nil)
+	return p.processLine(line, nil, nil, nil, nil)
 }
 
 func (p *LogProcessorImpl) ProcessLineWithTimestamp(line []byte, ts time.Time) error {
-	return p.processLine(line, &ts)
+	return p.processLine(line, &ts, nil, nil, nil)
 }
 
-func (p *LogProcessorImpl) processLine(line []byte, ts *time.Time) error {
+// ProcessLineWithFields processes a line whose fields were already extracted by the input, e.g. a syslog header.
+// The fields are copied into the entry before the pipeline runs. A zero ts falls back to the current time.
+func (p *LogProcessorImpl) ProcessLineWithFields(line []byte, ts time.Time, fields map[string]interface{}) error {
+	return p.processLine(line, &ts, fields, nil, nil)
+}
+
+// ProcessLineWithLabels is ProcessLineWithFields for inputs that also carry labels and structured metadata, e.g.
+// Promtail stream labels. Both are copied, so the caller may share one map between the lines of a stream.
+func (p *LogProcessorImpl) ProcessLineWithLabels(line []byte, ts time.Time, fields map[string]interface{}, labels, metadata map[string]string) error {
+	return p.processLine(line, &ts, fields, labels, metadata)
+}
+
+func (p *LogProcessorImpl) processLine(line []byte, ts *time.Time, fields map[string]interface{}, labels, metadata map[string]string) error {
 	slog.Debug("Processing line", "path", p.sourcePath)
 	// Acquire a *models.LogEntry
 	logEntry := bufferpool.LogEntryPool.Acquire()
 	// Ensure the acquired object is released back to the pool when done.
 	defer bufferpool.LogEntryPool.Release(logEntry)
 
+	if !p.prepare(logEntry, line, ts, fields, labels, metadata) {
+		return nil
+	}
+	return p.send(logEntry, line)
+}
+
+// prepare fills entry from line and runs it through the pipeline.
+// It returns false if the pipeline dropped the entry.
+func (p *LogProcessorImpl) prepare(logEntry *models.LogEntry, line []byte, ts *time.Time, fields map[string]interface{}, labels, metadata map[string]string) bool {
 	logEntry.LogLine = line
+	for k, v := range fields {
+		logEntry.Fields[k] = v
+	}
+	if len(labels) > 0 {
+		logEntry.Labels = maps.Clone(labels)
+	}
+	if len(metadata) > 0 {
+		logEntry.StructuredMetadata = maps.Clone(metadata)
+	}
 	logEntry.SourcePath = p.sourcePath
 	logEntry.App = p.appName
+	logEntry.Tenant = p.tenant
 	if ts != nil {
 		logEntry.Timestamp = *ts
 	}
@@ -58,7 +108,7 @@ func (p *LogProcessorImpl) processLine(line []byte, ts *time.Time) error {
 	if !keep {
 		// Drop the line if it was dropped by the pipeline
 		slog.Debug("Dropped line by pipeline", "path", p.sourcePath)
-		return nil
+		return false
 	}
 
 	slog.Debug("Processed line through pipeline", "path", p.sourcePath)
@@ -69,10 +119,24 @@ func (p *LogProcessorImpl) processLine(line []byte, ts *time.Time) error {
 	if logEntry.Timestamp.IsZero() {
 		logEntry.Timestamp = time.Now()
 	}
+	return true
+}
 
+// send hands a prepared entry to the backend, dead-lettering it with the original line on failure unless
+// DisableDeadLetter was called.
+func (p *LogProcessorImpl) send(logEntry *models.LogEntry, line []byte) error {
 	err := p.backend.Send(logEntry) // Pass the pointer
-	if err != nil {
+	if err != nil && !p.noDeadLetter {
 		slog.Error("Failed to send log entry to backend", "error", err, "source_path", logEntry.SourcePath, "app", logEntry.App)
+		deadletter.Write(deadletter.Record{
+			App:       p.appName,
+			Source:    p.sourcePath,
+			Tenant:    p.tenant,
+			Timestamp: logEntry.Timestamp,
+			Line:      string(line),
+			Backend:   p.backend.Name(),
+			Error:     err.Error(),
+		})
 	}
 
 	return err
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"log-enricher/internal/backends"
+	"log-enricher/internal/deadletter"
 	"log-enricher/internal/models"
 	"log-enricher/internal/pipeline"
 	"log-enricher/internal/state"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"testing"
@@ -56,6 +58,8 @@ func (b *captureBackend) Send(entry *models.LogEntry) error {
 		Timestamp:  entry.Timestamp,
 		SourcePath: entry.SourcePath,
 		App:        entry.App,
+		Labels:     maps.Clone(entry.Labels),
+		Tenant:     entry.Tenant,
 	})
 	return b.err
 }
@@ -64,6 +68,15 @@ func (b *captureBackend) Shutdown()                     {}
 func (b *captureBackend) Name() string                  { return "capture" }
 func (b *captureBackend) CloseWriter(sourcePath string) {}
 
+func (b *captureBackend) SendBatch(entries []*models.LogEntry) error {
+	for _, entry := range entries {
+		if err := b.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type timestampStage struct {
 	ts time.Time
 }
@@ -229,6 +242,20 @@ func TestLogProcessor_ProcessLineWithTimestamp_UsesProvidedTimestamp(t *testing.
 	assert.Equal(t, provided, backend.entries[0].Timestamp)
 }
 
+func TestLogProcessor_ProcessLineWithLabels_CopiesLabels(t *testing.T) {
+	backend := &captureBackend{}
+	processor := NewLogProcessor("orders-api", "source.log", &testPipeline{}, backend)
+	labels := map[string]string{"env": "prod"}
+
+	err := processor.ProcessLineWithLabels([]byte("line"), time.Time{}, nil, labels, nil)
+	labels["env"] = "changed"
+
+	require.NoError(t, err)
+	require.Len(t, backend.entries, 1)
+	assert.Equal(t, map[string]string{"env": "prod"}, backend.entries[0].Labels)
+	assert.False(t, backend.entries[0].Timestamp.IsZero())
+}
+
 func TestLogProcessor_ProcessLineWithTimestamp_PipelineCanOverrideTimestamp(t *testing.T) {
 	sourcePath := filepath.Join(t.TempDir(), "source.log")
 	backend := &captureBackend{}
@@ -261,3 +288,54 @@ func TestLogProcessor_ProcessLine_PropagatesBackendErrors(t *testing.T) {
 	assert.ErrorIs(t, err, expectedErr)
 	require.Len(t, backend.entries, 1)
 }
+
+func TestLogProcessor_ProcessLine_DeadLettersBackendErrors(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	sink, err := deadletter.NewFileSink(dlqPath)
+	require.NoError(t, err)
+	deadletter.SetSink(sink)
+	defer deadletter.Close()
+
+	sourcePath := filepath.Join(t.TempDir(), "source.log")
+	backend := &captureBackend{err: errors.New("backend write failed")}
+	ts := time.Date(2026, time.April, 5, 6, 7, 8, 0, time.UTC)
+	processor := NewLogProcessor("orders-api", sourcePath, &testPipeline{}, backend)
+
+	require.Error(t, processor.ProcessLineWithTimestamp([]byte("line"), ts))
+	deadletter.Close()
+
+	f, err := os.Open(dlqPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []deadletter.Record
+	require.NoError(t, deadletter.ReadRecords(f, func(r deadletter.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	require.Len(t, records, 1)
+	assert.Equal(t, "line", records[0].Line)
+	assert.Equal(t, "capture", records[0].Backend)
+	assert.Equal(t, "backend write failed", records[0].Error)
+	assert.Equal(t, "orders-api", records[0].App)
+	assert.Equal(t, sourcePath, records[0].Source)
+	assert.True(t, ts.Equal(records[0].Timestamp))
+}
+
+func TestLogProcessor_DisableDeadLetterOnlyReturnsBackendErrors(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	sink, err := deadletter.NewFileSink(dlqPath)
+	require.NoError(t, err)
+	deadletter.SetSink(sink)
+	defer deadletter.Close()
+
+	backend := &captureBackend{err: errors.New("backend write failed")}
+	processor := NewLogProcessor("orders-api", filepath.Join(t.TempDir(), "source.log"), &testPipeline{}, backend)
+	processor.DisableDeadLetter()
+
+	require.Error(t, processor.ProcessLine([]byte("line")))
+	deadletter.Close()
+
+	_, err = os.Stat(dlqPath)
+	assert.ErrorIs(t, err, os.ErrNotExist, "nothing was dead-lettered")
+}
@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/backends"
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/pipeline"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// ReplayStats summarizes a dead-letter replay run.
+type ReplayStats struct {
+	// Processed is the number of records that were run through the pipeline.
+	// Records that fail a stage again are dead-lettered by the pipeline and still count as processed.
+	Processed int
+	// Failed is the number of records whose backend send failed again.
+	Failed int
+}
+
+// ReplayDeadLetters re-processes every record of an NDJSON dead-letter file through the pipeline and backend.
+// The file is moved aside before replaying, so records that fail again are dead-lettered into a fresh file
+// at the configured sink instead of being re-read. The moved file is removed once the replay finished.
+func ReplayDeadLetters(path string, pm pipeline.Manager, backend backends.Backend) (ReplayStats, error) {
+	var stats ReplayStats
+
+	replayPath := fmt.Sprintf("%s.replay-%d", path, time.Now().UnixNano())
+	if err := os.Rename(path, replayPath); err != nil {
+		return stats, fmt.Errorf("failed to move dead-letter file %s aside for replay: %w", path, err)
+	}
+
+	f, err := os.Open(replayPath)
+	if err != nil {
+		return stats, fmt.Errorf("failed to open dead-letter file %s: %w", replayPath, err)
+	}
+	defer f.Close()
+
+	processors := make(map[string]*LogProcessorImpl)
+	err = deadletter.ReadRecords(f, func(record deadletter.Record) error {
+		key := record.Tenant + "\x00" + record.App + "\x00" + record.Source
+		lp, ok := processors[key]
+		if !ok {
+			lp = NewTenantLogProcessor(record.Tenant, record.App, record.Source, pm.GetTenantProcessPipeline(record.Tenant, record.Source), backend)
+			processors[key] = lp
+		}
+
+		var processErr error
+		if record.Timestamp.IsZero() {
+			processErr = lp.ProcessLine([]byte(record.Line))
+		} else {
+			processErr = lp.ProcessLineWithTimestamp([]byte(record.Line), record.Timestamp)
+		}
+
+		stats.Processed++
+		if processErr != nil {
+			stats.Failed++
+		}
+		return nil
+	})
+	if err != nil {
+		var readErr *deadletter.ReadError
+		if errors.As(err, &readErr) {
+			if keepErr := keepFrom(replayPath, readErr.Offset); keepErr != nil {
+				return stats, fmt.Errorf("failed to replay dead-letter file %s (kept in %s, including %d replayed records: %v): %w", path, replayPath, stats.Processed, keepErr, err)
+			}
+		}
+		return stats, fmt.Errorf("failed to replay dead-letter file %s (remaining records kept in %s): %w", path, replayPath, err)
+	}
+
+	if err := os.Remove(replayPath); err != nil {
+		slog.Warn("Failed to remove replayed dead-letter file", "path", replayPath, "error", err)
+	}
+
+	slog.Info("Replayed dead-letter records", "path", path, "processed", stats.Processed, "failed", stats.Failed)
+	return stats, nil
+}
+
+// keepFrom rewrites the file at path to hold only its content from offset on, so the records that were already
+// replayed are not replayed again.
+func keepFrom(path string, offset int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
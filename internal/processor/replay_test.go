@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/pipeline"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPipelineManager struct {
+	pipeline pipeline.ProcessPipeline
+}
+
+func (m *testPipelineManager) GetProcessPipeline(filePath string) pipeline.ProcessPipeline {
+	return m.pipeline
+}
+
+func (m *testPipelineManager) GetTenantProcessPipeline(tenant, filePath string) pipeline.ProcessPipeline {
+	return m.GetProcessPipeline(filePath)
+}
+
+func writeDeadLetterFile(t *testing.T, path string, records ...deadletter.Record) {
+	t.Helper()
+	sink, err := deadletter.NewFileSink(path)
+	require.NoError(t, err)
+	for _, record := range records {
+		require.NoError(t, sink.Write(record))
+	}
+	require.NoError(t, sink.Close())
+}
+
+func TestReplayDeadLetters_ProcessesRecordsAndRemovesFile(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	ts := time.Date(2026, time.May, 1, 0, 0, 0, 0, time.UTC)
+	writeDeadLetterFile(t, dlqPath,
+		deadletter.Record{App: "api", Source: "/logs/api.log", Timestamp: ts, Line: `{"msg":"a"}`, Stage: "template_resolver", Error: "boom"},
+		deadletter.Record{App: "web", Source: "/logs/web.log", Tenant: "team-a", Line: "plain", Backend: "loki", Error: "unavailable"},
+	)
+
+	backend := &captureBackend{}
+	stats, err := ReplayDeadLetters(dlqPath, &testPipelineManager{pipeline: &testPipeline{}}, backend)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, stats.Processed)
+	assert.Equal(t, 0, stats.Failed)
+	require.Len(t, backend.entries, 2)
+	assert.Equal(t, "api", backend.entries[0].App)
+	assert.Equal(t, "/logs/api.log", backend.entries[0].SourcePath)
+	assert.True(t, ts.Equal(backend.entries[0].Timestamp))
+	assert.Equal(t, "plain", string(backend.entries[1].LogLine))
+	assert.Equal(t, "", backend.entries[0].Tenant)
+	assert.Equal(t, "team-a", backend.entries[1].Tenant, "records are replayed for their tenant")
+	assert.False(t, backend.entries[1].Timestamp.IsZero(), "records without timestamp get the fallback timestamp")
+
+	matches, err := filepath.Glob(dlqPath + "*")
+	require.NoError(t, err)
+	assert.Empty(t, matches, "replayed file should be removed")
+}
+
+func TestReplayDeadLetters_FailedRecordsGoToFreshDeadLetterFile(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	writeDeadLetterFile(t, dlqPath,
+		deadletter.Record{App: "api", Source: "/logs/api.log", Line: "one", Backend: "loki", Error: "unavailable"},
+	)
+
+	sink, err := deadletter.NewFileSink(dlqPath)
+	require.NoError(t, err)
+	deadletter.SetSink(sink)
+	defer deadletter.Close()
+
+	backend := &captureBackend{err: errors.New("still down")}
+	stats, err := ReplayDeadLetters(dlqPath, &testPipelineManager{pipeline: &testPipeline{}}, backend)
+	require.NoError(t, err)
+	deadletter.Close()
+
+	assert.Equal(t, 1, stats.Processed)
+	assert.Equal(t, 1, stats.Failed)
+
+	content, err := os.ReadFile(dlqPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "still down")
+}
+
+func TestReplayDeadLetters_MissingFile(t *testing.T) {
+	_, err := ReplayDeadLetters(filepath.Join(t.TempDir(), "missing.ndjson"), &testPipelineManager{pipeline: &testPipeline{}}, &captureBackend{})
+	require.Error(t, err)
+}
+
+func TestReplayDeadLetters_KeepsOnlyUnreplayedRecordsOnError(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	writeDeadLetterFile(t, dlqPath,
+		deadletter.Record{App: "api", Source: "/logs/api.log", Line: "one", Error: "boom"},
+		deadletter.Record{App: "api", Source: "/logs/api.log", Line: "two", Error: "boom"},
+	)
+	f, err := os.OpenFile(dlqPath, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("not-json\n{\"line\":\"three\",\"error\":\"boom\"}\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	backend := &captureBackend{}
+	stats, replayErr := ReplayDeadLetters(dlqPath, &testPipelineManager{pipeline: &testPipeline{}}, backend)
+	require.Error(t, replayErr)
+	assert.Equal(t, 2, stats.Processed)
+	require.Len(t, backend.entries, 2)
+
+	kept, err := filepath.Glob(dlqPath + ".replay-*")
+	require.NoError(t, err)
+	require.Len(t, kept, 1)
+	assert.Contains(t, replayErr.Error(), kept[0])
+	content, err := os.ReadFile(kept[0])
+	require.NoError(t, err)
+	assert.Equal(t, "not-json\n{\"line\":\"three\",\"error\":\"boom\"}\n", string(content), "replayed records are not kept")
+}
@@ -0,0 +1,244 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log-enricher/internal/models"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const workerPoolStatsInterval = time.Minute
+
+// ErrWorkerPoolClosed is returned when a line is submitted after the pool was shut down.
+var ErrWorkerPoolClosed = errors.New("worker pool is shut down")
+
+// WorkerPool runs pipeline processing for many sources on a fixed number of goroutines.
+// Lines of a single source are processed in parallel but sent to the backend in the order they were submitted.
+type WorkerPool struct {
+	jobs    chan *poolJob
+	slots   chan struct{} // Bounds the number of lines submitted but not yet sent.
+	workers int
+	wg      sync.WaitGroup // Running workers.
+	pending sync.WaitGroup // Lines submitted but not yet sent.
+
+	mu     sync.RWMutex // Guards closed against concurrent submits during Shutdown.
+	closed bool
+
+	processed atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// WorkerPoolStats is a snapshot of the pool's queue depth and counters.
+type WorkerPoolStats struct {
+	Workers int
+	// Queued is the number of lines waiting for a worker.
+	Queued int
+	// InFlight is the number of lines submitted but not yet sent, including Queued.
+	InFlight int
+	// Capacity is the maximum of InFlight; submitting blocks once it is reached.
+	Capacity  int
+	Processed uint64
+	Dropped   uint64
+}
+
+type poolJob struct {
+	lp       *LogProcessorImpl
+	line     []byte
+	ts       *time.Time
+	fields   map[string]interface{}
+	labels   map[string]string
+	metadata map[string]string
+
+	entry *models.LogEntry
+	keep  bool
+	done  chan struct{}
+}
+
+// NewWorkerPool starts workers goroutines. At most queueSize lines may be in flight at once.
+// Queue depth is logged periodically until ctx is cancelled.
+func NewWorkerPool(ctx context.Context, workers, queueSize int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < workers {
+		queueSize = workers
+	}
+
+	p := &WorkerPool{
+		jobs:    make(chan *poolJob, queueSize),
+		slots:   make(chan struct{}, queueSize),
+		workers: workers,
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go p.reportStats(ctx)
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		// Entries wait for their predecessors before they are sent, so they are not taken from the
+		// bounded entry pool: a worker blocking on it could wait for an entry held by a later line.
+		job.entry = &models.LogEntry{Fields: make(map[string]interface{})}
+		job.keep = job.lp.prepare(job.entry, job.line, job.ts, job.fields, job.labels, job.metadata)
+		close(job.done)
+	}
+}
+
+// Stats returns the current queue depth and counters.
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	return WorkerPoolStats{
+		Workers:   p.workers,
+		Queued:    len(p.jobs),
+		InFlight:  len(p.slots),
+		Capacity:  cap(p.slots),
+		Processed: p.processed.Load(),
+		Dropped:   p.dropped.Load(),
+	}
+}
+
+func (p *WorkerPool) reportStats(ctx context.Context) {
+	ticker := time.NewTicker(workerPoolStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := p.Stats()
+			slog.Info("Worker Pool Stats", "workers", stats.Workers, "queued", stats.Queued, "in_flight", stats.InFlight, "capacity", stats.Capacity, "processed", stats.Processed, "dropped", stats.Dropped)
+		}
+	}
+}
+
+// Shutdown waits until every submitted line has been sent, then stops the workers.
+// Lines submitted afterwards are rejected with ErrWorkerPoolClosed.
+func (p *WorkerPool) Shutdown() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	p.pending.Wait()
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// NewOrderedProcessor returns a LogProcessor that processes lines for lp's source on the pool.
+// onSent, if not nil, is called after each line has been sent or dropped, in submission order, with the backend
+// error if sending failed.
+func (p *WorkerPool) NewOrderedProcessor(lp *LogProcessorImpl, onSent func(err error)) *OrderedProcessor {
+	o := &OrderedProcessor{
+		pool:    p,
+		lp:      lp,
+		onSent:  onSent,
+		ordered: make(chan *poolJob, cap(p.slots)),
+		stopped: make(chan struct{}),
+	}
+	go o.sendInOrder()
+	return o
+}
+
+// OrderedProcessor submits the lines of a single source to a WorkerPool and sends the results in order.
+// It must be used from one goroutine at a time and closed when the source is done.
+type OrderedProcessor struct {
+	pool    *WorkerPool
+	lp      *LogProcessorImpl
+	onSent  func(err error)
+	ordered chan *poolJob
+	stopped chan struct{}
+
+	closeOnce sync.Once
+	errMu     sync.Mutex
+	err       error // First backend error, reported by Close.
+}
+
+// ProcessLine queues line for processing. It blocks while the pool is at capacity.
+// Backend errors are logged and dead-lettered as usual; the first one is also returned by Close.
+func (o *OrderedProcessor) ProcessLine(line []byte) error {
+	return o.submit(line, nil)
+}
+
+// ProcessLineWithTimestamp queues line for processing with a pre-parsed timestamp.
+func (o *OrderedProcessor) ProcessLineWithTimestamp(line []byte, ts time.Time) error {
+	return o.submit(line, &ts)
+}
+
+// ProcessLineWithFields queues line for processing with fields already extracted by the input.
+func (o *OrderedProcessor) ProcessLineWithFields(line []byte, ts time.Time, fields map[string]interface{}) error {
+	return o.submitJob(&poolJob{lp: o.lp, line: line, ts: &ts, fields: fields})
+}
+
+// ProcessLineWithLabels queues line for processing with fields, labels and structured metadata already extracted
+// by the input.
+func (o *OrderedProcessor) ProcessLineWithLabels(line []byte, ts time.Time, fields map[string]interface{}, labels, metadata map[string]string) error {
+	return o.submitJob(&poolJob{lp: o.lp, line: line, ts: &ts, fields: fields, labels: labels, metadata: metadata})
+}
+
+func (o *OrderedProcessor) submit(line []byte, ts *time.Time) error {
+	return o.submitJob(&poolJob{lp: o.lp, line: line, ts: ts})
+}
+
+func (o *OrderedProcessor) submitJob(job *poolJob) error {
+	p := o.pool
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrWorkerPoolClosed
+	}
+
+	job.done = make(chan struct{})
+	p.slots <- struct{}{}
+	p.pending.Add(1)
+	// Both channels hold at least as many jobs as there are slots, so these sends do not block.
+	o.ordered <- job
+	p.jobs <- job
+	return nil
+}
+
+// Close waits until every queued line has been sent and returns the first backend error, if any.
+func (o *OrderedProcessor) Close() error {
+	o.closeOnce.Do(func() { close(o.ordered) })
+	<-o.stopped
+
+	o.errMu.Lock()
+	defer o.errMu.Unlock()
+	return o.err
+}
+
+func (o *OrderedProcessor) sendInOrder() {
+	defer close(o.stopped)
+	p := o.pool
+
+	for job := range o.ordered {
+		<-job.done
+		var err error
+		if job.keep {
+			if err = o.lp.send(job.entry, job.line); err != nil {
+				o.errMu.Lock()
+				if o.err == nil {
+					o.err = err
+				}
+				o.errMu.Unlock()
+			}
+			p.processed.Add(1)
+		} else {
+			p.dropped.Add(1)
+		}
+		if o.onSent != nil {
+			o.onSent(err)
+		}
+
+		<-p.slots
+		p.pending.Done()
+	}
+}
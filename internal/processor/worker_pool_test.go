@@ -0,0 +1,196 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log-enricher/internal/models"
+	"log-enricher/internal/pipeline"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jitterStage delays every entry by a line-dependent amount so workers finish out of order.
+type jitterStage struct{}
+
+func (s *jitterStage) Name() string { return "jitter_stage" }
+
+func (s *jitterStage) Process(entry *models.LogEntry) (bool, error) {
+	h := fnv.New32a()
+	h.Write(entry.LogLine)
+	time.Sleep(time.Duration(h.Sum32()%500) * time.Microsecond)
+	entry.Fields["line"] = string(entry.LogLine)
+	return true, nil
+}
+
+// dropStage drops entries whose line is "drop".
+type dropStage struct{}
+
+func (s *dropStage) Name() string { return "drop_stage" }
+
+func (s *dropStage) Process(entry *models.LogEntry) (bool, error) {
+	return string(entry.LogLine) != "drop", nil
+}
+
+// orderBackend records sent lines per source and is safe for concurrent use.
+type orderBackend struct {
+	mu     sync.Mutex
+	lines  map[string][]string
+	failOn string
+}
+
+func newOrderBackend() *orderBackend {
+	return &orderBackend{lines: make(map[string][]string)}
+}
+
+func (b *orderBackend) Send(entry *models.LogEntry) error {
+	if string(entry.LogLine) == b.failOn {
+		return errors.New("backend rejected entry")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines[entry.SourcePath] = append(b.lines[entry.SourcePath], string(entry.LogLine))
+	return nil
+}
+
+func (b *orderBackend) SendBatch(entries []*models.LogEntry) error {
+	for _, entry := range entries {
+		if err := b.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *orderBackend) Shutdown()                     {}
+func (b *orderBackend) Name() string                  { return "order" }
+func (b *orderBackend) CloseWriter(sourcePath string) {}
+
+func (b *orderBackend) sent(sourcePath string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines[sourcePath]...)
+}
+
+func TestWorkerPool_PreservesPerSourceOrder(t *testing.T) {
+	pool := NewWorkerPool(t.Context(), 8, 64)
+	backend := newOrderBackend()
+	pl := &testPipeline{stages: []pipeline.Stage{&jitterStage{}}}
+
+	const sources = 4
+	const linesPerSource = 200
+
+	var wg sync.WaitGroup
+	for s := 0; s < sources; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			sourcePath := fmt.Sprintf("/logs/%d.log", s)
+			var sentCount int
+			op := pool.NewOrderedProcessor(NewLogProcessor("app", sourcePath, pl, backend), func(error) { sentCount++ })
+			for i := 0; i < linesPerSource; i++ {
+				assert.NoError(t, op.ProcessLine([]byte(fmt.Sprintf("%d-%d", s, i))))
+			}
+			assert.NoError(t, op.Close())
+			assert.Equal(t, linesPerSource, sentCount)
+		}(s)
+	}
+	wg.Wait()
+	pool.Shutdown()
+
+	for s := 0; s < sources; s++ {
+		sent := backend.sent(fmt.Sprintf("/logs/%d.log", s))
+		require.Len(t, sent, linesPerSource)
+		for i, line := range sent {
+			assert.Equal(t, fmt.Sprintf("%d-%d", s, i), line)
+		}
+	}
+
+	stats := pool.Stats()
+	assert.Equal(t, uint64(sources*linesPerSource), stats.Processed)
+	assert.Zero(t, stats.InFlight)
+	assert.Zero(t, stats.Queued)
+}
+
+func TestWorkerPool_CountsDroppedLinesAndReportsBackendErrors(t *testing.T) {
+	pool := NewWorkerPool(t.Context(), 2, 4)
+	defer pool.Shutdown()
+	backend := newOrderBackend()
+	backend.failOn = "fail"
+	pl := &testPipeline{stages: []pipeline.Stage{&dropStage{}}}
+
+	var handled []error
+	op := pool.NewOrderedProcessor(NewLogProcessor("app", "/logs/a.log", pl, backend), func(err error) { handled = append(handled, err) })
+	for _, line := range []string{"one", "drop", "fail", "two"} {
+		require.NoError(t, op.ProcessLine([]byte(line)))
+	}
+	err := op.Close()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "backend rejected entry")
+	assert.Equal(t, []string{"one", "two"}, backend.sent("/logs/a.log"))
+	// Every line counts as handled, including dropped and failed ones; only the failed one reports an error.
+	require.Len(t, handled, 4)
+	assert.NoError(t, handled[0])
+	assert.NoError(t, handled[1])
+	assert.Error(t, handled[2])
+	assert.NoError(t, handled[3])
+
+	stats := pool.Stats()
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, uint64(3), stats.Processed)
+}
+
+func TestWorkerPool_BoundsInFlightLines(t *testing.T) {
+	pool := NewWorkerPool(t.Context(), 1, 2)
+	defer pool.Shutdown()
+
+	release := make(chan struct{})
+	blocking := &blockingBackend{orderBackend: newOrderBackend(), release: release}
+	op := pool.NewOrderedProcessor(NewLogProcessor("app", "/logs/a.log", &testPipeline{}, blocking), nil)
+
+	require.NoError(t, op.ProcessLine([]byte("1")))
+	require.NoError(t, op.ProcessLine([]byte("2")))
+
+	submitted := make(chan struct{})
+	go func() {
+		_ = op.ProcessLine([]byte("3"))
+		close(submitted)
+	}()
+
+	select {
+	case <-submitted:
+		t.Fatal("submit should block while the pool is at capacity")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 2, pool.Stats().InFlight)
+
+	close(release)
+	<-submitted
+	require.NoError(t, op.Close())
+	assert.Equal(t, []string{"1", "2", "3"}, blocking.sent("/logs/a.log"))
+}
+
+func TestWorkerPool_RejectsLinesAfterShutdown(t *testing.T) {
+	pool := NewWorkerPool(t.Context(), 1, 1)
+	op := pool.NewOrderedProcessor(NewLogProcessor("app", "/logs/a.log", &testPipeline{}, newOrderBackend()), nil)
+	pool.Shutdown()
+
+	assert.ErrorIs(t, op.ProcessLine([]byte("late")), ErrWorkerPoolClosed)
+	assert.NoError(t, op.Close())
+}
+
+// blockingBackend blocks every send until release is closed.
+type blockingBackend struct {
+	*orderBackend
+	release chan struct{}
+}
+
+func (b *blockingBackend) Send(entry *models.LogEntry) error {
+	<-b.release
+	return b.orderBackend.Send(entry)
+}
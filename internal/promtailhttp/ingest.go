@@ -0,0 +1,268 @@
+package promtailhttp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/netinput"
+	"log-enricher/internal/processor"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	ingestFallbackAppName = "ingest"
+	ingestAppHeader       = "X-Log-App"
+	ingestSourceHeader    = "X-Log-Source"
+	ingestFormatRaw       = "raw"
+	ingestFormatNDJSON    = "ndjson"
+	// ingestIdleTimeout replaces the server's read and write timeouts for /ingest, so uploads of any size
+	// succeed as long as data keeps arriving.
+	ingestIdleTimeout = 15 * time.Second
+)
+
+var errLineTooLong = errors.New("line exceeds size limit")
+
+// lineProcessor is implemented by both LogProcessorImpl and OrderedProcessor.
+type lineProcessor interface {
+	ProcessLineWithFields(line []byte, ts time.Time, fields map[string]interface{}) error
+}
+
+// spooledLines is the lineProcessor of /ingest with a spool. It collects lines and appends them to the spool
+// in records of up to maxBytes line bytes, so uploads of any size are spooled like push requests.
+type spooledLines struct {
+	r        *Receiver
+	tenant   string
+	app      string
+	source   string
+	maxBytes int
+	entries  []normalizedEntry
+	size     int
+}
+
+// ProcessLineWithFields adds the line to the current record. A line without timestamp gets the time it was
+// received, since it is processed later.
+func (s *spooledLines) ProcessLineWithFields(line []byte, ts time.Time, fields map[string]interface{}) error {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	s.entries = append(s.entries, normalizedEntry{app: s.app, source: s.source, timestamp: ts, line: line, fields: fields})
+	s.size += len(line)
+	if s.size >= s.maxBytes {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush appends the collected lines to the spool. They are dropped from the upload if that fails.
+func (s *spooledLines) flush() error {
+	if len(s.entries) == 0 {
+		return nil
+	}
+	err := s.r.acceptEntries(s.tenant, s.entries)
+	s.entries = nil
+	s.size = 0
+	return err
+}
+
+// handleIngest accepts newline-delimited raw lines or NDJSON objects for a single app and source.
+// The body is processed line by line while it is read, so its total size is not limited; each line is
+// limited to the configured maximum body size. With a spool the lines are spooled instead.
+func (r *Receiver) handleIngest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scope, status, err := r.authenticate(req)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	format, err := ingestFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	release := r.admitRequest(w, req)
+	if release == nil {
+		return
+	}
+	defer release()
+
+	body := io.Reader(req.Body)
+	encoding := strings.TrimSpace(strings.ToLower(req.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		// no-op
+	case "gzip":
+		gzr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid gzip payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gzr.Close()
+		body = gzr
+	default:
+		http.Error(w, fmt.Sprintf("unsupported content encoding: %s", encoding), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	app := ingestParam(req, "app", ingestAppHeader)
+	if app == "" {
+		app = r.defaultApp
+	}
+	if app == "" {
+		app = ingestFallbackAppName
+	}
+	source := netinput.SanitizeSourcePath(scope.sourceRoot(r.sourceRoot), ingestParam(req, "source", ingestSourceHeader), "ingest/"+app+".log")
+
+	rc := http.NewResponseController(w)
+	var target lineProcessor
+	var spooled *spooledLines
+	var ordered *processor.OrderedProcessor
+	if r.spool != nil {
+		spooled = &spooledLines{r: r, tenant: scope.tenant, app: app, source: source, maxBytes: int(r.maxBodySize)}
+		target = spooled
+	} else {
+		lp := processor.NewTenantLogProcessor(scope.tenant, app, source, r.pm.GetTenantProcessPipeline(scope.tenant, source), r.backend)
+		target = lp
+		if r.workers != nil {
+			ordered = r.workers.NewOrderedProcessor(lp, nil)
+			target = ordered
+		}
+	}
+
+	wait := func(line []byte) error {
+		return r.waitRateLimits(req.Context(), scope.tenant, app, source, line)
+	}
+	lines, status, err := r.ingestLines(rc, bufio.NewReader(body), format, target, wait)
+	if ordered != nil {
+		if closeErr := ordered.Close(); closeErr != nil && err == nil {
+			status, err = http.StatusInternalServerError, closeErr
+		}
+	}
+	if spooled != nil {
+		if flushErr := spooled.flush(); flushErr != nil && err == nil {
+			status, err = http.StatusInternalServerError, flushErr
+		}
+	}
+	_ = rc.SetWriteDeadline(time.Now().Add(ingestIdleTimeout))
+	if errors.Is(err, errSpoolFull) {
+		writeRetryAfter(w, spoolFullRetryAfter)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			slog.Error("Failed to process ingest request", "app", app, "source", source, "lines", lines, "error", err)
+			http.Error(w, "failed to process logs", status)
+			return
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ingestLines processes the body line by line and returns the number of processed lines. Lines before
+// an invalid one have already been processed when an error is returned. wait is called before each line
+// is processed and blocks while the rate limits are exhausted.
+func (r *Receiver) ingestLines(rc *http.ResponseController, reader *bufio.Reader, format string, target lineProcessor, wait func([]byte) error) (int, int, error) {
+	// Long uploads must not run into the server-wide timeouts; only stalled clients are cut off.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	lines := 0
+	for lineNo := 1; ; lineNo++ {
+		_ = rc.SetReadDeadline(time.Now().Add(ingestIdleTimeout))
+		line, readErr := readLine(reader, int(r.maxBodySize))
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			if errors.Is(readErr, errLineTooLong) {
+				return lines, http.StatusRequestEntityTooLarge, fmt.Errorf("line %d: %w", lineNo, readErr)
+			}
+			return lines, http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", readErr)
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 || (format == ingestFormatRaw && readErr == nil) {
+			var fields map[string]interface{}
+			if format == ingestFormatNDJSON {
+				if err := json.Unmarshal(line, &fields); err != nil || fields == nil {
+					return lines, http.StatusBadRequest, fmt.Errorf("line %d is not a JSON object", lineNo)
+				}
+			}
+			if err := wait(line); err != nil {
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return lines, http.StatusServiceUnavailable, fmt.Errorf("request canceled")
+				}
+				return lines, http.StatusRequestEntityTooLarge, fmt.Errorf("line %d exceeds the ingestion burst: %w", lineNo, err)
+			}
+			if err := target.ProcessLineWithFields(line, time.Time{}, fields); err != nil {
+				return lines, http.StatusInternalServerError, err
+			}
+			lines++
+		}
+
+		if readErr != nil {
+			return lines, http.StatusOK, nil
+		}
+	}
+}
+
+// readLine returns the next line without its line ending as a newly allocated slice. The last line is
+// returned together with io.EOF; a line longer than maxSize returns errLineTooLong.
+func readLine(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(bytes.TrimSuffix(line, []byte("\n"))) > maxSize {
+			return nil, errLineTooLong
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		return line, err
+	}
+}
+
+// ingestFormat returns the format from the "format" query parameter, or derives it from the content type:
+// NDJSON media types select ndjson and everything else, including curl's default form type, selects raw.
+func ingestFormat(req *http.Request) (string, error) {
+	if format := strings.ToLower(strings.TrimSpace(req.URL.Query().Get("format"))); format != "" {
+		if format != ingestFormatRaw && format != ingestFormatNDJSON {
+			return "", fmt.Errorf("unsupported format: %s", format)
+		}
+		return format, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return ingestFormatNDJSON, nil
+	default:
+		return ingestFormatRaw, nil
+	}
+}
+
+// ingestParam returns the query parameter name, falling back to header.
+func ingestParam(req *http.Request, name, header string) string {
+	if v := strings.TrimSpace(req.URL.Query().Get(name)); v != "" {
+		return v
+	}
+	return strings.TrimSpace(req.Header.Get(header))
+}
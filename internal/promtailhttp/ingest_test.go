@@ -0,0 +1,155 @@
+package promtailhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+	"log-enricher/internal/processor"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiver_IngestRawLinesStreamed(t *testing.T) {
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 64,
+		PromtailHTTPSourceRoot:   sourceRoot,
+	}
+	backend := &captureBackend{}
+	_, srv := newTestReceiver(t, cfg, backend)
+
+	body, writer := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/ingest?app=backup&source=../../cron/backup.log", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := srv.Client().Do(req)
+		assert.NoError(t, err)
+		respCh <- resp
+	}()
+
+	_, err = writer.Write([]byte("first line\r\n\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(backend.snapshot()) == 2
+	}, 5*time.Second, 10*time.Millisecond, "lines are processed before the body is complete")
+
+	// The body as a whole may exceed the maximum body size; only single lines are limited.
+	_, err = writer.Write([]byte(strings.Repeat("x", 60) + "\nlast line"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	resp := <-respCh
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 4)
+	assert.Equal(t, "first line", string(entries[0].LogLine))
+	assert.Equal(t, "", string(entries[1].LogLine), "empty raw lines are kept")
+	assert.Equal(t, "last line", string(entries[3].LogLine))
+	for _, entry := range entries {
+		assert.Equal(t, "backup", entry.App)
+		assert.Equal(t, filepath.Join(sourceRoot, "cron", "backup.log"), entry.SourcePath)
+		assert.Empty(t, entry.Fields)
+	}
+}
+
+func TestReceiver_IngestNDJSONFromHeadersOnPool(t *testing.T) {
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 1024,
+		PromtailHTTPSourceRoot:   sourceRoot,
+	}
+	backend := &captureBackend{}
+	r, srv := newTestReceiver(t, cfg, backend)
+	pool := processor.NewWorkerPool(t.Context(), 4, 16)
+	defer pool.Shutdown()
+	r.SetWorkerPool(pool)
+
+	var body bytes.Buffer
+	for i := 0; i < 20; i++ {
+		body.WriteString(`{"msg":"job step","step":` + strconvFormatInt(int64(i)) + "}\n")
+	}
+	body.WriteString("\n")
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/ingest", bytes.NewReader(gzipBytes(t, body.Bytes())))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Log-App", "nightly")
+
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 20, "blank NDJSON lines are skipped")
+	for i, entry := range entries {
+		assert.Equal(t, "nightly", entry.App)
+		assert.Equal(t, filepath.Join(sourceRoot, "ingest", "nightly.log"), entry.SourcePath)
+		assert.Equal(t, float64(i), entry.Fields["step"], "order is kept")
+		assert.Equal(t, "job step", entry.Fields["msg"])
+	}
+}
+
+func TestReceiver_IngestErrors(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 32,
+		PromtailHTTPBearerToken:  "secret",
+		PromtailHTTPSourceRoot:   t.TempDir(),
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		body      string
+		encoding  string
+		token     string
+		want      int
+		processed int
+	}{
+		{name: "unauthorized", body: "line", want: http.StatusUnauthorized},
+		{name: "unknown format", query: "?format=csv", body: "line", token: "secret", want: http.StatusUnsupportedMediaType},
+		{name: "unsupported encoding", body: "line", encoding: "br", token: "secret", want: http.StatusUnsupportedMediaType},
+		{name: "invalid gzip", body: "line", encoding: "gzip", token: "secret", want: http.StatusBadRequest},
+		{name: "invalid NDJSON line", query: "?format=ndjson", body: "{\"a\":1}\n[1,2]\n{\"b\":2}", token: "secret", want: http.StatusBadRequest, processed: 1},
+		{name: "line too long", body: "ok\n" + strings.Repeat("x", 33), token: "secret", want: http.StatusRequestEntityTooLarge, processed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &captureBackend{}
+			_, srv := newTestReceiver(t, cfg, backend)
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/ingest"+tt.query, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+			assert.Len(t, backend.snapshot(), tt.processed, "lines before the failing one are processed")
+		})
+	}
+}
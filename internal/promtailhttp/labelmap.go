@@ -0,0 +1,55 @@
+package promtailhttp
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+)
+
+// labelMapping derives a value, e.g. the app name, from stream labels. The template is tried first; if it is
+// unset, references a label the stream does not have or renders empty, the first non-empty label wins.
+type labelMapping struct {
+	tmpl   *template.Template
+	labels []string
+}
+
+func newLabelMapping(name, tmpl string, labels, defaults []string) (labelMapping, error) {
+	var m labelMapping
+	if strings.TrimSpace(tmpl) != "" {
+		parsed, err := template.New(name).Option("missingkey=error").Parse(tmpl)
+		if err != nil {
+			return m, fmt.Errorf("invalid %s template: %w", name, err)
+		}
+		m.tmpl = parsed
+	}
+
+	for _, label := range labels {
+		if label = strings.TrimSpace(label); label != "" {
+			m.labels = append(m.labels, label)
+		}
+	}
+	if len(m.labels) == 0 {
+		m.labels = defaults
+	}
+	return m, nil
+}
+
+// resolve returns the mapped value, or "" if neither the template nor a label produced one.
+func (m labelMapping) resolve(labels map[string]string) string {
+	if m.tmpl != nil {
+		var b strings.Builder
+		if err := m.tmpl.Execute(&b, labels); err != nil {
+			slog.Debug("Label template did not apply", "template", m.tmpl.Name(), "error", err)
+		} else if v := strings.TrimSpace(b.String()); v != "" {
+			return v
+		}
+	}
+
+	for _, key := range m.labels {
+		if v := strings.TrimSpace(labels[key]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,321 @@
+package promtailhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// queueFullRetryAfter is the Retry-After sent when the ingestion queue is full.
+	queueFullRetryAfter = time.Second
+	// idleLimiterTTL is how long the buckets of a tenant or stream are kept after its last request.
+	idleLimiterTTL = 10 * time.Minute
+)
+
+// errQueueFull is returned by admit when no request slot is free and the queue is full.
+var errQueueFull = errors.New("ingestion queue is full")
+
+// admission bounds the number of requests the receiver works on. At most cap(slots) requests are processed at
+// once and at most cap(queue) requests are admitted in total; further requests are rejected right away.
+type admission struct {
+	queue chan struct{}
+	slots chan struct{}
+}
+
+func newAdmission(maxConcurrent, queueSize int) *admission {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &admission{
+		queue: make(chan struct{}, maxConcurrent+max(queueSize, 0)),
+		slots: make(chan struct{}, maxConcurrent),
+	}
+}
+
+// admit waits for a processing slot and returns a function that releases it. It fails with errQueueFull if
+// the queue is full, or with the context's error if the client went away while waiting.
+func (a *admission) admit(ctx context.Context) (func(), error) {
+	if a == nil {
+		return func() {}, nil
+	}
+	select {
+	case a.queue <- struct{}{}:
+	default:
+		return nil, errQueueFull
+	}
+	select {
+	case a.slots <- struct{}{}:
+		return func() {
+			<-a.slots
+			<-a.queue
+		}, nil
+	case <-ctx.Done():
+		<-a.queue
+		return nil, ctx.Err()
+	}
+}
+
+// rateLimit configures a byte and a line token bucket. A zero rate disables that bucket; a zero burst
+// defaults to one second at the rate.
+type rateLimit struct {
+	BytesPerSecond int `json:"ingestion_rate_bytes"`
+	BurstBytes     int `json:"ingestion_burst_bytes"`
+	LinesPerSecond int `json:"ingestion_rate_lines"`
+	BurstLines     int `json:"ingestion_burst_lines"`
+}
+
+func (l rateLimit) enabled() bool {
+	return l.BytesPerSecond > 0 || l.LinesPerSecond > 0
+}
+
+// overriddenBy returns l with the non-zero values of override.
+func (l rateLimit) overriddenBy(override rateLimit) rateLimit {
+	if override.BytesPerSecond != 0 {
+		l.BytesPerSecond, l.BurstBytes = override.BytesPerSecond, override.BurstBytes
+	}
+	if override.LinesPerSecond != 0 {
+		l.LinesPerSecond, l.BurstLines = override.LinesPerSecond, override.BurstLines
+	}
+	return l
+}
+
+// tokenBucket refills at rate tokens per second up to burst tokens.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait returns how long it takes until n tokens are available, or false if n exceeds the burst.
+func (b *tokenBucket) wait(n int) (time.Duration, bool) {
+	if b == nil || float64(n) <= b.tokens {
+		return 0, true
+	}
+	if float64(n) > b.burst {
+		return 0, false
+	}
+	return time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second)), true
+}
+
+func (b *tokenBucket) take(n int) {
+	if b != nil {
+		b.tokens -= float64(n)
+	}
+}
+
+type limiterEntry struct {
+	bytes    *tokenBucket
+	lines    *tokenBucket
+	lastUsed time.Time
+}
+
+// usage is the number of bytes and lines a request takes from one tenant's or stream's buckets.
+type usage struct {
+	key   string
+	limit rateLimit
+	bytes int
+	lines int
+}
+
+// rateLimiter holds the token buckets of all tenants and streams. One mutex guards every bucket, so a request
+// takes from all of its buckets or none of them.
+type rateLimiter struct {
+	mu        sync.Mutex
+	entries   map[string]*limiterEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{entries: make(map[string]*limiterEntry), now: time.Now}
+}
+
+// reserve takes every usage from its buckets if all of them have enough tokens. Otherwise nothing is taken
+// and it returns how long the client should wait, or an error if a usage can never fit into its burst.
+func (l *rateLimiter) reserve(usages []usage) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	entries := make([]*limiterEntry, len(usages))
+	var retryAfter time.Duration
+	for i, u := range usages {
+		entry := l.entries[u.key]
+		if entry == nil {
+			entry = &limiterEntry{
+				bytes: newTokenBucket(u.limit.BytesPerSecond, u.limit.BurstBytes, now),
+				lines: newTokenBucket(u.limit.LinesPerSecond, u.limit.BurstLines, now),
+			}
+			l.entries[u.key] = entry
+		}
+		entry.lastUsed = now
+		entry.bytes.refill(now)
+		entry.lines.refill(now)
+		entries[i] = entry
+
+		bytesWait, ok := entry.bytes.wait(u.bytes)
+		if !ok {
+			return 0, fmt.Errorf("%d bytes exceed the burst of %.0f bytes", u.bytes, entry.bytes.burst)
+		}
+		linesWait, ok := entry.lines.wait(u.lines)
+		if !ok {
+			return 0, fmt.Errorf("%d lines exceed the burst of %.0f lines", u.lines, entry.lines.burst)
+		}
+		retryAfter = max(retryAfter, bytesWait, linesWait)
+	}
+	if retryAfter > 0 {
+		return retryAfter, nil
+	}
+
+	for i, u := range usages {
+		entries[i].bytes.take(u.bytes)
+		entries[i].lines.take(u.lines)
+	}
+	return 0, nil
+}
+
+// sweep drops the buckets of tenants and streams that were idle for idleLimiterTTL; they start full again.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastUsed) > idleLimiterTTL {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// usages returns what the entries of one request take from the tenant's and each stream's buckets.
+// Streams are identified by app and source path.
+func (r *Receiver) usages(tenant string, entries []normalizedEntry) []usage {
+	var usages []usage
+	tenantLimit := r.tenantLimit(tenant)
+	if tenantLimit.enabled() {
+		total := usage{key: "tenant\x00" + tenant, limit: tenantLimit}
+		for _, entry := range entries {
+			total.bytes += len(entry.line)
+			total.lines++
+		}
+		usages = append(usages, total)
+	}
+
+	if r.streamLimit.enabled() {
+		index := make(map[string]int)
+		for _, entry := range entries {
+			key := "stream\x00" + tenant + "\x00" + entry.app + "\x00" + entry.source
+			i, ok := index[key]
+			if !ok {
+				i = len(usages)
+				index[key] = i
+				usages = append(usages, usage{key: key, limit: r.streamLimit})
+			}
+			usages[i].bytes += len(entry.line)
+			usages[i].lines++
+		}
+	}
+	return usages
+}
+
+// tenantLimit returns the rate limit of tenant: the default overridden by the tenants file.
+func (r *Receiver) tenantLimit(tenant string) rateLimit {
+	if r.tenants != nil {
+		for _, t := range r.tenants.Tenants {
+			if t.ID == tenant {
+				return r.defaultTenantLimit.overriddenBy(t.Limits)
+			}
+		}
+	}
+	return r.defaultTenantLimit
+}
+
+// checkRateLimits takes the request's entries from the rate limits. It writes a 429 response with Retry-After
+// and returns false if the request exceeds a limit, or a 413 response if it is larger than a burst.
+func (r *Receiver) checkRateLimits(w http.ResponseWriter, tenant string, entries []normalizedEntry) bool {
+	usages := r.usages(tenant, entries)
+	if len(usages) == 0 {
+		return true
+	}
+	retryAfter, err := r.limiter.reserve(usages)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("request exceeds the ingestion burst: %v", err), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if retryAfter > 0 {
+		writeRetryAfter(w, retryAfter)
+		http.Error(w, "ingestion rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// waitRateLimits is checkRateLimits for streamed uploads: instead of rejecting, it waits until the line fits,
+// which slows the client down through TCP backpressure.
+func (r *Receiver) waitRateLimits(ctx context.Context, tenant, app, source string, line []byte) error {
+	usages := r.usages(tenant, []normalizedEntry{{app: app, source: source, line: line}})
+	if len(usages) == 0 {
+		return nil
+	}
+	for {
+		retryAfter, err := r.limiter.reserve(usages)
+		if err != nil || retryAfter == 0 {
+			return err
+		}
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// writeRetryAfter sets the Retry-After header to d, rounded up to whole seconds.
+func writeRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(d.Seconds())))))
+}
+
+// admitRequest waits for an ingestion slot. If the queue is full it writes a 503 response with Retry-After and
+// returns nil.
+func (r *Receiver) admitRequest(w http.ResponseWriter, req *http.Request) func() {
+	release, err := r.admission.admit(req.Context())
+	if err == nil {
+		return release
+	}
+	if errors.Is(err, errQueueFull) {
+		writeRetryAfter(w, queueFullRetryAfter)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	} else {
+		http.Error(w, "request canceled", http.StatusServiceUnavailable)
+	}
+	return nil
+}
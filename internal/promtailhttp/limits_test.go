@@ -0,0 +1,203 @@
+package promtailhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock drives a rateLimiter's token buckets in tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func pushJSON(t *testing.T, srv *httptest.Server, auth func(*http.Request), body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/loki/api/v1/push", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", jsonContentType)
+	if auth != nil {
+		auth(req)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestReceiver_QueueFullReturns503WithRetryAfter(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:                  "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:          1024 * 1024,
+		PromtailHTTPSourceRoot:            t.TempDir(),
+		PromtailHTTPMaxConcurrentRequests: 1,
+		PromtailHTTPQueueSize:             1,
+	}
+	backend := &captureBackend{}
+	r, srv := newTestReceiver(t, cfg, backend)
+	body := `{"streams":[{"stream":{"app":"web"},"values":[["1","line"]]}]}`
+
+	// Hold the only processing slot, so the next request waits in the queue.
+	release, err := r.admission.admit(context.Background())
+	require.NoError(t, err)
+	queued := make(chan int, 1)
+	go func() {
+		queued <- pushJSON(t, srv, nil, body).StatusCode
+	}()
+	require.Eventually(t, func() bool { return len(r.admission.queue) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	resp := pushJSON(t, srv, nil, body)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	assert.Empty(t, backend.snapshot())
+
+	release()
+	assert.Equal(t, http.StatusNoContent, <-queued)
+	assert.Len(t, backend.snapshot(), 1)
+	assert.Empty(t, r.admission.queue)
+}
+
+func TestReceiver_RateLimitsReturn429WithRetryAfter(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:            "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:    1024 * 1024,
+		PromtailHTTPSourceRoot:      t.TempDir(),
+		PromtailHTTPTenantRateLines: 3,
+		PromtailHTTPStreamRateLines: 2,
+		PromtailHTTPStreamRateBytes: 1000,
+	}
+	backend := &captureBackend{}
+	r, srv := newTestReceiver(t, cfg, backend)
+	clock := &fakeClock{now: time.Now()}
+	r.limiter.now = clock.Now
+
+	stream := func(app string, lines int) string {
+		values := strings.Repeat(`["1","line"],`, lines)
+		return `{"streams":[{"stream":{"app":"` + app + `"},"values":[` + strings.TrimSuffix(values, ",") + `]}]}`
+	}
+
+	require.Equal(t, http.StatusNoContent, pushJSON(t, srv, nil, stream("web", 2)).StatusCode)
+
+	resp := pushJSON(t, srv, nil, stream("web", 1))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "the stream's line bucket is empty")
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	require.Equal(t, http.StatusNoContent, pushJSON(t, srv, nil, stream("api", 1)).StatusCode, "other streams have their own bucket")
+	resp = pushJSON(t, srv, nil, stream("db", 1))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "the tenant's line bucket is empty")
+	assert.Len(t, backend.snapshot(), 3, "rejected requests are not processed")
+
+	resp = pushJSON(t, srv, nil, stream("web", 3))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, "requests larger than the burst never fit")
+	assert.Empty(t, resp.Header.Get("Retry-After"))
+
+	clock.Advance(time.Second)
+	assert.Equal(t, http.StatusNoContent, pushJSON(t, srv, nil, stream("web", 2)).StatusCode)
+	assert.Len(t, backend.snapshot(), 5)
+}
+
+func TestReceiver_TenantRateLimitOverrides(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:            "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:    1024 * 1024,
+		PromtailHTTPSourceRoot:      t.TempDir(),
+		PromtailHTTPTenantRateBytes: 1000,
+		PromtailHTTPTenantsFile: writeTenantsFile(t, `{"tenants": [
+			{"id": "team-a", "tokens": ["token-a"], "limits": {"ingestion_rate_bytes": 5, "ingestion_burst_bytes": 8}},
+			{"id": "team-b", "tokens": ["token-b"]}
+		]}`),
+	}
+	backend := &captureBackend{}
+	r, err := NewReceiver(cfg, &tenantPipelineManager{}, backend)
+	require.NoError(t, err)
+	r.limiter.now = (&fakeClock{now: time.Now()}).Now
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	body := `{"streams":[{"stream":{"app":"web"},"values":[["1","12345"]]}]}`
+	assert.Equal(t, http.StatusNoContent, pushJSON(t, srv, bearer("token-a"), body).StatusCode)
+	resp := pushJSON(t, srv, bearer("token-a"), body)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusNoContent, pushJSON(t, srv, bearer("token-b"), body).StatusCode, "team-b uses the default limit")
+	}
+
+	_, err = NewReceiver(&config.Config{
+		PromtailHTTPAddr:        "127.0.0.1:0",
+		PromtailHTTPTenantsFile: writeTenantsFile(t, `{"tenants": [{"id": "team-a", "tokens": ["a"], "limits": {"ingestion_rate_lines": -1}}]}`),
+	}, &tenantPipelineManager{}, backend)
+	assert.Error(t, err, "negative limits are rejected")
+}
+
+func TestReceiver_IngestLineLargerThanBurst(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:            "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:    1024 * 1024,
+		PromtailHTTPSourceRoot:      t.TempDir(),
+		PromtailHTTPStreamRateBytes: 10,
+	}
+	backend := &captureBackend{}
+	_, srv := newTestReceiver(t, cfg, backend)
+
+	resp, err := http.Post(srv.URL+"/ingest?app=web", "text/plain", strings.NewReader("short\nthis line is too long\n"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Retry-After"))
+	require.Len(t, backend.snapshot(), 1, "lines before the rejected one are processed")
+}
+
+func TestRateLimiter_ReserveIsAllOrNothing(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	l := newRateLimiter()
+	l.now = clock.Now
+
+	limit := rateLimit{BytesPerSecond: 10, BurstBytes: 20}
+	wait, err := l.reserve([]usage{{key: "a", limit: limit, bytes: 15}})
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+	// b has room but a does not, so neither bucket is charged.
+	wait, err = l.reserve([]usage{{key: "b", limit: limit, bytes: 20}, {key: "a", limit: limit, bytes: 10}})
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	wait, err = l.reserve([]usage{{key: "b", limit: limit, bytes: 20}})
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	clock.Advance(500 * time.Millisecond)
+	wait, err = l.reserve([]usage{{key: "a", limit: limit, bytes: 10}})
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	_, err = l.reserve([]usage{{key: "a", limit: limit, bytes: 21}})
+	assert.Error(t, err)
+
+	clock.Advance(idleLimiterTTL + time.Minute)
+	l.reserve(nil)
+	assert.Empty(t, l.entries, "idle buckets are dropped")
+}
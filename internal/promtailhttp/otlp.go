@@ -0,0 +1,462 @@
+package promtailhttp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log-enricher/internal/netinput"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	otlpFallbackAppName = "otlp"
+	otlpFallbackFile    = "otlp.log"
+	otlpServiceNameAttr = "service.name"
+	otlpFilePathAttr    = "log.file.path"
+)
+
+// OTLP/JSON mirrors the protobuf messages, except that 64-bit integers may be strings and
+// trace and span IDs are hex-encoded. See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type otlpJSONRequest struct {
+	ResourceLogs []otlpJSONResourceLogs `json:"resourceLogs"`
+}
+
+type otlpJSONResourceLogs struct {
+	Resource  otlpJSONResource    `json:"resource"`
+	ScopeLogs []otlpJSONScopeLogs `json:"scopeLogs"`
+}
+
+type otlpJSONResource struct {
+	Attributes []otlpJSONKeyValue `json:"attributes"`
+}
+
+type otlpJSONScopeLogs struct {
+	LogRecords []otlpJSONLogRecord `json:"logRecords"`
+}
+
+type otlpJSONLogRecord struct {
+	TimeUnixNano         json.RawMessage    `json:"timeUnixNano"`
+	ObservedTimeUnixNano json.RawMessage    `json:"observedTimeUnixNano"`
+	SeverityNumber       int32              `json:"severityNumber"`
+	SeverityText         string             `json:"severityText"`
+	Body                 *otlpJSONAnyValue  `json:"body"`
+	Attributes           []otlpJSONKeyValue `json:"attributes"`
+	TraceID              string             `json:"traceId"`
+	SpanID               string             `json:"spanId"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJSONAnyValue `json:"value"`
+}
+
+type otlpJSONAnyValue struct {
+	StringValue *string          `json:"stringValue"`
+	BoolValue   *bool            `json:"boolValue"`
+	IntValue    json.RawMessage  `json:"intValue"`
+	DoubleValue *float64         `json:"doubleValue"`
+	BytesValue  *string          `json:"bytesValue"`
+	ArrayValue  *otlpJSONArray   `json:"arrayValue"`
+	KvlistValue *otlpJSONKeyList `json:"kvlistValue"`
+}
+
+type otlpJSONArray struct {
+	Values []otlpJSONAnyValue `json:"values"`
+}
+
+type otlpJSONKeyList struct {
+	Values []otlpJSONKeyValue `json:"values"`
+}
+
+// handleOTLP accepts OTLP/HTTP log exports. Authentication and body limits are the same as for the push routes.
+func (r *Receiver) handleOTLP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scope, status, err := r.authenticate(req)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	release := r.admitRequest(w, req)
+	if release == nil {
+		return
+	}
+	defer release()
+
+	mediaType, entries, status, err := r.parseOTLPRequest(w, req, scope)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if !r.checkRateLimits(w, scope.tenant, entries) {
+		return
+	}
+
+	if err := r.acceptEntries(scope.tenant, entries); err != nil {
+		if errors.Is(err, errSpoolFull) {
+			writeRetryAfter(w, spoolFullRetryAfter)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		slog.Error("Failed to process OTLP request", "error", err)
+		http.Error(w, "failed to process logs", http.StatusInternalServerError)
+		return
+	}
+
+	// A successful export is answered with an empty ExportLogsServiceResponse in the request's encoding.
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	if mediaType == jsonContentType {
+		_, _ = w.Write([]byte("{}"))
+	}
+}
+
+func (r *Receiver) parseOTLPRequest(w http.ResponseWriter, req *http.Request, scope requestScope) (string, []normalizedEntry, int, error) {
+	contentType := req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != protobufContentType && mediaType != jsonContentType) {
+		return "", nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type: %s", contentType)
+	}
+
+	// readBody accepts snappy for Loki pushes only; OTLP exporters never use it.
+	if encoding := strings.TrimSpace(strings.ToLower(req.Header.Get("Content-Encoding"))); encoding == "snappy" {
+		return "", nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+
+	body, status, err := r.readBody(w, req, mediaType)
+	if err != nil {
+		return "", nil, status, err
+	}
+
+	var export collogspb.ExportLogsServiceRequest
+	if mediaType == protobufContentType {
+		if err := proto.Unmarshal(body, &export); err != nil {
+			return "", nil, http.StatusBadRequest, fmt.Errorf("failed to unmarshal OTLP protobuf payload: %w", err)
+		}
+	} else {
+		var jsonReq otlpJSONRequest
+		if err := json.Unmarshal(body, &jsonReq); err != nil {
+			return "", nil, http.StatusBadRequest, fmt.Errorf("failed to unmarshal OTLP JSON payload: %w", err)
+		}
+		resourceLogs, err := jsonReq.toProto()
+		if err != nil {
+			return "", nil, http.StatusBadRequest, err
+		}
+		export.ResourceLogs = resourceLogs
+	}
+
+	return mediaType, r.normalizeOTLPEntries(export.ResourceLogs, scope), http.StatusOK, nil
+}
+
+// normalizeOTLPEntries turns every LogRecord into an entry. Resource attributes, record attributes and the
+// fields of a map body are merged into the entry fields, in that order of precedence.
+func (r *Receiver) normalizeOTLPEntries(resourceLogs []*logspb.ResourceLogs, scope requestScope) []normalizedEntry {
+	entries := make([]normalizedEntry, 0, len(resourceLogs))
+
+	for _, rl := range resourceLogs {
+		resourceAttrs := rl.GetResource().GetAttributes()
+		for _, sl := range rl.GetScopeLogs() {
+			for _, record := range sl.GetLogRecords() {
+				fields := make(map[string]interface{}, len(resourceAttrs)+len(record.GetAttributes())+4)
+				for _, kv := range resourceAttrs {
+					fields[kv.GetKey()] = otlpValue(kv.GetValue())
+				}
+				for _, kv := range record.GetAttributes() {
+					fields[kv.GetKey()] = otlpValue(kv.GetValue())
+				}
+
+				if severity := otlpSeverity(record); severity != "" {
+					fields["severity"] = severity
+				}
+				if traceID := record.GetTraceId(); len(traceID) > 0 {
+					fields["trace_id"] = hex.EncodeToString(traceID)
+				}
+				if spanID := record.GetSpanId(); len(spanID) > 0 {
+					fields["span_id"] = hex.EncodeToString(spanID)
+				}
+
+				line := otlpBodyLine(record.GetBody(), fields)
+				app := r.deriveOTLPAppName(fields)
+				source := netinput.SanitizeSourcePath(scope.sourceRoot(r.sourceRoot), deriveOTLPSourcePath(fields, app), otlpFallbackFile)
+
+				entries = append(entries, normalizedEntry{
+					app:       app,
+					source:    source,
+					timestamp: otlpTimestamp(record),
+					line:      line,
+					fields:    fields,
+				})
+			}
+		}
+	}
+
+	return entries
+}
+
+// otlpBodyLine returns the log line for body. A string body is also kept as the "message" field, so it is not
+// lost when the entry is written as JSON; the keys of a map body are merged into fields instead.
+func otlpBodyLine(body *commonpb.AnyValue, fields map[string]interface{}) []byte {
+	if body == nil || body.GetValue() == nil {
+		return []byte{}
+	}
+
+	switch value := otlpValue(body).(type) {
+	case string:
+		fields["message"] = value
+		return []byte(value)
+	case map[string]interface{}:
+		for k, v := range value {
+			fields[k] = v
+		}
+		line, err := json.Marshal(value)
+		if err != nil {
+			return []byte{}
+		}
+		return line
+	default:
+		line, err := json.Marshal(value)
+		if err != nil {
+			return []byte{}
+		}
+		fields["message"] = string(line)
+		return line
+	}
+}
+
+func otlpValue(value *commonpb.AnyValue) interface{} {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return v.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, 0, len(v.ArrayValue.GetValues()))
+		for _, item := range v.ArrayValue.GetValues() {
+			values = append(values, otlpValue(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := make(map[string]interface{}, len(v.KvlistValue.GetValues()))
+		for _, kv := range v.KvlistValue.GetValues() {
+			values[kv.GetKey()] = otlpValue(kv.GetValue())
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func otlpSeverity(record *logspb.LogRecord) string {
+	if text := strings.TrimSpace(record.GetSeverityText()); text != "" {
+		return text
+	}
+	if number := record.GetSeverityNumber(); number != logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+		return strings.ToLower(strings.TrimPrefix(number.String(), "SEVERITY_NUMBER_"))
+	}
+	return ""
+}
+
+// otlpTimestamp prefers the event time over the observed time. A zero result makes the processor use the current time.
+func otlpTimestamp(record *logspb.LogRecord) time.Time {
+	for _, ns := range []uint64{record.GetTimeUnixNano(), record.GetObservedTimeUnixNano()} {
+		if ns != 0 {
+			return time.Unix(0, int64(ns)).UTC()
+		}
+	}
+	return time.Time{}
+}
+
+func (r *Receiver) deriveOTLPAppName(fields map[string]interface{}) string {
+	if name, ok := fields[otlpServiceNameAttr].(string); ok && strings.TrimSpace(name) != "" {
+		return strings.TrimSpace(name)
+	}
+	if r.defaultApp != "" {
+		return r.defaultApp
+	}
+	return otlpFallbackAppName
+}
+
+func deriveOTLPSourcePath(fields map[string]interface{}, app string) string {
+	if path, ok := fields[otlpFilePathAttr].(string); ok && strings.TrimSpace(path) != "" {
+		return path
+	}
+	return filepath.Join("otlp", app+".log")
+}
+
+func (req otlpJSONRequest) toProto() ([]*logspb.ResourceLogs, error) {
+	resourceLogs := make([]*logspb.ResourceLogs, 0, len(req.ResourceLogs))
+	for rlIdx, rl := range req.ResourceLogs {
+		resourceAttrs, err := otlpJSONAttributes(rl.Resource.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource attributes at resourceLogs[%d]: %w", rlIdx, err)
+		}
+
+		scopeLogs := make([]*logspb.ScopeLogs, 0, len(rl.ScopeLogs))
+		for slIdx, sl := range rl.ScopeLogs {
+			records := make([]*logspb.LogRecord, 0, len(sl.LogRecords))
+			for recIdx, rec := range sl.LogRecords {
+				record, err := rec.toProto()
+				if err != nil {
+					return nil, fmt.Errorf("invalid log record at resourceLogs[%d].scopeLogs[%d].logRecords[%d]: %w", rlIdx, slIdx, recIdx, err)
+				}
+				records = append(records, record)
+			}
+			scopeLogs = append(scopeLogs, &logspb.ScopeLogs{LogRecords: records})
+		}
+
+		resourceLogs = append(resourceLogs, &logspb.ResourceLogs{
+			Resource:  &resourcepb.Resource{Attributes: resourceAttrs},
+			ScopeLogs: scopeLogs,
+		})
+	}
+	return resourceLogs, nil
+}
+
+func (rec otlpJSONLogRecord) toProto() (*logspb.LogRecord, error) {
+	timeUnixNano, err := parseOTLPUint64(rec.TimeUnixNano)
+	if err != nil {
+		return nil, fmt.Errorf("timeUnixNano: %w", err)
+	}
+	observedTimeUnixNano, err := parseOTLPUint64(rec.ObservedTimeUnixNano)
+	if err != nil {
+		return nil, fmt.Errorf("observedTimeUnixNano: %w", err)
+	}
+	attributes, err := otlpJSONAttributes(rec.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("attributes: %w", err)
+	}
+	traceID, err := hex.DecodeString(rec.TraceID)
+	if err != nil {
+		return nil, fmt.Errorf("traceId must be hex-encoded")
+	}
+	spanID, err := hex.DecodeString(rec.SpanID)
+	if err != nil {
+		return nil, fmt.Errorf("spanId must be hex-encoded")
+	}
+
+	record := &logspb.LogRecord{
+		TimeUnixNano:         timeUnixNano,
+		ObservedTimeUnixNano: observedTimeUnixNano,
+		SeverityNumber:       logspb.SeverityNumber(rec.SeverityNumber),
+		SeverityText:         rec.SeverityText,
+		Attributes:           attributes,
+		TraceId:              traceID,
+		SpanId:               spanID,
+	}
+	if rec.Body != nil {
+		body, err := rec.Body.toProto()
+		if err != nil {
+			return nil, fmt.Errorf("body: %w", err)
+		}
+		record.Body = body
+	}
+	return record, nil
+}
+
+func otlpJSONAttributes(attributes []otlpJSONKeyValue) ([]*commonpb.KeyValue, error) {
+	result := make([]*commonpb.KeyValue, 0, len(attributes))
+	for _, kv := range attributes {
+		value, err := kv.Value.toProto()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kv.Key, err)
+		}
+		result = append(result, &commonpb.KeyValue{Key: kv.Key, Value: value})
+	}
+	return result, nil
+}
+
+func (v otlpJSONAnyValue) toProto() (*commonpb.AnyValue, error) {
+	switch {
+	case v.StringValue != nil:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: *v.StringValue}}, nil
+	case v.BoolValue != nil:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: *v.BoolValue}}, nil
+	case len(v.IntValue) > 0:
+		n, err := parseOTLPInt64(v.IntValue)
+		if err != nil {
+			return nil, fmt.Errorf("intValue: %w", err)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: n}}, nil
+	case v.DoubleValue != nil:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: *v.DoubleValue}}, nil
+	case v.BytesValue != nil:
+		decoded, err := base64.StdEncoding.DecodeString(*v.BytesValue)
+		if err != nil {
+			return nil, fmt.Errorf("bytesValue must be base64-encoded")
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: decoded}}, nil
+	case v.ArrayValue != nil:
+		values := make([]*commonpb.AnyValue, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			value, err := item.toProto()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}, nil
+	case v.KvlistValue != nil:
+		values, err := otlpJSONAttributes(v.KvlistValue.Values)
+		if err != nil {
+			return nil, err
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: values}}}, nil
+	default:
+		// An empty AnyValue is valid and represents an absent value.
+		return &commonpb.AnyValue{}, nil
+	}
+}
+
+// parseOTLPUint64 accepts the JSON number or decimal string encodings of a 64-bit integer. Absent values are 0.
+func parseOTLPUint64(raw json.RawMessage) (uint64, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strconv.ParseUint(s, 10, 64)
+	}
+	var n uint64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0, fmt.Errorf("must be an unsigned integer or a decimal string")
+	}
+	return n, nil
+}
+
+func parseOTLPInt64(raw json.RawMessage) (int64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0, fmt.Errorf("must be an integer or a decimal string")
+	}
+	return n, nil
+}
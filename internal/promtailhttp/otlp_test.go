@@ -0,0 +1,200 @@
+package promtailhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func TestReceiver_OTLPProtobufGzip(t *testing.T) {
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 1024 * 1024,
+		PromtailHTTPSourceRoot:   sourceRoot,
+	}
+	backend := &captureBackend{}
+	_, srv := newTestReceiver(t, cfg, backend)
+
+	ts := time.Date(2026, time.May, 6, 7, 8, 9, 10, time.UTC)
+	raw, err := proto.Marshal(&collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				stringAttr("service.name", "checkout"),
+				stringAttr("deployment.environment", "prod"),
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				LogRecords: []*logspb.LogRecord{
+					{
+						TimeUnixNano:   uint64(ts.UnixNano()),
+						SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+						Body:           &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "cart abandoned"}},
+						Attributes: []*commonpb.KeyValue{
+							stringAttr("deployment.environment", "canary"),
+							{Key: "items", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 3}}},
+						},
+						TraceId: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+					},
+					{
+						ObservedTimeUnixNano: uint64(ts.Add(time.Second).UnixNano()),
+						Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "second"}},
+					},
+				},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/logs", bytes.NewReader(gzipBytes(t, raw)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", protobufContentType)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, protobufContentType, resp.Header.Get("Content-Type"))
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "checkout", entries[0].App)
+	assert.Equal(t, filepath.Join(sourceRoot, "otlp", "checkout.log"), entries[0].SourcePath)
+	assert.Equal(t, "cart abandoned", string(entries[0].LogLine))
+	assert.Equal(t, ts, entries[0].Timestamp)
+	assert.Equal(t, "cart abandoned", entries[0].Fields["message"])
+	assert.Equal(t, "canary", entries[0].Fields["deployment.environment"], "record attributes override resource attributes")
+	assert.Equal(t, int64(3), entries[0].Fields["items"])
+	assert.Equal(t, "warn", entries[0].Fields["severity"])
+	assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", entries[0].Fields["trace_id"])
+
+	assert.Equal(t, ts.Add(time.Second), entries[1].Timestamp, "observed time is used without an event time")
+	assert.Equal(t, "prod", entries[1].Fields["deployment.environment"])
+}
+
+func TestReceiver_OTLPJSON(t *testing.T) {
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		AppName:                  "fallback-app",
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 1024 * 1024,
+		PromtailHTTPSourceRoot:   sourceRoot,
+	}
+	backend := &captureBackend{}
+	_, srv := newTestReceiver(t, cfg, backend)
+
+	body := `{"resourceLogs":[{"resource":{"attributes":[{"key":"host.name","value":{"stringValue":"node-1"}}]},
+		"scopeLogs":[{"scope":{"name":"app"},"logRecords":[{
+			"timeUnixNano":"1778051289000000010",
+			"severityText":"ERROR",
+			"traceId":"5b8efff798038103d269b633813fc60c",
+			"spanId":"eee19b7ec3c1b174",
+			"body":{"kvlistValue":{"values":[
+				{"key":"msg","value":{"stringValue":"payment failed"}},
+				{"key":"amount","value":{"doubleValue":12.5}},
+				{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"intValue":"7"}]}}}
+			]}},
+			"attributes":[
+				{"key":"log.file.path","value":{"stringValue":"/var/log/../../payments.log"}},
+				{"key":"retry","value":{"boolValue":true}},
+				{"key":"attempt","value":{"intValue":2}}
+			]
+		}]}]}]}`
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/logs", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(respBody))
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "fallback-app", entry.App, "without service.name the configured app is used")
+	assert.Equal(t, filepath.Join(sourceRoot, "var", "log", "payments.log"), entry.SourcePath)
+	assert.Equal(t, time.Unix(0, 1778051289000000010).UTC(), entry.Timestamp)
+	assert.JSONEq(t, `{"msg":"payment failed","amount":12.5,"tags":["a",7]}`, string(entry.LogLine))
+	assert.Equal(t, "payment failed", entry.Fields["msg"])
+	assert.Equal(t, 12.5, entry.Fields["amount"])
+	assert.Equal(t, []interface{}{"a", int64(7)}, entry.Fields["tags"])
+	assert.Equal(t, "node-1", entry.Fields["host.name"])
+	assert.Equal(t, true, entry.Fields["retry"])
+	assert.Equal(t, int64(2), entry.Fields["attempt"])
+	assert.Equal(t, "ERROR", entry.Fields["severity"])
+	assert.Equal(t, "5b8efff798038103d269b633813fc60c", entry.Fields["trace_id"])
+	assert.Equal(t, "eee19b7ec3c1b174", entry.Fields["span_id"])
+	assert.NotContains(t, entry.Fields, "message")
+}
+
+func TestReceiver_OTLPValidationAndAuthResponses(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 128,
+		PromtailHTTPBearerToken:  "secret",
+		PromtailHTTPSourceRoot:   t.TempDir(),
+	}
+	backend := &captureBackend{}
+	_, srv := newTestReceiver(t, cfg, backend)
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		encoding    string
+		token       string
+		want        int
+	}{
+		{name: "unauthorized", body: `{}`, contentType: jsonContentType, want: http.StatusUnauthorized},
+		{name: "unsupported content type", body: `{}`, contentType: "text/plain", token: "secret", want: http.StatusUnsupportedMediaType},
+		{name: "snappy encoding", body: `{}`, contentType: protobufContentType, encoding: "snappy", token: "secret", want: http.StatusUnsupportedMediaType},
+		{name: "malformed JSON", body: `{"resourceLogs":`, contentType: jsonContentType, token: "secret", want: http.StatusBadRequest},
+		{name: "invalid trace id", body: `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"traceId":"zz"}]}]}]}`, contentType: jsonContentType, token: "secret", want: http.StatusBadRequest},
+		{name: "malformed protobuf", body: "\xff\xff\xff", contentType: protobufContentType, token: "secret", want: http.StatusBadRequest},
+		{name: "request too large", body: `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}}]}]}]}`, contentType: jsonContentType, token: "secret", want: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/logs", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.want, resp.StatusCode)
+		})
+	}
+	assert.Empty(t, backend.snapshot())
+}
@@ -4,21 +4,23 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/subtle"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log-enricher/internal/backends"
 	"log-enricher/internal/config"
+	"log-enricher/internal/netinput"
 	"log-enricher/internal/pipeline"
 	"log-enricher/internal/processor"
 	"log/slog"
 	"mime"
 	"net"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/goccy/go-json"
@@ -32,7 +34,6 @@ const (
 	protobufContentType = "application/x-protobuf"
 	jsonContentType     = "application/json"
 	fallbackAppName     = "promtail"
-	defaultFallbackFile = "stream.log"
 )
 
 type Receiver struct {
@@ -42,7 +43,19 @@ type Receiver struct {
 	sourceRoot  string
 	maxBodySize int64
 	bearerToken string
-	server      *http.Server
+	appMap      labelMapping
+	sourceMap   labelMapping
+	tls         *tlsReloader // Optional; the receiver serves plain HTTP when nil.
+	tenants     *tenantTable // Optional; requests have no tenant when nil.
+	admission   *admission   // Optional; requests are not limited when nil.
+	spool       *spool       // Optional; entries are processed before the request is answered when nil.
+	limiter     *rateLimiter
+	// defaultTenantLimit applies to every tenant, including requests without one, unless the tenants file
+	// overrides it; streamLimit applies to every stream.
+	defaultTenantLimit rateLimit
+	streamLimit        rateLimit
+	server             *http.Server
+	workers            *processor.WorkerPool // Optional; entries are processed inline on the request goroutine when nil.
 }
 
 type normalizedEntry struct {
@@ -50,6 +63,9 @@ type normalizedEntry struct {
 	source    string
 	timestamp time.Time
 	line      []byte
+	fields    map[string]interface{} // Set by inputs that carry structured data, e.g. OTLP attributes.
+	labels    map[string]string      // Stream labels.
+	metadata  map[string]string      // The entry's structured metadata, kept apart from the stream labels.
 }
 
 type jsonPushRequest struct {
@@ -90,6 +106,42 @@ func NewReceiver(cfg *config.Config, pm pipeline.Manager, backend backends.Backe
 		sourceRoot = "/cache/promtail"
 	}
 
+	appMap, err := newLabelMapping("promtail_app", cfg.PromtailHTTPAppTemplate, cfg.PromtailHTTPAppLabels, config.DefaultPromtailHTTPAppLabels)
+	if err != nil {
+		return nil, err
+	}
+	sourceMap, err := newLabelMapping("promtail_source", cfg.PromtailHTTPSourceTemplate, cfg.PromtailHTTPSourceLabels, config.DefaultPromtailHTTPSourceLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsFiles *tlsReloader
+	certFile := strings.TrimSpace(cfg.PromtailHTTPTLSCertFile)
+	keyFile := strings.TrimSpace(cfg.PromtailHTTPTLSKeyFile)
+	caFile := strings.TrimSpace(cfg.PromtailHTTPTLSClientCAFile)
+	if certFile != "" || keyFile != "" || caFile != "" {
+		tlsFiles, err = newTLSReloader(certFile, keyFile, caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Promtail HTTP TLS configuration: %w", err)
+		}
+	}
+
+	var tenants *tenantTable
+	if path := strings.TrimSpace(cfg.PromtailHTTPTenantsFile); path != "" {
+		tenants, err = loadTenantTable(path, strings.TrimSpace(cfg.PromtailHTTPBearerToken))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var spooled *spool
+	if dir := strings.TrimSpace(cfg.PromtailHTTPSpoolDir); dir != "" {
+		spooled, err = openSpool(dir, int64(cfg.PromtailHTTPSpoolMaxBytes), int64(cfg.PromtailHTTPSpoolSegmentBytes), cfg.PromtailHTTPSpoolMaxAttempts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	r := &Receiver{
 		pm:          pm,
 		backend:     backend,
@@ -97,11 +149,32 @@ func NewReceiver(cfg *config.Config, pm pipeline.Manager, backend backends.Backe
 		sourceRoot:  filepath.Clean(sourceRoot),
 		maxBodySize: maxBodySize,
 		bearerToken: strings.TrimSpace(cfg.PromtailHTTPBearerToken),
+		appMap:      appMap,
+		sourceMap:   sourceMap,
+		tls:         tlsFiles,
+		tenants:     tenants,
+		spool:       spooled,
+		admission:   newAdmission(cfg.PromtailHTTPMaxConcurrentRequests, cfg.PromtailHTTPQueueSize),
+		limiter:     newRateLimiter(),
+		defaultTenantLimit: rateLimit{
+			BytesPerSecond: cfg.PromtailHTTPTenantRateBytes,
+			BurstBytes:     cfg.PromtailHTTPTenantBurstBytes,
+			LinesPerSecond: cfg.PromtailHTTPTenantRateLines,
+			BurstLines:     cfg.PromtailHTTPTenantBurstLines,
+		},
+		streamLimit: rateLimit{
+			BytesPerSecond: cfg.PromtailHTTPStreamRateBytes,
+			BurstBytes:     cfg.PromtailHTTPStreamBurstBytes,
+			LinesPerSecond: cfg.PromtailHTTPStreamRateLines,
+			BurstLines:     cfg.PromtailHTTPStreamBurstLines,
+		},
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/loki/api/v1/push", r.handlePush)
 	mux.HandleFunc("/api/prom/push", r.handlePush)
+	mux.HandleFunc("/v1/logs", r.handleOTLP)
+	mux.HandleFunc("/ingest", r.handleIngest)
 	mux.HandleFunc("/ready", r.handleReady)
 
 	r.server = &http.Server{
@@ -112,10 +185,18 @@ func NewReceiver(cfg *config.Config, pm pipeline.Manager, backend backends.Backe
 		WriteTimeout:      15 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	if tlsFiles != nil {
+		r.server.TLSConfig = &tls.Config{GetConfigForClient: tlsFiles.getConfigForClient}
+	}
 
 	return r, nil
 }
 
+// SetWorkerPool makes the receiver process entries on pool. It must be called before Start.
+func (r *Receiver) SetWorkerPool(pool *processor.WorkerPool) {
+	r.workers = pool
+}
+
 func (r *Receiver) Start() error {
 	listener, err := net.Listen("tcp", r.server.Addr)
 	if err != nil {
@@ -123,10 +204,19 @@ func (r *Receiver) Start() error {
 	}
 
 	r.server.Addr = listener.Addr().String()
-	slog.Info("Promtail HTTP receiver enabled", "addr", r.server.Addr)
+	slog.Info("Promtail HTTP receiver enabled", "addr", r.server.Addr, "tls", r.tls != nil, "client_ca", r.tls != nil && r.tls.caFile != "", "spool", r.spool != nil)
+
+	if r.spool != nil {
+		r.spool.start(r.processSpooled, r.backend.Name())
+	}
 
 	go func() {
-		if err := r.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		serve := r.server.Serve
+		if r.tls != nil {
+			// The certificate comes from TLSConfig, which reloads it on change.
+			serve = func(l net.Listener) error { return r.server.ServeTLS(l, "", "") }
+		}
+		if err := serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error("Promtail HTTP receiver failed", "error", err)
 		}
 	}()
@@ -135,7 +225,13 @@ func (r *Receiver) Start() error {
 }
 
 func (r *Receiver) Shutdown(ctx context.Context) error {
-	return r.server.Shutdown(ctx)
+	err := r.server.Shutdown(ctx)
+	if r.spool != nil {
+		if spoolErr := r.spool.close(ctx); spoolErr != nil && err == nil {
+			err = spoolErr
+		}
+	}
+	return err
 }
 
 func (r *Receiver) Addr() string {
@@ -163,18 +259,34 @@ func (r *Receiver) handlePush(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if !r.isAuthorized(req) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	scope, status, err := r.authenticate(req)
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	entries, status, err := r.parseRequest(w, req)
+	release := r.admitRequest(w, req)
+	if release == nil {
+		return
+	}
+	defer release()
+
+	entries, status, err := r.parseRequest(w, req, scope)
 	if err != nil {
 		http.Error(w, err.Error(), status)
 		return
 	}
 
-	if err := r.processEntries(entries); err != nil {
+	if !r.checkRateLimits(w, scope.tenant, entries) {
+		return
+	}
+
+	if err := r.acceptEntries(scope.tenant, entries); err != nil {
+		if errors.Is(err, errSpoolFull) {
+			writeRetryAfter(w, spoolFullRetryAfter)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		slog.Error("Failed to process Promtail request", "error", err)
 		http.Error(w, "failed to process logs", http.StatusInternalServerError)
 		return
@@ -183,7 +295,7 @@ func (r *Receiver) handlePush(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (r *Receiver) parseRequest(w http.ResponseWriter, req *http.Request) ([]normalizedEntry, int, error) {
+func (r *Receiver) parseRequest(w http.ResponseWriter, req *http.Request, scope requestScope) ([]normalizedEntry, int, error) {
 	contentType := req.Header.Get("Content-Type")
 	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
@@ -197,9 +309,9 @@ func (r *Receiver) parseRequest(w http.ResponseWriter, req *http.Request) ([]nor
 
 	switch mediaType {
 	case protobufContentType:
-		return r.parseProtobuf(body)
+		return r.parseProtobuf(body, scope)
 	case jsonContentType:
-		return r.parseJSON(body)
+		return r.parseJSON(body, scope)
 	default:
 		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type: %s", mediaType)
 	}
@@ -247,7 +359,7 @@ func (r *Receiver) readBody(w http.ResponseWriter, req *http.Request, mediaType
 	return body, http.StatusOK, nil
 }
 
-func (r *Receiver) parseProtobuf(body []byte) ([]normalizedEntry, int, error) {
+func (r *Receiver) parseProtobuf(body []byte, scope requestScope) ([]normalizedEntry, int, error) {
 	decoded, err := snappy.Decode(nil, body)
 	if err != nil {
 		return nil, http.StatusBadRequest, fmt.Errorf("invalid snappy payload: %w", err)
@@ -261,27 +373,27 @@ func (r *Receiver) parseProtobuf(body []byte) ([]normalizedEntry, int, error) {
 		return nil, http.StatusBadRequest, fmt.Errorf("failed to unmarshal protobuf payload: %w", err)
 	}
 
-	entries, err := r.normalizeProtoEntries(req.Streams)
+	entries, err := r.normalizeProtoEntries(req.Streams, scope)
 	if err != nil {
 		return nil, http.StatusBadRequest, err
 	}
 	return entries, http.StatusOK, nil
 }
 
-func (r *Receiver) parseJSON(body []byte) ([]normalizedEntry, int, error) {
+func (r *Receiver) parseJSON(body []byte, scope requestScope) ([]normalizedEntry, int, error) {
 	var req jsonPushRequest
 	if err := json.Unmarshal(body, &req); err != nil {
 		return nil, http.StatusBadRequest, fmt.Errorf("failed to unmarshal JSON payload: %w", err)
 	}
 
-	entries, err := r.normalizeJSONEntries(req.Streams)
+	entries, err := r.normalizeJSONEntries(req.Streams, scope)
 	if err != nil {
 		return nil, http.StatusBadRequest, err
 	}
 	return entries, http.StatusOK, nil
 }
 
-func (r *Receiver) normalizeProtoEntries(streams []push.Stream) ([]normalizedEntry, error) {
+func (r *Receiver) normalizeProtoEntries(streams []push.Stream, scope requestScope) ([]normalizedEntry, error) {
 	entries := make([]normalizedEntry, 0, len(streams))
 
 	for streamIdx, stream := range streams {
@@ -290,8 +402,9 @@ func (r *Receiver) normalizeProtoEntries(streams []push.Stream) ([]normalizedEnt
 			return nil, fmt.Errorf("invalid labels for stream %d: %w", streamIdx, err)
 		}
 
-		app := r.deriveAppName(labels)
-		source := sanitizeSourcePath(r.sourceRoot, deriveSourcePath(labels), fmt.Sprintf("stream-%d.log", streamIdx))
+		mapped := scope.identity.mappingLabels(labels)
+		app := r.deriveAppName(mapped)
+		source := netinput.SanitizeSourcePath(scope.sourceRoot(r.sourceRoot), r.deriveSourcePath(mapped), fmt.Sprintf("stream-%d.log", streamIdx))
 		for _, entry := range stream.Entries {
 			if entry.Line == "" {
 				// Empty lines are valid, but they should still pass through the normal pipeline.
@@ -301,6 +414,8 @@ func (r *Receiver) normalizeProtoEntries(streams []push.Stream) ([]normalizedEnt
 				source:    source,
 				timestamp: entry.Timestamp,
 				line:      []byte(entry.Line),
+				labels:    labels,
+				metadata:  structuredMetadata(entry.StructuredMetadata),
 			})
 		}
 	}
@@ -308,7 +423,7 @@ func (r *Receiver) normalizeProtoEntries(streams []push.Stream) ([]normalizedEnt
 	return entries, nil
 }
 
-func (r *Receiver) normalizeJSONEntries(streams []jsonPushStream) ([]normalizedEntry, error) {
+func (r *Receiver) normalizeJSONEntries(streams []jsonPushStream, scope requestScope) ([]normalizedEntry, error) {
 	entries := make([]normalizedEntry, 0, len(streams))
 
 	for streamIdx, stream := range streams {
@@ -321,8 +436,9 @@ func (r *Receiver) normalizeJSONEntries(streams []jsonPushStream) ([]normalizedE
 			labels = parsed
 		}
 
-		app := r.deriveAppName(labels)
-		source := sanitizeSourcePath(r.sourceRoot, deriveSourcePath(labels), fmt.Sprintf("stream-%d.log", streamIdx))
+		mapped := scope.identity.mappingLabels(labels)
+		app := r.deriveAppName(mapped)
+		source := netinput.SanitizeSourcePath(scope.sourceRoot(r.sourceRoot), r.deriveSourcePath(mapped), fmt.Sprintf("stream-%d.log", streamIdx))
 
 		for tupleIdx, tuple := range stream.Values {
 			if len(tuple) < 2 {
@@ -339,11 +455,20 @@ func (r *Receiver) normalizeJSONEntries(streams []jsonPushStream) ([]normalizedE
 				return nil, fmt.Errorf("invalid line at stream %d values[%d]: %w", streamIdx, tupleIdx, err)
 			}
 
+			var metadata map[string]string
+			if len(tuple) > 2 {
+				if err := json.Unmarshal(tuple[2], &metadata); err != nil {
+					return nil, fmt.Errorf("invalid structured metadata at stream %d values[%d]: %w", streamIdx, tupleIdx, err)
+				}
+			}
+
 			entries = append(entries, normalizedEntry{
 				app:       app,
 				source:    source,
 				timestamp: ts,
 				line:      []byte(line),
+				labels:    labels,
+				metadata:  metadata,
 			})
 		}
 
@@ -366,6 +491,7 @@ func (r *Receiver) normalizeJSONEntries(streams []jsonPushStream) ([]normalizedE
 				source:    source,
 				timestamp: ts,
 				line:      []byte(entry.Line),
+				labels:    labels,
 			})
 		}
 	}
@@ -373,18 +499,32 @@ func (r *Receiver) normalizeJSONEntries(streams []jsonPushStream) ([]normalizedE
 	return entries, nil
 }
 
-func (r *Receiver) processEntries(entries []normalizedEntry) error {
+// acceptEntries processes the entries of one request, or with a spool persists them for asynchronous
+// processing. Once it returns nil the request can be acknowledged.
+func (r *Receiver) acceptEntries(tenant string, entries []normalizedEntry) error {
+	if r.spool != nil {
+		return r.spool.append(tenant, entries)
+	}
+	return r.processEntries(tenant, entries)
+}
+
+// processEntries processes the entries of one request, which all belong to tenant.
+func (r *Receiver) processEntries(tenant string, entries []normalizedEntry) error {
+	if r.workers != nil {
+		return r.processEntriesOnPool(tenant, entries)
+	}
+
 	processors := make(map[string]*processor.LogProcessorImpl, len(entries))
 
 	for _, entry := range entries {
 		key := entry.app + "\x00" + entry.source
 		lp, ok := processors[key]
 		if !ok {
-			lp = processor.NewLogProcessor(entry.app, entry.source, r.pm.GetProcessPipeline(entry.source), r.backend)
+			lp = processor.NewTenantLogProcessor(tenant, entry.app, entry.source, r.pm.GetTenantProcessPipeline(tenant, entry.source), r.backend)
 			processors[key] = lp
 		}
 
-		if err := lp.ProcessLineWithTimestamp(entry.line, entry.timestamp); err != nil {
+		if err := lp.ProcessLineWithLabels(entry.line, entry.timestamp, entry.fields, entry.labels, entry.metadata); err != nil {
 			return err
 		}
 	}
@@ -392,26 +532,129 @@ func (r *Receiver) processEntries(entries []normalizedEntry) error {
 	return nil
 }
 
-func (r *Receiver) deriveAppName(labels map[string]string) string {
-	for _, key := range []string{"app", "service", "job"} {
-		if v := strings.TrimSpace(labels[key]); v != "" {
-			return v
+// processEntriesOnPool processes entries in parallel while keeping each stream's order.
+// The request is only answered once every entry was sent, so the first backend error is still reported.
+func (r *Receiver) processEntriesOnPool(tenant string, entries []normalizedEntry) error {
+	processors := make(map[string]*processor.OrderedProcessor)
+	order := make([]*processor.OrderedProcessor, 0)
+
+	var firstErr error
+	for _, entry := range entries {
+		key := entry.app + "\x00" + entry.source
+		op, ok := processors[key]
+		if !ok {
+			lp := processor.NewTenantLogProcessor(tenant, entry.app, entry.source, r.pm.GetTenantProcessPipeline(tenant, entry.source), r.backend)
+			op = r.workers.NewOrderedProcessor(lp, nil)
+			processors[key] = op
+			order = append(order, op)
+		}
+
+		if err := op.ProcessLineWithLabels(entry.line, entry.timestamp, entry.fields, entry.labels, entry.metadata); err != nil {
+			firstErr = err
+			break
 		}
 	}
 
+	for _, op := range order {
+		if err := op.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// processSpooled processes the entries of a spooled batch and returns the errors of those that were not sent, by
+// index. Unlike processEntries it goes on after a failure and leaves dead-lettering to the spool, which retries
+// the failed entries.
+func (r *Receiver) processSpooled(tenant string, entries []normalizedEntry) map[int]error {
+	if r.workers != nil {
+		return r.processSpooledOnPool(tenant, entries)
+	}
+
+	processors := make(map[string]*processor.LogProcessorImpl, len(entries))
+	failed := make(map[int]error)
+	for i, entry := range entries {
+		key := entry.app + "\x00" + entry.source
+		lp, ok := processors[key]
+		if !ok {
+			lp = processor.NewTenantLogProcessor(tenant, entry.app, entry.source, r.pm.GetTenantProcessPipeline(tenant, entry.source), r.backend)
+			lp.DisableDeadLetter()
+			processors[key] = lp
+		}
+
+		if err := lp.ProcessLineWithLabels(entry.line, entry.timestamp, entry.fields, entry.labels, entry.metadata); err != nil {
+			failed[i] = err
+		}
+	}
+	return failed
+}
+
+// processSpooledOnPool is processSpooled on the worker pool. Each stream reports its results in submission
+// order, so the indices of its queued entries are a FIFO.
+func (r *Receiver) processSpooledOnPool(tenant string, entries []normalizedEntry) map[int]error {
+	type stream struct {
+		op      *processor.OrderedProcessor
+		indices []int
+	}
+	var mu sync.Mutex // Guards failed and the streams' indices.
+	failed := make(map[int]error)
+	streams := make(map[string]*stream)
+	order := make([]*stream, 0)
+
+	for i, entry := range entries {
+		key := entry.app + "\x00" + entry.source
+		st, ok := streams[key]
+		if !ok {
+			st = &stream{}
+			lp := processor.NewTenantLogProcessor(tenant, entry.app, entry.source, r.pm.GetTenantProcessPipeline(tenant, entry.source), r.backend)
+			lp.DisableDeadLetter()
+			st.op = r.workers.NewOrderedProcessor(lp, func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				index := st.indices[0]
+				st.indices = st.indices[1:]
+				if err != nil {
+					failed[index] = err
+				}
+			})
+			streams[key] = st
+			order = append(order, st)
+		}
+
+		mu.Lock()
+		st.indices = append(st.indices, i)
+		mu.Unlock()
+		if err := st.op.ProcessLineWithLabels(entry.line, entry.timestamp, entry.fields, entry.labels, entry.metadata); err != nil {
+			// The pool is shut down: neither this entry nor the rest were queued.
+			mu.Lock()
+			st.indices = st.indices[:len(st.indices)-1]
+			for j := i; j < len(entries); j++ {
+				failed[j] = err
+			}
+			mu.Unlock()
+			break
+		}
+	}
+
+	for _, st := range order {
+		_ = st.op.Close()
+	}
+	return failed
+}
+
+func (r *Receiver) deriveAppName(labels map[string]string) string {
+	if v := r.appMap.resolve(labels); v != "" {
+		return v
+	}
+
 	if r.defaultApp != "" {
 		return r.defaultApp
 	}
 	return fallbackAppName
 }
 
-func deriveSourcePath(labels map[string]string) string {
-	for _, key := range []string{"filename", "__path__", "path", "source"} {
-		if v := strings.TrimSpace(labels[key]); v != "" {
-			return v
-		}
-	}
-	return ""
+func (r *Receiver) deriveSourcePath(labels map[string]string) string {
+	return r.sourceMap.resolve(labels)
 }
 
 func parseLabelString(value string) (map[string]string, error) {
@@ -475,70 +718,16 @@ func parseLineRaw(raw json.RawMessage) (string, error) {
 	return line, nil
 }
 
-func sanitizeSourcePath(root, candidate, fallback string) string {
-	cleanRoot := filepath.Clean(strings.TrimSpace(root))
-	if cleanRoot == "" {
-		cleanRoot = "."
-	}
-
-	safeFallback := sanitizeRelativePath(fallback)
-	if safeFallback == "" {
-		safeFallback = defaultFallbackFile
-	}
-
-	relativePath := sanitizeRelativePath(candidate)
-	if relativePath == "" {
-		relativePath = safeFallback
-	}
-
-	candidatePath := filepath.Clean(filepath.Join(cleanRoot, relativePath))
-	if isPathEscapingRoot(cleanRoot, candidatePath) {
-		return filepath.Join(cleanRoot, safeFallback)
-	}
-
-	return candidatePath
-}
-
-func sanitizeRelativePath(value string) string {
-	value = strings.TrimSpace(value)
-	if value == "" {
-		return ""
-	}
-
-	value = strings.ReplaceAll(value, "\\", "/")
-	if len(value) >= 2 && ((value[0] >= 'a' && value[0] <= 'z') || (value[0] >= 'A' && value[0] <= 'Z')) && value[1] == ':' {
-		value = value[2:]
-	}
-	value = strings.TrimLeft(value, "/")
-
-	parts := strings.Split(value, "/")
-	safeParts := make([]string, 0, len(parts))
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "" || part == "." || part == ".." {
-			continue
-		}
-		safeParts = append(safeParts, part)
-	}
-
-	if len(safeParts) == 0 {
-		return ""
-	}
-	return filepath.Join(safeParts...)
-}
-
-func isPathEscapingRoot(root, candidate string) bool {
-	root = filepath.Clean(root)
-	candidate = filepath.Clean(candidate)
-
-	rel, err := filepath.Rel(root, candidate)
-	if err != nil {
-		return true
+// structuredMetadata converts an entry's structured metadata to a map, or nil if it has none.
+func structuredMetadata(metadata push.LabelsAdapter) map[string]string {
+	if len(metadata) == 0 {
+		return nil
 	}
-	if rel == ".." {
-		return true
+	result := make(map[string]string, len(metadata))
+	for _, label := range metadata {
+		result[label.Name] = label.Value
 	}
-	return strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+	return result
 }
 
 func cloneLabels(labels map[string]string) map[string]string {
@@ -3,6 +3,7 @@ package promtailhttp
 import (
 	"bytes"
 	"compress/gzip"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
@@ -15,6 +16,7 @@ import (
 	"log-enricher/internal/config"
 	"log-enricher/internal/models"
 	"log-enricher/internal/pipeline"
+	"log-enricher/internal/processor"
 
 	"github.com/goccy/go-json"
 	"github.com/gogo/protobuf/proto"
@@ -30,6 +32,10 @@ func (m *stubPipelineManager) GetProcessPipeline(filePath string) pipeline.Proce
 	return &stubProcessPipeline{}
 }
 
+func (m *stubPipelineManager) GetTenantProcessPipeline(tenant, filePath string) pipeline.ProcessPipeline {
+	return m.GetProcessPipeline(filePath)
+}
+
 type stubProcessPipeline struct{}
 
 func (p *stubProcessPipeline) Process(entry *models.LogEntry) bool {
@@ -40,6 +46,7 @@ type captureBackend struct {
 	mu      sync.Mutex
 	entries []*models.LogEntry
 	err     error
+	failOn  string // Lines equal to failOn are rejected with err.
 }
 
 func (b *captureBackend) Send(entry *models.LogEntry) error {
@@ -51,12 +58,18 @@ func (b *captureBackend) Send(entry *models.LogEntry) error {
 		fields[k] = v
 	}
 	b.entries = append(b.entries, &models.LogEntry{
-		Fields:     fields,
-		LogLine:    append([]byte(nil), entry.LogLine...),
-		Timestamp:  entry.Timestamp,
-		SourcePath: entry.SourcePath,
-		App:        entry.App,
+		Fields:             fields,
+		LogLine:            append([]byte(nil), entry.LogLine...),
+		Timestamp:          entry.Timestamp,
+		SourcePath:         entry.SourcePath,
+		App:                entry.App,
+		Labels:             maps.Clone(entry.Labels),
+		Tenant:             entry.Tenant,
+		StructuredMetadata: maps.Clone(entry.StructuredMetadata),
 	})
+	if b.failOn != "" && string(entry.LogLine) != b.failOn {
+		return nil
+	}
 	return b.err
 }
 
@@ -64,6 +77,15 @@ func (b *captureBackend) Shutdown()                     {}
 func (b *captureBackend) Name() string                  { return "capture" }
 func (b *captureBackend) CloseWriter(sourcePath string) {}
 
+func (b *captureBackend) SendBatch(entries []*models.LogEntry) error {
+	for _, entry := range entries {
+		if err := b.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *captureBackend) snapshot() []*models.LogEntry {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -246,6 +268,156 @@ func TestReceiver_JSONValuesAndGzip(t *testing.T) {
 	assert.Equal(t, filepath.Join(sourceRoot, "logs", "payments", "app.log"), entries[0].SourcePath)
 }
 
+func TestReceiver_PreservesLabelsAndStructuredMetadata(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 1024 * 1024,
+		PromtailHTTPSourceRoot:   t.TempDir(),
+	}
+	backend := &captureBackend{}
+	_, srv := newTestReceiver(t, cfg, backend)
+
+	ts := time.Date(2026, time.April, 20, 1, 2, 3, 0, time.UTC)
+	protoBody := buildProtobufBody(t, push.PushRequest{
+		Streams: []push.Stream{{
+			Labels: `{app="api",namespace="prod",pod="api-7d9"}`,
+			Entries: []push.Entry{
+				{Timestamp: ts, Line: "with metadata", StructuredMetadata: push.LabelsAdapter{{Name: "trace_id", Value: "abc"}, {Name: "pod", Value: "api-override"}}},
+				{Timestamp: ts, Line: "without metadata"},
+			},
+		}},
+	})
+	jsonBody, err := json.Marshal(map[string]any{
+		"streams": []any{map[string]any{
+			"stream": map[string]string{"app": "worker", "host": "node-1"},
+			"values": [][]any{
+				{strconvFormatInt(ts.UnixNano()), "json with metadata", map[string]string{"user": "alice"}},
+				{strconvFormatInt(ts.UnixNano()), "json without metadata"},
+			},
+		}},
+	})
+	require.NoError(t, err)
+
+	for _, body := range []struct {
+		payload     []byte
+		contentType string
+	}{{protoBody, protobufContentType}, {jsonBody, jsonContentType}} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/loki/api/v1/push", bytes.NewReader(body.payload))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", body.contentType)
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 4)
+	assert.Equal(t, map[string]string{"app": "api", "namespace": "prod", "pod": "api-7d9"}, entries[0].Labels,
+		"structured metadata does not change the stream labels")
+	assert.Equal(t, map[string]string{"pod": "api-override", "trace_id": "abc"}, entries[0].StructuredMetadata)
+	assert.Equal(t, map[string]string{"app": "api", "namespace": "prod", "pod": "api-7d9"}, entries[1].Labels)
+	assert.Nil(t, entries[1].StructuredMetadata)
+	assert.Equal(t, map[string]string{"app": "worker", "host": "node-1"}, entries[2].Labels)
+	assert.Equal(t, map[string]string{"user": "alice"}, entries[2].StructuredMetadata)
+	assert.Equal(t, map[string]string{"app": "worker", "host": "node-1"}, entries[3].Labels)
+	assert.Nil(t, entries[3].StructuredMetadata)
+}
+
+func TestReceiver_LabelMappingTemplatesAndFallbacks(t *testing.T) {
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		AppName:                    "default-app",
+		PromtailHTTPAddr:           "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:   1024 * 1024,
+		PromtailHTTPSourceRoot:     sourceRoot,
+		PromtailHTTPAppTemplate:    "{{.namespace}}/{{.container}}",
+		PromtailHTTPAppLabels:      []string{" job ", ""},
+		PromtailHTTPSourceTemplate: "{{.namespace}}/{{.pod}}/{{.container}}.log",
+	}
+	backend := &captureBackend{}
+	_, srv := newTestReceiver(t, cfg, backend)
+
+	ts := strconvFormatInt(time.Date(2026, time.April, 21, 1, 2, 3, 0, time.UTC).UnixNano())
+	body, err := json.Marshal(map[string]any{
+		"streams": []any{
+			map[string]any{
+				"stream": map[string]string{"namespace": "prod", "pod": "api-7d9", "container": "api", "app": "ignored"},
+				"values": [][]any{{ts, "kubernetes"}},
+			},
+			map[string]any{
+				"stream": map[string]string{"namespace": "prod", "job": "cron", "filename": "/var/log/cron.log"},
+				"values": [][]any{{ts, "missing container label"}},
+			},
+			map[string]any{
+				"stream": map[string]string{"app": "not-in-label-list"},
+				"values": [][]any{{ts, "no mapping applies"}},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/loki/api/v1/push", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", jsonContentType)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "prod/api", entries[0].App)
+	assert.Equal(t, filepath.Join(sourceRoot, "prod", "api-7d9", "api.log"), entries[0].SourcePath)
+	assert.Equal(t, "cron", entries[1].App, "templates referencing missing labels fall back to the label list")
+	assert.Equal(t, filepath.Join(sourceRoot, "var", "log", "cron.log"), entries[1].SourcePath)
+	assert.Equal(t, "default-app", entries[2].App)
+	assert.Equal(t, filepath.Join(sourceRoot, "stream-2.log"), entries[2].SourcePath)
+
+	cfg.PromtailHTTPSourceTemplate = "{{.pod"
+	_, err = NewReceiver(cfg, &stubPipelineManager{}, backend)
+	assert.Error(t, err)
+}
+
+func TestReceiver_WorkerPoolKeepsStreamOrder(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 1024 * 1024,
+		PromtailHTTPSourceRoot:   t.TempDir(),
+	}
+	backend := &captureBackend{}
+	r, srv := newTestReceiver(t, cfg, backend)
+	pool := processor.NewWorkerPool(t.Context(), 4, 16)
+	t.Cleanup(pool.Shutdown)
+	r.SetWorkerPool(pool)
+
+	ts := time.Date(2026, time.May, 1, 0, 0, 0, 0, time.UTC)
+	var values [][]any
+	for i := 0; i < 100; i++ {
+		values = append(values, []any{strconvFormatInt(ts.UnixNano() + int64(i)), strconv.Itoa(i)})
+	}
+	body, err := json.Marshal(map[string]any{
+		"streams": []any{map[string]any{"stream": map[string]string{"app": "ordered"}, "values": values}},
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/loki/api/v1/push", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", jsonContentType)
+
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// The response is only sent once every entry reached the backend.
+	entries := backend.snapshot()
+	require.Len(t, entries, 100)
+	for i, entry := range entries {
+		assert.Equal(t, strconv.Itoa(i), string(entry.LogLine))
+	}
+}
+
 func TestReceiver_RejectsMalformedJSONBatchWithoutProcessing(t *testing.T) {
 	cfg := &config.Config{
 		AppName:                  "",
@@ -0,0 +1,508 @@
+package promtailhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log-enricher/internal/backends"
+	"log-enricher/internal/deadletter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spoolSegmentExt = ".seg"
+	// spoolRecordHeaderSize is the payload length and CRC-32 in front of every record.
+	spoolRecordHeaderSize = 8
+	// spoolFullRetryAfter is the Retry-After sent when the spool has no room for a request.
+	spoolFullRetryAfter = 5 * time.Second
+	// Failed entries are retried with a backoff between these bounds, so the backend is not hammered.
+	spoolRetryMin = time.Second
+	spoolRetryMax = 30 * time.Second
+	// defaultSpoolMaxAttempts is used when no positive number of attempts is configured.
+	defaultSpoolMaxAttempts = 10
+	spoolStatsInterval      = time.Minute
+)
+
+// errSpoolFull is returned by append when the batch would grow the spool beyond its maximum size.
+var errSpoolFull = errors.New("spool is full")
+
+// spoolProcessFunc processes the entries of a spooled batch and returns the errors of the entries that could not
+// be sent, by index. Entries the pipeline dropped count as processed. It must not dead-letter failed entries; the
+// spool retries them and dead-letters those it gives up on.
+type spoolProcessFunc func(tenant string, entries []normalizedEntry) map[int]error
+
+func init() {
+	// Entry fields are stored as interface values; these are the composite types inputs put there.
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// spooledBatch is the persisted form of one request's entries.
+type spooledBatch struct {
+	Tenant  string
+	Entries []spooledEntry
+}
+
+type spooledEntry struct {
+	App       string
+	Source    string
+	Timestamp time.Time
+	Line      []byte
+	Fields    map[string]interface{}
+	Labels    map[string]string
+	Metadata  map[string]string
+}
+
+type spoolSegment struct {
+	seq  uint64
+	size int64 // Bytes known to be durable; readers never look beyond it.
+}
+
+// spool is an append-only log of accepted batches, split into segment files. Requests are acknowledged once
+// their batch is fsynced; a single goroutine processes batches in order and deletes a segment once all of its
+// batches were processed. Segments left over from a previous run are processed first, so every acknowledged
+// batch is processed at least once. Entries that still fail after maxAttempts, or fail permanently, are
+// dead-lettered, so one bad batch cannot hold up the spool.
+type spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	maxAttempts  int
+
+	mu       sync.Mutex
+	segments []*spoolSegment // Oldest first; the last one is written to while active is set.
+	active   *os.File
+	nextSeq  uint64
+	size     int64 // Bytes of all segments, processed or not.
+
+	notify   chan struct{}
+	stop     chan struct{}
+	done     chan struct{} // Set by start; closed once processing stopped.
+	stopOnce sync.Once
+
+	processed    atomic.Uint64 // Batches processed, including those with dead-lettered entries.
+	deadLettered atomic.Uint64 // Entries given up on.
+}
+
+// spoolStats is a snapshot of the spool's size and counters.
+type spoolStats struct {
+	Segments     int
+	Bytes        int64
+	MaxBytes     int64
+	Processed    uint64
+	DeadLettered uint64
+}
+
+// openSpool opens the spool in dir, creating it if needed, and picks up the segments of a previous run.
+// Failed entries are tried up to maxAttempts times.
+func openSpool(dir string, maxBytes, segmentBytes int64, maxAttempts int) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %s: %w", dir, err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory %s: %w", dir, err)
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultSpoolMaxAttempts
+	}
+
+	s := &spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		maxAttempts:  maxAttempts,
+		nextSeq:      1,
+		notify:       make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), spoolSegmentExt), 10, 64)
+		if err != nil || !strings.HasSuffix(file.Name(), spoolSegmentExt) || !file.Type().IsRegular() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat spool segment %s: %w", file.Name(), err)
+		}
+		s.segments = append(s.segments, &spoolSegment{seq: seq, size: info.Size()})
+		s.size += info.Size()
+		s.nextSeq = max(s.nextSeq, seq+1)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	if len(s.segments) > 0 {
+		slog.Info("Replaying Promtail HTTP spool", "dir", dir, "segments", len(s.segments), "bytes", s.size)
+	}
+	return s, nil
+}
+
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// append durably writes the batch to the active segment and returns once it is fsynced.
+func (s *spool) append(tenant string, entries []normalizedEntry) error {
+	batch := spooledBatch{Tenant: tenant, Entries: make([]spooledEntry, len(entries))}
+	for i, entry := range entries {
+		batch.Entries[i] = spooledEntry{
+			App:       entry.app,
+			Source:    entry.source,
+			Timestamp: entry.timestamp,
+			Line:      entry.line,
+			Fields:    entry.fields,
+			Labels:    entry.labels,
+			Metadata:  entry.metadata,
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(make([]byte, spoolRecordHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(&batch); err != nil {
+		return fmt.Errorf("failed to encode spool record: %w", err)
+	}
+	record := buf.Bytes()
+	payload := record[spoolRecordHeaderSize:]
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+int64(len(record)) > s.maxBytes {
+		slog.Warn("Promtail HTTP spool is full, rejecting request", "bytes", s.size, "max_bytes", s.maxBytes)
+		return errSpoolFull
+	}
+	if s.active != nil && s.segments[len(s.segments)-1].size+int64(len(record)) > s.segmentBytes {
+		s.sealActive()
+	}
+	if s.active == nil {
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	segment := s.segments[len(s.segments)-1]
+	if _, err := s.active.Write(record); err != nil {
+		s.abortActive(segment)
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		s.abortActive(segment)
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+	segment.size += int64(len(record))
+	s.size += int64(len(record))
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// openSegment creates a new active segment. It must be called with mu held.
+func (s *spool) openSegment() error {
+	seq := s.nextSeq
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	syncDir(s.dir)
+	s.nextSeq++
+	s.active = f
+	s.segments = append(s.segments, &spoolSegment{seq: seq})
+	return nil
+}
+
+// sealActive closes the active segment; the next append starts a new one. It must be called with mu held.
+func (s *spool) sealActive() {
+	if s.active == nil {
+		return
+	}
+	if err := s.active.Close(); err != nil {
+		slog.Error("Failed to close Promtail HTTP spool segment", "error", err)
+	}
+	s.active = nil
+}
+
+// abortActive seals the active segment after a failed write. The partial record is cut off, and readers stop
+// at the segment's durable size anyway. It must be called with mu held.
+func (s *spool) abortActive(segment *spoolSegment) {
+	_ = s.active.Truncate(segment.size)
+	s.sealActive()
+}
+
+// start processes spooled batches with process until close is called. backend names the backend in
+// dead-letter records. The spool size is logged periodically while it runs.
+func (s *spool) start(process spoolProcessFunc, backend string) {
+	done := make(chan struct{})
+	s.mu.Lock()
+	s.done = done
+	s.mu.Unlock()
+	go func() {
+		defer close(done)
+		s.run(process, backend)
+	}()
+	go s.reportStats()
+}
+
+// stats returns the current size and counters.
+func (s *spool) stats() spoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return spoolStats{
+		Segments:     len(s.segments),
+		Bytes:        s.size,
+		MaxBytes:     s.maxBytes,
+		Processed:    s.processed.Load(),
+		DeadLettered: s.deadLettered.Load(),
+	}
+}
+
+func (s *spool) reportStats() {
+	ticker := time.NewTicker(spoolStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			stats := s.stats()
+			slog.Info("Promtail HTTP Spool Stats", "segments", stats.Segments, "bytes", stats.Bytes, "max_bytes", stats.MaxBytes, "processed", stats.Processed, "dead_lettered", stats.DeadLettered)
+		}
+	}
+}
+
+func (s *spool) run(process spoolProcessFunc, backend string) {
+	var (
+		file   *os.File
+		seq    uint64
+		offset int64
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.notify:
+			case <-s.stop:
+				return
+			}
+			continue
+		}
+		segment := s.segments[0]
+		end := segment.size
+		if file != nil && seq == segment.seq && offset >= end {
+			// Everything durable was processed. Segments still being written are only dropped here, so an idle
+			// spool is empty and a restart does not replay processed batches.
+			if len(s.segments) == 1 {
+				s.sealActive()
+			}
+			s.removeSegment(segment)
+			s.mu.Unlock()
+			file.Close()
+			file = nil
+			continue
+		}
+		s.mu.Unlock()
+
+		if file == nil || seq != segment.seq {
+			if file != nil {
+				file.Close()
+			}
+			f, err := os.Open(s.segmentPath(segment.seq))
+			if err != nil {
+				slog.Error("Failed to open Promtail HTTP spool segment, dropping it", "segment", segment.seq, "error", err)
+				s.mu.Lock()
+				s.removeSegment(segment)
+				s.mu.Unlock()
+				file = nil
+				continue
+			}
+			file, seq, offset = f, segment.seq, 0
+		}
+
+		batch, n, err := readSpoolRecord(file, offset, end)
+		if err != nil {
+			// Only a record that was never acknowledged can be torn, so the rest of the segment is skipped.
+			slog.Error("Invalid Promtail HTTP spool record, skipping the rest of the segment", "segment", seq, "offset", offset, "error", err)
+			offset = end
+			continue
+		}
+		if !s.processWithRetry(process, backend, batch) {
+			return
+		}
+		s.processed.Add(1)
+		offset += n
+	}
+}
+
+// processWithRetry processes batch, retrying only the entries that failed, until every entry was sent or
+// dead-lettered: an entry is dead-lettered once it failed maxAttempts times or with a permanent error. It returns
+// false if the spool was closed first; the batch then stays in the spool and is processed again on the next start.
+func (s *spool) processWithRetry(process spoolProcessFunc, backend string, batch *spooledBatch) bool {
+	entries := make([]normalizedEntry, len(batch.Entries))
+	for i, entry := range batch.Entries {
+		entries[i] = normalizedEntry{
+			app:       entry.App,
+			source:    entry.Source,
+			timestamp: entry.Timestamp,
+			line:      entry.Line,
+			fields:    entry.Fields,
+			labels:    entry.Labels,
+			metadata:  entry.Metadata,
+		}
+	}
+
+	backoff := spoolRetryMin
+	for attempt := 1; ; attempt++ {
+		failed := process(batch.Tenant, entries)
+		if len(failed) == 0 {
+			return true
+		}
+
+		var (
+			retry   []normalizedEntry
+			lastErr error
+		)
+		for i, entry := range entries {
+			err, ok := failed[i]
+			if !ok {
+				continue
+			}
+			if attempt >= s.maxAttempts || backends.IsPermanent(err) {
+				s.deadLetter(backend, batch.Tenant, entry, err)
+				continue
+			}
+			retry = append(retry, entry)
+			lastErr = err
+		}
+		if len(retry) == 0 {
+			return true
+		}
+
+		slog.Error("Failed to process spooled Promtail HTTP entries, retrying", "tenant", batch.Tenant, "entries", len(retry), "attempt", attempt, "retry_in", backoff, "error", lastErr)
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			return false
+		}
+		backoff = min(2*backoff, spoolRetryMax)
+		entries = retry
+	}
+}
+
+// deadLetter gives up on an entry that could not be sent.
+func (s *spool) deadLetter(backend, tenant string, entry normalizedEntry, err error) {
+	slog.Error("Giving up on spooled Promtail HTTP entry", "tenant", tenant, "app", entry.app, "source_path", entry.source, "error", err)
+	s.deadLettered.Add(1)
+	deadletter.Write(deadletter.Record{
+		App:       entry.app,
+		Source:    entry.source,
+		Tenant:    tenant,
+		Timestamp: entry.timestamp,
+		Line:      string(entry.line),
+		Backend:   backend,
+		Error:     err.Error(),
+	})
+}
+
+// removeSegment deletes the oldest segment. It must be called with mu held.
+func (s *spool) removeSegment(segment *spoolSegment) {
+	if err := os.Remove(s.segmentPath(segment.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Failed to remove processed Promtail HTTP spool segment", "segment", segment.seq, "error", err)
+	}
+	s.size -= segment.size
+	s.segments = s.segments[1:]
+}
+
+// close stops processing after the current batch and closes the active segment. Unprocessed batches stay on
+// disk for the next start.
+func (s *spool) close(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	var err error
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealActive()
+	if s.size > 0 {
+		slog.Info("Promtail HTTP spool closed with pending batches", "dir", s.dir, "segments", len(s.segments), "bytes", s.size)
+	}
+	return err
+}
+
+// readSpoolRecord reads the record at offset, which must end at or before end, and returns its size on disk.
+func readSpoolRecord(f *os.File, offset, end int64) (*spooledBatch, int64, error) {
+	if end-offset < spoolRecordHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	header := make([]byte, spoolRecordHeaderSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if length > end-offset-spoolRecordHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, offset+spoolRecordHeaderSize); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+
+	var batch spooledBatch
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&batch); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode spool record: %w", err)
+	}
+	return &batch, spoolRecordHeaderSize + length, nil
+}
+
+// syncDir fsyncs a directory, so newly created files survive a crash. It is best effort: not every platform
+// supports syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,324 @@
+package promtailhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"log-enricher/internal/backends"
+	"log-enricher/internal/config"
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/processor"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type spooledCall struct {
+	tenant  string
+	entries []normalizedEntry
+}
+
+// recordingProcess returns a process function for a spool that records its calls. fail decides which entries of
+// a call fail; with a nil fail every entry is sent.
+func recordingProcess(fail func(call int, entry normalizedEntry) error) (spoolProcessFunc, func() []spooledCall) {
+	var mu sync.Mutex
+	var calls []spooledCall
+	process := func(tenant string, entries []normalizedEntry) map[int]error {
+		mu.Lock()
+		defer mu.Unlock()
+		failed := make(map[int]error)
+		if fail != nil {
+			for i, entry := range entries {
+				if err := fail(len(calls), entry); err != nil {
+					failed[i] = err
+				}
+			}
+		}
+		calls = append(calls, spooledCall{tenant: tenant, entries: entries})
+		return failed
+	}
+	snapshot := func() []spooledCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]spooledCall(nil), calls...)
+	}
+	return process, snapshot
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+func TestReceiver_SpoolAcknowledgesBeforeProcessing(t *testing.T) {
+	spoolDir := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:              "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:      1024 * 1024,
+		PromtailHTTPSourceRoot:        t.TempDir(),
+		PromtailHTTPSpoolDir:          spoolDir,
+		PromtailHTTPSpoolMaxBytes:     1024 * 1024,
+		PromtailHTTPSpoolSegmentBytes: 1024 * 1024,
+	}
+	backend := &captureBackend{}
+	r, srv := newTestReceiver(t, cfg, backend)
+
+	body := `{"streams":[{"stream":{"app":"web","env":"prod"},"values":[["1","first"],["2","second"]]}]}`
+	require.Equal(t, http.StatusNoContent, pushJSON(t, srv, nil, body).StatusCode)
+	assert.Empty(t, backend.snapshot(), "entries are only persisted until the spool is started")
+	require.Len(t, segmentFiles(t, spoolDir), 1)
+	assert.Positive(t, r.spool.size)
+
+	r.spool.start(r.processSpooled, backend.Name())
+	t.Cleanup(func() { _ = r.spool.close(context.Background()) })
+	require.Eventually(t, func() bool { return len(backend.snapshot()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	entries := backend.snapshot()
+	assert.Equal(t, "first", string(entries[0].LogLine))
+	assert.Equal(t, "second", string(entries[1].LogLine))
+	assert.Equal(t, "web", entries[0].App)
+	assert.Equal(t, "prod", entries[0].Labels["env"])
+
+	require.Eventually(t, func() bool { return len(segmentFiles(t, spoolDir)) == 0 }, 5*time.Second, 10*time.Millisecond,
+		"processed segments are deleted")
+	r.spool.mu.Lock()
+	assert.Zero(t, r.spool.size)
+	r.spool.mu.Unlock()
+}
+
+func TestReceiver_SpoolFullReturns503(t *testing.T) {
+	cfg := &config.Config{
+		PromtailHTTPAddr:              "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:      1024 * 1024,
+		PromtailHTTPSourceRoot:        t.TempDir(),
+		PromtailHTTPSpoolDir:          t.TempDir(),
+		PromtailHTTPSpoolMaxBytes:     64,
+		PromtailHTTPSpoolSegmentBytes: 64,
+	}
+	_, srv := newTestReceiver(t, cfg, &captureBackend{})
+
+	resp := pushJSON(t, srv, nil, `{"streams":[{"stream":{"app":"web"},"values":[["1","line"]]}]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Retry-After"))
+
+	resp, err := http.Post(srv.URL+"/ingest?app=web", "text/plain", strings.NewReader("line\n"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Retry-After"))
+}
+
+func TestReceiver_IngestIsSpooled(t *testing.T) {
+	spoolDir := t.TempDir()
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:              "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:      16,
+		PromtailHTTPSourceRoot:        sourceRoot,
+		PromtailHTTPSpoolDir:          spoolDir,
+		PromtailHTTPSpoolMaxBytes:     1024 * 1024,
+		PromtailHTTPSpoolSegmentBytes: 1024 * 1024,
+	}
+	backend := &captureBackend{}
+	r, srv := newTestReceiver(t, cfg, backend)
+
+	resp, err := http.Post(srv.URL+"/ingest?app=backup", "text/plain", strings.NewReader("first line\nsecond line\nthird"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, backend.snapshot(), "lines are only persisted until the spool is started")
+
+	r.spool.start(r.processSpooled, backend.Name())
+	t.Cleanup(func() { _ = r.spool.close(context.Background()) })
+	require.Eventually(t, func() bool { return len(backend.snapshot()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	entries := backend.snapshot()
+	for i, line := range []string{"first line", "second line", "third"} {
+		assert.Equal(t, line, string(entries[i].LogLine))
+		assert.Equal(t, "backup", entries[i].App)
+		assert.Equal(t, filepath.Join(sourceRoot, "ingest", "backup.log"), entries[i].SourcePath)
+		assert.False(t, entries[i].Timestamp.IsZero())
+	}
+	assert.Eventually(t, func() bool { return r.spool.processed.Load() == 2 }, 5*time.Second, 10*time.Millisecond,
+		"lines are spooled in records of up to the maximum body size")
+}
+
+func TestSpool_ReplaysSegmentsOnStartup(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1024*1024, 256, 0)
+	require.NoError(t, err)
+
+	first := []normalizedEntry{{
+		app:       "web",
+		source:    "/cache/promtail/web.log",
+		timestamp: time.Unix(0, 1700000000000000001).UTC(),
+		line:      []byte("first"),
+		fields:    map[string]interface{}{"count": int64(1) << 60, "attrs": map[string]interface{}{"list": []interface{}{true, 1.5}}},
+		labels:    map[string]string{"env": "prod"},
+	}}
+	second := []normalizedEntry{{app: "web", source: "/cache/promtail/web.log", line: make([]byte, 300)}}
+	require.NoError(t, s.append("team-a", first))
+	require.NoError(t, s.append("", second))
+	require.NoError(t, s.close(context.Background()))
+
+	segments := segmentFiles(t, dir)
+	require.Len(t, segments, 2, "a batch that does not fit into the active segment starts a new one")
+	// A crash while writing leaves a torn record behind, which was never acknowledged.
+	f, err := os.OpenFile(segments[1], os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = openSpool(dir, 1024*1024, 256, 0)
+	require.NoError(t, err)
+	process, calls := recordingProcess(func(call int, _ normalizedEntry) error {
+		if call == 0 {
+			return errors.New("backend unavailable")
+		}
+		return nil
+	})
+	s.start(process, "capture")
+	t.Cleanup(func() { _ = s.close(context.Background()) })
+
+	require.Eventually(t, func() bool { return len(calls()) == 3 }, 5*time.Second, 10*time.Millisecond,
+		"a failed batch is retried")
+	got := calls()
+	assert.Equal(t, "team-a", got[1].tenant)
+	assert.Equal(t, first, got[1].entries, "entries keep their fields and labels with their types")
+	assert.Equal(t, "", got[2].tenant)
+	assert.Len(t, got[2].entries[0].line, 300)
+	require.Eventually(t, func() bool { return len(segmentFiles(t, dir)) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.close(context.Background()))
+	require.NoError(t, s.append("team-a", first))
+	assert.Equal(t, filepath.Join(dir, "00000000000000000003"+spoolSegmentExt), segmentFiles(t, dir)[0],
+		"new segments continue after the replayed ones")
+}
+
+func TestSpool_RetriesOnlyFailedEntries(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 1024*1024, 1024*1024, 0)
+	require.NoError(t, err)
+	entries := []normalizedEntry{
+		{app: "web", line: []byte("one")},
+		{app: "web", line: []byte("two")},
+		{app: "api", line: []byte("three")},
+	}
+	require.NoError(t, s.append("", entries))
+
+	process, calls := recordingProcess(func(call int, entry normalizedEntry) error {
+		if call == 0 && string(entry.line) == "two" {
+			return errors.New("backend unavailable")
+		}
+		return nil
+	})
+	s.start(process, "capture")
+	t.Cleanup(func() { _ = s.close(context.Background()) })
+
+	require.Eventually(t, func() bool { return s.stats().Processed == 1 }, 5*time.Second, 10*time.Millisecond)
+	got := calls()
+	require.Len(t, got, 2)
+	assert.Len(t, got[0].entries, 3)
+	require.Len(t, got[1].entries, 1, "sent entries are not sent again")
+	assert.Equal(t, "two", string(got[1].entries[0].line))
+	assert.Zero(t, s.stats().DeadLettered)
+}
+
+func TestSpool_DeadLettersPermanentAndExhaustedFailures(t *testing.T) {
+	dlqPath := filepath.Join(t.TempDir(), "dlq.ndjson")
+	sink, err := deadletter.NewFileSink(dlqPath)
+	require.NoError(t, err)
+	deadletter.SetSink(sink)
+	defer deadletter.Close()
+
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1024*1024, 1024*1024, 2)
+	require.NoError(t, err)
+	require.NoError(t, s.append("team-a", []normalizedEntry{
+		{app: "web", source: "/cache/promtail/web.log", line: []byte("rejected")},
+		{app: "web", source: "/cache/promtail/web.log", line: []byte("unavailable")},
+		{app: "web", source: "/cache/promtail/web.log", line: []byte("sent")},
+	}))
+	require.NoError(t, s.append("team-a", []normalizedEntry{{app: "web", line: []byte("next")}}))
+
+	process, calls := recordingProcess(func(_ int, entry normalizedEntry) error {
+		switch string(entry.line) {
+		case "rejected":
+			return &backends.StatusError{StatusCode: http.StatusBadRequest, Body: "entry too far behind"}
+		case "unavailable":
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	s.start(process, "loki")
+	t.Cleanup(func() { _ = s.close(context.Background()) })
+
+	require.Eventually(t, func() bool { return s.stats().Processed == 2 }, 5*time.Second, 10*time.Millisecond,
+		"the spool moves on past a batch it gave up on")
+	got := calls()
+	require.Len(t, got, 3)
+	assert.Len(t, got[0].entries, 3)
+	require.Len(t, got[1].entries, 1, "permanent failures are not retried")
+	assert.Equal(t, "unavailable", string(got[1].entries[0].line))
+	assert.Equal(t, "next", string(got[2].entries[0].line))
+	require.Eventually(t, func() bool { return len(segmentFiles(t, dir)) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	stats := s.stats()
+	assert.Equal(t, uint64(2), stats.DeadLettered)
+	assert.Zero(t, stats.Bytes)
+	assert.Zero(t, stats.Segments)
+
+	deadletter.Close()
+	f, err := os.Open(dlqPath)
+	require.NoError(t, err)
+	defer f.Close()
+	var records []deadletter.Record
+	require.NoError(t, deadletter.ReadRecords(f, func(r deadletter.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	require.Len(t, records, 2, "each entry is dead-lettered once")
+	assert.Equal(t, "rejected", records[0].Line)
+	assert.Contains(t, records[0].Error, "400")
+	assert.Equal(t, "unavailable", records[1].Line)
+	assert.Equal(t, "connection refused", records[1].Error)
+	for _, record := range records {
+		assert.Equal(t, "team-a", record.Tenant)
+		assert.Equal(t, "web", record.App)
+		assert.Equal(t, "/cache/promtail/web.log", record.Source)
+		assert.Equal(t, "loki", record.Backend)
+	}
+}
+
+func TestReceiver_ProcessSpooledReportsFailedEntries(t *testing.T) {
+	for _, workers := range []int{0, 4} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			backendErr := errors.New("backend unavailable")
+			backend := &captureBackend{err: backendErr, failOn: "two"}
+			r, _ := newTestReceiver(t, &config.Config{PromtailHTTPAddr: "127.0.0.1:0", PromtailHTTPSourceRoot: t.TempDir()}, backend)
+			if workers > 0 {
+				pool := processor.NewWorkerPool(t.Context(), workers, 16)
+				t.Cleanup(pool.Shutdown)
+				r.SetWorkerPool(pool)
+			}
+
+			failed := r.processSpooled("", []normalizedEntry{
+				{app: "web", source: "/cache/promtail/web.log", line: []byte("one")},
+				{app: "web", source: "/cache/promtail/web.log", line: []byte("two")},
+				{app: "api", source: "/cache/promtail/api.log", line: []byte("three")},
+				{app: "web", source: "/cache/promtail/web.log", line: []byte("four")},
+			})
+
+			assert.Equal(t, map[int]error{1: backendErr}, failed)
+			assert.Len(t, backend.snapshot(), 4, "entries after a failure are still sent")
+		})
+	}
+}
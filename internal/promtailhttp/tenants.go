@@ -0,0 +1,223 @@
+package promtailhttp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	orgIDHeader = "X-Scope-OrgID"
+	// anyOrgID in a tenant's org_ids allows it to push for every tenant, e.g. for a trusted gateway.
+	anyOrgID = "*"
+)
+
+var (
+	// tenantIDPattern follows Loki's tenant ID rules, so every tenant is also a valid Loki tenant and path segment.
+	tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9!_.*'()-]{1,150}$`)
+
+	errUnauthorized    = errors.New("unauthorized")
+	errTenantForbidden = errors.New("tenant not allowed for these credentials")
+)
+
+// tenantConfig is one entry of the tenants file. A request that presents one of the credentials belongs to ID,
+// or to the tenant named by X-Scope-OrgID if OrgIDs allows it.
+type tenantConfig struct {
+	ID          string            `json:"id"`
+	Tokens      []string          `json:"tokens"`
+	BasicAuth   map[string]string `json:"basic_auth"`
+	ClientNames []string          `json:"client_names"`
+	OrgIDs      []string          `json:"org_ids"`
+	// Limits overrides the default tenant rate limit for this tenant.
+	Limits rateLimit `json:"limits"`
+}
+
+type tenantTable struct {
+	Tenants []*tenantConfig `json:"tenants"`
+}
+
+// requestScope is what a request's credentials grant: the tenant its entries belong to, if any, and the
+// identity of its client certificate.
+type requestScope struct {
+	tenant   string
+	identity clientIdentity
+}
+
+// sourceRoot returns the directory the scope's source paths are rooted under. Tenants get their own
+// subdirectory, so they cannot write into each other's files.
+func (s requestScope) sourceRoot(root string) string {
+	if s.tenant == "" {
+		return root
+	}
+	return filepath.Join(root, s.tenant)
+}
+
+func loadTenantTable(path, globalToken string) (*tenantTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+
+	var table tenantTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file %s: %w", path, err)
+	}
+	if err := table.validate(globalToken); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+	return &table, nil
+}
+
+// validate checks the table; globalToken is the receiver's bearer token, which no tenant may use.
+func (t *tenantTable) validate(globalToken string) error {
+	if len(t.Tenants) == 0 {
+		return fmt.Errorf("no tenants defined")
+	}
+
+	ids := make(map[string]struct{}, len(t.Tenants))
+	tokens := map[string]struct{}{globalToken: {}}
+	users := make(map[string]struct{})
+	clientNames := make(map[string]struct{})
+	for i, tenant := range t.Tenants {
+		if tenant == nil || !validTenantID(tenant.ID) {
+			return fmt.Errorf("tenant %d: invalid id", i)
+		}
+		if _, ok := ids[tenant.ID]; ok {
+			return fmt.Errorf("tenant %q is defined twice", tenant.ID)
+		}
+		ids[tenant.ID] = struct{}{}
+
+		if len(tenant.Tokens) == 0 && len(tenant.BasicAuth) == 0 && len(tenant.ClientNames) == 0 {
+			return fmt.Errorf("tenant %q has no tokens, basic_auth or client_names", tenant.ID)
+		}
+		for _, token := range tenant.Tokens {
+			if token == "" {
+				return fmt.Errorf("tenant %q has an empty token", tenant.ID)
+			}
+			if _, ok := tokens[token]; ok {
+				return fmt.Errorf("tenant %q uses a token of another tenant or PROMTAIL_HTTP_BEARER_TOKEN", tenant.ID)
+			}
+			tokens[token] = struct{}{}
+		}
+		for user, password := range tenant.BasicAuth {
+			if user == "" || password == "" {
+				return fmt.Errorf("tenant %q has basic_auth credentials with an empty user or password", tenant.ID)
+			}
+			if _, ok := users[user]; ok {
+				return fmt.Errorf("tenant %q uses basic_auth user %q of another tenant", tenant.ID, user)
+			}
+			users[user] = struct{}{}
+		}
+		for _, name := range tenant.ClientNames {
+			if _, ok := clientNames[name]; ok || name == "" {
+				return fmt.Errorf("tenant %q uses an empty client name or one of another tenant", tenant.ID)
+			}
+			clientNames[name] = struct{}{}
+		}
+		if tenant.Limits.BytesPerSecond < 0 || tenant.Limits.BurstBytes < 0 || tenant.Limits.LinesPerSecond < 0 || tenant.Limits.BurstLines < 0 {
+			return fmt.Errorf("tenant %q has negative limits", tenant.ID)
+		}
+		for _, orgID := range tenant.OrgIDs {
+			if orgID != anyOrgID && !validTenantID(orgID) {
+				return fmt.Errorf("tenant %q has an invalid org_id %q", tenant.ID, orgID)
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the tenant whose credentials the request presents. Authorization header credentials are
+// checked before the client certificate.
+func (t *tenantTable) lookup(req *http.Request, id clientIdentity) *tenantConfig {
+	authHeader := req.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		token := []byte(strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer ")))
+		var match *tenantConfig
+		// Compare against every token, so the time taken does not reveal which tenant a token is close to.
+		for _, tenant := range t.Tenants {
+			for _, candidate := range tenant.Tokens {
+				if subtle.ConstantTimeCompare(token, []byte(candidate)) == 1 {
+					match = tenant
+				}
+			}
+		}
+		return match
+	}
+	if user, password, ok := req.BasicAuth(); ok {
+		for _, tenant := range t.Tenants {
+			if expected, ok := tenant.BasicAuth[user]; ok {
+				if subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+					return tenant
+				}
+				return nil
+			}
+		}
+		return nil
+	}
+	if authHeader != "" {
+		return nil
+	}
+
+	for _, tenant := range t.Tenants {
+		for _, name := range tenant.ClientNames {
+			if (id.cn != "" && name == id.cn) || (id.san != "" && name == id.san) {
+				return tenant
+			}
+		}
+	}
+	return nil
+}
+
+// tenantFor returns the tenant a request of c pushes for: the X-Scope-OrgID header if c may use it, else c's ID.
+func (c *tenantConfig) tenantFor(orgID string) (string, error) {
+	if orgID == "" || orgID == c.ID {
+		return c.ID, nil
+	}
+	if !validTenantID(orgID) {
+		return "", errTenantForbidden
+	}
+	for _, allowed := range c.OrgIDs {
+		if allowed == anyOrgID || allowed == orgID {
+			return orgID, nil
+		}
+	}
+	return "", errTenantForbidden
+}
+
+func validTenantID(id string) bool {
+	return id != "." && id != ".." && tenantIDPattern.MatchString(id)
+}
+
+// authenticate checks the request's credentials and returns its scope. Without a tenants file the single
+// bearer token, if configured, is required and requests have no tenant. With a tenants file, the bearer token
+// still authenticates requests without a tenant, and every other request must present a tenant's credentials.
+func (r *Receiver) authenticate(req *http.Request) (requestScope, int, error) {
+	scope := requestScope{identity: requestClientIdentity(req)}
+	if r.tenants == nil {
+		if !r.isAuthorized(req) {
+			return scope, http.StatusUnauthorized, errUnauthorized
+		}
+		return scope, http.StatusOK, nil
+	}
+	if r.bearerToken != "" && r.isAuthorized(req) {
+		return scope, http.StatusOK, nil
+	}
+
+	tenant := r.tenants.lookup(req, scope.identity)
+	if tenant == nil {
+		return scope, http.StatusUnauthorized, errUnauthorized
+	}
+	id, err := tenant.tenantFor(strings.TrimSpace(req.Header.Get(orgIDHeader)))
+	if err != nil {
+		return scope, http.StatusForbidden, err
+	}
+	scope.tenant = id
+	return scope, http.StatusOK, nil
+}
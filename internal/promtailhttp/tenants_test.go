@@ -0,0 +1,202 @@
+package promtailhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"log-enricher/internal/config"
+	"log-enricher/internal/models"
+	"log-enricher/internal/pipeline"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tenantPipelineManager returns pipelines that record which tenant's pipeline processed an entry.
+type tenantPipelineManager struct{}
+
+func (m *tenantPipelineManager) GetProcessPipeline(filePath string) pipeline.ProcessPipeline {
+	return m.GetTenantProcessPipeline("", filePath)
+}
+
+func (m *tenantPipelineManager) GetTenantProcessPipeline(tenant, filePath string) pipeline.ProcessPipeline {
+	return tenantPipeline(tenant)
+}
+
+type tenantPipeline string
+
+func (p tenantPipeline) Process(entry *models.LogEntry) bool {
+	entry.Fields["pipeline"] = string(p)
+	return true
+}
+
+const testTenantsFile = `{"tenants": [
+	{"id": "team-a", "tokens": ["token-a"], "org_ids": ["team-a-dev"]},
+	{"id": "team-b", "basic_auth": {"bob": "secret-b"}},
+	{"id": "gateway", "tokens": ["token-gw"], "org_ids": ["*"]}
+]}`
+
+func writeTenantsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestReceiver_TenantsFromCredentialsAndOrgID(t *testing.T) {
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 1024 * 1024,
+		PromtailHTTPBearerToken:  "admin",
+		PromtailHTTPSourceRoot:   sourceRoot,
+		PromtailHTTPTenantsFile:  writeTenantsFile(t, testTenantsFile),
+	}
+	backend := &captureBackend{}
+	r, err := NewReceiver(cfg, &tenantPipelineManager{}, backend)
+	require.NoError(t, err)
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	body := `{"streams":[{"stream":{"app":"web","filename":"/var/log/app.log"},"values":[["1","line"]]}]}`
+	tests := []struct {
+		name       string
+		auth       func(req *http.Request)
+		orgID      string
+		want       int
+		wantTenant string
+	}{
+		{name: "tenant token", auth: bearer("token-a"), want: http.StatusNoContent, wantTenant: "team-a"},
+		{name: "allowed org ID", auth: bearer("token-a"), orgID: "team-a-dev", want: http.StatusNoContent, wantTenant: "team-a-dev"},
+		{name: "own tenant as org ID", auth: bearer("token-a"), orgID: "team-a", want: http.StatusNoContent, wantTenant: "team-a"},
+		{name: "other tenant's org ID", auth: bearer("token-a"), orgID: "team-b", want: http.StatusForbidden},
+		{name: "basic auth", auth: basicAuth("bob", "secret-b"), want: http.StatusNoContent, wantTenant: "team-b"},
+		{name: "wrong basic auth password", auth: basicAuth("bob", "secret-a"), want: http.StatusUnauthorized},
+		{name: "gateway for any tenant", auth: bearer("token-gw"), orgID: "team-c", want: http.StatusNoContent, wantTenant: "team-c"},
+		{name: "invalid org ID", auth: bearer("token-gw"), orgID: "../team-a", want: http.StatusForbidden},
+		{name: "global token has no tenant", auth: bearer("admin"), orgID: "team-a", want: http.StatusNoContent, wantTenant: ""},
+		{name: "unknown token", auth: bearer("token-x"), want: http.StatusUnauthorized},
+		{name: "no credentials", auth: func(*http.Request) {}, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(backend.snapshot())
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/loki/api/v1/push", strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", jsonContentType)
+			tt.auth(req)
+			if tt.orgID != "" {
+				req.Header.Set(orgIDHeader, tt.orgID)
+			}
+
+			resp, err := srv.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tt.want, resp.StatusCode)
+
+			entries := backend.snapshot()[before:]
+			if tt.want != http.StatusNoContent {
+				assert.Empty(t, entries)
+				return
+			}
+			require.Len(t, entries, 1)
+			assert.Equal(t, tt.wantTenant, entries[0].Tenant)
+			assert.Equal(t, tt.wantTenant, entries[0].Fields["pipeline"], "the tenant selects the pipeline")
+			assert.Equal(t, filepath.Join(sourceRoot, tt.wantTenant, "var", "log", "app.log"), entries[0].SourcePath)
+		})
+	}
+}
+
+func TestReceiver_TenantOnIngestAndOTLP(t *testing.T) {
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:         "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes: 1024 * 1024,
+		PromtailHTTPSourceRoot:   sourceRoot,
+		PromtailHTTPTenantsFile:  writeTenantsFile(t, testTenantsFile),
+	}
+	backend := &captureBackend{}
+	r, err := NewReceiver(cfg, &tenantPipelineManager{}, backend)
+	require.NoError(t, err)
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path, contentType, body string
+	}{
+		{"/ingest?app=backup", "text/plain", "ingested"},
+		{"/v1/logs", jsonContentType, `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"exported"}}]}]}]}`},
+	} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+tc.path, bytes.NewReader([]byte(tc.body)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", tc.contentType)
+		basicAuth("bob", "secret-b")(req)
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Less(t, resp.StatusCode, 300, tc.path)
+
+		req.Header.Del("Authorization")
+		resp, err = srv.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, tc.path)
+	}
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "team-b", entries[0].Tenant)
+	assert.Equal(t, filepath.Join(sourceRoot, "team-b", "ingest", "backup.log"), entries[0].SourcePath)
+	assert.Equal(t, "team-b", entries[1].Tenant)
+	assert.Equal(t, filepath.Join(sourceRoot, "team-b", "otlp", "otlp.log"), entries[1].SourcePath)
+}
+
+func TestNewReceiver_TenantsFileValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid JSON", content: `{"tenants":`},
+		{name: "no tenants", content: `{"tenants": []}`},
+		{name: "invalid id", content: `{"tenants": [{"id": "../a", "tokens": ["t"]}]}`},
+		{name: "duplicate id", content: `{"tenants": [{"id": "a", "tokens": ["t1"]}, {"id": "a", "tokens": ["t2"]}]}`},
+		{name: "no credentials", content: `{"tenants": [{"id": "a"}]}`},
+		{name: "shared token", content: `{"tenants": [{"id": "a", "tokens": ["t"]}, {"id": "b", "tokens": ["t"]}]}`},
+		{name: "global token", content: `{"tenants": [{"id": "a", "tokens": ["admin"]}]}`},
+		{name: "shared basic auth user", content: `{"tenants": [{"id": "a", "basic_auth": {"u": "p1"}}, {"id": "b", "basic_auth": {"u": "p2"}}]}`},
+		{name: "invalid org ID", content: `{"tenants": [{"id": "a", "tokens": ["t"], "org_ids": ["a/b"]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				PromtailHTTPAddr:        "127.0.0.1:0",
+				PromtailHTTPBearerToken: "admin",
+				PromtailHTTPTenantsFile: writeTenantsFile(t, tt.content),
+			}
+			_, err := NewReceiver(cfg, &stubPipelineManager{}, &captureBackend{})
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := NewReceiver(&config.Config{PromtailHTTPTenantsFile: filepath.Join(t.TempDir(), "missing.json")}, &stubPipelineManager{}, &captureBackend{})
+	assert.Error(t, err)
+}
+
+func bearer(token string) func(req *http.Request) {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func basicAuth(user, password string) func(req *http.Request) {
+	return func(req *http.Request) {
+		req.SetBasicAuth(user, password)
+	}
+}
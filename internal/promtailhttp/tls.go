@@ -0,0 +1,169 @@
+package promtailhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Labels that carry the verified client certificate's identity into the app and source mapping.
+	clientCNLabel  = "__tls_client_cn"
+	clientSANLabel = "__tls_client_san"
+	// tlsReloadInterval limits how often the certificate files are checked for changes.
+	tlsReloadInterval = 5 * time.Second
+)
+
+// tlsReloader serves the receiver's TLS configuration and reloads the certificate, key and client CA
+// files when they change on disk. A reload that fails keeps the previous configuration.
+type tlsReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modTimes [3]time.Time
+	checked  time.Time
+}
+
+func newTLSReloader(certFile, keyFile, caFile string) (*tlsReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS requires both a certificate and a key file")
+	}
+	l := &tlsReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		interval: tlsReloadInterval,
+	}
+	modTimes, err := l.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := l.load(modTimes); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// getConfigForClient is used as tls.Config.GetConfigForClient, so every handshake uses the latest files.
+func (l *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.Sub(l.checked) >= l.interval {
+		l.checked = now
+		modTimes, err := l.stat()
+		if err != nil {
+			slog.Error("Failed to check Promtail HTTP TLS files", "error", err)
+		} else if modTimes != l.modTimes {
+			if err := l.load(modTimes); err != nil {
+				slog.Error("Failed to reload Promtail HTTP TLS files, keeping the previous certificate", "error", err)
+			} else {
+				slog.Info("Reloaded Promtail HTTP TLS files", "cert_file", l.certFile)
+			}
+		}
+	}
+	return l.config, nil
+}
+
+// load must be called with mu held or before the reloader is shared.
+func (l *tlsReloader) load(modTimes [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if l.caFile != "" {
+		pem, err := os.ReadFile(l.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in TLS client CA file %s", l.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	l.config = config
+	l.modTimes = modTimes
+	return nil
+}
+
+func (l *tlsReloader) stat() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, path := range []string{l.certFile, l.keyFile, l.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// clientIdentity is the identity of a request's verified client certificate.
+type clientIdentity struct {
+	cn  string
+	san string
+}
+
+// requestClientIdentity returns the common name and first subject alternative name (DNS name, email, URI or
+// IP, in that order) of the request's verified client certificate. Both are empty without mutual TLS.
+func requestClientIdentity(req *http.Request) clientIdentity {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return clientIdentity{}
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+
+	id := clientIdentity{cn: cert.Subject.CommonName}
+	switch {
+	case len(cert.DNSNames) > 0:
+		id.san = cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		id.san = cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		id.san = cert.URIs[0].String()
+	case len(cert.IPAddresses) > 0:
+		id.san = cert.IPAddresses[0].String()
+	}
+	return id
+}
+
+// mappingLabels returns the labels used to derive app and source: the stream labels with the identity labels
+// replaced by the verified certificate's, so clients cannot claim another identity through pushed labels.
+func (id clientIdentity) mappingLabels(labels map[string]string) map[string]string {
+	_, hasCN := labels[clientCNLabel]
+	_, hasSAN := labels[clientSANLabel]
+	if id.cn == "" && id.san == "" && !hasCN && !hasSAN {
+		return labels
+	}
+
+	result := cloneLabels(labels)
+	delete(result, clientCNLabel)
+	delete(result, clientSANLabel)
+	if id.cn != "" {
+		result[clientCNLabel] = id.cn
+	}
+	if id.san != "" {
+		result[clientSANLabel] = id.san
+	}
+	return result
+}
@@ -0,0 +1,229 @@
+package promtailhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+}
+
+// newTestCert issues a certificate signed by parent, or a self-signed CA when parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, pair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600))
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600))
+}
+
+func startTLSReceiver(t *testing.T, cfg *config.Config, backend *captureBackend, reloadInterval time.Duration) *Receiver {
+	t.Helper()
+	r, err := NewReceiver(cfg, &stubPipelineManager{}, backend)
+	require.NoError(t, err)
+	r.tls.interval = reloadInterval
+	require.NoError(t, r.Start())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = r.Shutdown(ctx)
+	})
+	return r
+}
+
+func tlsClient(roots *x509.CertPool, certs ...tls.Certificate) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func TestReceiver_MutualTLSClientIdentityAsApp(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test-ca"}}, nil)
+	server := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "receiver"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "shipper-1"},
+		DNSNames:    []string{"shipper-1.example"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	server.write(t, certFile, keyFile)
+	ca.write(t, caFile, "")
+
+	sourceRoot := t.TempDir()
+	cfg := &config.Config{
+		PromtailHTTPAddr:            "127.0.0.1:0",
+		PromtailHTTPMaxBodyBytes:    1024 * 1024,
+		PromtailHTTPSourceRoot:      sourceRoot,
+		PromtailHTTPAppTemplate:     "{{.__tls_client_cn}}",
+		PromtailHTTPSourceTemplate:  "{{.__tls_client_san}}/{{.job}}.log",
+		PromtailHTTPTLSCertFile:     certFile,
+		PromtailHTTPTLSKeyFile:      keyFile,
+		PromtailHTTPTLSClientCAFile: caFile,
+	}
+	backend := &captureBackend{}
+	r := startTLSReceiver(t, cfg, backend, tlsReloadInterval)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	body, err := json.Marshal(map[string]any{
+		"streams": []any{map[string]any{
+			"stream": map[string]string{"job": "nginx", "__tls_client_cn": "someone-else"},
+			"values": [][]any{{strconvFormatInt(time.Now().UnixNano()), "over mTLS"}},
+		}},
+	})
+	require.NoError(t, err)
+
+	resp, err := tlsClient(roots, client.pair).Post("https://"+r.Addr()+"/loki/api/v1/push", jsonContentType, bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	entries := backend.snapshot()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "shipper-1", entries[0].App, "pushed labels cannot override the certificate identity")
+	assert.Equal(t, filepath.Join(sourceRoot, "shipper-1.example", "nginx.log"), entries[0].SourcePath)
+
+	_, err = tlsClient(roots).Post("https://"+r.Addr()+"/loki/api/v1/push", jsonContentType, bytes.NewReader(body))
+	assert.Error(t, err, "clients without a certificate are rejected")
+	resp, err = http.Post("http://"+r.Addr()+"/loki/api/v1/push", jsonContentType, bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "plain HTTP is not served")
+	assert.Len(t, backend.snapshot(), 1)
+}
+
+func TestReceiver_TLSCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test-ca"}}, nil)
+	serverTemplate := func(cn string) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: cn},
+			IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	newTestCert(t, serverTemplate("first"), ca).write(t, certFile, keyFile)
+
+	cfg := &config.Config{
+		PromtailHTTPAddr:        "127.0.0.1:0",
+		PromtailHTTPSourceRoot:  t.TempDir(),
+		PromtailHTTPTLSCertFile: certFile,
+		PromtailHTTPTLSKeyFile:  keyFile,
+	}
+	r := startTLSReceiver(t, cfg, &captureBackend{}, 0)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	servedCN := func() string {
+		resp, err := tlsClient(roots).Get("https://" + r.Addr() + "/ready")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "first", servedCN())
+
+	// A broken key keeps the previous certificate.
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	assert.Equal(t, "first", servedCN())
+
+	newTestCert(t, serverTemplate("second"), ca).write(t, certFile, keyFile)
+	later := time.Now().Add(2 * time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	assert.Equal(t, "second", servedCN())
+}
+
+func TestNewReceiver_TLSValidation(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test-ca"}}, nil)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ca.write(t, certFile, keyFile)
+	emptyCA := filepath.Join(dir, "empty.crt")
+	require.NoError(t, os.WriteFile(emptyCA, nil, 0o600))
+
+	tests := []struct {
+		name                     string
+		certFile, keyFile, caCrt string
+	}{
+		{name: "certificate without key", certFile: certFile},
+		{name: "client CA without certificate", caCrt: certFile},
+		{name: "missing key file", certFile: certFile, keyFile: filepath.Join(dir, "missing.key")},
+		{name: "client CA without certificates", certFile: certFile, keyFile: keyFile, caCrt: emptyCA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				PromtailHTTPAddr:            "127.0.0.1:0",
+				PromtailHTTPTLSCertFile:     tt.certFile,
+				PromtailHTTPTLSKeyFile:      tt.keyFile,
+				PromtailHTTPTLSClientCAFile: tt.caCrt,
+			}
+			_, err := NewReceiver(cfg, &stubPipelineManager{}, &captureBackend{})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -28,25 +28,42 @@ func (f *FileState) IncrementLineNumber() {
 	f.LineNumber++
 }
 
+// AddLineNumber advances the line number by n, e.g. for an entry combined from n lines.
+func (f *FileState) AddLineNumber(n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.LineNumber += n
+}
+
 func (f *FileState) GetLineNumber() int64 {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 	return f.LineNumber
 }
 
+// BackfillState is the progress of one file of a backfill.
+type BackfillState struct {
+	Size    int64 `json:"size"`     // File size when the progress was recorded
+	ModTime int64 `json:"mod_time"` // Unix nanoseconds; with Size, identifies the file content
+	Lines   int64 `json:"lines"`    // Lines already processed
+	Done    bool  `json:"done,omitempty"`
+}
+
 // AppState holds all persistent state for the application
 type AppState struct {
-	Files  map[string]*FileState     `json:"files"`
-	Caches map[string]map[string]any `json:"caches"`
-	Cache  map[string]models.Result  `json:"cache"`
-	mu     sync.RWMutex
+	Files    map[string]*FileState     `json:"files"`
+	Caches   map[string]map[string]any `json:"caches"`
+	Cache    map[string]models.Result  `json:"cache"`
+	Backfill map[string]BackfillState  `json:"backfill,omitempty"`
+	mu       sync.RWMutex
 }
 
 func newAppState() *AppState {
 	return &AppState{
-		Files:  make(map[string]*FileState),
-		Caches: make(map[string]map[string]any),
-		Cache:  make(map[string]models.Result),
+		Files:    make(map[string]*FileState),
+		Caches:   make(map[string]map[string]any),
+		Cache:    make(map[string]models.Result),
+		Backfill: make(map[string]BackfillState),
 	}
 }
 
@@ -59,6 +76,7 @@ func resetGlobalState() {
 	globalState.Files = make(map[string]*FileState)
 	globalState.Caches = make(map[string]map[string]any)
 	globalState.Cache = make(map[string]models.Result)
+	globalState.Backfill = make(map[string]BackfillState)
 }
 
 func snapshotState() *AppState {
@@ -91,6 +109,10 @@ func snapshotState() *AppState {
 		snapshot.Cache[ip] = result
 	}
 
+	for path, progress := range globalState.Backfill {
+		snapshot.Backfill[path] = progress
+	}
+
 	return snapshot
 }
 
@@ -129,11 +151,15 @@ func Load(path string) error {
 	if loadedState.Cache == nil {
 		loadedState.Cache = make(map[string]models.Result)
 	}
+	if loadedState.Backfill == nil {
+		loadedState.Backfill = make(map[string]BackfillState)
+	}
 
 	globalState.mu.Lock()
 	globalState.Files = loadedState.Files
 	globalState.Caches = loadedState.Caches
 	globalState.Cache = loadedState.Cache
+	globalState.Backfill = loadedState.Backfill
 	filesCount := len(globalState.Files)
 	cacheEntries := len(globalState.Cache)
 	globalState.mu.Unlock()
@@ -266,6 +292,23 @@ func FindMatchingPosition(path string, storedState *FileState) (int64, bool) {
 	return 0, false
 }
 
+// --- Backfill Functions ---
+
+// GetBackfillState returns the recorded progress of a backfilled file.
+func GetBackfillState(path string) (BackfillState, bool) {
+	globalState.mu.RLock()
+	defer globalState.mu.RUnlock()
+	progress, ok := globalState.Backfill[path]
+	return progress, ok
+}
+
+// SetBackfillState records the progress of a backfilled file.
+func SetBackfillState(path string, progress BackfillState) {
+	globalState.mu.Lock()
+	defer globalState.mu.Unlock()
+	globalState.Backfill[path] = progress
+}
+
 // --- Cache Functions ---
 
 func GetCacheEntries(name string) (map[string]any, bool) {
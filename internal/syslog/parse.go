@@ -0,0 +1,311 @@
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Message is a parsed syslog message. Fields that are absent ("-" in RFC 5424) are empty.
+type Message struct {
+	Format    string // "rfc5424", "rfc3164" or "" if the message had no valid header.
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	// StructuredData maps SD-IDs to their parameters (RFC 5424 only).
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+// Fields returns the message metadata as LogEntry fields.
+func (m *Message) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"message": m.Message,
+	}
+	if m.Format != "" {
+		fields["facility"] = facilityName(m.Facility)
+		fields["severity"] = SeverityName(m.Severity)
+	}
+	for key, value := range map[string]string{
+		"hostname": m.Hostname,
+		"app_name": m.AppName,
+		"procid":   m.ProcID,
+		"msgid":    m.MsgID,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	if len(m.StructuredData) > 0 {
+		sd := make(map[string]interface{}, len(m.StructuredData))
+		for id, params := range m.StructuredData {
+			values := make(map[string]interface{}, len(params))
+			for k, v := range params {
+				values[k] = v
+			}
+			sd[id] = values
+		}
+		fields["structured_data"] = sd
+	}
+	return fields
+}
+
+func facilityName(facility int) string {
+	if facility >= 0 && facility < len(facilityNames) {
+		return facilityNames[facility]
+	}
+	return strconv.Itoa(facility)
+}
+
+// SeverityName returns the keyword of a syslog severity (0-7), e.g. "err" for 3, or the number for other values.
+func SeverityName(severity int) string {
+	if severity >= 0 && severity < len(severityNames) {
+		return severityNames[severity]
+	}
+	return strconv.Itoa(severity)
+}
+
+var errNoPriority = errors.New("missing <PRI> header")
+
+// Parse parses an RFC 5424 or RFC 3164 message. now is used to complete RFC 3164 timestamps, which have no year.
+// Messages without a valid header are returned with the whole input as Message and a non-nil error.
+func Parse(raw []byte, now time.Time) (*Message, error) {
+	raw = bytes.TrimRight(raw, "\r\n\x00")
+
+	pri, rest, err := parsePriority(raw)
+	if err != nil {
+		return headerlessMessage(raw), err
+	}
+
+	msg := &Message{Facility: pri / 8, Severity: pri % 8}
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && (rest[1] == ' ' || (len(rest) > 2 && isDigit(rest[1]) && rest[2] == ' ')) {
+		if err := parse5424(msg, rest); err != nil {
+			return headerlessMessage(raw), err
+		}
+		msg.Format = "rfc5424"
+		return msg, nil
+	}
+
+	parse3164(msg, rest, now)
+	msg.Format = "rfc3164"
+	return msg, nil
+}
+
+// headerlessMessage keeps the whole input as content with the user.notice priority RFC 3164 assumes for it.
+func headerlessMessage(raw []byte) *Message {
+	return &Message{Facility: 1, Severity: 5, Message: string(raw)}
+}
+
+func parsePriority(raw []byte) (int, []byte, error) {
+	if len(raw) < 3 || raw[0] != '<' {
+		return 0, nil, errNoPriority
+	}
+	end := bytes.IndexByte(raw[:min(len(raw), 5)], '>')
+	if end < 2 {
+		return 0, nil, errNoPriority
+	}
+	pri, err := strconv.Atoi(string(raw[1:end]))
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, nil, fmt.Errorf("invalid priority %q", raw[1:end])
+	}
+	return pri, raw[end+1:], nil
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// parse5424 parses "VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP SD [SP MSG]".
+func parse5424(msg *Message, rest []byte) error {
+	var fields [6]string
+	for i := range fields {
+		idx := bytes.IndexByte(rest, ' ')
+		if idx < 0 {
+			return fmt.Errorf("truncated RFC 5424 header")
+		}
+		fields[i] = string(rest[:idx])
+		rest = rest[idx+1:]
+	}
+	if fields[0] != "1" {
+		return fmt.Errorf("unsupported syslog version %s", fields[0])
+	}
+
+	if fields[1] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid RFC 5424 timestamp: %w", err)
+		}
+		msg.Timestamp = ts
+	}
+	msg.Hostname = nilValue(fields[2])
+	msg.AppName = nilValue(fields[3])
+	msg.ProcID = nilValue(fields[4])
+	msg.MsgID = nilValue(fields[5])
+
+	sd, rest, err := parseStructuredData(rest)
+	if err != nil {
+		return err
+	}
+	msg.StructuredData = sd
+
+	if len(rest) > 0 && rest[0] == ' ' {
+		rest = rest[1:]
+	}
+	msg.Message = string(bytes.TrimPrefix(rest, []byte("\xEF\xBB\xBF")))
+	return nil
+}
+
+func nilValue(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// parseStructuredData parses "-" or one or more "[SD-ID *(SP PARAM-NAME="PARAM-VALUE")]" elements.
+func parseStructuredData(data []byte) (map[string]map[string]string, []byte, error) {
+	if len(data) > 0 && data[0] == '-' {
+		return nil, data[1:], nil
+	}
+	if len(data) == 0 || data[0] != '[' {
+		return nil, nil, fmt.Errorf("invalid RFC 5424 structured data")
+	}
+
+	result := make(map[string]map[string]string)
+	for len(data) > 0 && data[0] == '[' {
+		data = data[1:]
+		end := bytes.IndexAny(data, " ]")
+		if end <= 0 {
+			return nil, nil, fmt.Errorf("invalid structured data element")
+		}
+		id := string(data[:end])
+		params := make(map[string]string)
+		data = data[end:]
+
+		for len(data) > 0 && data[0] == ' ' {
+			data = data[1:]
+			eq := bytes.IndexByte(data, '=')
+			if eq <= 0 || len(data) < eq+2 || data[eq+1] != '"' {
+				return nil, nil, fmt.Errorf("invalid structured data parameter in %s", id)
+			}
+			name := string(data[:eq])
+			value, remaining, err := parseParamValue(data[eq+2:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid value for %s in %s: %w", name, id, err)
+			}
+			params[name] = value
+			data = remaining
+		}
+
+		if len(data) == 0 || data[0] != ']' {
+			return nil, nil, fmt.Errorf("unterminated structured data element %s", id)
+		}
+		data = data[1:]
+		result[id] = params
+	}
+	return result, data, nil
+}
+
+// parseParamValue reads a quoted value up to the closing quote, resolving the \" \\ and \] escapes.
+func parseParamValue(data []byte) (string, []byte, error) {
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '\\':
+			if i+1 < len(data) && (data[i+1] == '"' || data[i+1] == '\\' || data[i+1] == ']') {
+				b.WriteByte(data[i+1])
+				i++
+				continue
+			}
+			b.WriteByte(c)
+		case '"':
+			return b.String(), data[i+1:], nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", nil, fmt.Errorf("missing closing quote")
+}
+
+// parse3164 parses "TIMESTAMP HOSTNAME TAG[PID]: MSG" as leniently as the many real-world variants require.
+// Parts that cannot be recognised are left in Message.
+func parse3164(msg *Message, rest []byte, now time.Time) {
+	line := string(rest)
+
+	// Classic timestamps are exactly len("Jan _2 15:04:05") long; RFC 3339 ones end at the first space.
+	if len(line) >= len(time.Stamp) {
+		if ts, err := time.ParseInLocation(time.Stamp, line[:len(time.Stamp)], now.Location()); err == nil {
+			msg.Timestamp = completeYear(ts, now)
+			line = strings.TrimPrefix(line[len(time.Stamp):], " ")
+		}
+	}
+	if msg.Timestamp.IsZero() {
+		if token, remaining, ok := strings.Cut(line, " "); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, token); err == nil {
+				msg.Timestamp = ts
+				line = remaining
+			}
+		}
+	}
+
+	// The hostname is omitted by some senders, in which case the first token is already the tag.
+	// Without a timestamp the header is not trusted to contain a hostname at all.
+	if token, remaining, ok := strings.Cut(line, " "); ok && !msg.Timestamp.IsZero() && !isTag(token) {
+		msg.Hostname = token
+		line = remaining
+	}
+
+	if token, remaining, ok := strings.Cut(line, " "); ok && isTag(token) {
+		tag := strings.TrimSuffix(token, ":")
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			msg.ProcID = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		msg.AppName = tag
+		line = remaining
+	}
+
+	msg.Message = line
+}
+
+// isTag reports whether token looks like "app:" or "app[pid]:".
+func isTag(token string) bool {
+	if !strings.HasSuffix(token, ":") || len(token) < 2 {
+		return false
+	}
+	name := strings.TrimSuffix(token, ":")
+	if open := strings.IndexByte(name, '['); open > 0 && strings.HasSuffix(name, "]") {
+		name = name[:open]
+	}
+	for _, r := range name {
+		if r == ' ' || r == '[' || r == ']' || r == ':' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// completeYear adds the current year to a timestamp without one, moving it to the previous year
+// if that would put it more than a day in the future (e.g. a December message received in January).
+func completeYear(ts, now time.Time) time.Time {
+	ts = time.Date(now.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), now.Location())
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts
+}
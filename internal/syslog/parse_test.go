@@ -0,0 +1,126 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var parseNow = time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+func TestParse_RFC5424(t *testing.T) {
+	raw := `<165>1 2026-03-10T11:22:33.123Z router-1 evntslog 4242 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication\]"][meta seq="7"] ` + "\xEF\xBB\xBF" + "An application event\n"
+
+	msg, err := Parse([]byte(raw), parseNow)
+	require.NoError(t, err)
+
+	assert.Equal(t, "rfc5424", msg.Format)
+	assert.Equal(t, 20, msg.Facility)
+	assert.Equal(t, 5, msg.Severity)
+	assert.Equal(t, time.Date(2026, time.March, 10, 11, 22, 33, 123000000, time.UTC), msg.Timestamp)
+	assert.Equal(t, "router-1", msg.Hostname)
+	assert.Equal(t, "evntslog", msg.AppName)
+	assert.Equal(t, "4242", msg.ProcID)
+	assert.Equal(t, "ID47", msg.MsgID)
+	assert.Equal(t, map[string]map[string]string{
+		"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication]`},
+		"meta":              {"seq": "7"},
+	}, msg.StructuredData)
+	assert.Equal(t, "An application event", msg.Message)
+
+	fields := msg.Fields()
+	assert.Equal(t, "local4", fields["facility"])
+	assert.Equal(t, "notice", fields["severity"])
+	assert.Equal(t, "router-1", fields["hostname"])
+	assert.Equal(t, map[string]interface{}{"seq": "7"}, fields["structured_data"].(map[string]interface{})["meta"])
+}
+
+func TestParse_RFC5424NilValues(t *testing.T) {
+	msg, err := Parse([]byte("<14>1 - - - - - -"), parseNow)
+	require.NoError(t, err)
+
+	assert.True(t, msg.Timestamp.IsZero())
+	assert.Empty(t, msg.Hostname)
+	assert.Empty(t, msg.AppName)
+	assert.Nil(t, msg.StructuredData)
+	assert.Empty(t, msg.Message)
+
+	fields := msg.Fields()
+	assert.NotContains(t, fields, "hostname")
+	assert.NotContains(t, fields, "structured_data")
+}
+
+func TestParse_RFC3164(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		ts       time.Time
+		hostname string
+		app      string
+		procid   string
+		message  string
+	}{
+		{
+			name:     "classic with pid",
+			raw:      "<34>Oct  3 22:14:15 mymachine su[123]: 'su root' failed for lonvick",
+			ts:       time.Date(2025, time.October, 3, 22, 14, 15, 0, time.UTC),
+			hostname: "mymachine",
+			app:      "su",
+			procid:   "123",
+			message:  "'su root' failed for lonvick",
+		},
+		{
+			name:     "nginx access log",
+			raw:      `<190>Mar 10 11:59:00 web-1 nginx: 203.0.113.7 - - "GET / HTTP/1.1" 200 612`,
+			ts:       time.Date(2026, time.March, 10, 11, 59, 0, 0, time.UTC),
+			hostname: "web-1",
+			app:      "nginx",
+			message:  `203.0.113.7 - - "GET / HTTP/1.1" 200 612`,
+		},
+		{
+			name:    "missing hostname",
+			raw:     "<13>Mar 10 11:59:00 cron: job done",
+			ts:      time.Date(2026, time.March, 10, 11, 59, 0, 0, time.UTC),
+			app:     "cron",
+			message: "job done",
+		},
+		{
+			name:     "rfc3339 timestamp",
+			raw:      "<13>2026-03-10T11:00:00+01:00 host app: hello",
+			ts:       time.Date(2026, time.March, 10, 10, 0, 0, 0, time.UTC),
+			hostname: "host",
+			app:      "app",
+			message:  "hello",
+		},
+		{
+			name:    "no timestamp",
+			raw:     "<13>just some text",
+			message: "just some text",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Parse([]byte(tt.raw), parseNow)
+			require.NoError(t, err)
+			assert.Equal(t, "rfc3164", msg.Format)
+			assert.True(t, tt.ts.Equal(msg.Timestamp), "timestamp %s", msg.Timestamp)
+			assert.Equal(t, tt.hostname, msg.Hostname)
+			assert.Equal(t, tt.app, msg.AppName)
+			assert.Equal(t, tt.procid, msg.ProcID)
+			assert.Equal(t, tt.message, msg.Message)
+		})
+	}
+}
+
+func TestParse_InvalidHeaderKeepsRawMessage(t *testing.T) {
+	for _, raw := range []string{"no priority here", "<999>Mar 10 11:59:00 host app: x", "<14>1 not-a-time host app - - -"} {
+		msg, err := Parse([]byte(raw), parseNow)
+		assert.Error(t, err, raw)
+		assert.Equal(t, raw, msg.Message)
+		assert.Equal(t, "user", facilityName(msg.Facility))
+		assert.Empty(t, msg.Format)
+	}
+}
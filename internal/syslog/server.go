@@ -0,0 +1,307 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/backends"
+	"log-enricher/internal/config"
+	"log-enricher/internal/netinput"
+	"log-enricher/internal/pipeline"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultMaxMessageSize = 64 * 1024
+	defaultSourceRoot     = "/cache/syslog"
+	fallbackSourceFile    = "syslog.log"
+	fallbackAppName       = "syslog"
+	unixHostname          = "localhost"
+)
+
+// Server receives syslog messages over UDP, TCP and a unix datagram socket and runs them through the pipeline.
+type Server struct {
+	appTemplate    *template.Template
+	sourceTemplate *template.Template
+	sourceRoot     string
+	maxMessageSize int
+
+	udpAddr    string
+	tcpAddr    string
+	unixSocket string
+
+	udpConn     net.PacketConn
+	unixConn    net.PacketConn
+	tcpListener net.Listener
+
+	processors *netinput.Processors
+	conns      *netinput.Conns
+}
+
+func NewServer(cfg *config.Config, pm pipeline.Manager, backend backends.Backend) (*Server, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config is required")
+	}
+	if pm == nil {
+		return nil, fmt.Errorf("pipeline manager is required")
+	}
+	if backend == nil {
+		return nil, fmt.Errorf("backend is required")
+	}
+	if cfg.SyslogUDPAddr == "" && cfg.SyslogTCPAddr == "" && cfg.SyslogUnixSocket == "" {
+		return nil, fmt.Errorf("at least one of SYSLOG_UDP_ADDR, SYSLOG_TCP_ADDR or SYSLOG_UNIX_SOCKET must be configured")
+	}
+
+	appTemplate, err := template.New("syslog_app").Parse(cfg.SyslogAppTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog app template: %w", err)
+	}
+	sourceTemplate, err := template.New("syslog_source").Parse(cfg.SyslogSourceTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog source template: %w", err)
+	}
+
+	maxMessageSize := cfg.SyslogMaxMessageBytes
+	if maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+
+	sourceRoot := strings.TrimSpace(cfg.SyslogSourceRoot)
+	if sourceRoot == "" {
+		sourceRoot = defaultSourceRoot
+	}
+
+	return &Server{
+		appTemplate:    appTemplate,
+		sourceTemplate: sourceTemplate,
+		sourceRoot:     filepath.Clean(sourceRoot),
+		maxMessageSize: maxMessageSize,
+		udpAddr:        cfg.SyslogUDPAddr,
+		tcpAddr:        cfg.SyslogTCPAddr,
+		unixSocket:     cfg.SyslogUnixSocket,
+		processors:     netinput.NewProcessors(pm, backend, cfg.InputMaxSources, time.Duration(cfg.InputSourceIdleTimeoutMs)*time.Millisecond),
+		conns:          netinput.NewConns("syslog TCP"),
+	}, nil
+}
+
+// Start opens all configured listeners. If one of them fails, the ones already opened are closed again.
+func (s *Server) Start() error {
+	if s.udpAddr != "" {
+		conn, err := net.ListenPacket("udp", s.udpAddr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen for syslog on udp %s: %w", s.udpAddr, err)
+		}
+		s.udpConn = conn
+		slog.Info("Syslog UDP listener enabled", "addr", conn.LocalAddr().String())
+	}
+
+	if s.tcpAddr != "" {
+		listener, err := net.Listen("tcp", s.tcpAddr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen for syslog on tcp %s: %w", s.tcpAddr, err)
+		}
+		s.tcpListener = listener
+		slog.Info("Syslog TCP listener enabled", "addr", listener.Addr().String())
+	}
+
+	if s.unixSocket != "" {
+		// A socket left behind by a previous run would make the bind fail. Anything else at the path is kept, since a
+		// mistyped SYSLOG_UNIX_SOCKET must not delete a file.
+		if info, err := os.Lstat(s.unixSocket); err == nil {
+			if info.Mode().Type() != os.ModeSocket {
+				s.closeListeners()
+				return fmt.Errorf("syslog unix socket path %s exists and is not a socket", s.unixSocket)
+			}
+			if err := os.Remove(s.unixSocket); err != nil {
+				s.closeListeners()
+				return fmt.Errorf("failed to remove stale syslog socket %s: %w", s.unixSocket, err)
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			s.closeListeners()
+			return fmt.Errorf("failed to check syslog unix socket path %s: %w", s.unixSocket, err)
+		}
+		conn, err := net.ListenPacket("unixgram", s.unixSocket)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen for syslog on unix socket %s: %w", s.unixSocket, err)
+		}
+		s.unixConn = conn
+		slog.Info("Syslog unix socket listener enabled", "path", s.unixSocket)
+	}
+
+	if s.udpConn != nil {
+		s.conns.Go(func() { s.servePackets(s.udpConn, "udp") })
+	}
+	if s.unixConn != nil {
+		s.conns.Go(func() { s.servePackets(s.unixConn, "unix") })
+	}
+	if s.tcpListener != nil {
+		s.conns.Serve(s.tcpListener, s.serveConn)
+	}
+	return nil
+}
+
+// Shutdown closes all listeners and open connections and waits for in-progress messages until ctx expires.
+// The backend writers of all sources are closed afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.conns.Shutdown(ctx, s.closeListeners)
+	s.processors.Close()
+	return err
+}
+
+func (s *Server) closeListeners() {
+	if s.udpConn != nil {
+		_ = s.udpConn.Close()
+	}
+	if s.tcpListener != nil {
+		_ = s.tcpListener.Close()
+	}
+	if s.unixConn != nil {
+		_ = s.unixConn.Close()
+		_ = os.Remove(s.unixSocket)
+	}
+}
+
+// UDPAddr returns the bound UDP address, or "" if UDP is disabled.
+func (s *Server) UDPAddr() string {
+	if s.udpConn == nil {
+		return ""
+	}
+	return s.udpConn.LocalAddr().String()
+}
+
+// TCPAddr returns the bound TCP address, or "" if TCP is disabled.
+func (s *Server) TCPAddr() string {
+	if s.tcpListener == nil {
+		return ""
+	}
+	return s.tcpListener.Addr().String()
+}
+
+// servePackets handles datagram transports, where every datagram is exactly one message.
+func (s *Server) servePackets(conn net.PacketConn, transport string) {
+	buf := make([]byte, s.maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.conns.Closing() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("Failed to read syslog datagram", "transport", transport, "error", err)
+			continue
+		}
+		s.handleMessage(buf[:n], transport, remoteHost(addr, transport))
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	host := remoteHost(conn.RemoteAddr(), "tcp")
+	reader := bufio.NewReaderSize(conn, s.maxMessageSize+1)
+	for {
+		frame, err := readFrame(reader, s.maxMessageSize)
+		if len(frame) > 0 {
+			s.handleMessage(frame, "tcp", host)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !s.conns.Closing() && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("Closing syslog TCP connection", "remote_addr", conn.RemoteAddr().String(), "error", err)
+			}
+			return
+		}
+	}
+}
+
+// readFrame reads one message using octet-counting framing ("LEN SP MSG", RFC 6587) if the frame starts
+// with a digit, and newline framing otherwise. A final unterminated line is returned together with io.EOF.
+func readFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if isDigit(first[0]) {
+		var length int
+		for digits := 0; ; digits++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if b == ' ' {
+				break
+			}
+			if !isDigit(b) || digits >= 9 {
+				return nil, fmt.Errorf("invalid octet-counting frame length")
+			}
+			length = length*10 + int(b-'0')
+		}
+		if length > maxSize {
+			return nil, fmt.Errorf("syslog message of %d bytes exceeds limit of %d bytes", length, maxSize)
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("syslog message exceeds limit of %d bytes", maxSize)
+	}
+	return line, err
+}
+
+func remoteHost(addr net.Addr, transport string) string {
+	if transport == "unix" || addr == nil {
+		return unixHostname
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (s *Server) handleMessage(raw []byte, transport, remote string) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return
+	}
+	msg, err := Parse(raw, time.Now())
+	if err != nil {
+		slog.Debug("Failed to parse syslog header, using the raw message", "transport", transport, "remote_addr", remote, "error", err)
+	}
+	if msg.Hostname == "" {
+		msg.Hostname = remote
+	}
+
+	data := map[string]string{
+		"hostname":    msg.Hostname,
+		"app_name":    msg.AppName,
+		"procid":      msg.ProcID,
+		"msgid":       msg.MsgID,
+		"facility":    facilityName(msg.Facility),
+		"severity":    SeverityName(msg.Severity),
+		"transport":   transport,
+		"remote_addr": remote,
+	}
+	app := strings.TrimSpace(netinput.ExecuteTemplate(s.appTemplate, data))
+	if app == "" {
+		app = fallbackAppName
+	}
+	source := netinput.SanitizeSourcePath(s.sourceRoot, netinput.ExecuteTemplate(s.sourceTemplate, data), fallbackSourceFile)
+
+	if err := s.processors.Get(app, source).ProcessLineWithFields([]byte(msg.Message), msg.Timestamp, msg.Fields()); err != nil {
+		slog.Error("Failed to process syslog message", "transport", transport, "remote_addr", remote, "error", err)
+	}
+}
@@ -0,0 +1,158 @@
+package syslog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+	"log-enricher/internal/netinput/netinputtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		SyslogUDPAddr:         "127.0.0.1:0",
+		SyslogTCPAddr:         "127.0.0.1:0",
+		SyslogAppTemplate:     `{{if .app_name}}{{.app_name}}{{else}}syslog{{end}}`,
+		SyslogSourceTemplate:  `{{.hostname}}/{{if .app_name}}{{.app_name}}{{else}}syslog{{end}}.log`,
+		SyslogSourceRoot:      t.TempDir(),
+		SyslogMaxMessageBytes: 1024,
+	}
+}
+
+func startTestServer(t *testing.T, cfg *config.Config, backend *netinputtest.CaptureBackend) *Server {
+	t.Helper()
+	server, err := NewServer(cfg, &netinputtest.PipelineManager{}, backend)
+	require.NoError(t, err)
+	netinputtest.Start(t, server)
+	return server
+}
+
+func TestServer_UDPWithTemplates(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SyslogTCPAddr = ""
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("udp", server.UDPAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("<190>1 2026-03-10T11:22:33Z ../../edge nginx 12 - - GET /index.html"))
+	require.NoError(t, err)
+
+	entries := backend.WaitFor(t, 1)
+	entry := entries[0]
+	assert.Equal(t, "nginx", entry.App)
+	// Path traversal in the hostname is stripped by the source path sanitizer.
+	assert.Equal(t, filepath.Join(cfg.SyslogSourceRoot, "edge", "nginx.log"), entry.SourcePath)
+	assert.Equal(t, "GET /index.html", string(entry.LogLine))
+	assert.Equal(t, time.Date(2026, time.March, 10, 11, 22, 33, 0, time.UTC), entry.Timestamp)
+	assert.Equal(t, "12", entry.Fields["procid"])
+	assert.Equal(t, "local7", entry.Fields["facility"])
+	assert.Equal(t, "info", entry.Fields["severity"])
+}
+
+func TestServer_TCPFraming(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SyslogUDPAddr = ""
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("tcp", server.TCPAddr())
+	require.NoError(t, err)
+
+	octetFramed := "<13>Mar 10 11:59:00 host-a app: multi\nline"
+	payload := fmt.Sprintf("%d %s", len(octetFramed), octetFramed) +
+		"<13>Mar 10 11:59:01 host-b app: newline framed\n" +
+		"\n" +
+		"<13>Mar 10 11:59:02 host-c app: unterminated"
+	_, err = conn.Write([]byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	entries := backend.WaitFor(t, 3)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "multi\nline", string(entries[0].LogLine))
+	assert.Equal(t, "host-a", entries[0].Fields["hostname"])
+	assert.Equal(t, "newline framed", string(entries[1].LogLine))
+	assert.Equal(t, "unterminated", string(entries[2].LogLine))
+}
+
+func TestServer_TCPRejectsOversizedFrame(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SyslogUDPAddr = ""
+	backend := &netinputtest.CaptureBackend{}
+	server := startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("tcp", server.TCPAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("99999 <13>too big"))
+	require.NoError(t, err)
+
+	// The server closes the connection instead of buffering the frame.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Empty(t, backend.Entries())
+}
+
+func TestServer_UnixSocketUsesLocalhost(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SyslogUDPAddr = ""
+	cfg.SyslogTCPAddr = ""
+	// Unix socket paths are limited to ~100 bytes, so keep it short.
+	cfg.SyslogUnixSocket = filepath.Join(shortTempDir(t), "log.sock")
+	backend := &netinputtest.CaptureBackend{}
+	startTestServer(t, cfg, backend)
+
+	conn, err := net.Dial("unixgram", cfg.SyslogUnixSocket)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("<14>Mar 10 11:59:00 sshd[99]: Accepted publickey"))
+	require.NoError(t, err)
+
+	entries := backend.WaitFor(t, 1)
+	assert.Equal(t, "sshd", entries[0].App)
+	assert.Equal(t, "localhost", entries[0].Fields["hostname"])
+	assert.Equal(t, filepath.Join(cfg.SyslogSourceRoot, "localhost", "sshd.log"), entries[0].SourcePath)
+}
+
+func TestServer_UnixSocketKeepsOtherFilesAtItsPath(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SyslogUDPAddr = ""
+	cfg.SyslogTCPAddr = ""
+	cfg.SyslogUnixSocket = filepath.Join(shortTempDir(t), "log.sock")
+	require.NoError(t, os.WriteFile(cfg.SyslogUnixSocket, []byte("keep"), 0644))
+
+	server, err := NewServer(cfg, &netinputtest.PipelineManager{}, &netinputtest.CaptureBackend{})
+	require.NoError(t, err)
+	assert.ErrorContains(t, server.Start(), "is not a socket")
+
+	content, err := os.ReadFile(cfg.SyslogUnixSocket)
+	require.NoError(t, err)
+	assert.Equal(t, "keep", string(content))
+}
+
+func TestNewServer_RequiresAListener(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SyslogUDPAddr = ""
+	cfg.SyslogTCPAddr = ""
+
+	_, err := NewServer(cfg, &netinputtest.PipelineManager{}, &netinputtest.CaptureBackend{})
+	assert.ErrorContains(t, err, "at least one of")
+}
+
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "syslog")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
@@ -0,0 +1,359 @@
+package tailer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log-enricher/internal/backends"
+	"log-enricher/internal/config"
+	"log-enricher/internal/pipeline"
+	"log-enricher/internal/processor"
+	"log-enricher/internal/state"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// compressedExtensions are removed from a backfilled file's name to get its source path.
+	compressedExtensions = []string{".gz", ".zst", ".bz2"}
+	// rotationSuffix matches the suffixes logrotate adds: a number (access.log.1) or a date (access.log-20240101).
+	rotationSuffix = regexp.MustCompile(`(\.\d+|-\d{8}(\d{2})?)$`)
+
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// BackfillStats summarizes a backfill run.
+type BackfillStats struct {
+	Files   int   // Files processed to the end in this run
+	Skipped int   // Files already completed by an earlier run
+	Lines   int64 // Entries processed in this run; a multiline event counts once
+	Failed  int64 // Lines the backend did not accept; they were dead-lettered
+}
+
+// Backfiller sends the content of historical files, such as rotated and compressed logs of a newly onboarded
+// app, through the pipeline once. Progress is kept in the state and saved periodically, so an interrupted or
+// crashed backfill resumes where it stopped.
+type Backfiller struct {
+	cfg               *config.Config
+	appIdentification *regexp.Regexp
+	multiline         []*multilineRule
+	pm                pipeline.Manager
+	// bb sends BACKEND_BATCH_SIZE entries at a time and is flushed before progress is saved, so saved progress
+	// only covers delivered lines, also for backends like Loki whose Send returns before the entry was pushed.
+	bb *backends.BufferedBackend
+}
+
+func NewBackfiller(cfg *config.Config, pm pipeline.Manager, bb backends.Backend) (*Backfiller, error) {
+	b := &Backfiller{cfg: cfg, pm: pm, bb: backends.NewBufferedBackend(bb, cfg.BackendBatchSize)}
+	if cfg.AppIdentificationRegex != "" {
+		re, err := compileAppIdentification(cfg.AppIdentificationRegex)
+		if err != nil {
+			return nil, err
+		}
+		b.appIdentification = re
+	}
+	multiline, err := compileMultilineRules(cfg.Multiline)
+	if err != nil {
+		return nil, err
+	}
+	b.multiline = multiline
+	return b, nil
+}
+
+type backfillFile struct {
+	path     string
+	info     os.FileInfo
+	rotation int // Rotation number from the file name; higher numbers are older.
+}
+
+// Run processes the files matching patterns, oldest first, and returns once all of them were processed or ctx
+// is canceled. Patterns are paths or globs; directories are searched for files whose name, without compression
+// and rotation suffixes, has one of LOG_FILE_EXTENSIONS. State is saved after every completed file, and within a
+// file every BACKFILL_CHECKPOINT_LINES lines or BACKFILL_CHECKPOINT_INTERVAL_MS, whichever comes first.
+func (b *Backfiller) Run(ctx context.Context, patterns []string) (BackfillStats, error) {
+	var stats BackfillStats
+	files, err := b.collectFiles(patterns)
+	if err != nil {
+		return stats, err
+	}
+	slog.Info("Starting backfill", "files", len(files))
+
+	for _, file := range files {
+		lines, failed, done, err := b.processFile(ctx, file)
+		stats.Lines += lines
+		stats.Failed += failed
+		if err != nil {
+			return stats, err
+		}
+		if !done {
+			stats.Skipped++
+			continue
+		}
+		stats.Files++
+		if err := state.Save(b.cfg.StateFilePath); err != nil {
+			slog.Error("Error saving backfill progress", "error", err)
+		}
+	}
+	return stats, nil
+}
+
+// collectFiles expands patterns into a list of distinct regular files, sorted oldest first.
+func (b *Backfiller) collectFiles(patterns []string) ([]backfillFile, error) {
+	seen := make(map[string]struct{})
+	var files []backfillFile
+	add := func(path string, info os.FileInfo) {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if _, ok := seen[path]; ok {
+			return
+		}
+		seen[path] = struct{}{}
+		files = append(files, backfillFile{path: path, info: info, rotation: rotationNumber(path)})
+	}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match, info)
+				continue
+			}
+			err = filepath.WalkDir(match, func(path string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() || !b.matchesAnyExtension(backfillSourcePath(path)) {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				add(path, info)
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to walk directory %s: %w", match, err)
+			}
+		}
+	}
+
+	// Modification times order files of different rotations; the rotation number breaks ties, e.g. after a copy
+	// that reset them.
+	sort.SliceStable(files, func(i, j int) bool {
+		if ti, tj := files[i].info.ModTime(), files[j].info.ModTime(); !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		if files[i].rotation != files[j].rotation {
+			return files[i].rotation > files[j].rotation
+		}
+		return files[i].path < files[j].path
+	})
+	return files, nil
+}
+
+func (b *Backfiller) matchesAnyExtension(filename string) bool {
+	for _, ext := range b.cfg.LogFileExtensions {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// processFile processes one file, skipping lines a previous run already processed. It returns false for done if
+// the file was completed by an earlier run. A canceled ctx records the progress and returns ctx's error.
+func (b *Backfiller) processFile(ctx context.Context, file backfillFile) (processed, failed int64, done bool, err error) {
+	progress := state.BackfillState{Size: file.info.Size(), ModTime: file.info.ModTime().UnixNano()}
+	if stored, ok := state.GetBackfillState(file.path); ok && stored.Size == progress.Size && stored.ModTime == progress.ModTime {
+		if stored.Done {
+			slog.Info("Skipping backfilled file", "path", file.path)
+			return 0, 0, false, nil
+		}
+		progress.Lines = stored.Lines
+	}
+	skip := progress.Lines
+	// flush sends the buffered entries; it must run before progress is recorded.
+	flush := func() { failed += int64(b.bb.Flush()) }
+	checkpoint := b.newCheckpointer(file.path, progress.Lines, flush)
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("failed to open %s: %w", file.path, err)
+	}
+	defer f.Close()
+	reader, err := decompressingReader(f)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("failed to read %s: %w", file.path, err)
+	}
+	defer reader.Close()
+
+	sourcePath := backfillSourcePath(file.path)
+	appName := appNameForPath(b.cfg.AppName, b.appIdentification, sourcePath)
+	lp := processor.NewLogProcessor(appName, sourcePath, b.pm.GetProcessPipeline(sourcePath), b.bb)
+	// Lines the pipeline finds no timestamp in get the file's modification time instead of the current time.
+	fallbackTimestamp := file.info.ModTime()
+	slog.Info("Backfilling file", "path", file.path, "source_path", sourcePath, "app", appName, "resume_after_line", progress.Lines)
+
+	// Progress only covers lines of processed entries; the lines of a pending multiline event are read again when
+	// an interrupted backfill resumes.
+	processEvent := func(event []byte, lines int64) {
+		if err := lp.ProcessLineWithTimestamp(event, fallbackTimestamp); err != nil {
+			failed++
+		}
+		processed++
+		progress.Lines += lines
+		checkpoint.maybeSave(progress)
+	}
+	processLine := func(line []byte) { processEvent(line, 1) }
+	var aggregator *multilineAggregator
+	if rule := multilineRuleFor(b.multiline, sourcePath); rule != nil {
+		aggregator = newMultilineAggregator(rule, processEvent)
+		processLine = aggregator.add
+	}
+
+	buffered := bufio.NewReader(reader)
+	var lineNumber int64
+	for {
+		line, readErr := buffered.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			flush()
+			state.SetBackfillState(file.path, progress)
+			return processed, failed, false, fmt.Errorf("failed to read %s: %w", file.path, readErr)
+		}
+		if len(line) > 0 {
+			lineNumber++
+			if lineNumber > skip {
+				if ctx.Err() != nil {
+					flush()
+					state.SetBackfillState(file.path, progress)
+					return processed, failed, false, ctx.Err()
+				}
+				processLine(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")))
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	if aggregator != nil {
+		aggregator.flush()
+	}
+	flush()
+
+	progress.Done = true
+	state.SetBackfillState(file.path, progress)
+	b.bb.CloseWriter(sourcePath)
+	slog.Info("Backfilled file", "path", file.path, "entries", processed, "failed", failed)
+	return processed, failed, true, nil
+}
+
+// checkpointer saves the progress of the file being backfilled, so a crash does not lose more than a checkpoint's
+// worth of lines. Lines sent after the last save are sent again on resume.
+type checkpointer struct {
+	statePath string
+	path      string
+	flush     func()        // Sends the buffered entries before progress is saved.
+	lines     int64         // Lines between saves; 0 disables the limit.
+	interval  time.Duration // Time between saves; 0 disables the limit.
+	lastLines int64         // Progress at the last save.
+	lastSave  time.Time
+}
+
+func (b *Backfiller) newCheckpointer(path string, lines int64, flush func()) *checkpointer {
+	return &checkpointer{
+		statePath: b.cfg.StateFilePath,
+		path:      path,
+		flush:     flush,
+		lines:     int64(b.cfg.BackfillCheckpointLines),
+		interval:  time.Duration(b.cfg.BackfillCheckpointMs) * time.Millisecond,
+		lastLines: lines,
+		lastSave:  time.Now(),
+	}
+}
+
+// maybeSave records progress in the state and saves it once enough lines or time passed since the last save.
+func (c *checkpointer) maybeSave(progress state.BackfillState) {
+	due := (c.lines > 0 && progress.Lines-c.lastLines >= c.lines) || (c.interval > 0 && time.Since(c.lastSave) >= c.interval)
+	if !due {
+		return
+	}
+	c.flush()
+	state.SetBackfillState(c.path, progress)
+	if err := state.Save(c.statePath); err != nil {
+		slog.Error("Error saving backfill progress", "path", c.path, "error", err)
+	}
+	c.lastLines = progress.Lines
+	c.lastSave = time.Now()
+}
+
+// decompressingReader returns a reader of r's content, decompressing gzip, zstd and bzip2 data.
+func decompressingReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return io.NopCloser(bzip2.NewReader(buffered)), nil
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
+
+// backfillSourcePath returns the path of the live file a rotated or compressed file came from, e.g.
+// /var/log/nginx/access.log for /var/log/nginx/access.log.2.gz, so backfilled entries join its stream.
+func backfillSourcePath(path string) string {
+	for _, ext := range compressedExtensions {
+		if strings.HasSuffix(path, ext) {
+			path = strings.TrimSuffix(path, ext)
+			break
+		}
+	}
+	return rotationSuffix.ReplaceAllString(path, "")
+}
+
+// rotationNumber returns n for files rotated to name.n or name.n.gz, and 0 otherwise.
+func rotationNumber(path string) int {
+	for _, ext := range compressedExtensions {
+		path = strings.TrimSuffix(path, ext)
+	}
+	dot := strings.LastIndexByte(path, '.')
+	if dot < 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(path[dot+1:])
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,241 @@
+package tailer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"log-enricher/internal/backends"
+	"log-enricher/internal/config"
+	"log-enricher/internal/models"
+	"log-enricher/internal/state"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bzip2Line is "third oldest\n" compressed with bzip2, which the standard library can only decompress.
+var bzip2Line = []byte{66, 90, 104, 57, 49, 65, 89, 38, 83, 89, 13, 36, 23, 150, 0, 0, 6, 81, 128, 0, 16, 64, 0, 6,
+	100, 156, 0, 32, 0, 49, 0, 211, 77, 4, 13, 3, 34, 7, 164, 105, 210, 82, 241, 119, 36, 83, 133, 9, 0, 210, 65,
+	121, 96}
+
+type backfilledEntry struct {
+	app, source, line string
+	timestamp         time.Time
+}
+
+// recordingBackend copies the entries it receives, since the processor reuses them after Send returns.
+type recordingBackend struct {
+	stubBackend
+	mu      sync.Mutex
+	entries []backfilledEntry
+	// cancel, if set, is called after the entry with this line was received.
+	cancelAfter string
+	cancel      context.CancelFunc
+	// onLine, if set, is called with every received line before it is recorded.
+	onLine func(line string)
+	// failLine, if set, is the line SendBatch reports as failed instead of recording it.
+	failLine string
+}
+
+func (b *recordingBackend) Send(entry *models.LogEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	line := string(entry.LogLine)
+	if b.onLine != nil {
+		b.onLine(line)
+	}
+	b.entries = append(b.entries, backfilledEntry{app: entry.App, source: entry.SourcePath, line: line, timestamp: entry.Timestamp})
+	if b.cancel != nil && line == b.cancelAfter {
+		b.cancel()
+	}
+	return nil
+}
+
+// SendBatch records the entries one by one; backfill sends all entries with SendBatch.
+func (b *recordingBackend) SendBatch(entries []*models.LogEntry) error {
+	var batchErr *backends.BatchError
+	for i, entry := range entries {
+		if b.failLine != "" && string(entry.LogLine) == b.failLine {
+			if batchErr == nil {
+				batchErr = &backends.BatchError{Err: errors.New("rejected")}
+			}
+			batchErr.Failed = append(batchErr.Failed, i)
+			continue
+		}
+		if err := b.Send(entry); err != nil {
+			return err
+		}
+	}
+	if batchErr != nil {
+		return batchErr
+	}
+	return nil
+}
+
+func (b *recordingBackend) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := make([]string, len(b.entries))
+	for i, entry := range b.entries {
+		lines[i] = entry.line
+	}
+	return lines
+}
+
+func writeBackfillFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, content, 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func gzipData(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T, content string) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(content), nil)
+}
+
+// savedLines reads the progress of path from disk, as a backfill started after a crash would.
+func savedLines(t *testing.T, statePath, path string) int64 {
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	var saved struct {
+		Backfill map[string]state.BackfillState `json:"backfill"`
+	}
+	require.NoError(t, json.Unmarshal(data, &saved))
+	return saved.Backfill[path].Lines
+}
+
+func newTestBackfiller(t *testing.T, backend *recordingBackend) *Backfiller {
+	t.Helper()
+	cfg := &config.Config{
+		AppName:           "default",
+		StateFilePath:     filepath.Join(t.TempDir(), "state.json"),
+		LogFileExtensions: []string{".log"},
+	}
+	require.NoError(t, state.Initialize(cfg.StateFilePath))
+	backfiller, err := NewBackfiller(cfg, &stubPipelineManager{}, backend)
+	require.NoError(t, err)
+	return backfiller
+}
+
+func TestBackfiller_DecompressesAndProcessesOldestFirst(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeBackfillFile(t, filepath.Join(dir, "access.log"), []byte("newest\n"), base.Add(4*time.Hour))
+	writeBackfillFile(t, filepath.Join(dir, "access.log.1"), zstdData(t, "second newest\r\n"), base.Add(3*time.Hour))
+	writeBackfillFile(t, filepath.Join(dir, "access.log.2.gz"), gzipData(t, "second oldest\nwithout newline"), base.Add(2*time.Hour))
+	// Copies often reset modification times; the rotation number still orders these.
+	writeBackfillFile(t, filepath.Join(dir, "access.log.3.bz2"), bzip2Line, base.Add(time.Hour))
+	writeBackfillFile(t, filepath.Join(dir, "access.log.4"), []byte("oldest\n"), base.Add(time.Hour))
+	writeBackfillFile(t, filepath.Join(dir, "notes.txt"), []byte("ignored\n"), base)
+
+	backend := &recordingBackend{}
+	backfiller := newTestBackfiller(t, backend)
+	stats, err := backfiller.Run(context.Background(), []string{dir, filepath.Join(dir, "access.log")})
+	require.NoError(t, err)
+
+	assert.Equal(t, BackfillStats{Files: 5, Lines: 6}, stats, "files matched twice are processed once")
+	assert.Equal(t, []string{"oldest", "third oldest", "second oldest", "without newline", "second newest", "newest"}, backend.lines())
+	for _, entry := range backend.entries {
+		assert.Equal(t, filepath.Join(dir, "access.log"), entry.source, "rotated files join the stream of the live file")
+		assert.Equal(t, "default", entry.app)
+	}
+	assert.Equal(t, base.Add(time.Hour), backend.entries[0].timestamp.UTC(),
+		"lines without a parsed timestamp get their file's modification time")
+	assert.Equal(t, base.Add(4*time.Hour), backend.entries[5].timestamp.UTC())
+
+	_, err = backfiller.Run(context.Background(), []string{filepath.Join(dir, "missing-*.log")})
+	assert.Error(t, err, "patterns without matches are reported")
+}
+
+func TestBackfiller_ResumesInterruptedBackfill(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeBackfillFile(t, filepath.Join(dir, "app.log.1.gz"), gzipData(t, "a\nb\n"), base)
+	writeBackfillFile(t, filepath.Join(dir, "app.log"), []byte("c\nd\ne\n"), base.Add(time.Hour))
+	patterns := []string{filepath.Join(dir, "app.log*")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backend := &recordingBackend{cancelAfter: "c", cancel: cancel}
+	backfiller := newTestBackfiller(t, backend)
+	stats, err := backfiller.Run(ctx, patterns)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, BackfillStats{Files: 1, Lines: 3}, stats)
+	require.NoError(t, state.Save(backfiller.cfg.StateFilePath))
+
+	// A new process resumes from the saved state.
+	require.NoError(t, state.Initialize(backfiller.cfg.StateFilePath))
+	backend.cancel = nil
+	stats, err = backfiller.Run(context.Background(), patterns)
+	require.NoError(t, err)
+	assert.Equal(t, BackfillStats{Files: 1, Skipped: 1, Lines: 2}, stats)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, backend.lines(), "no line is sent twice")
+
+	// A file that changed since it was backfilled is processed again.
+	writeBackfillFile(t, filepath.Join(dir, "app.log"), []byte("c\nd\ne\nf\n"), base.Add(2*time.Hour))
+	stats, err = backfiller.Run(context.Background(), patterns)
+	require.NoError(t, err)
+	assert.Equal(t, BackfillStats{Files: 1, Skipped: 1, Lines: 4}, stats)
+}
+
+func TestBackfiller_SavesProgressWithinFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writeBackfillFile(t, path, []byte("a\nb\nc\nd\ne\n"), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	backend := &recordingBackend{}
+	backfiller := newTestBackfiller(t, backend)
+	backfiller.cfg.BackfillCheckpointLines = 2
+	saved := make(map[string]int64)
+	backend.onLine = func(line string) { saved[line] = savedLines(t, backfiller.cfg.StateFilePath, path) }
+
+	_, err := backfiller.Run(context.Background(), []string{path})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"a": 0, "b": 0, "c": 2, "d": 2, "e": 4}, saved,
+		"progress is saved every BACKFILL_CHECKPOINT_LINES lines")
+}
+
+func TestBackfiller_SavesProgressOnlyForDeliveredBatches(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writeBackfillFile(t, path, []byte("a\nb\nc\nd\ne\n"), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	backend := &recordingBackend{failLine: "d"}
+	backfiller := newTestBackfiller(t, backend)
+	backfiller.cfg.BackfillCheckpointLines = 3
+	backfiller.bb = backends.NewBufferedBackend(backend, 2)
+	saved := make(map[string]int64)
+	backend.onLine = func(line string) { saved[line] = savedLines(t, backfiller.cfg.StateFilePath, path) }
+
+	stats, err := backfiller.Run(context.Background(), []string{path})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"a": 0, "b": 0, "c": 0, "e": 3}, saved,
+		"lines are sent in batches of 2, and the pending batch is sent before progress is saved")
+	assert.Equal(t, int64(5), stats.Lines)
+	assert.Equal(t, int64(1), stats.Failed, "failures of batched sends are counted")
+}
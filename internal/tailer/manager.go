@@ -35,7 +35,9 @@ type ManagerImpl struct {
 	tailedFiles          map[string]context.CancelFunc
 	pm                   pipeline.Manager
 	bb                   backends.Backend
+	workers              *processor.WorkerPool // Optional; lines are processed inline when nil.
 	ignoredLogFilesRegex *regexp.Regexp
+	multiline            []*multilineRule
 }
 
 func NewManagerImpl(cfg *config.Config, pm pipeline.Manager, bb backends.Backend) (*ManagerImpl, error) {
@@ -49,20 +51,9 @@ func NewManagerImpl(cfg *config.Config, pm pipeline.Manager, bb backends.Backend
 
 	// Compile app identification regex if provided
 	if cfg.AppIdentificationRegex != "" {
-		re, err := regexp.Compile(cfg.AppIdentificationRegex)
+		re, err := compileAppIdentification(cfg.AppIdentificationRegex)
 		if err != nil {
-			return nil, fmt.Errorf("invalid AppIdentificationRegex: %w", err)
-		}
-		// Check for named capture group "app"
-		foundAppGroup := false
-		for _, name := range re.SubexpNames() {
-			if name == "app" {
-				foundAppGroup = true
-				break
-			}
-		}
-		if !foundAppGroup {
-			return nil, fmt.Errorf("AppIdentificationRegex must contain a named capture group 'app'")
+			return nil, err
 		}
 		manager.appIdentification = re
 		slog.Info("App identification regex enabled", "regex", cfg.AppIdentificationRegex)
@@ -81,6 +72,12 @@ func NewManagerImpl(cfg *config.Config, pm pipeline.Manager, bb backends.Backend
 		slog.Info("Log files ignored regex enabled", "regex", cfg.LogFilesIgnored)
 	}
 
+	multiline, err := compileMultilineRules(cfg.Multiline)
+	if err != nil {
+		return nil, err
+	}
+	manager.multiline = multiline
+
 	fileWatcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create watcher: %w", err)
@@ -90,6 +87,12 @@ func NewManagerImpl(cfg *config.Config, pm pipeline.Manager, bb backends.Backend
 	return manager, nil
 }
 
+// SetWorkerPool makes the manager process lines on pool instead of inside each file's tailing goroutine.
+// It must be called before StartWatching.
+func (m *ManagerImpl) SetWorkerPool(pool *processor.WorkerPool) {
+	m.workers = pool
+}
+
 func (m *ManagerImpl) StartWatching(ctx context.Context) error {
 	// Watch for new files being created in the directory.
 	go m.watch(ctx)
@@ -311,6 +314,70 @@ func (m *ManagerImpl) tailFile(ctx context.Context, path string) {
 	slog.Debug("Determined app name for file", "path", path, "app_name", appName)
 	lp := processor.NewLogProcessor(appName, path, m.pm.GetProcessPipeline(path), m.bb)
 
+	// The line number is only advanced once a line has been handled, so resuming never skips queued lines.
+	processEvent := func(event []byte, lines int64) {
+		if err := lp.ProcessLine(event); err != nil {
+			slog.Error("Failed to process line, continuing", "path", path, "error", err)
+		}
+		fileState.AddLineNumber(lines)
+	}
+	if m.workers != nil {
+		// The pool reports sent entries in submission order, so the line counts of queued events are a FIFO.
+		var sentMu sync.Mutex
+		var sentLines []int64
+		ordered := m.workers.NewOrderedProcessor(lp, func(error) {
+			sentMu.Lock()
+			lines := sentLines[0]
+			sentLines = sentLines[1:]
+			sentMu.Unlock()
+			fileState.AddLineNumber(lines)
+		})
+		// Closing waits for queued lines, so the backend writer is only closed after they were sent.
+		defer ordered.Close()
+		processEvent = func(event []byte, lines int64) {
+			sentMu.Lock()
+			sentLines = append(sentLines, lines)
+			sentMu.Unlock()
+			if err := ordered.ProcessLine(event); err != nil {
+				sentMu.Lock()
+				sentLines = sentLines[:len(sentLines)-1]
+				sentMu.Unlock()
+				slog.Error("Failed to queue line, continuing", "path", path, "error", err)
+			}
+		}
+	}
+
+	processLine := func(line []byte) { processEvent(line, 1) }
+	// Lines of a pending multiline event are only counted once it was emitted; on shutdown they are read again
+	// after the restart. The flush timer emits the last event once the file goes idle.
+	var aggregator *multilineAggregator
+	var flushTimer *time.Timer
+	var flushC <-chan time.Time
+	if rule := multilineRuleFor(m.multiline, path); rule != nil {
+		slog.Info("Combining multiline events", "path", path, "mode", rule.mode)
+		aggregator = newMultilineAggregator(rule, processEvent)
+		flushTimer = time.NewTimer(rule.flushTimeout)
+		flushTimer.Stop()
+		defer flushTimer.Stop()
+		processLine = func(line []byte) {
+			aggregator.add(line)
+			if aggregator.pending() {
+				flushTimer.Reset(rule.flushTimeout)
+				flushC = flushTimer.C
+			} else {
+				flushTimer.Stop()
+				flushC = nil
+			}
+		}
+	}
+
+	// flushPending emits the pending event once the file will not be read any further.
+	flushPending := func() {
+		if aggregator != nil && ctx.Err() == nil {
+			aggregator.flush()
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -321,20 +388,20 @@ func (m *ManagerImpl) tailFile(ctx context.Context, path string) {
 				return
 			}
 			slog.Error("Error tailing file", "path", path, "error", err)
+			flushPending()
 			return
 		case line, ok := <-t.Lines:
 			if !ok {
 				// Channel closed, tailer has stopped.
 				slog.Info("Tailing was stopped for file", "path", path)
+				flushPending()
 				return
 			}
 
-			if err := lp.ProcessLine(line.Buffer); err != nil {
-				slog.Error("Failed to process line, continuing", "path", path, "error", err)
-			}
-
-			// Update state in memory.
-			fileState.IncrementLineNumber()
+			processLine(line.Buffer)
+		case <-flushC:
+			flushC = nil
+			aggregator.flush()
 		}
 	}
 }
@@ -369,13 +436,31 @@ func (m *ManagerImpl) matchesAnyExtension(filename string) bool {
 // getAppNameForPath determines the application name for a given log file path.
 // It uses the configured AppName, AppIdentificationRegex, or falls back to the directory name.
 func (m *ManagerImpl) getAppNameForPath(sourcePath string) string {
+	return appNameForPath(m.cfg.AppName, m.appIdentification, sourcePath)
+}
+
+// compileAppIdentification compiles an AppIdentificationRegex, which must have a named capture group "app".
+func compileAppIdentification(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AppIdentificationRegex: %w", err)
+	}
+	for _, name := range re.SubexpNames() {
+		if name == "app" {
+			return re, nil
+		}
+	}
+	return nil, fmt.Errorf("AppIdentificationRegex must contain a named capture group 'app'")
+}
+
+func appNameForPath(staticName string, appIdentification *regexp.Regexp, sourcePath string) string {
 	var appName string
-	if m.cfg.AppName != "" {
-		appName = m.cfg.AppName
-	} else if m.appIdentification != nil {
-		matches := m.appIdentification.FindStringSubmatch(sourcePath)
+	if staticName != "" {
+		appName = staticName
+	} else if appIdentification != nil {
+		matches := appIdentification.FindStringSubmatch(sourcePath)
 		if len(matches) > 0 {
-			for i, name := range m.appIdentification.SubexpNames() {
+			for i, name := range appIdentification.SubexpNames() {
 				if name == "app" && i < len(matches) {
 					appName = matches[i]
 					break
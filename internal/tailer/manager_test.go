@@ -24,6 +24,10 @@ func (m *stubPipelineManager) GetProcessPipeline(filePath string) pipeline.Proce
 	return &stubProcessPipeline{}
 }
 
+func (m *stubPipelineManager) GetTenantProcessPipeline(tenant, filePath string) pipeline.ProcessPipeline {
+	return m.GetProcessPipeline(filePath)
+}
+
 type stubProcessPipeline struct{}
 
 func (p *stubProcessPipeline) Process(entry *models.LogEntry) bool {
@@ -32,9 +36,10 @@ func (p *stubProcessPipeline) Process(entry *models.LogEntry) bool {
 
 type stubBackend struct{}
 
-func (b *stubBackend) Send(entry *models.LogEntry) error { return nil }
-func (b *stubBackend) Shutdown()                         {}
-func (b *stubBackend) Name() string                      { return "stub" }
+func (b *stubBackend) Send(entry *models.LogEntry) error          { return nil }
+func (b *stubBackend) SendBatch(entries []*models.LogEntry) error { return nil }
+func (b *stubBackend) Shutdown()                                  {}
+func (b *stubBackend) Name() string                               { return "stub" }
 func (b *stubBackend) CloseWriter(sourcePath string) {
 	stubBackendMu.Lock()
 	defer stubBackendMu.Unlock()
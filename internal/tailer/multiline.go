@@ -0,0 +1,130 @@
+package tailer
+
+import (
+	"fmt"
+	"log-enricher/internal/config"
+	"regexp"
+	"time"
+)
+
+const (
+	multilineModeStart        = "start"
+	multilineModeContinuation = "continuation"
+	multilineModeIndent       = "indent"
+)
+
+// multilineRule decides which lines of a file belong to the same event.
+type multilineRule struct {
+	appliesTo    *regexp.Regexp // Nil matches every file.
+	mode         string
+	pattern      *regexp.Regexp
+	maxLines     int64
+	maxBytes     int
+	flushTimeout time.Duration
+}
+
+func compileMultilineRules(cfgs []config.MultilineConfig) ([]*multilineRule, error) {
+	rules := make([]*multilineRule, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		rule := &multilineRule{
+			mode:         cfg.Mode,
+			maxLines:     int64(cfg.MaxLines),
+			maxBytes:     cfg.MaxBytes,
+			flushTimeout: time.Duration(cfg.FlushTimeoutMs) * time.Millisecond,
+		}
+		if rule.maxLines <= 0 || rule.maxBytes <= 0 || rule.flushTimeout <= 0 {
+			return nil, fmt.Errorf("multiline rule %d: max lines, max bytes and flush timeout must be positive", i)
+		}
+		if cfg.AppliesTo != "" {
+			re, err := regexp.Compile(cfg.AppliesTo)
+			if err != nil {
+				return nil, fmt.Errorf("multiline rule %d: invalid applies_to: %w", i, err)
+			}
+			rule.appliesTo = re
+		}
+
+		switch cfg.Mode {
+		case multilineModeStart, multilineModeContinuation:
+			if cfg.Pattern == "" {
+				return nil, fmt.Errorf("multiline rule %d: mode %q requires a pattern", i, cfg.Mode)
+			}
+			re, err := regexp.Compile(cfg.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("multiline rule %d: invalid pattern: %w", i, err)
+			}
+			rule.pattern = re
+		case multilineModeIndent:
+		default:
+			return nil, fmt.Errorf("multiline rule %d: unknown mode %q", i, cfg.Mode)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// multilineRuleFor returns the first rule that applies to path, or nil if its lines are not combined.
+func multilineRuleFor(rules []*multilineRule, path string) *multilineRule {
+	for _, rule := range rules {
+		if rule.appliesTo == nil || rule.appliesTo.MatchString(path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// continues reports whether line belongs to the event before it.
+func (r *multilineRule) continues(line []byte) bool {
+	switch r.mode {
+	case multilineModeStart:
+		return !r.pattern.Match(line)
+	case multilineModeContinuation:
+		return r.pattern.Match(line)
+	default:
+		return len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+	}
+}
+
+// multilineAggregator combines the lines of one file into events according to its rule. Events are passed to
+// emit together with the number of lines they contain, so the caller can track the line number of the file.
+type multilineAggregator struct {
+	rule  *multilineRule
+	emit  func(event []byte, lines int64)
+	event []byte
+	lines int64
+}
+
+func newMultilineAggregator(rule *multilineRule, emit func(event []byte, lines int64)) *multilineAggregator {
+	return &multilineAggregator{rule: rule, emit: emit}
+}
+
+// add appends line to the pending event, first emitting the pending event if line does not belong to it or
+// would make it exceed the byte limit. An event that reaches the line limit is emitted right away.
+func (a *multilineAggregator) add(line []byte) {
+	if a.lines > 0 && (!a.rule.continues(line) || len(a.event)+1+len(line) > a.rule.maxBytes) {
+		a.flush()
+	}
+	if a.lines > 0 {
+		a.event = append(a.event, '\n')
+	}
+	a.event = append(a.event, line...)
+	a.lines++
+	if a.lines >= a.rule.maxLines {
+		a.flush()
+	}
+}
+
+// flush emits the pending event, if any.
+func (a *multilineAggregator) flush() {
+	if a.lines == 0 {
+		return
+	}
+	// The event is handed over, so the next one needs a new buffer.
+	event, lines := a.event, a.lines
+	a.event, a.lines = nil, 0
+	a.emit(event, lines)
+}
+
+// pending reports whether lines are waiting for the rest of their event.
+func (a *multilineAggregator) pending() bool {
+	return a.lines > 0
+}
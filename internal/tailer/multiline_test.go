@@ -0,0 +1,151 @@
+package tailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"log-enricher/internal/config"
+	"log-enricher/internal/processor"
+	"log-enricher/internal/state"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const javaTrace = `2024-01-01 12:00:00 ERROR request failed
+java.lang.IllegalStateException: boom
+	at com.example.Service.handle(Service.java:42)
+	at com.example.Server.run(Server.java:7)
+Caused by: java.io.IOException: closed
+	... 2 more
+2024-01-01 12:00:01 INFO next request`
+
+type aggregatedEvent struct {
+	event string
+	lines int64
+}
+
+func aggregate(t *testing.T, cfg config.MultilineConfig, input string) []aggregatedEvent {
+	t.Helper()
+	if cfg.MaxLines == 0 {
+		cfg.MaxLines = 500
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 64 * 1024
+	}
+	cfg.FlushTimeoutMs = 1000
+	rules, err := compileMultilineRules([]config.MultilineConfig{cfg})
+	require.NoError(t, err)
+
+	var events []aggregatedEvent
+	aggregator := newMultilineAggregator(rules[0], func(event []byte, lines int64) {
+		events = append(events, aggregatedEvent{event: string(event), lines: lines})
+	})
+	for _, line := range strings.Split(input, "\n") {
+		aggregator.add([]byte(line))
+	}
+	aggregator.flush()
+	return events
+}
+
+func TestMultilineAggregator_Modes(t *testing.T) {
+	trace := strings.Join(strings.Split(javaTrace, "\n")[:6], "\n")
+	expected := []aggregatedEvent{{event: trace, lines: 6}, {event: "2024-01-01 12:00:01 INFO next request", lines: 1}}
+
+	assert.Equal(t, expected, aggregate(t, config.MultilineConfig{Mode: "start", Pattern: `^\d{4}-\d{2}-\d{2} `}, javaTrace))
+	assert.Equal(t, expected, aggregate(t, config.MultilineConfig{Mode: "continuation", Pattern: `^(\s|java\.|Caused by:)`}, javaTrace))
+
+	python := "Traceback (most recent call last):\n  File \"app.py\", line 1, in <module>\n    main()\nValueError: bad\nnext"
+	assert.Equal(t, []aggregatedEvent{
+		{event: "Traceback (most recent call last):\n  File \"app.py\", line 1, in <module>\n    main()", lines: 3},
+		{event: "ValueError: bad", lines: 1},
+		{event: "next", lines: 1},
+	}, aggregate(t, config.MultilineConfig{Mode: "indent"}, python))
+}
+
+func TestMultilineAggregator_Limits(t *testing.T) {
+	input := "start\n a\n b\n c\n d"
+	assert.Equal(t, []aggregatedEvent{{event: "start\n a", lines: 2}, {event: " b\n c", lines: 2}, {event: " d", lines: 1}},
+		aggregate(t, config.MultilineConfig{Mode: "indent", MaxLines: 2}, input))
+
+	assert.Equal(t, []aggregatedEvent{{event: "start\n a\n b", lines: 3}, {event: " c\n d", lines: 2}},
+		aggregate(t, config.MultilineConfig{Mode: "indent", MaxBytes: 11}, input), "events are split before they exceed the byte limit")
+
+	assert.Equal(t, []aggregatedEvent{{event: "start", lines: 1}, {event: " a long line", lines: 1}},
+		aggregate(t, config.MultilineConfig{Mode: "indent", MaxBytes: 4}, "start\n a long line"), "single lines are never cut")
+}
+
+func TestCompileMultilineRules_Validation(t *testing.T) {
+	valid := config.MultilineConfig{Mode: "start", Pattern: "^x", MaxLines: 1, MaxBytes: 1, FlushTimeoutMs: 1}
+	invalid := map[string]func(*config.MultilineConfig){
+		"unknown mode":      func(c *config.MultilineConfig) { c.Mode = "regex" },
+		"missing pattern":   func(c *config.MultilineConfig) { c.Pattern = "" },
+		"invalid pattern":   func(c *config.MultilineConfig) { c.Pattern = "(" },
+		"invalid applies":   func(c *config.MultilineConfig) { c.AppliesTo = "(" },
+		"zero max lines":    func(c *config.MultilineConfig) { c.MaxLines = 0 },
+		"zero flush":        func(c *config.MultilineConfig) { c.FlushTimeoutMs = 0 },
+		"negative maxbytes": func(c *config.MultilineConfig) { c.MaxBytes = -1 },
+	}
+	for name, mutate := range invalid {
+		cfg := valid
+		mutate(&cfg)
+		_, err := compileMultilineRules([]config.MultilineConfig{cfg})
+		assert.Error(t, err, name)
+	}
+
+	rules, err := compileMultilineRules([]config.MultilineConfig{
+		{AppliesTo: `java/`, Mode: "start", Pattern: "^x", MaxLines: 1, MaxBytes: 1, FlushTimeoutMs: 1},
+		{Mode: "indent", MaxLines: 1, MaxBytes: 1, FlushTimeoutMs: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "start", multilineRuleFor(rules, "/logs/java/app.log").mode)
+	assert.Equal(t, "indent", multilineRuleFor(rules, "/logs/python/app.log").mode, "the first matching rule applies")
+	assert.Nil(t, multilineRuleFor(rules[:1], "/logs/python/app.log"))
+}
+
+func TestManagerImpl_TailFile_CombinesMultilineEvents(t *testing.T) {
+	for _, withWorkers := range []bool{false, true} {
+		cfg := &config.Config{
+			LogBasePath:       t.TempDir(),
+			LogFileExtensions: []string{".log"},
+			Multiline: []config.MultilineConfig{
+				{Mode: "start", Pattern: `^\d{4}-`, MaxLines: 500, MaxBytes: 64 * 1024, FlushTimeoutMs: 50},
+			},
+		}
+		require.NoError(t, state.Initialize(filepath.Join(cfg.LogBasePath, "state.json")))
+		backend := &recordingBackend{}
+		manager, err := NewManagerImpl(cfg, &stubPipelineManager{}, backend)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = manager.watcher.Close() })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if withWorkers {
+			pool := processor.NewWorkerPool(ctx, 2, 10)
+			t.Cleanup(pool.Shutdown)
+			manager.SetWorkerPool(pool)
+		}
+
+		logPath := filepath.Join(cfg.LogBasePath, "app.log")
+		require.NoError(t, os.WriteFile(logPath, []byte(javaTrace+"\n"), 0o644))
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			manager.tailFile(ctx, logPath)
+		}()
+
+		require.Eventually(t, func() bool { return len(backend.lines()) == 2 }, 5*time.Second, 10*time.Millisecond,
+			"the last event is emitted once the file is idle for the flush timeout")
+		assert.Equal(t, strings.Split(javaTrace, "\n")[6], backend.lines()[1])
+		assert.Equal(t, strings.Join(strings.Split(javaTrace, "\n")[:6], "\n"), backend.lines()[0])
+		fileState := state.GetOrCreateFileState(logPath)
+		require.Eventually(t, func() bool { return fileState.GetLineNumber() == 7 }, time.Second, 10*time.Millisecond,
+			"the line number counts every line of the combined events")
+
+		cancel()
+		<-stopped
+	}
+}
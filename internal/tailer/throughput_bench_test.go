@@ -0,0 +1,124 @@
+package tailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"log-enricher/internal/backends"
+	"log-enricher/internal/config"
+	"log-enricher/internal/models"
+	"log-enricher/internal/pipeline"
+	"log-enricher/internal/state"
+)
+
+// These benchmarks measure lines per second from a tailed file through the pipeline to the backend, sending
+// each entry directly (sync) or through the queue used with BACKEND_ASYNC_ENABLED (async).
+// Run them with: go test -run '^$' -bench Throughput -benchmem ./internal/tailer/
+// The "remote" backend adds a fixed delay to every request, like a network round trip to Loki or an HTTP endpoint.
+
+// countingBackend counts the entries handed to the backend it wraps and closes done once target were sent.
+type countingBackend struct {
+	backends.Backend
+	delay  time.Duration
+	target int64
+	sent   atomic.Int64
+	done   chan struct{}
+}
+
+func (b *countingBackend) Send(entry *models.LogEntry) error {
+	time.Sleep(b.delay)
+	err := b.Backend.Send(entry)
+	b.count(1)
+	return err
+}
+
+func (b *countingBackend) SendBatch(entries []*models.LogEntry) error {
+	time.Sleep(b.delay)
+	err := b.Backend.SendBatch(entries)
+	b.count(len(entries))
+	return err
+}
+
+func (b *countingBackend) count(n int) {
+	if b.sent.Add(int64(n)) == b.target {
+		close(b.done)
+	}
+}
+
+func BenchmarkThroughput_TailerToBackend(b *testing.B) {
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	for _, delay := range []time.Duration{0, 100 * time.Microsecond} {
+		name := "file"
+		if delay > 0 {
+			name = fmt.Sprintf("remote-%dus", delay.Microseconds())
+		}
+		for _, async := range []bool{false, true} {
+			mode := "sync"
+			if async {
+				mode = "async"
+			}
+			b.Run(name+"/"+mode, func(b *testing.B) {
+				benchmarkTailerToBackend(b, delay, async)
+			})
+		}
+	}
+}
+
+func benchmarkTailerToBackend(b *testing.B, delay time.Duration, async bool) {
+	dir := b.TempDir()
+	if err := state.Initialize(filepath.Join(dir, "state.json")); err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(dir, "app.log")
+	line := `{"level":"info","msg":"request completed","status":200,"client_ip":"203.0.113.7"}` + "\n"
+	if err := os.WriteFile(path, []byte(strings.Repeat(line, b.N)), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := &config.Config{
+		LogBasePath:       dir,
+		LogFileExtensions: []string{".log"},
+		Stages:            []config.StageConfig{{Type: "json_parser"}},
+	}
+	pm, err := pipeline.NewManager(cfg, ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	counting := &countingBackend{Backend: backends.NewFileBackend(".enriched"), delay: delay, target: int64(b.N), done: make(chan struct{})}
+	var backend backends.Backend = counting
+	if async {
+		backend = backends.NewAsyncBackend(counting, 10000, 500, 200*time.Millisecond)
+	}
+	manager, err := NewManagerImpl(cfg, pm, backend)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer manager.watcher.Close()
+	b.ResetTimer()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		manager.tailFile(ctx, path)
+	}()
+	<-counting.done
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "lines/s")
+
+	cancel()
+	<-stopped
+	backend.Shutdown()
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log-enricher/internal/pipeline"
 	"log-enricher/internal/promtailhttp"
 	"log-enricher/internal/tailer"
@@ -15,11 +20,31 @@ import (
 
 	"log-enricher/internal/backends"
 	"log-enricher/internal/config"
+	"log-enricher/internal/deadletter"
+	"log-enricher/internal/fluentforward"
+	"log-enricher/internal/gelf"
 	"log-enricher/internal/logging"
+	"log-enricher/internal/processor"
 	"log-enricher/internal/state"
+	"log-enricher/internal/syslog"
 )
 
 func main() {
+	// Dispatch one-shot subcommands before starting the long-running service.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "replay-dlq":
+			os.Exit(runReplayDeadLetters(config.Load(), os.Args[2:]))
+		case "backfill":
+			os.Exit(runBackfill(config.Load(), os.Args[2:]))
+		}
+		if hasStdinFlag(os.Args[1:]) {
+			os.Exit(runStdin(config.Load(), os.Args[1:], os.Stdin, os.Stdout))
+		}
+	}
+
 	// Load configuration
 	cfg := config.Load()
 
@@ -54,26 +79,19 @@ func runApplication(ctx context.Context, cfg *config.Config) error {
 	}
 
 	// Initialize Backend
-	var backend backends.Backend
-	if cfg.Backend == "file" {
-		backend = backends.NewFileBackend(cfg.EnrichedFileSuffix)
-	} else if cfg.Backend == "loki" {
-		if cfg.LokiURL == "" {
-			return fmt.Errorf("LOKI_URL must be configured when BACKEND=loki")
-		}
-
-		var err error
-		backend, err = backends.NewLokiBackend(cfg.LokiURL)
-
-		if err != nil {
-			return fmt.Errorf("failed to initialize Loki backend: %w", err)
-		}
-	} else {
-		return fmt.Errorf("backend %s not supported", cfg.Backend)
+	backend, err := newBackend(cfg)
+	if err != nil {
+		return err
 	}
 
 	// Hijack the standard logger to send all logs to backends and stdout.
-	logging.New(cfg.LogLevel, filepath.Clean(cfg.LogBasePath+"/log-enricher/process.log"), backend)
+	setupLogging(cfg, backend)
+
+	// Configure the dead-letter sink for entries that fail a stage or a backend send.
+	if err := setupDeadLetter(cfg, backend); err != nil {
+		return err
+	}
+	defer deadletter.Close()
 
 	// Create pipeline stages
 	pipelineManager, err := pipeline.NewManager(cfg, ctx)
@@ -81,23 +99,69 @@ func runApplication(ctx context.Context, cfg *config.Config) error {
 		return fmt.Errorf("failed to initialize pipeline: %w", err)
 	}
 
+	// Optionally move pipeline processing off the tailing and request goroutines.
+	var workers *processor.WorkerPool
+	if cfg.PipelineWorkers > 0 {
+		workers = processor.NewWorkerPool(ctx, cfg.PipelineWorkers, cfg.PipelineQueueSize)
+		slog.Info("Pipeline worker pool enabled", "workers", cfg.PipelineWorkers, "queue_size", cfg.PipelineQueueSize)
+	}
+
 	var promtailReceiver *promtailhttp.Receiver
 	if cfg.PromtailHTTPEnabled {
 		promtailReceiver, err = promtailhttp.NewReceiver(cfg, pipelineManager, backend)
 		if err != nil {
 			return fmt.Errorf("failed to initialize Promtail HTTP receiver: %w", err)
 		}
+		if workers != nil {
+			promtailReceiver.SetWorkerPool(workers)
+		}
 		if err := promtailReceiver.Start(); err != nil {
 			return fmt.Errorf("failed to start Promtail HTTP receiver: %w", err)
 		}
 	}
 
+	var syslogServer *syslog.Server
+	if cfg.SyslogEnabled {
+		syslogServer, err = syslog.NewServer(cfg, pipelineManager, backend)
+		if err != nil {
+			return fmt.Errorf("failed to initialize syslog server: %w", err)
+		}
+		if err := syslogServer.Start(); err != nil {
+			return fmt.Errorf("failed to start syslog server: %w", err)
+		}
+	}
+
+	var forwardServer *fluentforward.Server
+	if cfg.FluentForwardEnabled {
+		forwardServer, err = fluentforward.NewServer(cfg, pipelineManager, backend)
+		if err != nil {
+			return fmt.Errorf("failed to initialize fluent forward server: %w", err)
+		}
+		if err := forwardServer.Start(); err != nil {
+			return fmt.Errorf("failed to start fluent forward server: %w", err)
+		}
+	}
+
+	var gelfServer *gelf.Server
+	if cfg.GelfEnabled {
+		gelfServer, err = gelf.NewServer(cfg, pipelineManager, backend)
+		if err != nil {
+			return fmt.Errorf("failed to initialize GELF server: %w", err)
+		}
+		if err := gelfServer.Start(); err != nil {
+			return fmt.Errorf("failed to start GELF server: %w", err)
+		}
+	}
+
 	// Create the log manager
 	manager, err := tailer.NewManagerImpl(cfg, pipelineManager, backend)
 
 	if err != nil {
 		return fmt.Errorf("failed to initialize log manager: %w", err)
 	}
+	if workers != nil {
+		manager.SetWorkerPool(workers)
+	}
 
 	if err := manager.StartWatching(ctx); err != nil {
 		return fmt.Errorf("failed to start log watcher: %w", err)
@@ -115,13 +179,434 @@ func runApplication(ctx context.Context, cfg *config.Config) error {
 		}
 	}
 
+	if syslogServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := syslogServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down syslog server", "error", err)
+		}
+	}
+
+	if forwardServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := forwardServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down fluent forward server", "error", err)
+		}
+	}
+
+	if gelfServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := gelfServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down GELF server", "error", err)
+		}
+	}
+
+	// Send everything still queued on the workers before positions are saved.
+	if workers != nil {
+		workers.Shutdown()
+	}
+
 	// Save unified state (includes file metadata, positions, and cache).
 	if err := state.Save(cfg.StateFilePath); err != nil {
 		slog.Error("Error saving state", "error", err)
 	}
 
 	// 2. Shutdown backends (like logging) only after all other work is done.
+	deadletter.Close()
 	backend.Shutdown()
 
 	return nil
 }
+
+// newBackend creates the output backend selected by cfg.Backend.
+func newBackend(cfg *config.Config) (backends.Backend, error) {
+	backend, err := newOutputBackend(cfg)
+	if err != nil || !cfg.BackendAsyncEnabled {
+		return backend, err
+	}
+
+	slog.Info("Asynchronous backend queue enabled", "queue_size", cfg.BackendQueueSize, "batch_size", cfg.BackendBatchSize, "max_wait_ms", cfg.BackendBatchMaxWaitMs)
+	return backends.NewAsyncBackend(backend, cfg.BackendQueueSize, cfg.BackendBatchSize, time.Duration(cfg.BackendBatchMaxWaitMs)*time.Millisecond), nil
+}
+
+// newOutputBackend creates the backend selected by cfg.Backend without the asynchronous queue.
+func newOutputBackend(cfg *config.Config) (backends.Backend, error) {
+	switch cfg.Backend {
+	case "file":
+		if cfg.FileHashChainEnabled {
+			return backends.NewHashChainFileBackend(cfg.EnrichedFileSuffix, []byte(cfg.FileHashChainHMACKey), cfg.FileHashChainCheckpoint), nil
+		}
+		return backends.NewFileBackend(cfg.EnrichedFileSuffix), nil
+	case "loki":
+		if cfg.LokiURL == "" {
+			return nil, fmt.Errorf("LOKI_URL must be configured when BACKEND=loki")
+		}
+
+		backend, err := backends.NewLokiBackend(cfg.LokiURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Loki backend: %w", err)
+		}
+		return backend, nil
+	case "http":
+		if cfg.HTTPBackendURL == "" {
+			return nil, fmt.Errorf("HTTP_BACKEND_URL must be configured when BACKEND=http")
+		}
+
+		backend, err := backends.NewHTTPBackend(cfg.HTTPBackendURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize HTTP backend: %w", err)
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("backend %s not supported", cfg.Backend)
+	}
+}
+
+// setupLogging sends log records to stdout and, unqueued, to the backend. The queue's own goroutine logs send
+// failures; if those records went back into the full queue, it would wait for itself.
+func setupLogging(cfg *config.Config, backend backends.Backend) {
+	logging.New(cfg.LogLevel, filepath.Clean(cfg.LogBasePath+"/log-enricher/process.log"), unwrapAsync(backend))
+}
+
+// unwrapAsync returns the backend an AsyncBackend sends to, or backend itself.
+func unwrapAsync(backend backends.Backend) backends.Backend {
+	if async, ok := backend.(*backends.AsyncBackend); ok {
+		return async.Unwrap()
+	}
+	return backend
+}
+
+// setupDeadLetter installs the dead-letter sink selected by cfg.DeadLetterSink.
+func setupDeadLetter(cfg *config.Config, backend backends.Backend) error {
+	switch cfg.DeadLetterSink {
+	case "":
+		deadletter.SetSink(nil)
+	case "file":
+		sink, err := deadletter.NewFileSink(cfg.DeadLetterPath)
+		if err != nil {
+			return fmt.Errorf("failed to initialize dead-letter sink: %w", err)
+		}
+		deadletter.SetSink(sink)
+		slog.Info("Dead-letter sink enabled", "sink", "file", "path", cfg.DeadLetterPath)
+	case "backend":
+		// Records of failed backend sends go to the file instead of the backend that failed them.
+		fallback, err := deadletter.NewFileSink(cfg.DeadLetterPath)
+		if err != nil {
+			return fmt.Errorf("failed to initialize dead-letter sink: %w", err)
+		}
+		// Dead-letter records are sent synchronously, so failures of the queued backend cannot loop back into the queue.
+		backend = unwrapAsync(backend)
+		deadletter.SetSink(deadletter.NewBackendSink(backend, fallback))
+		slog.Info("Dead-letter sink enabled", "sink", "backend", "backend", backend.Name(), "fallback_path", cfg.DeadLetterPath)
+	default:
+		return fmt.Errorf("dead-letter sink %s not supported", cfg.DeadLetterSink)
+	}
+	return nil
+}
+
+// runVerify implements `log-enricher verify [-key <hmac key>] [-interval <records>] <file>...`.
+// It walks the hash chain of each enriched file and returns a non-zero exit code if any chain is broken.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	key := flags.String("key", os.Getenv("FILE_HASH_CHAIN_HMAC_KEY"), "HMAC key used to verify checkpoint records (defaults to FILE_HASH_CHAIN_HMAC_KEY)")
+	interval := flags.Int("interval", config.Load().FileHashChainCheckpoint, "number of records between checkpoints, enforced with -key (defaults to FILE_HASH_CHAIN_CHECKPOINT_INTERVAL)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: log-enricher verify [-key <hmac key>] [-interval <records>] <file>...")
+		return 2
+	}
+
+	exitCode := 0
+	for _, path := range flags.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+
+		result, err := backends.VerifyHashChain(f, []byte(*key), *interval)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to read file: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+
+		if !result.Intact() {
+			fmt.Printf("%s: BROKEN at line %d (%s) after %d valid records\n", path, result.BrokenLine, result.Reason, result.Records)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("%s: OK (%d records, %d checkpoints verified)\n", path, result.Records, result.Checkpoints)
+	}
+
+	return exitCode
+}
+
+// runReplayDeadLetters implements `log-enricher replay-dlq [file]`.
+// It runs every record of a file dead-letter sink through the current pipeline and backend configuration.
+// Records that fail again are written to the configured dead-letter sink.
+func runReplayDeadLetters(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("replay-dlq", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	path := cfg.DeadLetterPath
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	if err := state.Initialize(cfg.StateFilePath); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize state: %v\n", err)
+		return 1
+	}
+
+	// Replay sends synchronously so the reported failure count is accurate.
+	backend, err := newOutputBackend(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer backend.Shutdown()
+
+	if err := setupDeadLetter(cfg, backend); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer deadletter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pipelineManager, err := pipeline.NewManager(cfg, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize pipeline: %v\n", err)
+		return 1
+	}
+
+	stats, err := processor.ReplayDeadLetters(path, pipelineManager, backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := state.Save(cfg.StateFilePath); err != nil {
+		slog.Error("Error saving state", "error", err)
+	}
+
+	fmt.Printf("%s: %d records replayed, %d backend sends failed again\n", path, stats.Processed, stats.Failed)
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runBackfill implements `log-enricher backfill <path or glob>...`.
+// It runs historical files, including rotated and gzip, zstd or bzip2 compressed ones, through the pipeline
+// oldest first and exits when done. Progress is kept in the state file, so an interrupted backfill resumes.
+func runBackfill(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: log-enricher backfill <path or glob>...")
+		return 2
+	}
+
+	if err := state.Initialize(cfg.StateFilePath); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize state: %v\n", err)
+		return 1
+	}
+
+	// The backfiller sends batches with SendBatch and saves progress only after they were delivered; Loki's Send
+	// would only hand entries to its client.
+	backend, err := newOutputBackend(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer backend.Shutdown()
+
+	if err := setupDeadLetter(cfg, backend); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer deadletter.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pipelineManager, err := pipeline.NewManager(cfg, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize pipeline: %v\n", err)
+		return 1
+	}
+
+	backfiller, err := tailer.NewBackfiller(cfg, pipelineManager, backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	stats, runErr := backfiller.Run(ctx, flags.Args())
+
+	if err := state.Save(cfg.StateFilePath); err != nil {
+		slog.Error("Error saving state", "error", err)
+	}
+
+	fmt.Printf("backfill: %d files processed, %d already done, %d entries sent, %d backend sends failed\n",
+		stats.Files, stats.Skipped, stats.Lines, stats.Failed)
+	if errors.Is(runErr, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "backfill interrupted, run it again to resume")
+		return 1
+	}
+	if runErr != nil {
+		fmt.Fprintln(os.Stderr, runErr)
+		return 1
+	}
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// hasStdinFlag reports whether args select the stdin input mode.
+func hasStdinFlag(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "-stdin", "--stdin", "-stdin=true", "--stdin=true":
+			return true
+		}
+	}
+	return false
+}
+
+// runStdin implements `log-enricher --stdin [--app <name>] [--source <path>] [--stdout]`.
+// It runs every line of stdin through the configured pipeline and sends it to the configured backend, or with
+// --stdout writes the enriched entries to stdout, and exits once stdin is closed and everything was flushed.
+func runStdin(cfg *config.Config, args []string, stdin io.Reader, stdout io.Writer) int {
+	flags := flag.NewFlagSet("stdin", flag.ContinueOnError)
+	flags.Bool("stdin", true, "read log lines from stdin")
+	app := flags.String("app", cfg.AppName, "app name of the entries (defaults to APP_NAME, then stdin)")
+	source := flags.String("source", "stdin.log", "source path of the entries, used to select pipeline stages and by the file backend")
+	toStdout := flags.Bool("stdout", false, "write enriched entries to stdout instead of the configured backend")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: log-enricher --stdin [--app <name>] [--source <path>] [--stdout]")
+		return 2
+	}
+	if *app == "" {
+		*app = "stdin"
+	}
+	sourcePath := filepath.Clean(*source)
+
+	// A closed stdout must not kill the process before the backend is flushed; writes fail with EPIPE instead.
+	signal.Ignore(syscall.SIGPIPE)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := state.Initialize(cfg.StateFilePath); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize state: %v\n", err)
+		return 1
+	}
+
+	var backend backends.Backend
+	var out *backends.WriterBackend
+	if *toStdout {
+		out = backends.NewWriterBackend(stdout)
+		backend = out
+	} else {
+		var err error
+		backend, err = newBackend(cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	if err := setupDeadLetter(cfg, backend); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		backend.Shutdown()
+		return 1
+	}
+
+	pipelineManager, err := pipeline.NewManager(cfg, ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize pipeline: %v\n", err)
+		deadletter.Close()
+		backend.Shutdown()
+		return 1
+	}
+	lp := processor.NewLogProcessor(*app, sourcePath, pipelineManager.GetProcessPipeline(sourcePath), backend)
+
+	// Lines are read on their own goroutine, so a signal can end a blocked read.
+	lines := make(chan []byte, 64)
+	readErrs := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(stdin)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					readErrs <- err
+				}
+				return
+			}
+		}
+	}()
+
+	processed, failed := 0, 0
+read:
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Interrupted, flushing stdin input")
+			break read
+		case line, ok := <-lines:
+			if !ok {
+				break read
+			}
+			if err := lp.ProcessLine(line); err != nil {
+				failed++
+			}
+			processed++
+			if out != nil && out.Closed() {
+				// The reader of stdout went away, e.g. `| head`; there is nobody left to write to.
+				break read
+			}
+		}
+	}
+
+	// Flush queued entries before the dead-letter sink and state are closed.
+	backend.Shutdown()
+	deadletter.Close()
+	if err := state.Save(cfg.StateFilePath); err != nil {
+		slog.Error("Error saving state", "error", err)
+	}
+
+	slog.Info("Stdin input finished", "app", *app, "source", sourcePath, "lines", processed, "failed", failed)
+	select {
+	case err := <-readErrs:
+		fmt.Fprintf(os.Stderr, "failed to read stdin: %v\n", err)
+		return 1
+	default:
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
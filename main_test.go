@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -10,10 +13,14 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"log-enricher/internal/backends"
 	"log-enricher/internal/config"
+	"log-enricher/internal/models"
+	"log-enricher/internal/state"
 
 	"github.com/goccy/go-json"
 	"github.com/stretchr/testify/assert"
@@ -246,6 +253,98 @@ func TestRunApplication_LokiBackendMissingURL(t *testing.T) {
 	}
 }
 
+func TestRunVerify_ReportsIntactAndBrokenChains(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "audit.log")
+	backend := backends.NewHashChainFileBackend(".enriched", []byte("key"), 1)
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("first")}))
+	require.NoError(t, backend.Send(&models.LogEntry{SourcePath: sourcePath, LogLine: []byte("second")}))
+	backend.Shutdown()
+
+	enrichedPath := sourcePath + ".enriched"
+	assert.Equal(t, 0, runVerify([]string{"-key", "key", "-interval", "1", enrichedPath}))
+	assert.Equal(t, 1, runVerify([]string{"-key", "key", "-interval", "2", enrichedPath}), "checkpoints must follow the interval")
+	assert.Equal(t, 1, runVerify([]string{"-key", "other", "-interval", "1", enrichedPath}))
+	assert.Equal(t, 2, runVerify(nil))
+
+	content, err := os.ReadFile(enrichedPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(enrichedPath, []byte(strings.Replace(string(content), "second", "edited", 1)), 0644))
+	assert.Equal(t, 1, runVerify([]string{"-key", "key", "-interval", "1", enrichedPath}))
+}
+
+func TestRunStdin_WritesEnrichedEntriesToStdout(t *testing.T) {
+	cfg := newMinimalConfig(t.TempDir())
+	cfg.Stages = []config.StageConfig{
+		{Type: "json_parser"},
+		{Type: "filter", Params: map[string]interface{}{"action": "drop", "regex": "^debug"}},
+	}
+
+	var stdout bytes.Buffer
+	stdin := strings.NewReader("{\"msg\":\"one\"}\nplain two\r\ndebug three\nlast without newline")
+	code := runStdin(cfg, []string{"--stdin", "--app", "myapp", "--source", "k8s/myapp.log", "--stdout"}, stdin, &stdout)
+	require.Equal(t, 0, code)
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &fields))
+	assert.Equal(t, "one", fields["msg"])
+	assert.Equal(t, []string{"plain two", "last without newline"}, lines[1:])
+}
+
+func TestRunStdin_SendsToConfiguredBackend(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := newMinimalConfig(tempDir)
+	sourcePath := filepath.Join(tempDir, "k8s", "myapp.log")
+	require.NoError(t, os.MkdirAll(filepath.Dir(sourcePath), 0755))
+
+	var stdout bytes.Buffer
+	code := runStdin(cfg, []string{"--stdin", "--source", sourcePath}, strings.NewReader("first\nsecond\n"), &stdout)
+	require.Equal(t, 0, code)
+	assert.Empty(t, stdout.String())
+
+	content, err := os.ReadFile(sourcePath + ".enriched")
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(content))
+
+	assert.Equal(t, 2, runStdin(cfg, []string{"--stdin", "extra"}, strings.NewReader(""), &stdout))
+}
+
+// brokenPipeWriter fails like stdout after the reading end of the pipe was closed.
+type brokenPipeWriter struct {
+	writes int
+}
+
+func (w *brokenPipeWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, &os.PathError{Op: "write", Path: "/dev/stdout", Err: syscall.EPIPE}
+}
+
+func TestRunStdin_StopsQuietlyWhenStdoutIsClosed(t *testing.T) {
+	cfg := newMinimalConfig(t.TempDir())
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		// Input never ends on its own, like `kubectl logs -f`.
+		for {
+			if _, err := writer.Write([]byte("line\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	stdout := &brokenPipeWriter{}
+	done := make(chan int, 1)
+	go func() { done <- runStdin(cfg, []string{"--stdin", "--stdout"}, reader, stdout) }()
+	select {
+	case code := <-done:
+		assert.Equal(t, 0, code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("runStdin did not stop after stdout was closed")
+	}
+	assert.Equal(t, 1, stdout.writes)
+}
+
 func TestRunApplication_PromtailHTTPEnabled(t *testing.T) {
 	tempDir := t.TempDir()
 	addr := getFreeTCPAddr(t)
@@ -465,3 +564,203 @@ func readMarkerCounts(enrichedPath string) (map[string]int, error) {
 
 	return counts, nil
 }
+
+func TestRunApplication_AsyncBackendQueue(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := newMinimalConfig(tempDir)
+	cfg.BackendAsyncEnabled = true
+	cfg.BackendQueueSize = 100
+	cfg.BackendBatchSize = 10
+	cfg.BackendBatchMaxWaitMs = 20
+
+	logPath := filepath.Join(tempDir, "queued.log")
+	enrichedPath := logPath + cfg.EnrichedFileSuffix
+
+	cancel, done := startApplicationForTest(t, cfg)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	var lines []string
+	expected := make(map[string]int)
+	for i := 0; i < 25; i++ {
+		marker := fmt.Sprintf("queued-%d", i)
+		lines = append(lines, fmt.Sprintf(`{"level":"info","msg":"queued","marker":"%s"}`, marker))
+		expected[marker] = 1
+	}
+	appendLogLines(t, logPath, lines)
+
+	waitForMarkerCounts(t, enrichedPath, expected)
+}
+
+func TestRunApplication_PipelineWorkerPool(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := newMinimalConfig(tempDir)
+	cfg.PipelineWorkers = 4
+	cfg.PipelineQueueSize = 16
+
+	logPath := filepath.Join(tempDir, "parallel.log")
+	enrichedPath := logPath + cfg.EnrichedFileSuffix
+
+	var lines []string
+	for i := 0; i < 50; i++ {
+		lines = append(lines, fmt.Sprintf(`{"level":"info","msg":"parallel","marker":"parallel-%d"}`, i))
+	}
+	appendLogLines(t, logPath, lines)
+
+	cancel, done := startApplicationForTest(t, cfg)
+	expected := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		expected[fmt.Sprintf("parallel-%d", i)] = 1
+	}
+	waitForMarkerCounts(t, enrichedPath, expected)
+	cancel()
+	require.NoError(t, <-done)
+
+	content, err := os.ReadFile(enrichedPath)
+	require.NoError(t, err)
+	for i, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		assert.Contains(t, line, fmt.Sprintf(`"marker":"parallel-%d"`, i))
+	}
+	assert.Equal(t, int64(50), state.GetOrCreateFileState(logPath).GetLineNumber())
+}
+
+func TestRunApplication_SyslogEnabled(t *testing.T) {
+	tempDir := t.TempDir()
+	addr := getFreeTCPAddr(t)
+	cfg := newMinimalConfig(tempDir)
+	cfg.SyslogEnabled = true
+	cfg.SyslogTCPAddr = addr
+	cfg.SyslogAppTemplate = "{{.app_name}}"
+	cfg.SyslogSourceTemplate = "{{.hostname}}/{{.app_name}}.log"
+	cfg.SyslogSourceRoot = filepath.Join(t.TempDir(), "syslog")
+	cfg.SyslogMaxMessageBytes = 1024
+
+	cancel, done := startApplicationForTest(t, cfg)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		var err error
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	_, err := conn.Write([]byte("<13>1 2026-03-10T11:22:33Z web-1 billing - - - syslog-marker\n"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	enrichedPath := filepath.Join(cfg.SyslogSourceRoot, "web-1", "billing.log") + cfg.EnrichedFileSuffix
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(enrichedPath)
+		return err == nil && strings.Contains(string(content), "syslog-marker")
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestRunApplication_FluentForwardEnabled(t *testing.T) {
+	tempDir := t.TempDir()
+	addr := getFreeTCPAddr(t)
+	cfg := newMinimalConfig(tempDir)
+	cfg.FluentForwardEnabled = true
+	cfg.FluentForwardAddr = addr
+	cfg.FluentForwardAppTemplate = "{{.tag}}"
+	cfg.FluentForwardSourceTemplate = "{{.tag}}.log"
+	cfg.FluentForwardSourceRoot = filepath.Join(t.TempDir(), "fluent")
+	cfg.FluentForwardMaxMessageBytes = 64 * 1024
+
+	cancel, done := startApplicationForTest(t, cfg)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	fixture, err := os.ReadFile(filepath.Join("internal", "fluentforward", "testdata", "forward_mode.msgpack"))
+	require.NoError(t, err)
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	_, err = conn.Write(fixture)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	enrichedPath := filepath.Join(cfg.FluentForwardSourceRoot, "app.api.log") + cfg.EnrichedFileSuffix
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(enrichedPath)
+		return err == nil && strings.Contains(string(content), `"message":"second"`)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestRunApplication_GelfEnabled(t *testing.T) {
+	tempDir := t.TempDir()
+	addr := getFreeTCPAddr(t)
+	cfg := newMinimalConfig(tempDir)
+	cfg.GelfEnabled = true
+	cfg.GelfUDPAddr = ""
+	cfg.GelfTCPAddr = addr
+	cfg.GelfAppTemplate = `{{if .container_name}}{{.container_name}}{{else}}gelf{{end}}`
+	cfg.GelfSourceTemplate = `{{.host}}/{{if .container_name}}{{.container_name}}{{else}}gelf{{end}}.log`
+	cfg.GelfSourceRoot = filepath.Join(t.TempDir(), "gelf")
+	cfg.GelfMaxMessageBytes = 64 * 1024
+
+	cancel, done := startApplicationForTest(t, cfg)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	var conn net.Conn
+	var err error
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	_, err = conn.Write([]byte(`{"version":"1.1","host":"docker-1","short_message":"gelf marker","level":4,"_container_name":"web"}` + "\x00"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	enrichedPath := filepath.Join(cfg.GelfSourceRoot, "docker-1", "web.log") + cfg.EnrichedFileSuffix
+	require.Eventually(t, func() bool {
+		content, err := os.ReadFile(enrichedPath)
+		return err == nil && strings.Contains(string(content), `"message":"gelf marker"`) && strings.Contains(string(content), `"level":"warning"`)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+// failingBackend rejects every entry.
+type failingBackend struct{}
+
+func (failingBackend) Send(*models.LogEntry) error { return fmt.Errorf("backend unavailable") }
+func (failingBackend) SendBatch(entries []*models.LogEntry) error {
+	return fmt.Errorf("backend unavailable")
+}
+func (failingBackend) Shutdown()          {}
+func (failingBackend) Name() string       { return "failing" }
+func (failingBackend) CloseWriter(string) {}
+
+func TestSetupLogging_AsyncBackendFailuresDoNotBlockTheQueue(t *testing.T) {
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	async := backends.NewAsyncBackend(failingBackend{}, 4, 2, time.Millisecond)
+	setupLogging(newMinimalConfig(t.TempDir()), async)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_ = async.Send(&models.LogEntry{SourcePath: "/logs/app.log", LogLine: []byte(fmt.Sprintf("line %d", i)), Fields: map[string]interface{}{}})
+		}
+		async.Shutdown()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("sending to an async backend whose batches fail blocked")
+	}
+}